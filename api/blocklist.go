@@ -0,0 +1,25 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/elgatito/elementum/blocklist"
+)
+
+// BlocklistStatus shows number of blocklist rules and time of the last update
+func BlocklistStatus(ctx *gin.Context) {
+	ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	ctx.JSON(200, blocklist.GetStatus())
+}
+
+// UpdateBlocklist reloads user blocklists immediately
+func UpdateBlocklist(ctx *gin.Context) {
+	ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if err := blocklist.Update(); err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(200, blocklist.GetStatus())
+}
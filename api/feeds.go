@@ -0,0 +1,30 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/elgatito/elementum/feeds"
+	"github.com/elgatito/elementum/xbmc"
+)
+
+// FeedsStatus shows watched feeds, rules and results of the last check
+func FeedsStatus(ctx *gin.Context) {
+	ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	ctx.JSON(200, feeds.GetStatus())
+}
+
+// CheckFeeds polls watched feeds immediately
+func CheckFeeds(ctx *gin.Context) {
+	ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+
+	added, err := feeds.Check()
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	if added > 0 {
+		xbmc.Refresh()
+	}
+	ctx.JSON(200, gin.H{"added": added})
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"github.com/elgatito/elementum/bittorrent"
 	"github.com/elgatito/elementum/config"
 	"github.com/elgatito/elementum/xbmc"
 	"github.com/gin-gonic/gin"
@@ -11,13 +12,19 @@ func init() {
 }
 
 // Index ...
-func Index(ctx *gin.Context) {
-	action := ctx.Query("action")
-	if action == "search" || action == "manualsearch" {
-		SubtitlesIndex(ctx)
-		return
+func Index(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		action := ctx.Query("action")
+		if action == "search" || action == "manualsearch" {
+			subtitlesIndex(btService, ctx)
+			return
+		}
+
+		index(ctx)
 	}
+}
 
+func index(ctx *gin.Context) {
 	ctx.JSON(200, xbmc.NewView("", xbmc.ListItems{
 		{Label: "LOCALIZE[30214]", Path: URLForXBMC("/movies/"), Thumbnail: config.AddonResource("img", "movies.png")},
 		{Label: "LOCALIZE[30215]", Path: URLForXBMC("/shows/"), Thumbnail: config.AddonResource("img", "tv.png")},
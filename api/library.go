@@ -1,7 +1,9 @@
 package api
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"strconv"
 
 	"github.com/elgatito/elementum/bittorrent"
@@ -195,7 +197,7 @@ func UpdateTrakt(ctx *gin.Context) {
 	xbmc.Notify("Elementum", "LOCALIZE[30358]", config.AddonIcon())
 	ctx.String(200, "")
 	go func() {
-		library.RefreshTrakt()
+		library.RefreshTraktFull()
 		if config.Get().LibraryUpdate == 0 || (config.Get().LibraryUpdate == 1 && xbmc.DialogConfirm("Elementum", "LOCALIZE[30288]")) {
 			xbmc.VideoLibraryScan()
 		}
@@ -217,3 +219,71 @@ func PlayShow(btService *bittorrent.BTService) gin.HandlerFunc {
 	}
 	return ShowEpisodeLinks(btService)
 }
+
+// ExportLibrary returns library state as JSON, or writes it to the file, if path is set
+func ExportLibrary(ctx *gin.Context) {
+	ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+
+	export, err := library.ExportLibrary()
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	if path := ctx.Query("path"); path != "" {
+		b, err := json.MarshalIndent(export, "", "  ")
+		if err == nil {
+			err = ioutil.WriteFile(path, b, 0644)
+		}
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+
+		xbmc.Notify("Elementum", "Library exported", config.AddonIcon())
+		ctx.String(200, "")
+		return
+	}
+
+	ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=elementum-library-%s.json", export.Created.Format("20060102")))
+	ctx.JSON(200, export)
+}
+
+// ImportLibrary restores library state from JSON in the request body, or from the file, if path is set
+func ImportLibrary(ctx *gin.Context) {
+	ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+
+	var b []byte
+	var err error
+	if path := ctx.Query("path"); path != "" {
+		b, err = ioutil.ReadFile(path)
+	} else {
+		b, err = ioutil.ReadAll(ctx.Request.Body)
+	}
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	export := &library.Export{}
+	if err := json.Unmarshal(b, export); err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	result, err := library.ImportLibrary(export)
+	if err != nil {
+		xbmc.Notify("Elementum", err.Error(), config.AddonIcon())
+		ctx.Error(err)
+		return
+	}
+
+	xbmc.Notify("Elementum", fmt.Sprintf("Library imported: %d items", result.Written), config.AddonIcon())
+	ctx.JSON(200, result)
+
+	go func() {
+		if config.Get().LibraryUpdate == 0 || (config.Get().LibraryUpdate == 1 && xbmc.DialogConfirm("Elementum", "LOCALIZE[30288]")) {
+			xbmc.VideoLibraryScan()
+		}
+	}()
+}
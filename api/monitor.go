@@ -0,0 +1,56 @@
+package api
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/elgatito/elementum/config"
+	"github.com/elgatito/elementum/library"
+	"github.com/elgatito/elementum/monitor"
+	"github.com/elgatito/elementum/xbmc"
+)
+
+// MonitorStatus shows monitored shows and results of the last check
+func MonitorStatus(ctx *gin.Context) {
+	ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	ctx.JSON(200, monitor.GetStatus())
+}
+
+// CheckMonitor searches aired episodes of monitored shows immediately
+func CheckMonitor(ctx *gin.Context) {
+	ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+
+	added, err := monitor.Check()
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(200, gin.H{"added": added})
+}
+
+// MonitorShow enables monitoring of new episodes for library show
+func MonitorShow(ctx *gin.Context) {
+	showID, _ := strconv.Atoi(ctx.Params.ByName("tmdbId"))
+	if err := monitor.Add(showID); err != nil {
+		xbmc.Notify("Elementum", err.Error(), config.AddonIcon())
+		ctx.String(200, err.Error())
+		return
+	}
+
+	library.ClearPageCache()
+	xbmc.Refresh()
+}
+
+// UnmonitorShow disables monitoring of new episodes for library show
+func UnmonitorShow(ctx *gin.Context) {
+	showID, _ := strconv.Atoi(ctx.Params.ByName("tmdbId"))
+	if err := monitor.Remove(showID); err != nil {
+		ctx.String(200, err.Error())
+		return
+	}
+
+	library.ClearPageCache()
+	xbmc.Refresh()
+}
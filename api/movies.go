@@ -2,7 +2,6 @@ package api
 
 import (
 	"fmt"
-	"sort"
 	"strconv"
 	"strings"
 
@@ -562,7 +561,10 @@ func MoviePlay(btService *bittorrent.BTService) gin.HandlerFunc {
 			return
 		}
 
-		sort.Sort(sort.Reverse(providers.ByQuality(torrents)))
+		if torrents = providers.SortAuto(torrents); len(torrents) == 0 {
+			xbmc.Notify("Elementum", "LOCALIZE[30205]", config.AddonIcon())
+			return
+		}
 
 		AddToTorrentsMap(tmdbID, torrents[0])
 
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/elgatito/elementum/config"
+	"github.com/elgatito/elementum/database"
+	"github.com/elgatito/elementum/numbering"
+	"github.com/elgatito/elementum/xbmc"
+)
+
+// ShowNumbering returns numbering mode and scene mapping of the show
+func ShowNumbering(ctx *gin.Context) {
+	ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	showID, _ := strconv.Atoi(ctx.Params.ByName("showId"))
+
+	ctx.JSON(200, gin.H{
+		"mode":          numbering.ModeName(database.Get().GetShowNumbering(showID)),
+		"scene_mapping": database.Get().GetSceneMapping(showID),
+	})
+}
+
+// SelectShowNumbering asks user for numbering mode of the show
+func SelectShowNumbering(ctx *gin.Context) {
+	showID, _ := strconv.Atoi(ctx.Params.ByName("showId"))
+
+	items := make([]string, 0, len(numbering.Modes))
+	for i := range numbering.Modes {
+		items = append(items, fmt.Sprintf("LOCALIZE[%d]", 30506+i))
+	}
+
+	choice := xbmc.ListDialog("LOCALIZE[30505]", items...)
+	if choice < 0 {
+		return
+	}
+
+	setShowNumbering(ctx, showID, numbering.Modes[choice])
+}
+
+// SetShowNumbering sets numbering mode of the show
+func SetShowNumbering(ctx *gin.Context) {
+	showID, _ := strconv.Atoi(ctx.Params.ByName("showId"))
+	setShowNumbering(ctx, showID, ctx.Params.ByName("mode"))
+}
+
+func setShowNumbering(ctx *gin.Context, showID int, name string) {
+	mode, err := numbering.ParseMode(name)
+	if err == nil && mode == database.NumberingScene && len(database.Get().GetSceneMapping(showID)) == 0 {
+		err = fmt.Errorf("Scene mapping is not uploaded for the show")
+	}
+	if err == nil {
+		err = database.Get().SetShowNumbering(showID, mode)
+	}
+	if err != nil {
+		xbmc.Notify("Elementum", err.Error(), config.AddonIcon())
+		ctx.String(200, err.Error())
+		return
+	}
+
+	ctx.String(200, "")
+}
+
+// UploadSceneMapping stores scene mapping, sent as JSON list in the body, and selects scene numbering for the show
+func UploadSceneMapping(ctx *gin.Context) {
+	ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	showID, _ := strconv.Atoi(ctx.Params.ByName("showId"))
+
+	body, err := ioutil.ReadAll(ctx.Request.Body)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	mapping := []*database.SceneMapping{}
+	if err := json.Unmarshal(body, &mapping); err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	if err := database.Get().SetSceneMapping(showID, mapping); err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	mode := database.NumberingScene
+	if len(mapping) == 0 {
+		mode = database.NumberingAired
+	}
+	if err := database.Get().SetShowNumbering(showID, mode); err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(200, gin.H{"mode": numbering.ModeName(mode), "episodes": len(mapping)})
+}
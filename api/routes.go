@@ -23,7 +23,7 @@ func Routes(btService *bittorrent.BTService) *gin.Engine {
 
 	gin.SetMode(gin.ReleaseMode)
 
-	r.GET("/", Index)
+	r.GET("/", Index(btService))
 	r.GET("/playtorrent", PlayTorrent)
 	r.GET("/infolabels", InfoLabelsStored(btService))
 	r.GET("/changelog", Changelog)
@@ -54,9 +54,23 @@ func Routes(btService *bittorrent.BTService) *gin.Engine {
 		torrents.GET("/pause", PauseSession(btService))
 		torrents.GET("/resume", ResumeSession(btService))
 		torrents.GET("/move/:torrentId", MoveTorrent(btService))
+		torrents.GET("/volume/:torrentId", MoveTorrentVolume(btService))
 		torrents.GET("/pause/:torrentId", PauseTorrent(btService))
 		torrents.GET("/resume/:torrentId", ResumeTorrent(btService))
 		torrents.GET("/delete/:torrentId", RemoveTorrent(btService))
+		torrents.GET("/seeding/:torrentId", TorrentSeedPolicy(btService))
+		torrents.GET("/completed", ListCompletedTorrents(btService))
+		torrents.GET("/completed/forget/:infohash", ForgetCompletedTorrent(btService))
+
+		queue := torrents.Group("/queue")
+		{
+			queue.GET("/", ListQueue(btService))
+			queue.GET("/move/:torrentId", MoveQueueTorrent(btService))
+			queue.GET("/priority/:torrentId", PriorityQueueTorrent(btService))
+			queue.GET("/pause/:torrentId", PauseQueueTorrent(btService))
+			queue.GET("/resume/:torrentId", ResumeQueueTorrent(btService))
+			queue.GET("/promote/:torrentId", PromoteQueueTorrent(btService))
+		}
 
 		// Web UI json
 		torrents.GET("/list", ListTorrentsWeb(btService))
@@ -185,6 +199,7 @@ func Routes(btService *bittorrent.BTService) *gin.Engine {
 		show.GET("/:showId/season/:season/links", ShowSeasonLinks(btService))
 		show.GET("/:showId/season/:season/play", ShowSeasonPlay(btService))
 		show.GET("/:showId/season/:season/episodes", ShowEpisodes)
+		show.GET("/:showId/season/:season/pack/remove", RemoveSeasonPack)
 		show.GET("/:showId/season/:season/episode/:episode/infolabels", InfoLabelsEpisode(btService))
 		show.GET("/:showId/season/:season/episode/:episode/play", ShowEpisodePlaySelector("play", btService))
 		show.GET("/:showId/season/:season/episode/:episode/forceplay", ShowEpisodePlaySelector("forceplay", btService))
@@ -194,6 +209,10 @@ func Routes(btService *bittorrent.BTService) *gin.Engine {
 		show.GET("/:showId/watchlist/remove", RemoveShowFromWatchlist)
 		show.GET("/:showId/collection/add", AddShowToCollection)
 		show.GET("/:showId/collection/remove", RemoveShowFromCollection)
+		show.GET("/:showId/numbering", ShowNumbering)
+		show.GET("/:showId/numbering/select", SelectShowNumbering)
+		show.GET("/:showId/numbering/set/:mode", SetShowNumbering)
+		show.POST("/:showId/numbering/scene", UploadSceneMapping)
 	}
 	// TODO
 	// episode := r.Group("/episode")
@@ -211,6 +230,13 @@ func Routes(btService *bittorrent.BTService) *gin.Engine {
 		library.GET("/show/remove/:tmdbId", RemoveShow)
 		library.GET("/show/list/add/:listId", AddShowsList)
 		library.GET("/show/play/:showId/:season/:episode", PlayShow(btService))
+		library.GET("/show/monitor/:tmdbId", MonitorShow)
+		library.GET("/show/unmonitor/:tmdbId", UnmonitorShow)
+		library.GET("/monitor", MonitorStatus)
+		library.GET("/monitor/check", CheckMonitor)
+		library.GET("/export", ExportLibrary)
+		library.GET("/import", ImportLibrary)
+		library.POST("/import", ImportLibrary)
 
 		library.GET("/update", UpdateLibrary)
 
@@ -254,15 +280,40 @@ func Routes(btService *bittorrent.BTService) *gin.Engine {
 		trakt.GET("/authorize", AuthorizeTrakt)
 		trakt.GET("/select_list/:action/:media", SelectTraktUserList)
 		trakt.GET("/update", UpdateTrakt)
+
+		trakt.GET("/queue", TraktQueue)
+		trakt.GET("/queue/retry", RetryTraktQueue)
+		trakt.GET("/queue/retry/:id", RetryTraktQueue)
+		trakt.GET("/queue/delete/:id", DeleteTraktQueue)
+	}
+
+	feeds := r.Group("/feeds")
+	{
+		feeds.GET("", FeedsStatus)
+		feeds.GET("/check", CheckFeeds)
+	}
+
+	scoring := r.Group("/scoring")
+	{
+		scoring.GET("", ScoringProfile)
+		scoring.GET("/movie/:tmdbId", ScoringMovie)
+		scoring.GET("/show/:showId/season/:season/episode/:episode", ScoringEpisode)
 	}
 
 	r.GET("/migrate/:plugin", MigratePlugin)
 
 	r.GET("/setviewmode/:content_type", SetViewMode)
 
-	r.GET("/subtitles", SubtitlesIndex)
+	r.GET("/subtitles", SubtitlesIndex(btService))
+	r.GET("/subtitles/embedded/:infohash/:file", EmbeddedSubtitles(btService))
+	r.GET("/subtitles/embedded/:infohash/:file/:track", EmbeddedSubtitleGet(btService))
 	r.GET("/subtitle/:id", SubtitleGet)
 
+	r.GET("/blocklist", BlocklistStatus)
+	r.GET("/blocklist/update", UpdateBlocklist)
+
+	r.GET("/volumes", VolumesStatus(btService))
+
 	r.GET("/play", Play(btService))
 	r.Any("/playuri", PlayURI(btService))
 
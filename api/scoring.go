@@ -0,0 +1,67 @@
+package api
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/elgatito/elementum/bittorrent"
+	"github.com/elgatito/elementum/providers"
+)
+
+// ScoringProfile shows current scoring profile
+func ScoringProfile(ctx *gin.Context) {
+	ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+
+	profile, err := providers.LoadScoringProfile()
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(200, gin.H{"path": providers.ScoringPath(), "profile": profile})
+}
+
+// ScoringMovie shows how search results for a movie are scored
+func ScoringMovie(ctx *gin.Context) {
+	ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+
+	tmdbID := ctx.Params.ByName("tmdbId")
+	torrents, err := GetCachedTorrents(tmdbID)
+	if err != nil || len(torrents) == 0 {
+		torrents = movieLinks(tmdbID)
+	}
+
+	renderScores(ctx, torrents)
+}
+
+// ScoringEpisode shows how search results for an episode are scored
+func ScoringEpisode(ctx *gin.Context) {
+	ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+
+	showID, _ := strconv.Atoi(ctx.Params.ByName("showId"))
+	seasonNumber, _ := strconv.Atoi(ctx.Params.ByName("season"))
+	episodeNumber, _ := strconv.Atoi(ctx.Params.ByName("episode"))
+
+	torrents, err := showEpisodeLinks(showID, seasonNumber, episodeNumber)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	renderScores(ctx, torrents)
+}
+
+func renderScores(ctx *gin.Context, torrents []*bittorrent.TorrentFile) {
+	profile, err := providers.LoadScoringProfile()
+	if err != nil {
+		ctx.Error(err)
+		return
+	} else if profile == nil {
+		ctx.Error(errors.New("Scoring profile is not defined"))
+		return
+	}
+
+	ctx.JSON(200, profile.Explain(torrents))
+}
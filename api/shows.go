@@ -8,7 +8,9 @@ import (
 
 	"github.com/elgatito/elementum/bittorrent"
 	"github.com/elgatito/elementum/config"
+	"github.com/elgatito/elementum/database"
 	"github.com/elgatito/elementum/library"
+	"github.com/elgatito/elementum/prefetch"
 	"github.com/elgatito/elementum/providers"
 	"github.com/elgatito/elementum/tmdb"
 	"github.com/elgatito/elementum/trakt"
@@ -164,6 +166,11 @@ func renderShows(ctx *gin.Context, shows tmdb.Shows, page int, total int, query
 		if err := library.IsDuplicateShow(tmdbID); err != nil || library.IsAddedToLibrary(tmdbID, library.ShowType) {
 			libraryActions = append(libraryActions, []string{"LOCALIZE[30283]", fmt.Sprintf("XBMC.RunPlugin(%s)", URLForXBMC("/library/show/add/%d?force=true", show.ID))})
 			libraryActions = append(libraryActions, []string{"LOCALIZE[30253]", fmt.Sprintf("XBMC.RunPlugin(%s)", URLForXBMC("/library/show/remove/%d", show.ID))})
+			if database.Get().IsShowMonitored(show.ID) {
+				libraryActions = append(libraryActions, []string{"LOCALIZE[30504]", fmt.Sprintf("XBMC.RunPlugin(%s)", URLForXBMC("/library/show/unmonitor/%d", show.ID))})
+			} else if config.Get().MonitorEnabled {
+				libraryActions = append(libraryActions, []string{"LOCALIZE[30503]", fmt.Sprintf("XBMC.RunPlugin(%s)", URLForXBMC("/library/show/monitor/%d", show.ID))})
+			}
 		} else {
 			libraryActions = append(libraryActions, []string{"LOCALIZE[30252]", fmt.Sprintf("XBMC.RunPlugin(%s)", URLForXBMC("/library/show/add/%d", show.ID))})
 		}
@@ -181,6 +188,7 @@ func renderShows(ctx *gin.Context, shows tmdb.Shows, page int, total int, query
 		item.ContextMenu = [][]string{
 			watchlistAction,
 			collectionAction,
+			[]string{"LOCALIZE[30505]", fmt.Sprintf("XBMC.RunPlugin(%s)", URLForXBMC("/show/%d/numbering/select", show.ID))},
 			[]string{"LOCALIZE[30035]", fmt.Sprintf("XBMC.RunPlugin(%s)", URLForXBMC("/setviewmode/tvshows"))},
 		}
 		item.ContextMenu = append(libraryActions, item.ContextMenu...)
@@ -326,6 +334,9 @@ func ShowSeasons(ctx *gin.Context) {
 			[]string{contextOppositeLabel, fmt.Sprintf("XBMC.PlayMedia(%s)", contextOppositeURL)},
 			[]string{"LOCALIZE[30036]", fmt.Sprintf("XBMC.RunPlugin(%s)", URLForXBMC("/setviewmode/seasons"))},
 		}
+		if database.Get().GetSeasonPack(show.ID, item.Info.Season) != nil {
+			item.ContextMenu = append(item.ContextMenu, []string{"LOCALIZE[30510]", fmt.Sprintf("XBMC.RunPlugin(%s)", URLForXBMC("/show/%d/season/%d/pack/remove", show.ID, item.Info.Season))})
+		}
 		reversedItems = append(reversedItems, item)
 	}
 	// xbmc.ListItems always returns false to Less() so that order is unchanged
@@ -651,6 +662,79 @@ func showEpisodeLinks(showID int, seasonNumber int, episodeNumber int) ([]*bitto
 	return providers.SearchEpisode(searchers, show, episode), nil
 }
 
+// prefetchedURL returns play URL of the episode, prefetched during playback of the previous one, or empty string
+func prefetchedURL(btService *bittorrent.BTService, showID, seasonNumber, episodeNumber, episodeID int, doresume string) string {
+	infoHash := prefetch.Get(showID, seasonNumber, episodeNumber)
+	if infoHash == "" || btService.HasTorrentByHash(infoHash) == "" {
+		return ""
+	}
+
+	log.Infof("Playing prefetched episode %d from %s", episodeNumber, infoHash)
+	return URLQuery(URLForXBMC("/play"), append([]string{"resume", infoHash}, episodePlayQuery(showID, seasonNumber, episodeNumber, episodeID, doresume)...)...)
+}
+
+func episodePlayQuery(showID, seasonNumber, episodeNumber, episodeID int, doresume string) []string {
+	return []string{
+		"doresume", doresume,
+		"tmdb", strconv.Itoa(episodeID),
+		"show", strconv.Itoa(showID),
+		"season", strconv.Itoa(seasonNumber),
+		"episode", strconv.Itoa(episodeNumber),
+		"type", "episode",
+	}
+}
+
+// seasonPackURL returns play URL of the episode from a known season pack, or empty string,
+// active season pack torrent is resumed, otherwise it is added from stored metainfo.
+// Season pack is used with the same rules as cached selection of the episode
+func seasonPackURL(btService *bittorrent.BTService, showID, seasonNumber, episodeNumber, episodeID int, doresume string) string {
+	if !config.Get().UseCacheSelection {
+		return ""
+	}
+
+	pack := database.Get().GetSeasonPack(showID, seasonNumber)
+	if pack == nil {
+		return ""
+	}
+	if _, ok := pack.Files[episodeNumber]; !ok {
+		return ""
+	}
+
+	query := episodePlayQuery(showID, seasonNumber, episodeNumber, episodeID, doresume)
+
+	existingTorrent := btService.HasTorrentByHash(pack.InfoHash)
+	if existingTorrent != "" && (config.Get().SilentStreamStart || xbmc.DialogConfirmFocused("Elementum", "LOCALIZE[30270]", xbmc.DialogExpiration.Existing)) {
+		log.Infof("Playing episode %d from active season pack %s", episodeNumber, existingTorrent)
+		return URLQuery(URLForXBMC("/play"), append([]string{"resume", existingTorrent}, query...)...)
+	}
+
+	torrent := &bittorrent.TorrentFile{}
+	if err := torrent.LoadFromBytes(pack.Metainfo); err != nil || torrent.URI == "" {
+		log.Warningf("Could not load season pack %s: %s", pack.InfoHash, err)
+		return ""
+	}
+	if !config.Get().SilentStreamStart && !xbmc.DialogConfirmFocused("Elementum", fmt.Sprintf("LOCALIZE[30260];;[COLOR gold]%s[/COLOR]", torrent.Name), xbmc.DialogExpiration.InTorrents) {
+		return ""
+	}
+
+	log.Infof("Playing episode %d from season pack %s", episodeNumber, pack.InfoHash)
+	return URLQuery(URLForXBMC("/play"), append([]string{"uri", torrent.URI}, query...)...)
+}
+
+// RemoveSeasonPack forgets season pack, so episodes of the season are searched again
+func RemoveSeasonPack(ctx *gin.Context) {
+	showID, _ := strconv.Atoi(ctx.Params.ByName("showId"))
+	seasonNumber, _ := strconv.Atoi(ctx.Params.ByName("season"))
+
+	if err := database.Get().DeleteSeasonPack(showID, seasonNumber); err != nil {
+		ctx.String(200, err.Error())
+		return
+	}
+
+	xbmc.Refresh()
+	ctx.String(200, "")
+}
+
 // ShowEpisodePlaySelector ...
 func ShowEpisodePlaySelector(link string, btService *bittorrent.BTService) gin.HandlerFunc {
 	play := strings.Contains(link, "play")
@@ -854,6 +938,24 @@ func ShowEpisodePlay(btService *bittorrent.BTService) gin.HandlerFunc {
 			return
 		}
 
+		if rURL := prefetchedURL(btService, showID, seasonNumber, episodeNumber, episode.ID, doresume); rURL != "" {
+			if external != "" {
+				xbmc.PlayURL(rURL)
+			} else {
+				ctx.Redirect(302, rURL)
+			}
+			return
+		}
+
+		if rURL := seasonPackURL(btService, showID, seasonNumber, episodeNumber, episode.ID, doresume); rURL != "" {
+			if external != "" {
+				xbmc.PlayURL(rURL)
+			} else {
+				ctx.Redirect(302, rURL)
+			}
+			return
+		}
+
 		if torrent := InTorrentsMap(strconv.Itoa(episode.ID)); torrent != nil {
 			rURL := URLQuery(URLForXBMC("/play"),
 				"doresume", doresume,
@@ -1,18 +1,17 @@
 package api
 
 import (
-	"compress/gzip"
 	"fmt"
-	"io"
 	"net/url"
 	"os"
 	"path/filepath"
-	"sort"
 	"strconv"
 	"strings"
 
-	"github.com/elgatito/elementum/scrape"
+	"github.com/elgatito/elementum/bittorrent"
+	"github.com/elgatito/elementum/subtitles"
 	"github.com/elgatito/elementum/tmdb"
+	"github.com/elgatito/elementum/tracks"
 
 	"github.com/elgatito/elementum/config"
 	"github.com/elgatito/elementum/osdb"
@@ -24,64 +23,60 @@ import (
 
 var subLog = logging.MustGetLogger("subtitles")
 
-func appendLocalFilePayloads(playingFile string, payloads *[]osdb.SearchPayload) error {
+func appendLocalFileQuery(playingFile string, query *subtitles.Query) error {
 	file, err := os.Open(playingFile)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	hashPayload := osdb.SearchPayload{}
 	if h, err := osdb.HashFile(file); err == nil {
-		hashPayload.Hash = h
+		query.Hash = h
 	}
 	if s, err := file.Stat(); err == nil {
-		hashPayload.Size = s.Size()
+		query.Size = s.Size()
 	}
-
-	*payloads = append(*payloads, []osdb.SearchPayload{
-		hashPayload,
-		{Query: strings.Replace(filepath.Base(playingFile), filepath.Ext(playingFile), "", -1)},
-	}...)
+	query.FilePath = playingFile
+	query.FileName = filepath.Base(playingFile)
 
 	return nil
 }
 
-func appendMoviePayloads(labels map[string]string, payloads *[]osdb.SearchPayload) error {
+func appendMovieQuery(labels map[string]string, query *subtitles.Query) error {
 	title := labels["VideoPlayer.OriginalTitle"]
 	if title == "" {
 		title = labels["VideoPlayer.Title"]
 	}
-	*payloads = append(*payloads, osdb.SearchPayload{
-		Query: fmt.Sprintf("%s %s", title, labels["VideoPlayer.Year"]),
-	})
+	query.Title = title
+	query.Year = labels["VideoPlayer.Year"]
 	return nil
 }
 
-func appendEpisodePayloads(labels map[string]string, payloads *[]osdb.SearchPayload) error {
-	season := -1
+func appendEpisodeQuery(labels map[string]string, query *subtitles.Query) error {
+	query.ShowTitle = labels["VideoPlayer.TVshowtitle"]
+	query.Season = -1
 	if labels["VideoPlayer.Season"] != "" {
 		if s, err := strconv.Atoi(labels["VideoPlayer.Season"]); err == nil {
-			season = s
+			query.Season = s
 		}
 	}
-	episode := -1
+	query.Episode = -1
 	if labels["VideoPlayer.Episode"] != "" {
 		if e, err := strconv.Atoi(labels["VideoPlayer.Episode"]); err == nil {
-			episode = e
+			query.Episode = e
 		}
 	}
-	if season >= 0 && episode > 0 {
-		searchString := fmt.Sprintf("%s S%02dE%02d", labels["VideoPlayer.TVshowtitle"], season, episode)
-		*payloads = append(*payloads, osdb.SearchPayload{
-			Query: searchString,
-		})
-	}
 	return nil
 }
 
 // SubtitlesIndex ...
-func SubtitlesIndex(ctx *gin.Context) {
+func SubtitlesIndex(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		subtitlesIndex(btService, ctx)
+	}
+}
+
+func subtitlesIndex(btService *bittorrent.BTService, ctx *gin.Context) {
 	q := ctx.Request.URL.Query()
 	searchString := q.Get("searchstring")
 	languages := strings.Split(q.Get("languages"), ",")
@@ -93,12 +88,9 @@ func SubtitlesIndex(ctx *gin.Context) {
 		languages = []string{config.Get().OSDBLanguage}
 	}
 
-	// If there is preferred language - we should use it
+	// If there is preferred language - we should use it, results are ranked by the order of languages
 	if preferredLanguage != "" && preferredLanguage != "Unknown" && !contains(languages, preferredLanguage) {
 		languages = append([]string{preferredLanguage}, languages...)
-		preferredLanguage = strings.ToLower(preferredLanguage)
-	} else {
-		preferredLanguage = ""
 	}
 
 	labels := xbmc.InfoLabels(
@@ -130,16 +122,16 @@ func SubtitlesIndex(ctx *gin.Context) {
 		}
 	}
 
-	payloads := []osdb.SearchPayload{}
+	query := &subtitles.Query{
+		Languages: languages,
+	}
 	if searchString != "" {
-		payloads = append(payloads, osdb.SearchPayload{
-			Query:     searchString,
-			Languages: strings.Join(languages, ","),
-		})
+		query.Text = searchString
 	} else {
 		// If player ListItem has IMDBNumber specified - we try to get TMDB item from it.
 		// If not - we can use localized show/movie name - which is not always found on OSDB.
 		if strings.HasPrefix(labels["VideoPlayer.IMDBNumber"], "tt") {
+			query.IMDBId = labels["VideoPlayer.IMDBNumber"]
 			if labels["VideoPlayer.TVshowtitle"] != "" {
 				r := tmdb.Find(labels["VideoPlayer.IMDBNumber"], "imdb_id")
 				if r != nil && len(r.TVResults) > 0 {
@@ -154,68 +146,43 @@ func SubtitlesIndex(ctx *gin.Context) {
 		}
 
 		if strings.HasPrefix(playingFile, "http://") == false && strings.HasPrefix(playingFile, "https://") == false {
-			appendLocalFilePayloads(playingFile, &payloads)
+			appendLocalFileQuery(playingFile, query)
 		}
 
 		if labels["VideoPlayer.TVshowtitle"] != "" {
-			appendEpisodePayloads(labels, &payloads)
+			appendEpisodeQuery(labels, query)
 		} else {
-			appendMoviePayloads(labels, &payloads)
+			appendMovieQuery(labels, query)
 		}
 	}
 
-	for i, payload := range payloads {
-		payload.Languages = strings.Join(languages, ",")
-		payloads[i] = payload
-	}
-
-	subLog.Infof("Subtitles payload: %+v", payloads)
-
-	client, err := osdb.NewClient()
-	if err != nil {
-		subLog.Error(err)
-		ctx.String(200, err.Error())
-		return
-	}
-	if err := client.LogIn(config.Get().OSDBUser, config.Get().OSDBPass, config.Get().OSDBLanguage); err != nil {
-		subLog.Error(err)
-		ctx.String(200, err.Error())
-		return
-	}
+	subLog.Infof("Subtitles query: %+v", query)
 
-	items := make(xbmc.ListItems, 0)
-	results, _ := client.SearchSubtitles(payloads)
-
-	// If needed - try to manually sort items
-	if preferredLanguage != "" {
-		sort.Slice(results, func(i, j int) bool {
-			id := strings.ToLower(results[i].LanguageName) == preferredLanguage
-			return id
-		})
-	}
+	// Embedded subtitles of the playing torrent file are always in sync, so they go first
+	items := embeddedSubtitleItems(btService)
 
-	for _, sub := range results {
-		rating, _ := strconv.ParseFloat(sub.SubRating, 64)
+	for _, sub := range subtitles.Search(subtitles.Enabled(), query) {
 		subLang := sub.LanguageName
 		if subLang == "Brazilian" {
 			subLang = "Portuguese (Brazil)"
 		}
 		item := &xbmc.ListItem{
 			Label:     subLang,
-			Label2:    sub.SubFileName,
-			Icon:      strconv.Itoa(int((rating / 2) + 0.5)),
+			Label2:    sub.FileName,
+			Icon:      strconv.Itoa(int((sub.Rating / 2) + 0.5)),
 			Thumbnail: sub.ISO639,
-			Path: URLQuery(URLForXBMC("/subtitle/%s", sub.IDSubtitleFile),
-				"file", sub.SubFileName,
-				"lang", sub.SubLanguageID,
-				"fmt", sub.SubFormat,
-				"dl", sub.SubDownloadLink),
+			Path: URLQuery(URLForXBMC("/subtitle/%s", url.PathEscape(sub.ID)),
+				"provider", sub.Provider,
+				"file", sub.FileName,
+				"lang", sub.Language,
+				"fmt", sub.Format,
+				"dl", sub.URL),
 			Properties: make(map[string]string),
 		}
-		if sub.MatchedBy == "moviehash" {
+		if sub.HashMatch {
 			item.Properties["sync"] = trueType
 		}
-		if sub.SubHearingImpaired == "1" {
+		if sub.HearingImpaired {
 			item.Properties["hearing_imp"] = trueType
 		}
 		items = append(items, item)
@@ -224,51 +191,145 @@ func SubtitlesIndex(ctx *gin.Context) {
 	ctx.JSON(200, xbmc.NewView("", items))
 }
 
-// SubtitleGet ...
+// SubtitleGet downloads subtitle with provider of the search result
 func SubtitleGet(ctx *gin.Context) {
 	q := ctx.Request.URL.Query()
-	file := q.Get("file")
-	dl := q.Get("dl")
+	file := filepath.Base(q.Get("file"))
+
+	sub := &subtitles.Subtitle{
+		Provider: q.Get("provider"),
+		ID:       ctx.Params.ByName("id"),
+		FileName: file,
+		Language: q.Get("lang"),
+		Format:   q.Get("fmt"),
+		URL:      q.Get("dl"),
+	}
+	// Links, made before providers, are for OpenSubtitles
+	if sub.Provider == "" {
+		sub.Provider = (&subtitles.OpenSubtitles{}).Name()
+	}
 
-	resp, err := scrape.GetClient().Get(dl)
+	outFile, err := os.Create(filepath.Join(subtitles.DownloadPath(), file))
 	if err != nil {
 		subLog.Error(err)
 		ctx.String(200, err.Error())
 		return
 	}
-	defer resp.Body.Close()
+	defer outFile.Close()
 
-	reader, err := gzip.NewReader(resp.Body)
-	if err != nil {
+	if err := subtitles.Download(sub, outFile); err != nil {
 		subLog.Error(err)
 		ctx.String(200, err.Error())
 		return
 	}
-	defer reader.Close()
 
-	subtitlesPath := filepath.Join(config.Get().DownloadPath, "Subtitles")
-	if config.Get().DownloadPath == "." {
-		subtitlesPath = filepath.Join(config.Get().TemporaryPath, "Subtitles")
+	ctx.JSON(200, xbmc.NewView("", xbmc.ListItems{
+		{Label: file, Path: outFile.Name()},
+	}))
+}
+
+// EmbeddedSubtitles lists subtitle tracks, embedded into MKV or MP4 torrent file
+func EmbeddedSubtitles(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+
+		list, err := bittorrent.GetEmbeddedSubtitles(btService, ctx.Params.ByName("infohash"), ctx.Params.ByName("file"))
+		if err != nil {
+			ctx.JSON(404, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx.JSON(200, list)
 	}
-	if _, errStat := os.Stat(subtitlesPath); os.IsNotExist(errStat) {
-		if errMk := os.Mkdir(subtitlesPath, 0755); errMk != nil {
-			subLog.Error("Unable to create Subtitles folder")
+}
+
+// EmbeddedSubtitleGet extracts embedded text subtitle track into Subtitles folder
+func EmbeddedSubtitleGet(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		infoHash := ctx.Params.ByName("infohash")
+		file := ctx.Params.ByName("file")
+		number, _ := strconv.Atoi(ctx.Params.ByName("track"))
+
+		list, err := bittorrent.GetEmbeddedSubtitles(btService, infoHash, file)
+		if err != nil {
+			subLog.Error(err)
+			ctx.String(200, err.Error())
+			return
+		}
+
+		var track *tracks.Track
+		for _, t := range list {
+			if t.Number == number {
+				track = t
+			}
+		}
+		if track == nil || !track.Text {
+			ctx.String(200, tracks.ErrNotText.Error())
+			return
+		}
+
+		fileName := fmt.Sprintf("%s.%s.%d.%s.%s", infoHash, file, track.Number, track.Language, track.Format)
+		outFile, err := os.Create(filepath.Join(subtitles.DownloadPath(), fileName))
+		if err != nil {
+			subLog.Error(err)
+			ctx.String(200, err.Error())
+			return
+		}
+		defer outFile.Close()
+
+		if _, err := bittorrent.ExtractEmbeddedSubtitle(btService, infoHash, file, track.Number, outFile); err != nil {
+			subLog.Errorf("Could not extract subtitle track %d: %s", track.Number, err)
+			ctx.String(200, err.Error())
+			return
 		}
+
+		ctx.JSON(200, xbmc.NewView("", xbmc.ListItems{
+			{Label: fileName, Path: outFile.Name()},
+		}))
+	}
+}
+
+// embeddedSubtitleItems returns text subtitle tracks of the active player's file
+func embeddedSubtitleItems(btService *bittorrent.BTService) xbmc.ListItems {
+	items := make(xbmc.ListItems, 0)
+
+	player := btService.GetActivePlayer()
+	if player == nil || player.Torrent == nil {
+		return items
+	}
+	infoHash := player.Torrent.InfoHash()
+	file := player.ChosenFileIndex()
+	if file == "" {
+		return items
 	}
 
-	outFile, err := os.Create(filepath.Join(subtitlesPath, file))
+	list, err := bittorrent.GetEmbeddedSubtitles(btService, infoHash, file)
 	if err != nil {
-		subLog.Error(err)
-		ctx.String(200, err.Error())
-		return
+		subLog.Debugf("No embedded subtitles: %s", err)
+		return items
 	}
-	defer outFile.Close()
 
-	io.Copy(outFile, reader)
+	for _, t := range list {
+		if !t.Text {
+			continue
+		}
 
-	ctx.JSON(200, xbmc.NewView("", xbmc.ListItems{
-		{Label: file, Path: outFile.Name()},
-	}))
+		label2 := fmt.Sprintf("#%d %s", t.Number, strings.ToUpper(t.Format))
+		if t.Name != "" {
+			label2 = fmt.Sprintf("#%d %s (%s)", t.Number, t.Name, strings.ToUpper(t.Format))
+		}
+		item := &xbmc.ListItem{
+			Label:      xbmc.ConvertLanguage(t.Language, xbmc.EnglishName),
+			Label2:     label2,
+			Icon:       "5",
+			Thumbnail:  xbmc.ConvertLanguage(t.Language, xbmc.Iso639_1),
+			Path:       URLForXBMC("/subtitles/embedded/%s/%s/%d", infoHash, file, t.Number),
+			Properties: map[string]string{"sync": trueType},
+		}
+		items = append(items, item)
+	}
+
+	return items
 }
 
 func contains(s []string, e string) bool {
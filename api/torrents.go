@@ -10,6 +10,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/dustin/go-humanize"
 	"github.com/gin-gonic/gin"
@@ -29,22 +30,27 @@ var (
 
 // TorrentsWeb ...
 type TorrentsWeb struct {
-	ID            string  `json:"id"`
-	Name          string  `json:"name"`
-	Size          string  `json:"size"`
-	Status        string  `json:"status"`
-	Progress      float64 `json:"progress"`
-	Ratio         float64 `json:"ratio"`
-	TimeRatio     float64 `json:"time_ratio"`
-	SeedingTime   string  `json:"seeding_time"`
-	SeedTime      float64 `json:"seed_time"`
-	SeedTimeLimit int     `json:"seed_time_limit"`
-	DownloadRate  float64 `json:"download_rate"`
-	UploadRate    float64 `json:"upload_rate"`
-	Seeders       int     `json:"seeders"`
-	SeedersTotal  int     `json:"seeders_total"`
-	Peers         int     `json:"peers"`
-	PeersTotal    int     `json:"peers_total"`
+	ID             string  `json:"id"`
+	Name           string  `json:"name"`
+	Size           string  `json:"size"`
+	Status         string  `json:"status"`
+	Progress       float64 `json:"progress"`
+	Ratio          float64 `json:"ratio"`
+	RatioLimit     int     `json:"ratio_limit"`
+	TimeRatio      float64 `json:"time_ratio"`
+	TimeRatioLimit int     `json:"time_ratio_limit"`
+	SeedingTime    string  `json:"seeding_time"`
+	SeedTime       float64 `json:"seed_time"`
+	SeedTimeLimit  int     `json:"seed_time_limit"`
+	SeedProgress   float64 `json:"seed_progress"`
+	DownloadRate   float64 `json:"download_rate"`
+	UploadRate     float64 `json:"upload_rate"`
+	Seeders        int     `json:"seeders"`
+	SeedersTotal   int     `json:"seeders_total"`
+	Peers          int     `json:"peers"`
+	PeersTotal     int     `json:"peers_total"`
+
+	PostProcess []*database.PostProcessStep `json:"post_process"`
 }
 
 // AddToTorrentsMap ...
@@ -54,7 +60,7 @@ func AddToTorrentsMap(tmdbID string, torrent *bittorrent.TorrentFile) {
 		if b, err := torrent.MarshalJSON(); err == nil {
 			database.Get().AddTorrentHistory(tmdbID, torrent.InfoHash, b)
 		}
-		
+
 		return
 	}
 
@@ -135,6 +141,12 @@ func SetCachedTorrents(tmdbID string, torrents []*bittorrent.TorrentFile) error
 func ListTorrents(btService *bittorrent.BTService) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		items := make(xbmc.ListItems, 0, len(btService.Torrents))
+		if len(database.Get().GetPostProcessedItems()) > 0 {
+			items = append(items, &xbmc.ListItem{
+				Label: "LOCALIZE[30511]",
+				Path:  URLForXBMC("/torrents/completed"),
+			})
+		}
 		if len(btService.Torrents) == 0 {
 			ctx.JSON(200, xbmc.NewView("", items))
 			return
@@ -210,6 +222,11 @@ func ListTorrents(btService *bittorrent.BTService) gin.HandlerFunc {
 				"season", season,
 				"episode", episode)
 
+			if status == statusSeeding {
+				seed := torrent.GetSeedStatus()
+				status = fmt.Sprintf("%s %.0f%%", status, seed.Progress)
+			}
+
 			item := xbmc.ListItem{
 				Label: fmt.Sprintf("%.2f%% - [COLOR %s]%s[/COLOR] - %s", progress, color, status, torrentName),
 				Path:  playURL,
@@ -217,6 +234,11 @@ func ListTorrents(btService *bittorrent.BTService) gin.HandlerFunc {
 					Title: torrentName,
 				},
 			}
+			if steps := torrent.GetPostProcessSteps(); len(steps) > 0 {
+				label, plot := postProcessInfo(steps)
+				item.Label += " - " + label
+				item.Info.Plot = plot
+			}
 			item.ContextMenu = [][]string{
 				[]string{"LOCALIZE[30230]", fmt.Sprintf("XBMC.PlayMedia(%s)", playURL)},
 				torrentAction,
@@ -225,6 +247,13 @@ func ListTorrents(btService *bittorrent.BTService) gin.HandlerFunc {
 				[]string{"LOCALIZE[30308]", fmt.Sprintf("XBMC.RunPlugin(%s)", URLForXBMC("/torrents/move/%s", i))},
 				sessionAction,
 			}
+			if btService.Queue.Has(i) {
+				item.ContextMenu = append(item.ContextMenu,
+					[]string{"LOCALIZE[30500]", fmt.Sprintf("XBMC.RunPlugin(%s)", URLForXBMC("/torrents/queue/promote/%s", i))},
+					[]string{"LOCALIZE[30501]", fmt.Sprintf("XBMC.RunPlugin(%s)", URLQuery(URLForXBMC("/torrents/queue/move/%s", i), "direction", "up"))},
+					[]string{"LOCALIZE[30502]", fmt.Sprintf("XBMC.RunPlugin(%s)", URLQuery(URLForXBMC("/torrents/queue/move/%s", i), "direction", "down"))},
+				)
+			}
 			item.IsPlayable = true
 			items = append(items, &item)
 		}
@@ -272,16 +301,27 @@ func ListTorrentsWeb(btService *bittorrent.BTService) gin.HandlerFunc {
 			peers := stats.ActivePeers
 			peersTotal := stats.TotalPeers
 
+			seed := torrent.GetSeedStatus()
+
 			t := TorrentsWeb{
-				ID:           torrent.InfoHash(),
-				Name:         torrentName,
-				Size:         size,
-				Status:       status,
-				Progress:     progress,
-				DownloadRate: downloadRate,
-				UploadRate:   uploadRate,
-				Peers:        peers,
-				PeersTotal:   peersTotal,
+				ID:             torrent.InfoHash(),
+				Name:           torrentName,
+				Size:           size,
+				Status:         status,
+				Progress:       progress,
+				Ratio:          seed.Ratio,
+				RatioLimit:     seed.Policy.RatioLimit,
+				TimeRatio:      seed.TimeRatio,
+				TimeRatioLimit: seed.Policy.TimeRatioLimit,
+				SeedingTime:    seed.SeedTime.Round(time.Second).String(),
+				SeedTime:       seed.SeedTime.Hours(),
+				SeedTimeLimit:  seed.Policy.SeedTimeLimit,
+				SeedProgress:   seed.Progress,
+				DownloadRate:   downloadRate,
+				UploadRate:     uploadRate,
+				Peers:          peers,
+				PeersTotal:     peersTotal,
+				PostProcess:    torrent.GetPostProcessSteps(),
 			}
 			torrents = append(torrents, &t)
 
@@ -293,6 +333,65 @@ func ListTorrentsWeb(btService *bittorrent.BTService) gin.HandlerFunc {
 	}
 }
 
+// ListCompletedTorrents shows torrents, removed from the client after post-processing, with results of the steps
+func ListCompletedTorrents(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		completed := database.Get().GetPostProcessedItems()
+		items := make(xbmc.ListItems, 0, len(completed))
+
+		for _, c := range completed {
+			label, plot := postProcessInfo(c.Steps)
+			item := &xbmc.ListItem{
+				Label: fmt.Sprintf("%s - %s", c.Name, label),
+				Info: &xbmc.ListItemInfo{
+					Title: c.Name,
+					Plot:  plot,
+				},
+				ContextMenu: [][]string{
+					[]string{"LOCALIZE[30512]", fmt.Sprintf("XBMC.RunPlugin(%s)", URLForXBMC("/torrents/completed/forget/%s", c.InfoHash))},
+				},
+			}
+			items = append(items, item)
+		}
+
+		ctx.JSON(200, xbmc.NewView("", items))
+	}
+}
+
+// ForgetCompletedTorrent removes results of post-processing of the torrent from completed items
+func ForgetCompletedTorrent(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		infoHash := ctx.Params.ByName("infohash")
+		if err := database.Get().DeletePostProcessSteps(infoHash); err != nil {
+			ctx.Error(err)
+			return
+		}
+
+		xbmc.Refresh()
+		ctx.String(200, "")
+	}
+}
+
+// postProcessInfo returns short label with post-processing steps, failed steps are red,
+// and messages of the steps
+func postProcessInfo(steps []*database.PostProcessStep) (label string, plot string) {
+	names := make([]string, 0, len(steps))
+	messages := make([]string, 0, len(steps))
+	for _, step := range steps {
+		switch step.Status {
+		case database.PostProcessFailed:
+			names = append(names, fmt.Sprintf("[COLOR red]%s[/COLOR]", step.Step))
+		case database.PostProcessSkipped:
+			names = append(names, fmt.Sprintf("[COLOR grey]%s[/COLOR]", step.Step))
+		default:
+			names = append(names, step.Step)
+		}
+		messages = append(messages, fmt.Sprintf("%s: %s", step.Step, step.Message))
+	}
+
+	return strings.Join(names, ", "), strings.Join(messages, "\n")
+}
+
 // PauseSession ...
 func PauseSession(btService *bittorrent.BTService) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
@@ -334,7 +433,19 @@ func AddTorrent(btService *bittorrent.BTService) gin.HandlerFunc {
 		}
 		torrentsLog.Infof("Adding torrent from %s", uri)
 
-		_, err := btService.AddTorrent(uri)
+		options, err := storageOptions(ctx)
+		if err != nil {
+			ctx.String(404, err.Error())
+			return
+		}
+
+		// Torrents are put into download queue only when asked
+		if queue, _ := strconv.ParseBool(ctx.Request.FormValue("queue")); queue {
+			priority, _ := strconv.Atoi(ctx.Request.FormValue("priority"))
+			_, err = btService.QueueTorrent(uri, priority, options)
+		} else {
+			_, err = btService.AddTorrent(uri, options)
+		}
 		if err != nil {
 			ctx.String(404, err.Error())
 			return
@@ -347,6 +458,32 @@ func AddTorrent(btService *bittorrent.BTService) gin.HandlerFunc {
 	}
 }
 
+// storageOptions returns storage, selected for new torrent with "storage" type and "storage_path",
+// without them the torrent is placed on download volume for the "type" of media
+func storageOptions(ctx *gin.Context) (*bittorrent.StorageOptions, error) {
+	storage := ctx.Request.FormValue("storage")
+	path := ctx.Request.FormValue("storage_path")
+	if storage == "" && path == "" {
+		return bittorrent.AutoStorage(ctx.Request.FormValue("type")), nil
+	}
+
+	storageType := -1
+	if storage != "" {
+		var err error
+		if storageType, err = strconv.Atoi(storage); err != nil {
+			return nil, fmt.Errorf("Wrong storage type %s", storage)
+		}
+	}
+
+	if path != "" {
+		if err := config.IsWritablePath(path); err != nil {
+			return nil, err
+		}
+	}
+
+	return bittorrent.NewStorageOptions(storageType, path)
+}
+
 // ResumeTorrent ...
 func ResumeTorrent(btService *bittorrent.BTService) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
@@ -376,6 +513,8 @@ func MoveTorrent(btService *bittorrent.BTService) gin.HandlerFunc {
 		}
 
 		torrentsLog.Infof("Marking %s to be moved...", torrent.Name())
+		// Processing is started again, even if it was done before
+		database.Get().DeletePostProcessSteps(torrent.InfoHash())
 		btService.MarkedToMove = torrent.InfoHash()
 
 		xbmc.Refresh()
@@ -446,6 +585,180 @@ func RemoveTorrent(btService *bittorrent.BTService) gin.HandlerFunc {
 	}
 }
 
+// TorrentSeedPolicy shows seeding status of the torrent and changes its limits.
+// Limits are taken from query params, -1 means global setting is used,
+// "reset" param removes all overrides.
+func TorrentSeedPolicy(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		torrentID := ctx.Params.ByName("torrentId")
+		torrent, err := GetTorrentFromParam(btService, torrentID)
+		if err != nil {
+			ctx.Error(fmt.Errorf("Unable to find torrent with index %s", torrentID))
+			return
+		}
+
+		if ctx.Query("reset") != "" {
+			err = btService.SetSeedPolicy(torrent, nil)
+		} else if ctx.Query("ratio") != "" || ctx.Query("time") != "" || ctx.Query("time_ratio") != "" || ctx.Query("action") != "" {
+			policy := database.Get().GetSeedPolicy(torrent.InfoHash())
+			if policy == nil {
+				policy = &database.SeedPolicy{RatioLimit: -1, SeedTimeLimit: -1, TimeRatioLimit: -1, Action: -1}
+			}
+
+			if v, errConv := strconv.Atoi(ctx.Query("ratio")); errConv == nil {
+				policy.RatioLimit = v
+			}
+			if v, errConv := strconv.Atoi(ctx.Query("time")); errConv == nil {
+				policy.SeedTimeLimit = v
+			}
+			if v, errConv := strconv.Atoi(ctx.Query("time_ratio")); errConv == nil {
+				policy.TimeRatioLimit = v
+			}
+			if v, errConv := strconv.Atoi(ctx.Query("action")); errConv == nil {
+				policy.Action = v
+			}
+
+			err = btService.SetSeedPolicy(torrent, policy)
+		}
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+
+		ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+		ctx.JSON(200, torrent.GetSeedStatus())
+	}
+}
+
+// ListQueue returns download queue items in the order of processing
+func ListQueue(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		type queueItem struct {
+			*bittorrent.QueueItem
+			Status   string  `json:"status"`
+			Progress float64 `json:"progress"`
+		}
+
+		items := btService.Queue.Items()
+		ret := make([]*queueItem, 0, len(items))
+		for _, i := range items {
+			item := &queueItem{
+				QueueItem: i,
+				Status:    bittorrent.QueueStateStrings[i.State],
+			}
+			if t, ok := btService.Torrents[i.InfoHash]; ok && t != nil {
+				item.Progress = t.GetProgress()
+			}
+			ret = append(ret, item)
+		}
+
+		ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+		ctx.JSON(200, ret)
+	}
+}
+
+// MoveQueueTorrent changes position of torrent in download queue,
+// either to exact position or one step up/down.
+func MoveQueueTorrent(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		torrentID := ctx.Params.ByName("torrentId")
+
+		position := -1
+		for _, i := range btService.Queue.Items() {
+			if i.InfoHash == torrentID {
+				position = i.Position
+			}
+		}
+		if position < 0 {
+			ctx.Error(fmt.Errorf("Unable to move queued torrent with index %s", torrentID))
+			return
+		}
+
+		switch ctx.Query("direction") {
+		case "up":
+			position--
+		case "down":
+			position++
+		default:
+			if p, err := strconv.Atoi(ctx.Query("position")); err == nil {
+				position = p
+			}
+		}
+
+		if err := btService.Queue.Move(torrentID, position); err != nil {
+			ctx.Error(err)
+			return
+		}
+
+		xbmc.Refresh()
+		ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+		ctx.String(200, "")
+	}
+}
+
+// PriorityQueueTorrent changes priority of torrent in download queue
+func PriorityQueueTorrent(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		torrentID := ctx.Params.ByName("torrentId")
+		priority, err := strconv.Atoi(ctx.Query("priority"))
+		if err != nil {
+			ctx.Error(fmt.Errorf("Wrong priority for queued torrent with index %s", torrentID))
+			return
+		}
+
+		if err := btService.Queue.SetPriority(torrentID, priority); err != nil {
+			ctx.Error(err)
+			return
+		}
+
+		xbmc.Refresh()
+		ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+		ctx.String(200, "")
+	}
+}
+
+// PauseQueueTorrent ...
+func PauseQueueTorrent(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if err := btService.Queue.Pause(ctx.Params.ByName("torrentId")); err != nil {
+			ctx.Error(err)
+			return
+		}
+
+		xbmc.Refresh()
+		ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+		ctx.String(200, "")
+	}
+}
+
+// ResumeQueueTorrent ...
+func ResumeQueueTorrent(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if err := btService.Queue.Resume(ctx.Params.ByName("torrentId")); err != nil {
+			ctx.Error(err)
+			return
+		}
+
+		xbmc.Refresh()
+		ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+		ctx.String(200, "")
+	}
+}
+
+// PromoteQueueTorrent ...
+func PromoteQueueTorrent(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if err := btService.Queue.Promote(ctx.Params.ByName("torrentId")); err != nil {
+			ctx.Error(err)
+			return
+		}
+
+		xbmc.Refresh()
+		ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+		ctx.String(200, "")
+	}
+}
+
 // Versions ...
 func Versions(btService *bittorrent.BTService) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
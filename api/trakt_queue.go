@@ -0,0 +1,46 @@
+package api
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/elgatito/elementum/trakt"
+)
+
+// TraktQueue shows pending and failed requests, queued while Trakt was not reachable
+func TraktQueue(ctx *gin.Context) {
+	ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	ctx.JSON(200, trakt.GetOutbox())
+}
+
+// RetryTraktQueue sends queued request immediately, or all of them, if id is not set
+func RetryTraktQueue(ctx *gin.Context) {
+	ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+
+	id, _ := strconv.Atoi(ctx.Params.ByName("id"))
+	sent, err := trakt.RetryOutbox(id)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(200, gin.H{"sent": sent, "pending": trakt.GetOutbox().Pending})
+}
+
+// DeleteTraktQueue removes queued request
+func DeleteTraktQueue(ctx *gin.Context) {
+	ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+
+	id, err := strconv.Atoi(ctx.Params.ByName("id"))
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+	if err := trakt.DeleteOutbox(id); err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(200, trakt.GetOutbox())
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/elgatito/elementum/bittorrent"
+	"github.com/elgatito/elementum/xbmc"
+)
+
+// VolumesStatus shows free space of download volumes and space, taken by torrents
+func VolumesStatus(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+		ctx.JSON(200, btService.GetVolumesStatus())
+	}
+}
+
+// MoveTorrentVolume moves files of the torrent to download volume with "path"
+func MoveTorrentVolume(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+
+		torrentID := ctx.Params.ByName("torrentId")
+		torrent, err := GetTorrentFromParam(btService, torrentID)
+		if err != nil {
+			ctx.Error(fmt.Errorf("Unable to move torrent with index %s", torrentID))
+			return
+		}
+
+		path := ctx.Query("path")
+		if path == "" {
+			ctx.Error(errors.New("Missing volume path"))
+			return
+		}
+
+		if err := btService.MoveTorrentStorage(torrent, path); err != nil {
+			ctx.Error(err)
+			return
+		}
+
+		xbmc.Refresh()
+		ctx.String(200, "")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/anacrolix/sync"
 	gotorrent "github.com/anacrolix/torrent"
 
 	"github.com/elgatito/elementum/bittorrent/reader"
@@ -19,11 +20,25 @@ type FileReader struct {
 	*Torrent
 	*reader.PositionReader
 
+	// mu guards Reader and File, which are replaced when client is recreated
+	mu *sync.Mutex
 	id int64
 }
 
 // NewFileReader ...
 func NewFileReader(t *Torrent, f *gotorrent.File, rmethod string) (*FileReader, error) {
+	fr, err := newFileReader(t, f, rmethod)
+	if err != nil {
+		return nil, err
+	}
+
+	fr.setSubtitles()
+
+	return fr, nil
+}
+
+// newFileReader creates registered reader, without notifying Kodi player
+func newFileReader(t *Torrent, f *gotorrent.File, rmethod string) (*FileReader, error) {
 	fr := &FileReader{
 		Reader:  f.NewReader(),
 		File:    f,
@@ -35,6 +50,7 @@ func NewFileReader(t *Torrent, f *gotorrent.File, rmethod string) (*FileReader,
 			Pieces:      t.Torrent.Info().NumPieces(),
 		},
 
+		mu: &sync.Mutex{},
 		id: time.Now().UTC().UnixNano(),
 	}
 	fr.SetReadahead(1)
@@ -56,8 +72,6 @@ func NewFileReader(t *Torrent, f *gotorrent.File, rmethod string) (*FileReader,
 		t.SetReaders()
 	}
 
-	fr.setSubtitles()
-
 	return fr, nil
 }
 
@@ -65,7 +79,9 @@ func NewFileReader(t *Torrent, f *gotorrent.File, rmethod string) (*FileReader,
 func (fr *FileReader) Close() (err error) {
 	log.Debugf("Closing reader: %#v", fr.id)
 
+	fr.mu.Lock()
 	err = fr.Reader.Close()
+	fr.mu.Unlock()
 	if fr.Service == nil || fr.Service.ShuttingDown {
 		return
 	}
@@ -81,6 +97,9 @@ func (fr *FileReader) Close() (err error) {
 
 // Read ...
 func (fr *FileReader) Read(b []byte) (n int, err error) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
 	n, err = fr.Reader.Read(b)
 	if err == nil {
 		fr.PositionReader.Pos += int64(n)
@@ -91,6 +110,9 @@ func (fr *FileReader) Read(b []byte) (n int, err error) {
 
 // ReadContext ...
 func (fr *FileReader) ReadContext(ctx context.Context, b []byte) (n int, err error) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
 	n, err = fr.Reader.ReadContext(ctx, b)
 	if err == nil {
 		fr.PositionReader.Pos += int64(n)
@@ -101,6 +123,9 @@ func (fr *FileReader) ReadContext(ctx context.Context, b []byte) (n int, err err
 
 // Seek ...
 func (fr *FileReader) Seek(off int64, whence int) (ret int64, err error) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
 	ret, err = fr.Reader.Seek(off, whence)
 	if err == nil {
 		fr.PositionReader.Pos = ret
@@ -111,6 +136,9 @@ func (fr *FileReader) Seek(off int64, whence int) (ret int64, err error) {
 
 // SetReadahead ...
 func (fr *FileReader) SetReadahead(ra int64) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
 	fr.Reader.SetReadahead(ra)
 	fr.PositionReader.Readahead = ra
 }
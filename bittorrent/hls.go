@@ -0,0 +1,174 @@
+package bittorrent
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	gotorrent "github.com/anacrolix/torrent"
+
+	"github.com/elgatito/elementum/config"
+	"github.com/elgatito/elementum/hls"
+)
+
+var (
+	hlsMu      sync.Mutex
+	hlsStreams = map[string]*hlsStream{}
+)
+
+type hlsStream struct {
+	*hls.Stream
+	t *Torrent
+}
+
+// fileSource reads ranges of torrent file for HLS segments and embedded subtitles
+type fileSource struct {
+	t *Torrent
+	f *gotorrent.File
+}
+
+// segmentWriter remembers whether response is started, so errors are not written into segment data
+type segmentWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (w *segmentWriter) Write(b []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(b)
+}
+
+type fileSourceReader struct {
+	io.Reader
+	fr *FileReader
+}
+
+// ServeHLS remuxes torrent files into HLS, like /hls/<infohash>/<file index>/index.m3u8
+func ServeHLS(s *BTService) http.Handler {
+	return http.StripPrefix("/hls/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !config.Get().HLSEnabled {
+			http.Error(w, "HLS is disabled", http.StatusNotFound)
+			return
+		}
+
+		parts := strings.Split(r.URL.Path, "/")
+		if len(parts) != 3 {
+			http.Error(w, "file not found", http.StatusNotFound)
+			return
+		}
+
+		stream, err := getHLSStream(s, parts[0], parts[1])
+		if err != nil {
+			tfsLog.Warningf("Could not open HLS stream: %s", err)
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		sw := &segmentWriter{ResponseWriter: w}
+
+		name := parts[2]
+		switch {
+		case name == hls.PlaylistName:
+			w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+			err = stream.WritePlaylist(sw)
+		case name == hls.InitName:
+			w.Header().Set("Content-Type", stream.ContentType())
+			err = stream.WriteInit(sw)
+		default:
+			var index int
+			if index, err = stream.ParseSegmentName(name); err != nil {
+				http.Error(w, "file not found", http.StatusNotFound)
+				return
+			}
+
+			tfsLog.Debugf("Serving HLS segment %d of %s", index, stream.t.Name())
+			w.Header().Set("Content-Type", stream.ContentType())
+			err = stream.WriteSegment(sw, index)
+		}
+
+		if err != nil {
+			tfsLog.Warningf("Could not serve HLS %s: %s", name, err)
+			if !sw.written {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+		}
+	}))
+}
+
+// getHLSStream returns cached stream for torrent file, file index is used in the URL
+func getHLSStream(s *BTService, infoHash string, file string) (*hlsStream, error) {
+	t, f, err := getTorrentFile(s, infoHash, file)
+	if err != nil {
+		return nil, err
+	}
+
+	key := infoHash + "/" + file
+
+	hlsMu.Lock()
+	defer hlsMu.Unlock()
+
+	if stream, ok := hlsStreams[key]; ok && stream.t == t {
+		return stream, nil
+	}
+
+	stream, err := hls.NewStream(f.Path(), &fileSource{t: t, f: f})
+	if err != nil {
+		return nil, err
+	}
+
+	hlsStreams[key] = &hlsStream{Stream: stream, t: t}
+	return hlsStreams[key], nil
+}
+
+// getTorrentFile returns torrent and its file, file index is used in the URL
+func getTorrentFile(s *BTService, infoHash string, file string) (*Torrent, *gotorrent.File, error) {
+	s.mu.Lock()
+	t, ok := s.Torrents[strings.ToLower(infoHash)]
+	s.mu.Unlock()
+	if !ok || t == nil || t.Torrent.Info() == nil {
+		return nil, nil, fmt.Errorf("Torrent %s not found", infoHash)
+	}
+
+	index, err := strconv.Atoi(file)
+	files := t.Files()
+	if err != nil || index < 0 || index >= len(files) {
+		return nil, nil, fmt.Errorf("File %s not found", file)
+	}
+
+	return t, files[index], nil
+}
+
+// Length ...
+func (s *fileSource) Length() int64 {
+	return s.f.Length()
+}
+
+// Open seeks a new reader to the range, readahead of the reader covers the whole range,
+// so only pieces, needed for the range, are prioritised
+func (s *fileSource) Open(offset, length int64) (io.ReadCloser, error) {
+	fr, err := newFileReader(s.t, s.f, "GET")
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := fr.Seek(offset, io.SeekStart); err != nil {
+		fr.Close()
+		return nil, err
+	}
+	fr.SetReadahead(length)
+	s.t.SetReaders()
+
+	return &fileSourceReader{
+		Reader: io.LimitReader(fr, length),
+		fr:     fr,
+	}, nil
+}
+
+// Close ...
+func (r *fileSourceReader) Close() error {
+	return r.fr.Close()
+}
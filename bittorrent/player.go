@@ -24,6 +24,7 @@ import (
 	"github.com/elgatito/elementum/config"
 	"github.com/elgatito/elementum/database"
 	"github.com/elgatito/elementum/library"
+	"github.com/elgatito/elementum/numbering"
 	estorage "github.com/elgatito/elementum/storage"
 	"github.com/elgatito/elementum/tmdb"
 	"github.com/elgatito/elementum/trakt"
@@ -32,10 +33,11 @@ import (
 )
 
 const (
-	endBufferSize     int64 = 5400276 // ~5.5mb
-	playbackMaxWait         = 30 * time.Second
-	minCandidateSize        = 100 * 1024 * 1024
-	episodeMatchRegex       = `(?i)(^|\W|_)(S0*?%[1]d\W?E0*?%[2]d|%[1]dx0*?%[2]d)(\W|_)`
+	endBufferSize    int64 = 5400276 // ~5.5mb
+	playbackMaxWait        = 30 * time.Second
+	minCandidateSize       = 100 * 1024 * 1024
+	// traktResumeMinPosition is a position in seconds, after which playback is considered resumed by Kodi
+	traktResumeMinPosition = 10
 )
 
 // BTPlayer ...
@@ -47,6 +49,9 @@ type BTPlayer struct {
 	overlayStatus        *xbmc.OverlayStatus
 	torrentFile          string
 	scrobble             bool
+	syncPlayback         bool
+	traktResume          bool
+	traktChecked         bool
 	deleteAfter          bool
 	keepDownloading      int
 	keepFilesPlaying     int
@@ -111,6 +116,7 @@ func NewBTPlayer(bts *BTService, params PlayerParams) *BTPlayer {
 		keepFilesPlaying:     config.Get().KeepFilesPlaying,
 		keepFilesFinished:    config.Get().KeepFilesFinished,
 		scrobble:             config.Get().Scrobble == true && params.TMDBId > 0 && config.Get().TraktToken != "",
+		syncPlayback:         config.Get().TraktSyncPlayback && params.TMDBId > 0 && config.Get().TraktToken != "",
 		torrentFile:          "",
 		hasChosenFile:        false,
 		fileSize:             0,
@@ -125,7 +131,7 @@ func NewBTPlayer(bts *BTService, params PlayerParams) *BTPlayer {
 
 func (btp *BTPlayer) addTorrent() error {
 	if btp.Torrent == nil {
-		torrent, err := btp.s.AddTorrent(btp.p.URI)
+		torrent, err := btp.s.AddTorrent(btp.p.URI, AutoStorage(btp.p.ContentType))
 		if err != nil {
 			return err
 		}
@@ -181,7 +187,7 @@ func (btp *BTPlayer) Buffer() error {
 }
 
 func (btp *BTPlayer) waitCheckAvailableSpace() {
-	if btp.s.config.DownloadStorage == estorage.StorageMemory {
+	if !estorage.KeepsFiles(btp.Torrent.StorageType()) {
 		return
 	}
 
@@ -227,6 +233,9 @@ func (btp *BTPlayer) onMetadataReceived() {
 
 	infoHash := btp.Torrent.InfoHash()
 
+	// Prefetched episode is being played now, so torrent follows usual rules after playback
+	btp.Torrent.SetPrefetched(false)
+
 	btp.hasChosenFile = true
 	btp.fileSize = btp.chosenFile.Length()
 	btp.fileName = filepath.Base(btp.chosenFile.Path())
@@ -249,6 +258,7 @@ func (btp *BTPlayer) onMetadataReceived() {
 	btp.log.Info("Setting piece priorities")
 
 	go btp.Torrent.Buffer(btp.chosenFile)
+	go btp.fetchSubtitles()
 
 	// TODO find usage of resumeIndex. Do we need pause/resume for it?
 	// if btp.resumeIndex < 0 {
@@ -370,13 +380,20 @@ func (btp *BTPlayer) chooseFile() (*gotorrent.File, error) {
 			//   in the torrent history table
 			go btp.smartMatch(choices)
 
+			// Season pack already knows which file belongs to the episode
+			if f := btp.Torrent.SeasonPackFile(btp.p.ShowID, btp.p.Season, btp.p.Episode); f != nil {
+				return f, nil
+			}
+
 			var lastMatched int
 			var foundMatches int
-			// Case-insensitive, starting with a line-start or non-ascii, can have leading zeros, followed by non-ascii
-			// TODO: Add logic for matching S01E0102 (double episode filename)
-			re := regexp.MustCompile(fmt.Sprintf(episodeMatchRegex, btp.p.Season, btp.p.Episode))
+			// Episode is matched with numbering, selected for the show, multi-episode files are matched as well
+			number := &numbering.Number{Season: btp.p.Season, Episode: btp.p.Episode}
+			if show := tmdb.GetShow(btp.p.ShowID, config.Get().Language); show != nil {
+				number = numbering.Get(show).Episode(btp.p.Season, btp.p.Episode)
+			}
 			for index, choice := range choices {
-				if re.MatchString(choice.Filename) {
+				if number.Match(choice.Filename) {
 					lastMatched = index
 					foundMatches++
 				}
@@ -475,7 +492,16 @@ func (btp *BTPlayer) Close() {
 		}
 	}
 
-	if keepDownloading == false || deleteAnswer == true || btp.notEnoughSpace || btp.s.config.DownloadStorage == estorage.StorageMemory {
+	remove := keepDownloading == false || deleteAnswer == true || btp.notEnoughSpace || !estorage.KeepsFiles(btp.Torrent.StorageType())
+
+	// Torrent with prefetched next episode is kept for the next playback, and is removed later, if the episode is not played
+	if btp.Torrent.IsPrefetched() && !btp.notEnoughSpace {
+		btp.log.Info("Keeping the torrent with prefetched next episode")
+		btp.Torrent.keepPrefetched(remove, btp.deleteAfter || deleteAnswer == true)
+		return
+	}
+
+	if remove {
 		// Delete torrent file
 		if len(btp.torrentFile) > 0 {
 			if _, err := os.Stat(btp.torrentFile); err == nil {
@@ -528,8 +554,8 @@ func (btp *BTPlayer) bufferDialog() {
 				btp.dialogProgress.Update(int(progress), line1, line2, line3)
 
 				if btp.Torrent.IsRarArchive && progress >= 100 {
-					archivePath := filepath.Join(btp.s.config.DownloadPath, btp.chosenFile.Path())
-					destPath := filepath.Join(btp.s.config.DownloadPath, filepath.Dir(btp.chosenFile.Path()), "extracted")
+					archivePath := filepath.Join(btp.Torrent.DownloadPath(), btp.chosenFile.Path())
+					destPath := filepath.Join(btp.Torrent.DownloadPath(), filepath.Dir(btp.chosenFile.Path()), "extracted")
 
 					if _, err := os.Stat(destPath); err == nil {
 						btp.findExtracted(destPath)
@@ -639,6 +665,9 @@ func (btp *BTPlayer) playerLoop() {
 		return
 	}
 
+	// Resume position should be known before Kodi starts the playback
+	btp.GetIdent()
+
 	btp.log.Info("Waiting for playback...")
 	oneSecond := time.NewTicker(1 * time.Second)
 	defer oneSecond.Stop()
@@ -663,9 +692,9 @@ playbackWaitLoop:
 	playing := true
 
 	btp.updateWatchTimes()
-	btp.GetIdent()
 
 	btp.log.Infof("Got playback: %fs / %fs", btp.p.WatchedTime, btp.p.VideoDuration)
+	btp.resumeFromTrakt()
 	if btp.scrobble {
 		trakt.Scrobble("start", btp.p.ContentType, btp.p.TMDBId, btp.p.WatchedTime, btp.p.VideoDuration)
 	}
@@ -690,7 +719,7 @@ playbackLoop:
 				if playing == true {
 					playing = false
 					btp.updateWatchTimes()
-					if btp.scrobble {
+					if btp.scrobble || btp.syncPlayback {
 						trakt.Scrobble("pause", btp.p.ContentType, btp.p.TMDBId, btp.p.WatchedTime, btp.p.VideoDuration)
 					}
 				}
@@ -725,6 +754,9 @@ playbackLoop:
 		btp.UpdateWatched()
 		if btp.scrobble {
 			trakt.Scrobble("stop", btp.p.ContentType, btp.p.TMDBId, btp.p.WatchedTime, btp.p.VideoDuration)
+		} else if btp.syncPlayback && btp.p.VideoDuration > 0 && !btp.IsWatched() {
+			// Pause keeps the position on Trakt, without marking the item as watched
+			trakt.Scrobble("pause", btp.p.ContentType, btp.p.TMDBId, btp.p.WatchedTime, btp.p.VideoDuration)
 		}
 
 		btp.p.Playing = false
@@ -810,11 +842,9 @@ func (btp *BTPlayer) IsWatched() bool {
 	return (100 * btp.p.WatchedTime / btp.p.VideoDuration) > float64(config.Get().PlaybackPercent)
 }
 
+// smartMatch matches torrent files with show episodes, to store found episodes in the torrent history,
+// and remembers torrent as a season pack, if it has files for several episodes of the season
 func (btp *BTPlayer) smartMatch(choices []*candidateFile) {
-	if !config.Get().SmartEpisodeMatch {
-		return
-	}
-
 	var buf bytes.Buffer
 	btp.Torrent.Torrent.Metainfo().Write(&buf)
 	b := buf.Bytes()
@@ -823,28 +853,54 @@ func (btp *BTPlayer) smartMatch(choices []*candidateFile) {
 	if show == nil {
 		return
 	}
+	scheme := numbering.Get(show)
+	files := btp.Torrent.Files()
 
 	for _, season := range show.Seasons {
 		if season.EpisodeCount == 0 {
 			continue
 		}
+		// Without smart match only the playing season is checked for a season pack
+		if !config.Get().SmartEpisodeMatch && season.Season != btp.p.Season {
+			continue
+		}
 		episodes := tmdb.GetSeason(btp.p.ShowID, season.Season, config.Get().Language).Episodes
 
+		pack := &database.SeasonPack{
+			ShowID:   btp.p.ShowID,
+			Season:   season.Season,
+			InfoHash: btp.Torrent.InfoHash(),
+			Metainfo: b,
+			Files:    map[int]string{},
+		}
 		for _, episode := range episodes {
 			if episode == nil {
 				continue
 			}
 
-			re := regexp.MustCompile(fmt.Sprintf(episodeMatchRegex, season.Season, episode.EpisodeNumber))
+			number := scheme.Episode(season.Season, episode.EpisodeNumber)
 			for _, choice := range choices {
-				if re.MatchString(choice.Filename) {
-					database.Get().AddTorrentHistory(strconv.Itoa(episode.ID), btp.Torrent.InfoHash(), b)
+				if number.Match(choice.Filename) {
+					if config.Get().SmartEpisodeMatch {
+						database.Get().AddTorrentHistory(strconv.Itoa(episode.ID), btp.Torrent.InfoHash(), b)
+					}
+					pack.Files[episode.EpisodeNumber] = files[choice.Index].Path()
 				}
 			}
 		}
+
+		if len(pack.Files) > 1 {
+			btp.log.Infof("Remembering season pack with %d episodes for season %d", len(pack.Files), season.Season)
+			database.Get().AddSeasonPack(pack)
+		}
 	}
 }
 
+// Closing is closed, when player is closed
+func (btp *BTPlayer) Closing() <-chan interface{} {
+	return btp.closing
+}
+
 // GetIdent tries to find playing item in Kodi library
 func (btp *BTPlayer) GetIdent() {
 	if btp.p.TMDBId == 0 || btp.p.KodiID != 0 {
@@ -872,5 +928,31 @@ func (btp *BTPlayer) GetIdent() {
 
 	if btp.p.KodiID == 0 {
 		log.Debugf("Can't find %s for these parameters: %+v", btp.p.ContentType, btp.p)
+
+		// Items, not in the library, can be resumed from position, paused on Trakt
+		if btp.syncPlayback && btp.p.Resume == nil && !btp.traktChecked {
+			btp.traktChecked = true
+			if btp.p.ContentType == movieType {
+				btp.p.Resume = library.GetTraktMovieResume(btp.p.TMDBId)
+			} else if btp.p.ContentType == episodeType {
+				btp.p.Resume = library.GetTraktEpisodeResume(btp.p.ShowID, btp.p.Season, btp.p.Episode)
+			}
+			btp.traktResume = btp.p.Resume != nil
+		}
+	}
+}
+
+// resumeFromTrakt seeks to position, paused on Trakt, for items without Kodi bookmark
+func (btp *BTPlayer) resumeFromTrakt() {
+	if !btp.traktResume || !config.Get().PlayResume || btp.p.SkipResume || btp.p.Resume.Position <= 0 {
+		return
+	}
+	// Playback is already resumed from local bookmark
+	if btp.p.WatchedTime > traktResumeMinPosition {
+		return
 	}
+
+	btp.log.Infof("Resuming playback from Trakt position: %fs", btp.p.Resume.Position)
+	xbmc.PlayerSeek(btp.p.Resume.Position)
+	btp.p.Seeked = true
 }
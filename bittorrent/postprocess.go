@@ -0,0 +1,587 @@
+package bittorrent
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elgatito/elementum/config"
+	"github.com/elgatito/elementum/database"
+	"github.com/elgatito/elementum/tmdb"
+	"github.com/elgatito/elementum/util"
+	"github.com/elgatito/elementum/xbmc"
+)
+
+const (
+	stepFiles    = "files"
+	stepSamples  = "skip_samples"
+	stepRename   = "rename"
+	stepTransfer = "transfer"
+	stepNFO      = "nfo"
+	stepScan     = "scan"
+
+	defaultMoviesPattern = "{title} ({year})/{title} ({year})"
+	defaultShowsPattern  = "{title} ({year})/{title} - S{season}E{episode}"
+)
+
+var (
+	rarMatcher    = regexp.MustCompile(`(?i).*\.rar$`)
+	videoMatcher  = regexp.MustCompile(`(?i).*\.(mkv|mp4|mov|avi|m4v|ts|wmv)$`)
+	sampleMatcher = regexp.MustCompile(`(?i)(^|[\W_])sample([\W_]|$)`)
+
+	postProcessing   = map[string]bool{}
+	postProcessingMu sync.Mutex
+)
+
+// postJob is a completed torrent, processed by post-processing steps
+type postJob struct {
+	s        *BTService
+	t        *Torrent
+	item     *database.BTItem
+	infoHash string
+
+	downloadPath string
+	root         string
+	files        []*postFile
+
+	name  string
+	steps []*database.PostProcessStep
+
+	meta *postMeta
+}
+
+// postFile is a file of the torrent, path is relative to download path
+type postFile struct {
+	path      string
+	dst       string
+	extracted bool
+}
+
+// postMeta is used for renaming and NFO files
+type postMeta struct {
+	title        string
+	year         string
+	episodeTitle string
+}
+
+type postStep struct {
+	name    string
+	enabled bool
+	run     func() (int, string)
+}
+
+// beginPostProcess checks whether completed torrent needs processing, torrents are processed once,
+// results are kept until the torrent is removed, or forgotten in completed items, when torrent was moved
+func beginPostProcess(infoHash string) bool {
+	postProcessingMu.Lock()
+	defer postProcessingMu.Unlock()
+
+	if postProcessing[infoHash] || len(database.Get().GetPostProcessSteps(infoHash)) > 0 {
+		return false
+	}
+
+	postProcessing[infoHash] = true
+	return true
+}
+
+func endPostProcess(infoHash string) {
+	postProcessingMu.Lock()
+	defer postProcessingMu.Unlock()
+
+	delete(postProcessing, infoHash)
+}
+
+// GetPostProcessSteps returns results of post-processing of the torrent
+func (t *Torrent) GetPostProcessSteps() []*database.PostProcessStep {
+	return database.Get().GetPostProcessSteps(t.infoHash)
+}
+
+// postProcess runs enabled steps for completed torrent, each step result is saved,
+// and failed step stops the processing
+func (s *BTService) postProcess(t *Torrent, item *database.BTItem) {
+	defer endPostProcess(t.infoHash)
+
+	j := &postJob{
+		s:            s,
+		t:            t,
+		item:         item,
+		infoHash:     t.infoHash,
+		name:         t.Name(),
+		downloadPath: t.DownloadPath(),
+	}
+	if item.Type == movieType {
+		j.root = filepath.Dir(s.config.CompletedMoviesPath)
+	} else {
+		j.root = filepath.Dir(s.config.CompletedShowsPath)
+	}
+
+	steps := []postStep{
+		{stepFiles, true, j.collectFiles},
+		{stepSamples, s.config.CompletedSkipSamples, j.skipSamples},
+		{stepRename, s.config.CompletedRename, j.rename},
+		{stepTransfer, true, j.transfer},
+		{stepNFO, s.config.CompletedNFO, j.writeNFO},
+		{stepScan, s.config.CompletedScan, j.scan},
+	}
+
+	for _, step := range steps {
+		if !step.enabled {
+			continue
+		}
+
+		status, message := step.run()
+		j.record(step.name, status, message)
+		if status == database.PostProcessFailed {
+			return
+		}
+	}
+}
+
+func (j *postJob) record(step string, status int, message string) {
+	if status == database.PostProcessFailed {
+		log.Errorf("Post-processing step %s failed for %s: %s", step, j.infoHash, message)
+	} else {
+		log.Infof("Post-processing step %s for %s: %s", step, j.infoHash, message)
+	}
+
+	s := &database.PostProcessStep{
+		Step:    step,
+		Status:  status,
+		Message: message,
+		Updated: time.Now(),
+	}
+	j.steps = append(j.steps, s)
+	database.Get().AddPostProcessStep(j.infoHash, j.name, s)
+}
+
+// collectFiles finds files, chosen for the torrent, and files, extracted from RAR archives
+func (j *postJob) collectFiles() (int, string) {
+	for _, p := range j.item.Files {
+		for _, f := range j.t.Files() {
+			if f.Path() != p {
+				continue
+			}
+
+			pf := &postFile{path: f.Path()}
+			if rarMatcher.MatchString(pf.path) {
+				extracted, err := findExtracted(filepath.Join(j.downloadPath, filepath.Dir(pf.path), "extracted"))
+				if err != nil {
+					return database.PostProcessFailed, err.Error()
+				}
+				pf.path = filepath.Join(filepath.Dir(pf.path), "extracted", extracted)
+				pf.extracted = true
+			}
+			j.files = append(j.files, pf)
+		}
+	}
+
+	if len(j.files) == 0 {
+		return database.PostProcessFailed, fmt.Sprintf("Cannot find saved files: %#v", j.item.Files)
+	}
+	return database.PostProcessDone, fmt.Sprintf("Found %d files", len(j.files))
+}
+
+func findExtracted(path string) (string, error) {
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return "", err
+	}
+	if len(files) == 1 {
+		return files[0].Name(), nil
+	}
+	for _, file := range files {
+		if videoMatcher.MatchString(file.Name()) {
+			return file.Name(), nil
+		}
+	}
+	return "", errors.New("No extracted file to move")
+}
+
+// skipSamples removes sample videos from processed files
+func (j *postJob) skipSamples() (int, string) {
+	files := []*postFile{}
+	skipped := 0
+	for _, f := range j.files {
+		name := strings.TrimSuffix(filepath.Base(f.path), filepath.Ext(f.path))
+		if sampleMatcher.MatchString(name) || sampleMatcher.MatchString(filepath.Base(filepath.Dir(f.path))) {
+			log.Infof("Skipping sample file %s", f.path)
+			skipped++
+			continue
+		}
+		files = append(files, f)
+	}
+
+	if skipped == 0 {
+		return database.PostProcessSkipped, "No sample files"
+	} else if len(files) == 0 {
+		return database.PostProcessFailed, "All files are samples"
+	}
+
+	j.files = files
+	return database.PostProcessDone, fmt.Sprintf("Skipped %d sample files", skipped)
+}
+
+// rename sets destination of files, using pattern of the media type.
+// Files, which would get the same name, like a second video, keep original names
+func (j *postJob) rename() (int, string) {
+	meta, err := j.metadata()
+	if err != nil {
+		return database.PostProcessFailed, err.Error()
+	}
+
+	pattern := j.s.config.CompletedMoviesPattern
+	if pattern == "" {
+		pattern = defaultMoviesPattern
+	}
+	if j.item.Type != movieType {
+		if pattern = j.s.config.CompletedShowsPattern; pattern == "" {
+			pattern = defaultShowsPattern
+		}
+	}
+
+	used := map[string]bool{}
+	for _, f := range j.files {
+		ext := filepath.Ext(f.path)
+		name := strings.TrimSuffix(filepath.Base(f.path), ext)
+		// Values are cleaned before replacing, so titles with slashes don't create folders
+		replacer := strings.NewReplacer(
+			"{title}", util.ToFileName(meta.title),
+			"{year}", meta.year,
+			"{season}", fmt.Sprintf("%02d", j.item.Season),
+			"{episode}", fmt.Sprintf("%02d", j.item.Episode),
+			"{episode_title}", util.ToFileName(meta.episodeTitle),
+			"{name}", name,
+		)
+
+		parts := []string{j.root}
+		for _, part := range strings.Split(replacer.Replace(pattern), "/") {
+			if part = strings.TrimSpace(util.ToFileName(part)); part != "" {
+				parts = append(parts, part)
+			}
+		}
+
+		dst := filepath.Join(parts...) + ext
+		if used[dst] {
+			dst = filepath.Join(filepath.Dir(dst), filepath.Base(f.path))
+		}
+		used[dst] = true
+		f.dst = dst
+	}
+
+	return database.PostProcessDone, fmt.Sprintf("Renamed to %s", j.files[0].dst)
+}
+
+// metadata loads titles from TMDB once
+func (j *postJob) metadata() (*postMeta, error) {
+	if j.meta != nil {
+		return j.meta, nil
+	}
+
+	language := config.Get().Language
+	meta := &postMeta{}
+	if j.item.Type == movieType {
+		movie := tmdb.GetMovie(j.item.ID, language)
+		if movie == nil {
+			return nil, fmt.Errorf("Cannot find movie %d", j.item.ID)
+		}
+		meta.title = movie.Title
+		meta.year = strings.Split(movie.ReleaseDate, "-")[0]
+	} else {
+		if j.item.ShowID == 0 {
+			return nil, errors.New("Missing show of the episode")
+		}
+		show := tmdb.GetShow(j.item.ShowID, language)
+		if show == nil {
+			return nil, fmt.Errorf("Cannot find show %d", j.item.ShowID)
+		}
+		meta.title = show.Name
+		meta.year = strings.Split(show.FirstAirDate, "-")[0]
+
+		if episode := tmdb.GetEpisode(j.item.ShowID, j.item.Season, j.item.Episode, language); episode != nil {
+			meta.episodeTitle = episode.Name
+		}
+	}
+
+	j.meta = meta
+	return meta, nil
+}
+
+// destination returns path for files without renaming, shows are put in show and season folders
+func (j *postJob) destination(f *postFile) string {
+	if f.dst != "" {
+		return f.dst
+	}
+	if j.item.Type == movieType || j.item.ShowID == 0 {
+		return filepath.Join(j.root, filepath.Base(f.path))
+	}
+
+	meta, err := j.metadata()
+	if err != nil {
+		return filepath.Join(j.root, filepath.Base(f.path))
+	}
+
+	seasonPath := fmt.Sprintf("Season %d", j.item.Season)
+	if j.item.Season == 0 {
+		seasonPath = "Specials"
+	}
+	showPath := util.ToFileName(fmt.Sprintf("%s (%s)", meta.title, meta.year))
+	return filepath.Join(j.root, showPath, seasonPath, filepath.Base(f.path))
+}
+
+// transfer hardlinks files to completed folder, so the torrent keeps seeding,
+// or moves them, when hardlinks are disabled or not supported between folders
+func (j *postJob) transfer() (int, string) {
+	for _, f := range j.files {
+		f.dst = j.destination(f)
+	}
+
+	if j.s.config.CompletedHardlink {
+		err := j.link()
+		if err == nil {
+			return database.PostProcessDone, fmt.Sprintf("Hardlinked %d files to %s", len(j.files), j.root)
+		}
+		log.Infof("Cannot hardlink files of %s, moving them: %s", j.t.Name(), err)
+	}
+
+	// Files are moved before removing the torrent, so it is kept, if any of files can't be moved
+	paused := j.t.IsPaused
+	j.t.Pause()
+	moved, err := j.move()
+	if err != nil {
+		if !paused {
+			j.t.Resume()
+		}
+		return database.PostProcessFailed, err.Error()
+	}
+
+	log.Info("Removing the torrent without deleting files after Completed move ...")
+	if !j.s.RemoveTorrent(j.t, false) {
+		restoreMoved(moved)
+		if !paused {
+			j.t.Resume()
+		}
+		return database.PostProcessFailed, "Cannot remove the torrent"
+	}
+
+	// Results are deleted with the torrent, they are kept to show in completed items
+	for _, s := range j.steps {
+		database.Get().AddPostProcessStep(j.infoHash, j.name, s)
+	}
+
+	// Delete torrent file
+	torrentFile := filepath.Join(j.s.config.TorrentsPath, fmt.Sprintf("%s.torrent", j.infoHash))
+	if _, err := os.Stat(torrentFile); err == nil {
+		log.Info("Deleting torrent file at ", torrentFile)
+		if err := os.Remove(torrentFile); err != nil {
+			log.Error(err)
+		}
+	}
+
+	// Remove leftover folders
+	leftovers := map[string]bool{}
+	for _, f := range j.files {
+		srcPath := filepath.Join(j.downloadPath, f.path)
+		if dirPath := filepath.Dir(f.path); dirPath != "." {
+			leftovers[filepath.Dir(srcPath)] = true
+			if f.extracted {
+				if parentPath := filepath.Clean(filepath.Join(filepath.Dir(srcPath), "..")); parentPath != "." && parentPath != j.downloadPath {
+					leftovers[parentPath] = true
+				}
+			}
+		}
+	}
+	for path := range leftovers {
+		os.RemoveAll(path)
+	}
+
+	log.Infof("Marking %s for removal from library and database...", j.name)
+	database.Get().UpdateStatusBTItem(j.infoHash, Remove)
+
+	return database.PostProcessDone, fmt.Sprintf("Moved %d files to %s", len(j.files), j.root)
+}
+
+// move moves all files to their destinations, or moves them back, if any of files can't be moved.
+// Returns moved paths, mapped to their sources
+func (j *postJob) move() (map[string]string, error) {
+	moved := map[string]string{}
+	for _, f := range j.files {
+		srcPath := filepath.Join(j.downloadPath, f.path)
+		log.Infof("Moving %s to %s", srcPath, f.dst)
+
+		if err := os.MkdirAll(filepath.Dir(f.dst), 0755); err != nil {
+			restoreMoved(moved)
+			return nil, err
+		}
+		dst, err := util.Move(srcPath, f.dst)
+		if err != nil {
+			// Failed move can leave a copy in destination
+			if dst != "" && dst != srcPath {
+				if _, statErr := os.Stat(srcPath); statErr == nil {
+					os.Remove(dst)
+				}
+			}
+			restoreMoved(moved)
+			return nil, err
+		}
+		log.Warning(filepath.Base(f.path), "moved to", dst)
+		moved[dst] = srcPath
+	}
+	return moved, nil
+}
+
+// restoreMoved moves files back to their sources, copies are removed, when source is still there
+func restoreMoved(moved map[string]string) {
+	for dst, src := range moved {
+		if _, err := os.Stat(src); err == nil {
+			os.Remove(dst)
+			continue
+		}
+
+		log.Infof("Moving %s back to %s", dst, src)
+		if _, err := util.Move(dst, src); err != nil {
+			log.Errorf("Cannot move %s back to %s: %s", dst, src, err)
+		}
+	}
+}
+
+// link hardlinks all files, or removes created links, if any of files can't be linked
+func (j *postJob) link() error {
+	linked := []string{}
+	for _, f := range j.files {
+		if err := os.MkdirAll(filepath.Dir(f.dst), 0755); err != nil {
+			return err
+		}
+		if err := os.Link(filepath.Join(j.downloadPath, f.path), f.dst); err != nil {
+			for _, path := range linked {
+				os.Remove(path)
+			}
+			return err
+		}
+		linked = append(linked, f.dst)
+	}
+	return nil
+}
+
+type nfoUniqueID struct {
+	Type    string `xml:"type,attr"`
+	Default bool   `xml:"default,attr"`
+	Value   int    `xml:",chardata"`
+}
+
+type movieNFO struct {
+	XMLName  xml.Name    `xml:"movie"`
+	Title    string      `xml:"title"`
+	Year     string      `xml:"year,omitempty"`
+	UniqueID nfoUniqueID `xml:"uniqueid"`
+}
+
+type showNFO struct {
+	XMLName  xml.Name    `xml:"tvshow"`
+	Title    string      `xml:"title"`
+	Year     string      `xml:"year,omitempty"`
+	UniqueID nfoUniqueID `xml:"uniqueid"`
+}
+
+type episodeNFO struct {
+	XMLName  xml.Name    `xml:"episodedetails"`
+	Title    string      `xml:"title"`
+	Season   int         `xml:"season"`
+	Episode  int         `xml:"episode"`
+	UniqueID nfoUniqueID `xml:"uniqueid"`
+}
+
+// writeNFO writes NFO files with TMDB ids next to videos, and to the show folder
+func (j *postJob) writeNFO() (int, string) {
+	meta, err := j.metadata()
+	if err != nil {
+		return database.PostProcessFailed, err.Error()
+	}
+
+	written := 0
+	for _, f := range j.files {
+		if !videoMatcher.MatchString(f.dst) {
+			continue
+		}
+
+		var nfo interface{}
+		if j.item.Type == movieType {
+			nfo = &movieNFO{Title: meta.title, Year: meta.year, UniqueID: nfoUniqueID{"tmdb", true, j.item.ID}}
+		} else {
+			nfo = &episodeNFO{Title: meta.episodeTitle, Season: j.item.Season, Episode: j.item.Episode, UniqueID: nfoUniqueID{"tmdb", true, j.item.ID}}
+
+			if showDir := j.showDir(f.dst); showDir != "" {
+				showFile := filepath.Join(showDir, "tvshow.nfo")
+				if _, err := os.Stat(showFile); os.IsNotExist(err) {
+					if err := writeXML(showFile, &showNFO{Title: meta.title, Year: meta.year, UniqueID: nfoUniqueID{"tmdb", true, j.item.ShowID}}); err != nil {
+						return database.PostProcessFailed, err.Error()
+					}
+				}
+			}
+		}
+
+		if err := writeXML(strings.TrimSuffix(f.dst, filepath.Ext(f.dst))+".nfo", nfo); err != nil {
+			return database.PostProcessFailed, err.Error()
+		}
+		written++
+	}
+
+	if written == 0 {
+		return database.PostProcessSkipped, "No video files"
+	}
+	return database.PostProcessDone, fmt.Sprintf("Written %d NFO files", written)
+}
+
+// showDir returns top folder of the file in completed shows path
+func (j *postJob) showDir(path string) string {
+	rel, err := filepath.Rel(j.root, path)
+	if err != nil || !strings.Contains(rel, string(filepath.Separator)) {
+		return ""
+	}
+	return filepath.Join(j.root, strings.Split(rel, string(filepath.Separator))[0])
+}
+
+func writeXML(path string, v interface{}) error {
+	out, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append([]byte(xml.Header), out...), 0644)
+}
+
+// scan asks Kodi to scan folders with processed files
+func (j *postJob) scan() (int, string) {
+	dirs := map[string]bool{}
+	for _, f := range j.files {
+		dir := filepath.Dir(f.dst)
+		if j.item.Type != movieType {
+			if showDir := j.showDir(f.dst); showDir != "" {
+				dir = showDir
+			}
+		}
+		if dir == j.root {
+			continue
+		}
+		dirs[dir] = true
+	}
+	if len(dirs) == 0 {
+		dirs[j.root] = true
+	}
+
+	scanned := []string{}
+	for dir := range dirs {
+		log.Infof("Scanning %s for library", dir)
+		if ret := xbmc.VideoLibraryScanDirectory(dir+string(filepath.Separator), false); ret != "OK" {
+			return database.PostProcessFailed, fmt.Sprintf("Scan of %s failed: %s", dir, ret)
+		}
+		scanned = append(scanned, dir)
+	}
+
+	return database.PostProcessDone, "Scanned " + strings.Join(scanned, ", ")
+}
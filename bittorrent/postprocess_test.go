@@ -0,0 +1,156 @@
+package bittorrent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/elgatito/elementum/config"
+	"github.com/elgatito/elementum/database"
+)
+
+func newTestPostJob(root string, c *config.Configuration, item *database.BTItem, paths ...string) *postJob {
+	j := &postJob{
+		s:    &BTService{config: c},
+		item: item,
+		root: root,
+		meta: &postMeta{title: "Show: Name", year: "2019", episodeTitle: "Pilot/Part 1"},
+	}
+	for _, p := range paths {
+		j.files = append(j.files, &postFile{path: p})
+	}
+	return j
+}
+
+func TestPostProcessRename(t *testing.T) {
+	root := t.TempDir()
+
+	tests := []struct {
+		name    string
+		pattern string
+		item    *database.BTItem
+		files   []string
+		want    []string
+	}{
+		{
+			name:  "default movie pattern",
+			item:  &database.BTItem{Type: movieType},
+			files: []string{"Release/movie.mkv"},
+			want:  []string{"Show Name (2019)/Show Name (2019).mkv"},
+		},
+		{
+			name:  "default show pattern",
+			item:  &database.BTItem{Type: episodeType, Season: 1, Episode: 2},
+			files: []string{"Release/episode.mkv"},
+			want:  []string{"Show Name (2019)/Show Name - S01E02.mkv"},
+		},
+		{
+			name:    "episode title and original name",
+			pattern: "{title}/Season {season}/{episode} {episode_title} [{name}]",
+			item:    &database.BTItem{Type: episodeType, Season: 3, Episode: 10},
+			files:   []string{"episode.mkv"},
+			want:    []string{"Show Name/Season 03/10 PilotPart 1 [episode].mkv"},
+		},
+		{
+			name:    "empty parts are skipped",
+			pattern: "{title}//{year}/ /{title}",
+			item:    &database.BTItem{Type: episodeType},
+			files:   []string{"episode.mkv"},
+			want:    []string{"Show Name/2019/Show Name.mkv"},
+		},
+		{
+			name:  "collision keeps original name",
+			item:  &database.BTItem{Type: movieType},
+			files: []string{"Release/cd1.avi", "Release/cd2.avi", "Release/movie.srt"},
+			want: []string{
+				"Show Name (2019)/Show Name (2019).avi",
+				"Show Name (2019)/cd2.avi",
+				"Show Name (2019)/Show Name (2019).srt",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		c := &config.Configuration{CompletedMoviesPattern: test.pattern, CompletedShowsPattern: test.pattern}
+		j := newTestPostJob(root, c, test.item, test.files...)
+
+		if status, message := j.rename(); status != database.PostProcessDone {
+			t.Errorf("%s: rename failed: %s", test.name, message)
+			continue
+		}
+		for i, f := range j.files {
+			if want := filepath.Join(root, filepath.FromSlash(test.want[i])); f.dst != want {
+				t.Errorf("%s: file %s renamed to %s, expected %s", test.name, f.path, f.dst, want)
+			}
+		}
+	}
+}
+
+func TestPostProcessSkipSamples(t *testing.T) {
+	tests := []struct {
+		name   string
+		files  []string
+		status int
+		want   []string
+	}{
+		{"no samples", []string{"Movie/movie.mkv", "Movie/samples.of.life.mkv"}, database.PostProcessSkipped, []string{"Movie/movie.mkv", "Movie/samples.of.life.mkv"}},
+		{"sample file", []string{"Movie/movie.mkv", "Movie/movie-sample.mkv", "Movie/SAMPLE.mkv"}, database.PostProcessDone, []string{"Movie/movie.mkv"}},
+		{"sample folder", []string{"Movie/movie.mkv", "Movie/Sample/movie.mkv"}, database.PostProcessDone, []string{"Movie/movie.mkv"}},
+		{"only samples", []string{"Movie/sample.mkv"}, database.PostProcessFailed, []string{"Movie/sample.mkv"}},
+	}
+
+	for _, test := range tests {
+		j := newTestPostJob("", &config.Configuration{}, &database.BTItem{Type: movieType}, test.files...)
+
+		if status, message := j.skipSamples(); status != test.status {
+			t.Errorf("%s: status is %d (%s), expected %d", test.name, status, message, test.status)
+		}
+		if len(j.files) != len(test.want) {
+			t.Errorf("%s: %d files left, expected %d", test.name, len(j.files), len(test.want))
+			continue
+		}
+		for i, f := range j.files {
+			if f.path != test.want[i] {
+				t.Errorf("%s: file %s left, expected %s", test.name, f.path, test.want[i])
+			}
+		}
+	}
+}
+
+func TestPostProcessFindExtracted(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   []string
+		want    string
+		wantErr bool
+	}{
+		{"single file", []string{"movie.bin"}, "movie.bin", false},
+		{"video among files", []string{"info.nfo", "movie.mkv", "readme.txt"}, "movie.mkv", false},
+		{"no video", []string{"info.nfo", "readme.txt"}, "", true},
+		{"empty folder", []string{}, "", true},
+		{"missing folder", nil, "", true},
+	}
+
+	for _, test := range tests {
+		dir := filepath.Join(t.TempDir(), "extracted")
+		if test.files != nil {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			for _, f := range test.files {
+				if err := ioutil.WriteFile(filepath.Join(dir, f), []byte("data"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		got, err := findExtracted(dir)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: error is %v, expected error: %v", test.name, err, test.wantErr)
+		}
+		if got != test.want {
+			t.Errorf("%s: found %q, expected %q", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,391 @@
+package bittorrent
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/anacrolix/sync"
+
+	"github.com/elgatito/elementum/database"
+	estorage "github.com/elgatito/elementum/storage"
+)
+
+// DownloadQueue keeps the order of whole-torrent downloads
+// and starts them one by one, according to the limit of active downloads.
+type DownloadQueue struct {
+	s  *BTService
+	mu sync.Mutex
+
+	items []*QueueItem
+}
+
+// QueueItem is a torrent, managed by the download queue
+type QueueItem struct {
+	InfoHash string `json:"infohash"`
+	Name     string `json:"name"`
+	Priority int    `json:"priority"`
+	State    int    `json:"state"`
+	Position int    `json:"position"`
+}
+
+// QueueStateStrings ...
+var QueueStateStrings = []string{
+	"None",
+	"Waiting",
+	"Active",
+	"Paused",
+	"Done",
+}
+
+// NewDownloadQueue ...
+func NewDownloadQueue(s *BTService) *DownloadQueue {
+	return &DownloadQueue{
+		s:     s,
+		items: []*QueueItem{},
+	}
+}
+
+// QueueTorrent adds torrent to the client and puts it into download queue,
+// all files of the torrent are selected for download, if none are chosen yet.
+func (s *BTService) QueueTorrent(uri string, priority int, options *StorageOptions) (*Torrent, error) {
+	t, err := s.AddTorrent(uri, options)
+	if err != nil {
+		return nil, err
+	}
+
+	// Memory storage can't keep whole torrents, so they are not queued
+	if !estorage.KeepsFiles(t.StorageType()) {
+		log.Infof("Not queueing %s, download queue is not available for memory storage", t.Name())
+		return t, nil
+	}
+
+	s.Queue.Add(t, priority)
+	return t, nil
+}
+
+// Items returns a copy of queue items, in the order of processing
+func (q *DownloadQueue) Items() []*QueueItem {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	ret := make([]*QueueItem, 0, len(q.items))
+	for _, i := range q.items {
+		item := *i
+		ret = append(ret, &item)
+	}
+
+	return ret
+}
+
+// Has checks whether torrent is managed by the queue
+func (q *DownloadQueue) Has(infoHash string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.find(infoHash) >= 0
+}
+
+// Add puts torrent into the end of its priority group
+func (q *DownloadQueue) Add(t *Torrent, priority int) {
+	if t == nil {
+		return
+	}
+
+	q.mu.Lock()
+	if q.find(t.InfoHash()) >= 0 {
+		q.mu.Unlock()
+		return
+	}
+
+	log.Infof("Adding %s to download queue with priority %d", t.Name(), priority)
+
+	t.IsQueued = true
+	t.Torrent.SetMaxEstablishedConns(0)
+
+	// Files, chosen before queueing, are kept
+	if len(t.ChosenFiles) == 0 {
+		for _, f := range t.Torrent.Files() {
+			t.DownloadFile(f)
+		}
+	}
+
+	if i := database.Get().GetBTItem(t.InfoHash()); i != nil {
+		database.Get().UpdateBTItem(t.InfoHash(), i.ID, i.Type, t.ChosenFiles, i.Query, i.ShowID, i.Season, i.Episode)
+	} else {
+		database.Get().UpdateBTItem(t.InfoHash(), 0, "", t.ChosenFiles, "")
+	}
+	t.DBItem = database.Get().GetBTItem(t.InfoHash())
+
+	q.items = append(q.items, &QueueItem{
+		InfoHash: t.InfoHash(),
+		Name:     t.Name(),
+		Priority: priority,
+		State:    database.QueueWaiting,
+		Position: len(q.items),
+	})
+	q.sort()
+	q.mu.Unlock()
+
+	q.Process()
+}
+
+// Restore puts torrent, loaded on startup, back into the queue with saved state
+func (q *DownloadQueue) Restore(t *Torrent, item *database.BTItem) {
+	if t == nil || item == nil || item.QueueState == database.QueueNone {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.find(t.InfoHash()) >= 0 {
+		return
+	}
+
+	state := item.QueueState
+	if state == database.QueueActive {
+		state = database.QueueWaiting
+	}
+
+	t.IsQueued = state == database.QueueWaiting
+	if state == database.QueuePaused {
+		t.Pause()
+	} else if state == database.QueueWaiting {
+		t.Torrent.SetMaxEstablishedConns(0)
+	}
+
+	q.items = append(q.items, &QueueItem{
+		InfoHash: t.InfoHash(),
+		Name:     t.Name(),
+		Priority: item.QueuePriority,
+		State:    state,
+		Position: item.QueuePosition,
+	})
+	q.sort()
+}
+
+// Remove drops torrent from the queue
+func (q *DownloadQueue) Remove(infoHash string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if i := q.find(infoHash); i >= 0 {
+		q.items = append(q.items[:i], q.items[i+1:]...)
+		q.save()
+	}
+}
+
+// Move changes position of torrent in the queue.
+// Torrent is moved within its priority group.
+func (q *DownloadQueue) Move(infoHash string, position int) error {
+	q.mu.Lock()
+
+	i := q.find(infoHash)
+	if i < 0 {
+		q.mu.Unlock()
+		return errors.New("Torrent is not queued")
+	}
+
+	if position < 0 {
+		position = 0
+	} else if position >= len(q.items) {
+		position = len(q.items) - 1
+	}
+
+	item := q.items[i]
+	q.items = append(q.items[:i], q.items[i+1:]...)
+	q.items = append(q.items[:position], append([]*QueueItem{item}, q.items[position:]...)...)
+	for idx, it := range q.items {
+		it.Position = idx
+	}
+	q.sort()
+	q.mu.Unlock()
+
+	q.Process()
+	return nil
+}
+
+// SetPriority changes priority of queued torrent
+func (q *DownloadQueue) SetPriority(infoHash string, priority int) error {
+	q.mu.Lock()
+
+	i := q.find(infoHash)
+	if i < 0 {
+		q.mu.Unlock()
+		return errors.New("Torrent is not queued")
+	}
+
+	q.items[i].Priority = priority
+	q.sort()
+	q.mu.Unlock()
+
+	q.Process()
+	return nil
+}
+
+// Pause stops queued torrent and makes queue skip it
+func (q *DownloadQueue) Pause(infoHash string) error {
+	q.mu.Lock()
+
+	i := q.find(infoHash)
+	if i < 0 {
+		q.mu.Unlock()
+		return errors.New("Torrent is not queued")
+	}
+
+	if q.items[i].State != database.QueueDone {
+		q.items[i].State = database.QueuePaused
+		if t := q.s.GetTorrentByHash(infoHash); t != nil {
+			t.IsQueued = false
+			t.Pause()
+		}
+	}
+	q.save()
+	q.mu.Unlock()
+
+	q.Process()
+	return nil
+}
+
+// Resume returns paused torrent back to waiting state
+func (q *DownloadQueue) Resume(infoHash string) error {
+	q.mu.Lock()
+
+	i := q.find(infoHash)
+	if i < 0 {
+		q.mu.Unlock()
+		return errors.New("Torrent is not queued")
+	}
+
+	if q.items[i].State == database.QueuePaused {
+		q.items[i].State = database.QueueWaiting
+		if t := q.s.GetTorrentByHash(infoHash); t != nil {
+			t.IsPaused = false
+			t.IsQueued = true
+		}
+	}
+	q.save()
+	q.mu.Unlock()
+
+	q.Process()
+	return nil
+}
+
+// Promote moves torrent to the top of the queue and starts it immediately,
+// even if it means stopping another active download.
+func (q *DownloadQueue) Promote(infoHash string) error {
+	q.mu.Lock()
+
+	i := q.find(infoHash)
+	if i < 0 {
+		q.mu.Unlock()
+		return errors.New("Torrent is not queued")
+	}
+
+	item := q.items[i]
+	for _, it := range q.items {
+		if it.Priority > item.Priority {
+			item.Priority = it.Priority
+		}
+	}
+	item.Position = -1
+	if item.State != database.QueueDone {
+		item.State = database.QueueWaiting
+		if t := q.s.GetTorrentByHash(infoHash); t != nil {
+			t.IsPaused = false
+		}
+	}
+	q.sort()
+	q.mu.Unlock()
+
+	q.Process()
+	return nil
+}
+
+// Process checks finished downloads and starts the next waiting torrents,
+// stopping active ones that are beyond the limit.
+func (q *DownloadQueue) Process() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	limit := q.s.config.MaxActiveDownloads
+	active := 0
+	changed := false
+
+	for _, item := range q.items {
+		t := q.s.GetTorrentByHash(item.InfoHash)
+		if t == nil {
+			continue
+		} else if t.IsMoving() {
+			// Moving torrent keeps its slot
+			if item.State == database.QueueActive {
+				active++
+			}
+			continue
+		}
+
+		if item.State == database.QueueActive && t.GetProgress() >= 100 {
+			log.Infof("Queued download finished: %s", item.Name)
+			item.State = database.QueueDone
+			t.IsQueued = false
+			changed = true
+			continue
+		}
+
+		if item.State != database.QueueActive && item.State != database.QueueWaiting {
+			continue
+		}
+
+		if limit <= 0 || active < limit {
+			active++
+			if item.State == database.QueueWaiting {
+				log.Infof("Starting queued download: %s", item.Name)
+				item.State = database.QueueActive
+				t.IsQueued = false
+				t.Resume()
+				changed = true
+			}
+		} else if item.State == database.QueueActive {
+			log.Infof("Stopping queued download to free the slot: %s", item.Name)
+			item.State = database.QueueWaiting
+			t.IsQueued = true
+			t.Torrent.SetMaxEstablishedConns(0)
+			changed = true
+		}
+	}
+
+	if changed {
+		q.save()
+	}
+}
+
+func (q *DownloadQueue) find(infoHash string) int {
+	for i, item := range q.items {
+		if item.InfoHash == infoHash {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// sort orders items by priority and position, and renumbers positions
+func (q *DownloadQueue) sort() {
+	sort.SliceStable(q.items, func(i, j int) bool {
+		if q.items[i].Priority != q.items[j].Priority {
+			return q.items[i].Priority > q.items[j].Priority
+		}
+		return q.items[i].Position < q.items[j].Position
+	})
+
+	for i, item := range q.items {
+		item.Position = i
+	}
+	q.save()
+}
+
+func (q *DownloadQueue) save() {
+	for _, item := range q.items {
+		database.Get().UpdateQueueBTItem(item.InfoHash, item.State, item.Priority, item.Position)
+	}
+}
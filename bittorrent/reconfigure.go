@@ -0,0 +1,305 @@
+package bittorrent
+
+import (
+	"io"
+	"net/url"
+
+	gotorrent "github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+
+	"github.com/elgatito/elementum/config"
+	"github.com/elgatito/elementum/scrape"
+	estorage "github.com/elgatito/elementum/storage"
+)
+
+// rebuildChanges returns names of changed settings, that can't be applied to running client
+func (s *BTService) rebuildChanges(previous *config.Configuration) []string {
+	c := s.config
+	memory := s.usesMemoryStorage()
+	checks := []struct {
+		name    string
+		changed bool
+	}{
+		{"download storage", previous.DownloadStorage != c.DownloadStorage},
+		{"download path", previous.DownloadPath != c.DownloadPath},
+		{"memory size", memory && previous.MemorySize != c.MemorySize},
+		{"buffer size", memory && previous.BufferSize != c.BufferSize},
+		{"disk cache", previous.DiskCachePath != c.DiskCachePath || previous.DiskCacheSize != c.DiskCacheSize},
+		{"listen address", previous.ListenPortMin != c.ListenPortMin || previous.ListenPortMax != c.ListenPortMax ||
+			previous.ListenInterfaces != c.ListenInterfaces || previous.ListenAutoDetectIP != c.ListenAutoDetectIP ||
+			previous.ListenAutoDetectPort != c.ListenAutoDetectPort},
+		{"protocols", previous.DisableTCP != c.DisableTCP || previous.DisableUTP != c.DisableUTP},
+		{"DHT", previous.DisableDHT != c.DisableDHT},
+		{"UPnP", previous.DisableUPNP != c.DisableUPNP},
+		{"user agent", previous.SpoofUserAgent != c.SpoofUserAgent},
+		{"internal proxy", previous.InternalProxyEnabled != c.InternalProxyEnabled},
+		{"download proxy", previous.ProxyUseDownload != c.ProxyUseDownload || (c.ProxyUseDownload && previous.ProxyURL != c.ProxyURL)},
+		{"tracker proxy", previous.ProxyUseTracker != c.ProxyUseTracker || previous.ProxyURL != c.ProxyURL},
+		{"connections limit", previous.ConnectionsLimit != c.ConnectionsLimit},
+		{"encryption", previous.EncryptionPolicy != c.EncryptionPolicy},
+		{"upload", previous.DisableUpload != c.DisableUpload},
+		// Unlimited limiters are created without bursts, and can't be limited later
+		{"rate limits", s.limiterNeedsBurst(s.DownloadLimiter.Burst(), c.DownloadRateLimit) || s.limiterNeedsBurst(s.UploadLimiter.Burst(), c.UploadRateLimit)},
+	}
+
+	ret := []string{}
+	for _, check := range checks {
+		if check.changed {
+			ret = append(ret, check.name)
+		}
+	}
+	return ret
+}
+
+// usesMemoryStorage checks whether default storage, or storage of any torrent, is keeping pieces in memory
+func (s *BTService) usesMemoryStorage() bool {
+	if estorage.IsMemoryStorage(s.config.DownloadStorage) {
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.Torrents {
+		if estorage.IsMemoryStorage(t.StorageType()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *BTService) limiterNeedsBurst(burst int, limit int) bool {
+	return burst == 0 && (limit > 0 || s.hasBandwidthRules())
+}
+
+// applyRuntimeConfig applies changed settings to the running client and torrents.
+// Client config is read by the client without locks, so only settings,
+// that have own setters, are changed here, others recreate the client.
+func (s *BTService) applyRuntimeConfig() {
+	s.setConnTrackerLimit()
+
+	s.DefaultStorage.SetReadaheadSize(s.GetBufferSize())
+	s.storagesMu.Lock()
+	for _, st := range s.storages {
+		st.SetReadaheadSize(s.GetBufferSize())
+	}
+	s.storagesMu.Unlock()
+
+	s.limitsMu.Lock()
+	lifted := s.limitsLifted
+	s.limitsMu.Unlock()
+
+	if lifted && !s.config.LimitAfterBuffering {
+		s.RestoreLimits()
+	} else {
+		s.applyLimits()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.Torrents {
+		if !t.allowsConnections() {
+			t.Torrent.SetMaxEstablishedConns(0)
+		} else if s.config.ConnectionsLimit > 0 {
+			t.Torrent.SetMaxEstablishedConns(s.config.ConnectionsLimit)
+		}
+	}
+}
+
+// setConnectionLimits modifies default connections settings
+func (s *BTService) setConnectionLimits() {
+	s.ClientConfig.EstablishedConnsPerTorrent = s.config.ConnectionsLimit
+	s.ClientConfig.TorrentPeersHighWater = max(s.config.ConnectionsLimit*10, 3000)
+	s.ClientConfig.HalfOpenConnsPerTorrent = max(int(s.config.ConnectionsLimit/2), 50)
+
+	s.setConnTrackerLimit()
+}
+
+// setConnTrackerLimit modifies ConnTracker default values
+func (s *BTService) setConnTrackerLimit() {
+	if s.config.ConnTrackerLimitAuto || s.config.ConnTrackerLimit == 0 {
+		s.ClientConfig.ConnTracker.SetMaxEntries(s.config.ConnectionsLimit * 15)
+	} else {
+		s.ClientConfig.ConnTracker.SetMaxEntries(max(s.config.ConnTrackerLimit, 10))
+	}
+}
+
+// setPeerPolicy sets seeding, uploading and encryption of peer connections.
+// Seeding limits are applied per torrent, torrents without limits stop seeding
+func (s *BTService) setPeerPolicy() {
+	s.ClientConfig.Seed = !s.config.DisableUpload
+	s.ClientConfig.NoUpload = s.config.DisableUpload
+
+	s.ClientConfig.EncryptionPolicy = gotorrent.EncryptionPolicy{
+		DisableEncryption: s.config.EncryptionPolicy == 1,
+		ForceEncryption:   s.config.EncryptionPolicy == 2,
+	}
+}
+
+// setTrackerProxy sets proxy for trackers, it is used with every announce
+func (s *BTService) setTrackerProxy() {
+	s.ClientConfig.HTTPProxy = nil
+	if s.config.ProxyURL == "" {
+		s.ClientConfig.HTTPProxy = scrape.GetProxyURL(nil)
+	} else if s.config.ProxyUseTracker {
+		if fixedURL, err := url.Parse(s.config.ProxyURL); err == nil {
+			s.ClientConfig.HTTPProxy = scrape.GetProxyURL(fixedURL)
+		}
+	}
+}
+
+// saveTorrents returns metainfo of active torrents, to add them to recreated client
+func (s *BTService) saveTorrents() map[*Torrent]metainfo.MetaInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ret := map[*Torrent]metainfo.MetaInfo{}
+	for _, t := range s.Torrents {
+		// Moving torrents are added back, when files are moved
+		if t.Torrent == nil || t.Torrent.Info() == nil || t.IsMoving() {
+			continue
+		}
+		ret[t] = t.Torrent.Metainfo()
+	}
+	return ret
+}
+
+// restoreTorrents adds saved torrents to recreated client, keeping chosen files,
+// storages, readers and players, so playback and downloads continue
+func (s *BTService) restoreTorrents(saved map[*Torrent]metainfo.MetaInfo) {
+	for t, mi := range saved {
+		handle, err := s.addTorrentSpec(gotorrent.TorrentSpecFromMetaInfo(&mi), t.storageOptions)
+		if err != nil {
+			log.Warningf("Could not restore torrent %s: %v", t.Name(), err)
+
+			s.mu.Lock()
+			delete(s.Torrents, t.infoHash)
+			s.mu.Unlock()
+			continue
+		}
+
+		log.Infof("Restoring torrent %s", t.Name())
+		s.replaceHandle(t, handle)
+	}
+}
+
+// replaceHandle moves torrent, its players and readers to the handle, added to the client again
+func (s *BTService) replaceHandle(t *Torrent, handle *gotorrent.Torrent) {
+	if !t.allowsConnections() {
+		handle.SetMaxEstablishedConns(0)
+	} else if s.config.ConnectionsLimit > 0 {
+		handle.SetMaxEstablishedConns(s.config.ConnectionsLimit)
+	}
+
+	files := t.setHandle(handle)
+
+	s.mu.Lock()
+	for _, p := range s.Players {
+		if p.Torrent != t {
+			continue
+		}
+		if p.chosenFile != nil {
+			p.chosenFile = files[p.chosenFile.Path()]
+		}
+		if p.subtitlesFile != nil {
+			p.subtitlesFile = files[p.subtitlesFile.Path()]
+		}
+	}
+	s.mu.Unlock()
+
+	forgetStreams(t)
+}
+
+// setHandle replaces torrent of the closed client with the new one, and moves chosen files
+// and readers to it. Returns files of the new torrent by their paths
+func (t *Torrent) setHandle(handle *gotorrent.Torrent) map[string]*gotorrent.File {
+	files := map[string]*gotorrent.File{}
+	for _, f := range handle.Files() {
+		files[f.Path()] = f
+	}
+
+	t.mu.Lock()
+	t.Torrent = handle
+	chosen := t.ChosenFiles
+	t.ChosenFiles = nil
+	t.mu.Unlock()
+
+	// Stats of the new handle start from 0
+	t.muSeeding.Lock()
+	t.uploadedBefore += t.uploadedSize
+	t.uploadedSize = 0
+	t.downloadedSize = 0
+	t.muSeeding.Unlock()
+
+	for _, f := range chosen {
+		if nf, ok := files[f.Path()]; ok {
+			t.DownloadFile(nf)
+		}
+	}
+
+	t.muBuffer.Lock()
+	for _, r := range t.bufferReaders {
+		r.reopen(files)
+	}
+	for _, r := range t.prefetchReaders {
+		r.reopen(files)
+	}
+	t.muBuffer.Unlock()
+
+	t.muReaders.Lock()
+	for _, r := range t.readers {
+		r.reopen(files)
+	}
+	hasReaders := len(t.readers) > 0
+	t.muReaders.Unlock()
+
+	if hasReaders {
+		t.ResetReaders()
+		t.SetReaders()
+	}
+
+	return files
+}
+
+// reopen moves reader to the file of new torrent, keeping position and readahead.
+// Reads of the closed torrent fail, so reopen waits for them to return.
+func (fr *FileReader) reopen(files map[string]*gotorrent.File) {
+	if fr == nil {
+		return
+	}
+
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	f, ok := files[fr.File.Path()]
+	if !ok {
+		return
+	}
+
+	fr.Reader.Close()
+
+	fr.File = f
+	fr.Reader = f.NewReader()
+	fr.Reader.SetReadahead(fr.PositionReader.Readahead)
+	fr.Reader.Seek(fr.PositionReader.Pos, io.SeekStart)
+}
+
+// forgetStreams removes cached HLS streams and subtitle tracks, opened with files of the closed client
+func forgetStreams(t *Torrent) {
+	hlsMu.Lock()
+	for key, stream := range hlsStreams {
+		if stream.t == t {
+			delete(hlsStreams, key)
+		}
+	}
+	hlsMu.Unlock()
+
+	tracksMu.Lock()
+	for key, tf := range tracksFiles {
+		if tf.t == t {
+			delete(tracksFiles, key)
+		}
+	}
+	tracksMu.Unlock()
+}
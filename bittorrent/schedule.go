@@ -0,0 +1,255 @@
+package bittorrent
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BandwidthRule sets download and upload limits for a period of time
+type BandwidthRule struct {
+	Days  [7]bool
+	Start int
+	End   int
+
+	Download int
+	Upload   int
+
+	source string
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// ParseBandwidthSchedule parses schedule rules, separated by ";" or new lines.
+// Each rule looks like "[days] HH:MM-HH:MM download[/upload]", where days are
+// comma-separated names or ranges, like "mon-fri,sun", or "*" for every day.
+// Limits are in kB/s, 0 means unlimited, missing upload limit means unlimited.
+// Period can span midnight, like "22:00-07:00", "24:00" is the end of the day,
+// and period with the same start and end, like "00:00-00:00", lasts all day.
+func ParseBandwidthSchedule(schedule string) ([]*BandwidthRule, error) {
+	rules := []*BandwidthRule{}
+
+	for _, line := range strings.FieldsFunc(schedule, func(r rune) bool { return r == ';' || r == '\n' }) {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		fields := strings.Fields(strings.ToLower(line))
+		if len(fields) == 2 {
+			fields = append([]string{"*"}, fields...)
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("Wrong bandwidth rule: %s", line)
+		}
+
+		rule := &BandwidthRule{source: line}
+		if err := rule.parseDays(fields[0]); err != nil {
+			return nil, err
+		}
+		if err := rule.parsePeriod(fields[1]); err != nil {
+			return nil, err
+		}
+		if err := rule.parseLimits(fields[2]); err != nil {
+			return nil, err
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+func (r *BandwidthRule) parseDays(s string) error {
+	if s == "*" {
+		for i := range r.Days {
+			r.Days[i] = true
+		}
+		return nil
+	}
+
+	for _, part := range strings.Split(s, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		from, ok := weekdays[bounds[0]]
+		if !ok {
+			return fmt.Errorf("Wrong day in bandwidth rule: %s", bounds[0])
+		}
+		to := from
+		if len(bounds) == 2 {
+			if to, ok = weekdays[bounds[1]]; !ok {
+				return fmt.Errorf("Wrong day in bandwidth rule: %s", bounds[1])
+			}
+		}
+
+		for d := from; ; d = (d + 1) % 7 {
+			r.Days[d] = true
+			if d == to {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r *BandwidthRule) parsePeriod(s string) (err error) {
+	bounds := strings.SplitN(s, "-", 2)
+	if len(bounds) != 2 {
+		return fmt.Errorf("Wrong period in bandwidth rule: %s", s)
+	}
+
+	if r.Start, err = parseMinutes(bounds[0]); err != nil {
+		return
+	}
+	r.End, err = parseMinutes(bounds[1])
+	return
+}
+
+func (r *BandwidthRule) parseLimits(s string) (err error) {
+	limits := strings.SplitN(s, "/", 2)
+	if r.Download, err = strconv.Atoi(limits[0]); err != nil || r.Download < 0 {
+		return fmt.Errorf("Wrong download limit in bandwidth rule: %s", limits[0])
+	}
+	if len(limits) == 2 {
+		if r.Upload, err = strconv.Atoi(limits[1]); err != nil || r.Upload < 0 {
+			return fmt.Errorf("Wrong upload limit in bandwidth rule: %s", limits[1])
+		}
+	}
+
+	r.Download *= 1024
+	r.Upload *= 1024
+	return nil
+}
+
+func parseMinutes(s string) (int, error) {
+	if s == "24:00" {
+		return 24 * 60, nil
+	}
+
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("Wrong time in bandwidth rule: %s", s)
+	}
+
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Matches checks whether rule is active at the time
+func (r *BandwidthRule) Matches(t time.Time) bool {
+	minutes := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+
+	if r.Start == r.End {
+		return r.Days[day]
+	} else if r.Start < r.End {
+		return r.Days[day] && minutes >= r.Start && minutes < r.End
+	} else if minutes >= r.Start {
+		return r.Days[day]
+	} else if minutes < r.End {
+		// Period has started on the previous day
+		return r.Days[(day+6)%7]
+	}
+
+	return false
+}
+
+func (r *BandwidthRule) String() string {
+	return r.source
+}
+
+// loadBandwidthSchedule parses schedule rules from settings
+func (s *BTService) loadBandwidthSchedule() {
+	var rules []*BandwidthRule
+	if s.config.BandwidthScheduleEnabled {
+		var err error
+		if rules, err = ParseBandwidthSchedule(s.config.BandwidthSchedule); err != nil {
+			log.Warningf("Cannot parse bandwidth schedule: %s", err)
+		} else {
+			log.Infof("Using bandwidth schedule with %d rules", len(rules))
+		}
+	}
+
+	s.limitsMu.Lock()
+	s.bandwidthRules = rules
+	s.limitsMu.Unlock()
+}
+
+// hasBandwidthRules checks whether limits are changed by schedule
+func (s *BTService) hasBandwidthRules() bool {
+	s.limitsMu.Lock()
+	defer s.limitsMu.Unlock()
+
+	return len(s.bandwidthRules) > 0
+}
+
+// activeBandwidthRule returns first schedule rule, matching current time
+func (s *BTService) activeBandwidthRule() *BandwidthRule {
+	if !s.config.BandwidthScheduleEnabled {
+		return nil
+	}
+
+	s.limitsMu.Lock()
+	rules := s.bandwidthRules
+	s.limitsMu.Unlock()
+
+	now := time.Now()
+	for _, r := range rules {
+		if r.Matches(now) {
+			return r
+		}
+	}
+
+	return nil
+}
+
+// currentLimits returns download and upload limits for current time,
+// taken from active schedule rule or from default settings.
+func (s *BTService) currentLimits() (download, upload int) {
+	if r := s.activeBandwidthRule(); r != nil {
+		return r.Download, r.Upload
+	}
+
+	return s.config.DownloadRateLimit, s.config.UploadRateLimit
+}
+
+// watchBandwidthSchedule switches limits when schedule rules start and end
+func (s *BTService) watchBandwidthSchedule() {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	// Rule, active on start, is applied without waiting for the ticker
+	lastRule := s.activeBandwidthRule()
+	if lastRule != nil {
+		log.Infof("Using scheduled bandwidth limits: %s", lastRule)
+		s.applyLimits()
+	}
+
+	for range ticker.C {
+		if s.ShuttingDown {
+			return
+		}
+
+		rule := s.activeBandwidthRule()
+		if rule == lastRule {
+			continue
+		}
+		lastRule = rule
+
+		if rule != nil {
+			log.Infof("Switching to scheduled bandwidth limits: %s", rule)
+		} else {
+			log.Infof("Switching to default bandwidth limits")
+		}
+
+		s.applyLimits()
+	}
+}
@@ -0,0 +1,120 @@
+package bittorrent
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseBandwidthSchedule(t *testing.T) {
+	all := [7]bool{true, true, true, true, true, true, true}
+	weekdays := [7]bool{false, true, true, true, true, true, false}
+
+	tests := []struct {
+		schedule string
+		wantErr  bool
+		days     [7]bool
+		start    int
+		end      int
+		download int
+		upload   int
+	}{
+		{schedule: "08:00-18:30 500", days: all, start: 8 * 60, end: 18*60 + 30, download: 500 * 1024},
+		{schedule: "* 08:00-18:30 500/100", days: all, start: 8 * 60, end: 18*60 + 30, download: 500 * 1024, upload: 100 * 1024},
+		{schedule: "mon-fri 22:00-07:00 0/50", days: weekdays, start: 22 * 60, end: 7 * 60, upload: 50 * 1024},
+		{schedule: "fri-mon 00:00-24:00 100", days: [7]bool{true, true, false, false, false, true, true}, end: 24 * 60, download: 100 * 1024},
+		{schedule: "SUN,wed 12:00-12:00 1", days: [7]bool{true, false, false, true, false, false, false}, start: 12 * 60, end: 12 * 60, download: 1024},
+		{schedule: "sat 25:00-07:00 100", wantErr: true},
+		{schedule: "sat 22:00 100", wantErr: true},
+		{schedule: "sat 22:00-7 100", wantErr: true},
+		{schedule: "sunday 22:00-07:00 100", wantErr: true},
+		{schedule: "mon-xyz 22:00-07:00 100", wantErr: true},
+		{schedule: "mon 22:00-07:00 -1", wantErr: true},
+		{schedule: "mon 22:00-07:00 100/abc", wantErr: true},
+		{schedule: "mon 22:00-07:00", wantErr: true},
+		{schedule: "mon 22:00-07:00 100 200", wantErr: true},
+	}
+
+	for _, test := range tests {
+		rules, err := ParseBandwidthSchedule(test.schedule)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%q: expected error", test.schedule)
+			}
+			continue
+		}
+		if err != nil || len(rules) != 1 {
+			t.Errorf("%q: parsed %d rules with error %v", test.schedule, len(rules), err)
+			continue
+		}
+
+		r := rules[0]
+		if r.Days != test.days {
+			t.Errorf("%q: days are %v, expected %v", test.schedule, r.Days, test.days)
+		}
+		if r.Start != test.start || r.End != test.end {
+			t.Errorf("%q: period is %d-%d, expected %d-%d", test.schedule, r.Start, r.End, test.start, test.end)
+		}
+		if r.Download != test.download || r.Upload != test.upload {
+			t.Errorf("%q: limits are %d/%d, expected %d/%d", test.schedule, r.Download, r.Upload, test.download, test.upload)
+		}
+	}
+}
+
+func TestParseBandwidthScheduleLines(t *testing.T) {
+	rules, err := ParseBandwidthSchedule("mon 08:00-18:00 100;\n tue 08:00-18:00 200 \n\n;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 {
+		t.Fatalf("Parsed %d rules, expected 2", len(rules))
+	}
+	if rules[1].String() != "tue 08:00-18:00 200" {
+		t.Errorf("Second rule is %q", rules[1])
+	}
+}
+
+func TestBandwidthRuleMatches(t *testing.T) {
+	// 1 January of 2024 is Monday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 1, day, hour, minute, 0, 0, time.Local)
+	}
+
+	tests := []struct {
+		schedule string
+		time     time.Time
+		want     bool
+	}{
+		{"mon 08:00-18:00 100", at(1, 8, 0), true},
+		{"mon 08:00-18:00 100", at(1, 17, 59), true},
+		{"mon 08:00-18:00 100", at(1, 18, 0), false},
+		{"mon 08:00-18:00 100", at(1, 7, 59), false},
+		{"mon 08:00-18:00 100", at(2, 12, 0), false},
+		// Period, crossing midnight, belongs to the day it starts
+		{"mon 22:00-07:00 100", at(1, 23, 0), true},
+		{"mon 22:00-07:00 100", at(2, 6, 59), true},
+		{"mon 22:00-07:00 100", at(2, 7, 0), false},
+		{"mon 22:00-07:00 100", at(1, 6, 0), false},
+		{"mon 22:00-07:00 100", at(2, 23, 0), false},
+		{"sun 22:00-07:00 100", at(1, 3, 0), true},
+		{"sat-sun 23:00-01:00 100", at(1, 0, 30), true},
+		{"sat-sun 23:00-01:00 100", at(1, 23, 30), false},
+		// Whole day periods
+		{"mon 00:00-24:00 100", at(1, 0, 0), true},
+		{"mon 00:00-24:00 100", at(1, 23, 59), true},
+		{"mon 00:00-24:00 100", at(2, 0, 0), false},
+		{"tue 00:00-00:00 100", at(2, 12, 0), true},
+		{"tue 09:30-09:30 100", at(2, 3, 0), true},
+		{"tue 09:30-09:30 100", at(1, 12, 0), false},
+	}
+
+	for _, test := range tests {
+		rules, err := ParseBandwidthSchedule(test.schedule)
+		if err != nil {
+			t.Errorf("%q: %s", test.schedule, err)
+			continue
+		}
+		if got := rules[0].Matches(test.time); got != test.want {
+			t.Errorf("%q at %s: matches is %v, expected %v", test.schedule, test.time.Format("Mon 15:04"), got, test.want)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package bittorrent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/elgatito/elementum/database"
+)
+
+// seedStatsInterval is how often seeding stats are saved
+const seedStatsInterval = time.Minute
+
+// SeedStatus shows how close the torrent is to its seeding limits
+type SeedStatus struct {
+	Policy    database.SeedPolicy `json:"policy"`
+	Ratio     float64             `json:"ratio"`
+	TimeRatio float64             `json:"time_ratio"`
+	SeedTime  time.Duration       `json:"seed_time"`
+	// Progress is the highest progress to any of enabled limits, in percents
+	Progress float64 `json:"progress"`
+}
+
+// GetSeedPolicy returns global seeding limits, merged with torrent's overrides
+func (s *BTService) GetSeedPolicy(infoHash string) database.SeedPolicy {
+	if s.config.DisableUpload {
+		return database.SeedPolicy{}
+	}
+
+	policy := database.SeedPolicy{
+		RatioLimit:     s.config.ShareRatioLimit,
+		SeedTimeLimit:  s.config.SeedTimeLimit,
+		TimeRatioLimit: s.config.SeedTimeRatioLimit,
+		Action:         s.config.SeedLimitAction,
+	}
+
+	if o := database.Get().GetSeedPolicy(infoHash); o != nil {
+		if o.RatioLimit >= 0 {
+			policy.RatioLimit = o.RatioLimit
+		}
+		if o.SeedTimeLimit >= 0 {
+			policy.SeedTimeLimit = o.SeedTimeLimit
+		}
+		if o.TimeRatioLimit >= 0 {
+			policy.TimeRatioLimit = o.TimeRatioLimit
+		}
+		if o.Action >= 0 {
+			policy.Action = o.Action
+		}
+	}
+
+	return policy
+}
+
+// SetSeedPolicy saves torrent's overrides of seeding limits,
+// nil policy removes the overrides.
+func (s *BTService) SetSeedPolicy(t *Torrent, p *database.SeedPolicy) error {
+	var err error
+	if p == nil {
+		err = database.Get().DeleteSeedPolicy(t.InfoHash())
+	} else {
+		err = database.Get().SetSeedPolicy(t.InfoHash(), p)
+	}
+
+	t.resetSeedPolicy()
+
+	return err
+}
+
+// resetSeedPolicies makes torrents take seeding limits from settings again
+func (s *BTService) resetSeedPolicies() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.Torrents {
+		t.resetSeedPolicy()
+	}
+}
+
+// resetSeedPolicy drops cached seeding limits of the torrent,
+// torrents, that stopped seeding, check new limits on next progress update
+func (t *Torrent) resetSeedPolicy() {
+	t.muSeeding.Lock()
+	defer t.muSeeding.Unlock()
+
+	t.seedPolicy = nil
+	if t.seedingStopped && !t.Service.config.DisableUpload {
+		t.needSeeding = true
+	}
+}
+
+// seedingEnabled checks whether any seeding limit of the torrent is set
+func (t *Torrent) seedingEnabled() bool {
+	p := t.GetSeedStatus().Policy
+	return p.RatioLimit > 0 || p.SeedTimeLimit > 0 || p.TimeRatioLimit > 0
+}
+
+// GetSeedStatus calculates current ratios and seeding time of the torrent
+func (t *Torrent) GetSeedStatus() *SeedStatus {
+	t.muSeeding.Lock()
+	defer t.muSeeding.Unlock()
+
+	if t.seedPolicy == nil {
+		p := t.Service.GetSeedPolicy(t.infoHash)
+		t.seedPolicy = &p
+	}
+
+	ret := &SeedStatus{
+		Policy: *t.seedPolicy,
+	}
+
+	if length := t.chosenLength(); length > 0 {
+		ret.Ratio = float64(t.uploadedBefore+t.uploadedSize) / float64(length)
+	}
+	if !t.seedingSince.IsZero() {
+		ret.SeedTime = time.Since(t.seedingSince)
+		// Time ratio is not checked, when download time is not known, like for completed torrents, added again
+		if t.downloadDuration > 0 {
+			ret.TimeRatio = float64(ret.SeedTime) / float64(t.downloadDuration)
+		}
+	}
+
+	progress := func(current, limit float64) {
+		if limit <= 0 {
+			return
+		}
+		if p := current / limit * 100; p > ret.Progress {
+			ret.Progress = p
+		}
+	}
+	progress(ret.Ratio*100, float64(ret.Policy.RatioLimit))
+	progress(ret.SeedTime.Hours(), float64(ret.Policy.SeedTimeLimit))
+	progress(ret.TimeRatio*100, float64(ret.Policy.TimeRatioLimit))
+
+	if ret.Progress > 100 {
+		ret.Progress = 100
+	}
+
+	return ret
+}
+
+func (t *Torrent) startSeeding() {
+	t.muSeeding.Lock()
+
+	log.Debugf("Starting seeding for: %s", t.Info().Name)
+
+	t.IsSeeding = true
+	t.needSeeding = false
+	if t.seedingStopped {
+		t.seedingStopped = false
+		if t.allowsConnections() && t.Service.config.ConnectionsLimit > 0 {
+			t.Torrent.SetMaxEstablishedConns(t.Service.config.ConnectionsLimit)
+		}
+	}
+
+	// Seeding, started before restart, is continued
+	if t.seedingSince.IsZero() {
+		t.seedingSince = time.Now()
+	}
+	if !t.downloadStarted.IsZero() {
+		t.downloadDuration += time.Since(t.downloadStarted)
+		t.downloadStarted = time.Time{}
+	}
+	t.muSeeding.Unlock()
+
+	t.saveSeedStats()
+}
+
+// checkSeedPolicy applies seeding limits action when any limit is reached
+func (t *Torrent) checkSeedPolicy() {
+	status := t.GetSeedStatus()
+	if status.Progress < 100 {
+		return
+	}
+
+	log.Infof("Seeding limits reached for %s: ratio %.2f, time ratio %.2f, seeding time %s", t.Name(), status.Ratio, status.TimeRatio, status.SeedTime)
+
+	t.stopSeeding()
+
+	if status.Policy.Action == database.SeedActionRemove {
+		log.Infof("Removing torrent after seeding: %s", t.Name())
+
+		torrentFile := filepath.Join(t.Service.config.TorrentsPath, fmt.Sprintf("%s.torrent", t.InfoHash()))
+		if _, err := os.Stat(torrentFile); err == nil {
+			os.Remove(torrentFile)
+		}
+
+		go t.Service.RemoveTorrent(t, false)
+	}
+}
+
+func (t *Torrent) stopSeeding() {
+	log.Debugf("Stopping seeding for: %s", t.Info().Name)
+
+	t.muSeeding.Lock()
+	defer t.muSeeding.Unlock()
+
+	t.Torrent.SetMaxEstablishedConns(0)
+	t.IsSeeding = false
+	t.seedingStopped = true
+}
+
+// loadSeedStats restores seeding stats, saved before restart
+func (t *Torrent) loadSeedStats() {
+	stats := database.Get().GetSeedStats(t.infoHash)
+	if stats == nil {
+		return
+	}
+
+	t.muSeeding.Lock()
+	defer t.muSeeding.Unlock()
+
+	t.downloadDuration = stats.DownloadDuration
+	t.seedingSince = stats.SeedingSince
+	t.uploadedBefore = stats.Uploaded
+}
+
+// saveSeedStats saves seeding stats, so limits are checked correctly after restart
+func (t *Torrent) saveSeedStats() {
+	t.muSeeding.Lock()
+	stats := &database.SeedStats{
+		DownloadDuration: t.downloadDuration,
+		SeedingSince:     t.seedingSince,
+		Uploaded:         t.uploadedBefore + t.uploadedSize,
+	}
+	if !t.downloadStarted.IsZero() {
+		stats.DownloadDuration += time.Since(t.downloadStarted)
+	}
+	t.seedStatsSaved = time.Now()
+	t.muSeeding.Unlock()
+
+	if stats.DownloadDuration == 0 && stats.SeedingSince.IsZero() && stats.Uploaded == 0 {
+		return
+	}
+	database.Get().UpdateSeedStats(t.infoHash, stats)
+}
+
+// trackSeedStats counts time, spent on downloading, and saves seeding stats periodically
+func (t *Torrent) trackSeedStats() {
+	downloading := t.GetProgress() < 100
+
+	t.muSeeding.Lock()
+	if downloading && !t.IsSeeding && t.downloadStarted.IsZero() {
+		t.downloadStarted = time.Now()
+	}
+	save := time.Since(t.seedStatsSaved) >= seedStatsInterval
+	t.muSeeding.Unlock()
+
+	if save {
+		t.saveSeedStats()
+	}
+}
+
+func (t *Torrent) chosenLength() (total int64) {
+	for _, f := range t.ChosenFiles {
+		total += f.Length()
+	}
+	return
+}
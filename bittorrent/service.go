@@ -4,12 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net/http"
-	"net/url"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strconv"
 	"strings"
 	"sync"
@@ -24,6 +21,7 @@ import (
 	"github.com/anacrolix/missinggo/conntrack"
 	gotorrent "github.com/anacrolix/torrent"
 	"github.com/anacrolix/torrent/iplist"
+	"github.com/anacrolix/torrent/metainfo"
 	"github.com/anacrolix/torrent/storage"
 
 	"github.com/elgatito/elementum/config"
@@ -31,7 +29,6 @@ import (
 	"github.com/elgatito/elementum/diskusage"
 	"github.com/elgatito/elementum/scrape"
 	estorage "github.com/elgatito/elementum/storage"
-	memory "github.com/elgatito/elementum/storage/memory_v2"
 	"github.com/elgatito/elementum/tmdb"
 	"github.com/elgatito/elementum/util"
 	"github.com/elgatito/elementum/xbmc"
@@ -50,11 +47,22 @@ type BTService struct {
 	PieceCompletion storage.PieceCompletion
 	DefaultStorage  estorage.ElementumStorage
 
+	storagesMu sync.Mutex
+	storages   map[string]estorage.ElementumStorage
+
 	DownloadLimiter *rate.Limiter
 	UploadLimiter   *rate.Limiter
 
+	// limitsMu guards schedule rules and lifted limits, changed by ticker, settings and playback
+	limitsMu       sync.Mutex
+	bandwidthRules []*BandwidthRule
+	limitsLifted   bool
+
+	ipBlocklist iplist.Ranger
+
 	Players  map[string]*BTPlayer
 	Torrents map[string]*Torrent
+	Queue    *DownloadQueue
 
 	UserAgent   string
 	PeerID      string
@@ -94,12 +102,15 @@ func NewBTService() *BTService {
 		UploadLimiter:   rate.NewLimiter(rate.Inf, 2<<16),
 	}
 
+	s.Queue = NewDownloadQueue(s)
+
 	s.configure()
 
 	tmdb.CheckAPIKey()
 
 	go s.loadTorrentFiles()
 	go s.downloadProgress()
+	go s.watchBandwidthSchedule()
 
 	return s
 }
@@ -120,16 +131,34 @@ func (s *BTService) Close(shutdown bool) {
 
 // Reconfigure fired every time addon configuration has changed
 // and Kodi sent a notification about that.
-// Should reassemble Service configuration and restart everything.
+// Settings, that can be changed in runtime, are applied to the running client,
+// otherwise client is recreated and active torrents are added back to it.
 // For non-memory storage it should also load old torrent files.
 func (s *BTService) Reconfigure() {
-	s.stopServices()
+	previous := s.config
 
 	config.Reload()
 	scrape.Reload()
 
 	s.config = config.Get()
-	s.configure()
+	if s.config.ConnectionsLimit == 0 {
+		setPlatformSpecificSettings(s.config)
+	}
+	s.loadBandwidthSchedule()
+
+	if changed := s.rebuildChanges(previous); len(changed) == 0 {
+		log.Info("Applying changed settings to running client")
+		s.applyRuntimeConfig()
+	} else {
+		log.Infof("Recreating client because of changed settings: %s", strings.Join(changed, ", "))
+		saved := s.saveTorrents()
+
+		s.stopServices()
+		s.configure()
+
+		s.restoreTorrents(saved)
+	}
+	s.resetSeedPolicies()
 
 	if config.Get().AntizapretEnabled {
 		go scrape.PacParser.Update()
@@ -168,7 +197,12 @@ func (s *BTService) configure() {
 
 	log.Infof("ListenIP=%s, ListenIPv6=%s, ListenPort=%d, DisableIPv6=%v", s.ListenIP, s.ListenIPv6, s.ListenPort, s.DisableIPv6)
 
-	blocklist, _ := iplist.MMapPackedFile(filepath.Join(config.Get().Info.Path, "resources", "misc", "pack-iplist"))
+	s.mu.Lock()
+	blocklist := s.ipBlocklist
+	s.mu.Unlock()
+	if blocklist == nil {
+		blocklist, _ = iplist.MMapPackedFile(filepath.Join(config.Get().Info.Path, "resources", "misc", "pack-iplist"))
+	}
 
 	s.PeerID, s.UserAgent = util.GetUserAndPeer()
 	log.Infof("UserAgent: %s, PeerID: %s", s.UserAgent, s.PeerID)
@@ -177,32 +211,25 @@ func (s *BTService) configure() {
 		setPlatformSpecificSettings(s.config)
 	}
 
-	if s.config.DownloadRateLimit == 0 {
+	s.loadBandwidthSchedule()
+
+	// Scheduled limits are changed in runtime, so limiters should allow bursts
+	scheduled := s.hasBandwidthRules()
+	if s.config.DownloadRateLimit == 0 && !scheduled {
 		s.DownloadLimiter = rate.NewLimiter(rate.Inf, 0)
+	} else if s.DownloadLimiter.Burst() == 0 {
+		s.DownloadLimiter = rate.NewLimiter(rate.Inf, 2<<16)
 	}
-	if s.config.UploadRateLimit == 0 {
+	if s.config.UploadRateLimit == 0 && !scheduled {
 		s.UploadLimiter = rate.NewLimiter(rate.Inf, 0)
+	} else if s.UploadLimiter.Burst() == 0 {
+		s.UploadLimiter = rate.NewLimiter(rate.Inf, 2<<16)
 	}
 
 	log.Infof("DownloadStorage: %s", estorage.Storages[s.config.DownloadStorage])
-	if s.config.DownloadStorage == estorage.StorageMemory {
-		memSize := int64(config.Get().MemorySize)
-		needSize := int64(s.config.BufferSize) + endBufferSize + 6*1024*1024
-
-		if memSize < needSize {
-			log.Noticef("Raising memory size (%d) to fit all the buffer (%d)", memSize, needSize)
-			memSize = needSize
-		}
-
-		s.DefaultStorage = memory.NewMemoryStorage(memSize)
-	} else if s.config.DownloadStorage == estorage.StorageFat32 {
-		s.DefaultStorage = estorage.NewFat32Storage(config.Get().DownloadPath)
-	} else if s.config.DownloadStorage == estorage.StorageMMap {
-		s.DefaultStorage = estorage.NewMMapStorage(config.Get().DownloadPath, s.PieceCompletion)
-	} else {
-		s.DefaultStorage = estorage.NewFileStorage(config.Get().DownloadPath, s.PieceCompletion)
-	}
+	s.DefaultStorage = s.newStorage(s.config.DownloadStorage, config.Get().DownloadPath)
 	s.DefaultStorage.SetReadaheadSize(s.GetBufferSize())
+	s.resetStorages()
 
 	s.ClientConfig = gotorrent.NewDefaultClientConfig()
 
@@ -216,34 +243,20 @@ func (s *BTService) configure() {
 	s.ClientConfig.DisableTCP = s.config.DisableTCP
 	s.ClientConfig.DisableUTP = s.config.DisableUTP
 
-	if s.config.ProxyURL != "" {
-		if config.Get().ProxyUseDownload {
-			s.ClientConfig.ProxyURL = s.config.ProxyURL
+	if s.config.ProxyURL != "" && config.Get().ProxyUseDownload {
+		s.ClientConfig.ProxyURL = s.config.ProxyURL
 
-			s.ClientConfig.DisableUTP = true
-			log.Info("Disabling UTP because of enabled proxy and not working UDP proxying")
-		}
-		if config.Get().ProxyUseTracker {
-			if fixedURL, err := url.Parse(s.config.ProxyURL); err == nil {
-				s.ClientConfig.HTTPProxy = scrape.GetProxyURL(fixedURL)
-			}
-		}
-	} else {
-		s.ClientConfig.HTTPProxy = scrape.GetProxyURL(nil)
+		s.ClientConfig.DisableUTP = true
+		log.Info("Disabling UTP because of enabled proxy and not working UDP proxying")
 	}
+	s.setTrackerProxy()
 
 	s.ClientConfig.NoDefaultPortForwarding = s.config.DisableUPNP
 
 	s.ClientConfig.NoDHT = s.config.DisableDHT
 	s.ClientConfig.DhtStartingNodes = dht.GlobalBootstrapAddrs
 
-	s.ClientConfig.Seed = s.config.SeedTimeLimit > 0
-	s.ClientConfig.NoUpload = s.config.DisableUpload
-
-	s.ClientConfig.EncryptionPolicy = gotorrent.EncryptionPolicy{
-		DisableEncryption: s.config.EncryptionPolicy == 1,
-		ForceEncryption:   s.config.EncryptionPolicy == 2,
-	}
+	s.setPeerPolicy()
 
 	s.ClientConfig.DownloadRateLimiter = s.DownloadLimiter
 	s.ClientConfig.UploadRateLimiter = s.UploadLimiter
@@ -253,17 +266,7 @@ func (s *BTService) configure() {
 
 	s.ClientConfig.HTTPUserAgent = s.UserAgent
 
-	// Modify default Connections settings
-	s.ClientConfig.EstablishedConnsPerTorrent = s.config.ConnectionsLimit
-	s.ClientConfig.TorrentPeersHighWater = max(s.config.ConnectionsLimit*10, 3000)
-	s.ClientConfig.HalfOpenConnsPerTorrent = max(int(s.config.ConnectionsLimit/2), 50)
-
-	// Modify ConnTracker default values
-	if s.config.ConnTrackerLimitAuto || s.config.ConnTrackerLimit == 0 {
-		s.ClientConfig.ConnTracker.SetMaxEntries(s.config.ConnectionsLimit * 15)
-	} else {
-		s.ClientConfig.ConnTracker.SetMaxEntries(max(s.config.ConnTrackerLimit, 10))
-	}
+	s.setConnectionLimits()
 
 	s.ClientConfig.ConnTracker.Timeout = func(e conntrack.Entry) time.Duration {
 		return 10 * time.Second
@@ -292,9 +295,19 @@ func (s *BTService) configure() {
 	for _, addr := range s.Client.ListenAddrs() {
 		log.Debugf("Client listening on %s: %s", addr.Network(), addr.String())
 	}
+}
+
+// SetIPBlocklist replaces blocklist of the client, without restarting it.
+// The list is kept for next client, created after reconfiguration
+func (s *BTService) SetIPBlocklist(blocklist iplist.Ranger) {
+	s.mu.Lock()
+	s.ipBlocklist = blocklist
+	client := s.Client
+	s.mu.Unlock()
 
-	// Setting it here to avoid spamming the log file
-	// s.Client.SetIPBlockList(blocklist)
+	if client != nil {
+		client.SetIPBlockList(blocklist)
+	}
 }
 
 func (s *BTService) stopServices() {
@@ -332,19 +345,20 @@ func (s *BTService) stopServices() {
 
 // CheckAvailableSpace ...
 func (s *BTService) CheckAvailableSpace(torrent *Torrent) bool {
+	if torrent == nil || torrent.Info() == nil {
+		log.Warning("Missing torrent info to check available space.")
+		return false
+	}
+
 	// For memory storage we don't need to check available space
-	if s.config.DownloadStorage != estorage.StorageMemory {
+	if !estorage.KeepsFiles(torrent.StorageType()) {
 		return true
 	}
 
-	diskStatus, err := diskusage.DiskUsage(config.Get().DownloadPath)
+	path := torrent.DownloadPath()
+	diskStatus, err := diskusage.DiskUsage(path)
 	if err != nil {
-		log.Warningf("Unable to retrieve the free space for %s, continuing anyway...", config.Get().DownloadPath)
-		return false
-	}
-
-	if torrent == nil || torrent.Info() == nil {
-		log.Warning("Missing torrent info to check available space.")
+		log.Warningf("Unable to retrieve the free space for %s, continuing anyway...", path)
 		return false
 	}
 
@@ -352,7 +366,15 @@ func (s *BTService) CheckAvailableSpace(torrent *Torrent) bool {
 	totalDone := torrent.BytesCompleted()
 	sizeLeft := torrent.BytesMissing()
 	availableSpace := diskStatus.Free
-	path := s.ClientConfig.DataDir
+
+	// Quota of the volume is shared with other torrents
+	for _, v := range s.Volumes() {
+		if v.Quota > 0 && samePath(v.Path, path) {
+			if status := s.volumeStatus(v, torrent); v.Quota-status.Used-totalDone < availableSpace {
+				availableSpace = max64(v.Quota-status.Used-totalDone, 0)
+			}
+		}
+	}
 
 	if torrent.IsRarArchive {
 		sizeLeft = sizeLeft * 2
@@ -370,7 +392,7 @@ func (s *BTService) CheckAvailableSpace(torrent *Torrent) bool {
 	log.Infof("Available space: %s", humanize.Bytes(uint64(availableSpace)))
 
 	if availableSpace < sizeLeft {
-		log.Errorf("Unsufficient free space on %s. Has %d, needs %d.", path, diskStatus.Free, sizeLeft)
+		log.Errorf("Unsufficient free space on %s. Has %d, needs %d.", path, availableSpace, sizeLeft)
 		xbmc.Notify("Elementum", "LOCALIZE[30207]", config.AddonIcon())
 
 		torrent.Pause()
@@ -380,22 +402,18 @@ func (s *BTService) CheckAvailableSpace(torrent *Torrent) bool {
 	return true
 }
 
-// AddTorrent ...
-func (s *BTService) AddTorrent(uri string) (*Torrent, error) {
+// AddTorrent adds torrent from magnet, URL or file. Options select storage of the torrent,
+// nil options mean storage, selected for the torrent before, or storage from settings,
+// options from AutoStorage place new torrents on download volume with enough free space
+func (s *BTService) AddTorrent(uri string, options *StorageOptions) (*Torrent, error) {
 	log.Infof("Adding torrent from %s", uri)
 
-	if s.config.DownloadStorage != estorage.StorageMemory && s.config.DownloadPath == "." {
-		xbmc.Notify("Elementum", "LOCALIZE[30113]", config.AddonIcon())
-		return nil, fmt.Errorf("Download path empty")
-	}
-
 	var err error
-	var torrentHandle *gotorrent.Torrent
+	var spec *gotorrent.TorrentSpec
+	var size int64
 	if strings.HasPrefix(uri, "magnet:") {
-		if torrentHandle, err = s.Client.AddMagnet(uri); err != nil {
+		if spec, err = gotorrent.TorrentSpecFromMagnetURI(uri); err != nil {
 			return nil, err
-		} else if torrentHandle == nil {
-			return nil, errors.New("Could not add torrent")
 		}
 		uri = ""
 	} else {
@@ -410,22 +428,62 @@ func (s *BTService) AddTorrent(uri string) (*Torrent, error) {
 		}
 
 		log.Debugf("Adding torrent: %#v", uri)
-		if torrentHandle, err = s.Client.AddTorrentFromFile(uri); err != nil {
+		mi, err := metainfo.LoadFromFile(uri)
+		if err != nil {
 			log.Warningf("Could not add torrent %s: %#v", uri, err)
 			return nil, err
-		} else if torrentHandle == nil {
-			return nil, errors.New("Could not add torrent")
 		}
+		spec = gotorrent.TorrentSpecFromMetaInfo(mi)
+		if info, err := mi.UnmarshalInfo(); err == nil {
+			size = info.TotalLength()
+		}
+	}
 
+	infoHash := spec.InfoHash.HexString()
+	selected := options != nil && !options.auto()
+	if t := s.GetTorrentByHash(infoHash); t != nil {
+		// Active torrent keeps its storage
+		options = t.storageOptions
+		selected = false
+	} else if !selected {
+		auto := options
+		options = savedStorageOptions(infoHash)
+
+		// New torrents go to the volume with enough free space
+		if options == nil && auto != nil && estorage.KeepsFiles(s.config.DownloadStorage) {
+			if v := s.placeTorrent(auto.MediaType, size); v != nil && !samePath(v.Path, s.config.DownloadPath) {
+				options = &StorageOptions{Type: -1, Path: v.Path}
+				selected = true
+			}
+		}
+	}
+
+	if estorage.KeepsFiles(s.storageType(options)) && s.storagePath(options) == "." {
+		xbmc.Notify("Elementum", "LOCALIZE[30113]", config.AddonIcon())
+		return nil, fmt.Errorf("Download path empty")
+	}
+
+	torrentHandle, err := s.addTorrentSpec(spec, options)
+	if err != nil {
+		log.Warningf("Could not add torrent %s: %#v", infoHash, err)
+		return nil, err
+	}
+
+	if selected {
+		log.Infof("Using storage %s for torrent %s", options, infoHash)
+		database.Get().UpdateStorageBTItem(infoHash, options.Type, options.Path)
 	}
 
 	log.Debugf("Making new torrent item with url = '%s'", uri)
 	torrent := NewTorrent(s, torrentHandle, uri)
+	torrent.storageOptions = options
 	if s.config.ConnectionsLimit > 0 {
 		torrentHandle.SetMaxEstablishedConns(s.config.ConnectionsLimit)
 	}
 
+	s.mu.Lock()
 	s.Torrents[torrent.infoHash] = torrent
+	s.mu.Unlock()
 
 	go torrent.SaveMetainfo(s.config.TorrentsPath)
 	go torrent.Watch()
@@ -438,14 +496,22 @@ func (s *BTService) RemoveTorrent(torrent *Torrent, removeFiles bool) bool {
 	log.Debugf("Removing torrent: %s", torrent.Name())
 	if torrent == nil {
 		return false
+	} else if torrent.IsMoving() {
+		log.Warningf("Torrent %s is moving, cannot remove it", torrent.Name())
+		return false
 	}
 
 	defer func() {
+		s.Queue.Remove(torrent.InfoHash())
 		database.Get().DeleteBTItem(torrent.InfoHash())
 	}()
 
-	if t, ok := s.Torrents[torrent.infoHash]; ok {
-		delete(s.Torrents, torrent.infoHash)
+	s.mu.Lock()
+	t, ok := s.Torrents[torrent.infoHash]
+	delete(s.Torrents, torrent.infoHash)
+	s.mu.Unlock()
+
+	if ok {
 		t.Drop(removeFiles)
 		return true
 	}
@@ -454,21 +520,36 @@ func (s *BTService) RemoveTorrent(torrent *Torrent, removeFiles bool) bool {
 }
 
 func (s *BTService) loadTorrentFiles() {
-	// Not loading previous torrents on start
-	// Otherwise we can dig out all the memory and halt the device
-	if s.config.DownloadStorage == estorage.StorageMemory || !s.config.AutoloadTorrents {
-		return
-	}
-
 	pattern := filepath.Join(s.config.TorrentsPath, "*.torrent")
 	files, _ := filepath.Glob(pattern)
 
 	for _, torrentFile := range files {
+		infoHash := strings.TrimSuffix(filepath.Base(torrentFile), filepath.Ext(torrentFile))
+
+		// Torrents, restored after reconfiguration, are already active
+		s.mu.Lock()
+		_, active := s.Torrents[infoHash]
+		s.mu.Unlock()
+		if active {
+			continue
+		}
+
+		// Not loading previous torrents of memory storage on start
+		// Otherwise we can dig out all the memory and halt the device
+		if !estorage.KeepsFiles(s.storageType(savedStorageOptions(infoHash))) {
+			continue
+		}
+
+		// Without autoloading we only restore torrents, managed by download queue
+		if !s.config.AutoloadTorrents {
+			if i := database.Get().GetBTItem(infoHash); i == nil || i.QueueState == database.QueueNone {
+				continue
+			}
+		}
+
 		log.Infof("Loading torrent file %s", torrentFile)
 
-		var err error
-		var torrentHandle *gotorrent.Torrent
-		if torrentHandle, err = s.Client.AddTorrentFromFile(torrentFile); err != nil || torrentHandle == nil {
+		if _, err := metainfo.LoadFromFile(torrentFile); err != nil {
 			log.Errorf("Error adding torrent file for %s", torrentFile)
 			if _, err := os.Stat(torrentFile); err == nil {
 				if err := os.Remove(torrentFile); err != nil {
@@ -479,7 +560,7 @@ func (s *BTService) loadTorrentFiles() {
 			continue
 		}
 
-		t, _ := s.AddTorrent(torrentFile)
+		t, _ := s.AddTorrent(torrentFile, nil)
 		if t != nil {
 			i := database.Get().GetBTItem(t.InfoHash())
 
@@ -489,14 +570,17 @@ func (s *BTService) loadTorrentFiles() {
 				for _, p := range i.Files {
 					for _, f := range t.Torrent.Files() {
 						if f.Path() == p {
-							t.ChosenFiles = append(t.ChosenFiles, f)
-							f.Download()
+							t.DownloadFile(f)
 						}
 					}
 				}
+
+				s.Queue.Restore(t, i)
 			}
 		}
 	}
+
+	s.Queue.Process()
 }
 
 func (s *BTService) downloadProgress() {
@@ -510,6 +594,8 @@ func (s *BTService) downloadProgress() {
 	for {
 		select {
 		case <-rotateTicker.C:
+			s.Queue.Process()
+
 			// TODO: there should be a check whether service is in Pause state
 			// if !s.config.DisableBgProgress && s.dialogProgressBG != nil {
 			// 	s.dialogProgressBG.Close()
@@ -524,7 +610,7 @@ func (s *BTService) downloadProgress() {
 			activeTorrents := make([]*activeTorrent, 0)
 
 			for i, torrentHandle := range s.Torrents {
-				if torrentHandle == nil {
+				if torrentHandle == nil || torrentHandle.IsMoving() {
 					continue
 				}
 
@@ -535,7 +621,7 @@ func (s *BTService) downloadProgress() {
 				totalDownloadRate += torrentHandle.DownloadRate
 				totalUploadRate += torrentHandle.UploadRate
 
-				if progress < 100 && status != StatusPaused {
+				if progress < 100 && status != StatusPaused && !torrentHandle.IsQueued {
 					activeTorrents = append(activeTorrents, &activeTorrent{
 						torrentName:  torrentName,
 						downloadRate: float64(torrentHandle.DownloadRate),
@@ -578,7 +664,6 @@ func (s *BTService) downloadProgress() {
 						log.Error(errMsg)
 						return errors.New(errMsg)
 					}
-					log.Warning(torrentName, "finished seeding, moving files...")
 
 					// Check paths are valid and writable, and only once
 					if _, exists := pathChecked[item.Type]; !exists {
@@ -601,107 +686,13 @@ func (s *BTService) downloadProgress() {
 						}
 					}
 
-					log.Info("Removing the torrent without deleting files after Completed move ...")
-					s.RemoveTorrent(torrentHandle, false)
-
-					// Delete torrent file
-					torrentFile := filepath.Join(s.config.TorrentsPath, fmt.Sprintf("%s.torrent", infoHash))
-					if _, err := os.Stat(torrentFile); err == nil {
-						log.Info("Deleting torrent file at ", torrentFile)
-						if err := os.Remove(torrentFile); err != nil {
-							log.Error(err)
-							return err
-						}
+					// Torrents are processed once, results of the steps are shown in the torrents list
+					if !beginPostProcess(infoHash) {
+						return nil
 					}
 
-					if len(item.Files) <= 0 {
-						return errors.New("No files saved for BTItem")
-					}
-
-					// TODO: change logic to move all selected files, not just one
-					filePath := ""
-					fileName := ""
-					for _, p := range item.Files {
-						for _, f := range torrentHandle.Files() {
-							if f.Path() == p {
-								filePath = f.Path()
-								fileName = filepath.Base(filePath)
-							}
-						}
-					}
-
-					if filePath == "" {
-						return fmt.Errorf("Cannot find saved files: %#v", item.Files)
-					}
-
-					extracted := ""
-					re := regexp.MustCompile("(?i).*\\.rar")
-					if re.MatchString(fileName) {
-						extractedPath := filepath.Join(s.config.DownloadPath, filepath.Dir(filePath), "extracted")
-						files, err := ioutil.ReadDir(extractedPath)
-						if err != nil {
-							return err
-						}
-						if len(files) == 1 {
-							extracted = files[0].Name()
-						} else {
-							for _, file := range files {
-								fileNameCurrent := file.Name()
-								re := regexp.MustCompile("(?i).*\\.(mkv|mp4|mov|avi)")
-								if re.MatchString(fileNameCurrent) {
-									extracted = fileNameCurrent
-									break
-								}
-							}
-						}
-						if extracted != "" {
-							filePath = filepath.Join(filepath.Dir(filePath), "extracted", extracted)
-						} else {
-							return errors.New("No extracted file to move")
-						}
-					}
-
-					var dstPath string
-					if item.Type == "movie" {
-						dstPath = filepath.Dir(s.config.CompletedMoviesPath)
-					} else {
-						dstPath = filepath.Dir(s.config.CompletedShowsPath)
-						if item.ShowID > 0 {
-							show := tmdb.GetShow(item.ShowID, config.Get().Language)
-							if show != nil {
-								showPath := util.ToFileName(fmt.Sprintf("%s (%s)", show.Name, strings.Split(show.FirstAirDate, "-")[0]))
-								seasonPath := filepath.Join(showPath, fmt.Sprintf("Season %d", item.Season))
-								if item.Season == 0 {
-									seasonPath = filepath.Join(showPath, "Specials")
-								}
-								dstPath = filepath.Join(dstPath, seasonPath)
-								os.MkdirAll(dstPath, 0755)
-							}
-						}
-					}
-
-					go func() {
-						log.Infof("Moving %s to %s", fileName, dstPath)
-						srcPath := filepath.Join(s.config.DownloadPath, filePath)
-						if dst, err := util.Move(srcPath, dstPath); err != nil {
-							log.Error(err)
-						} else {
-							// Remove leftover folders
-							if dirPath := filepath.Dir(filePath); dirPath != "." {
-								os.RemoveAll(filepath.Dir(srcPath))
-								if extracted != "" {
-									parentPath := filepath.Clean(filepath.Join(filepath.Dir(srcPath), ".."))
-									if parentPath != "." && parentPath != s.config.DownloadPath {
-										os.RemoveAll(parentPath)
-									}
-								}
-							}
-							log.Warning(fileName, "moved to", dst)
-
-							log.Infof("Marking %s for removal from library and database...", torrentName)
-							database.Get().UpdateStatusBTItem(infoHash, Remove)
-						}
-					}()
+					log.Warning(torrentName, "finished seeding, processing files...")
+					go s.postProcess(torrentHandle, item)
 					return nil
 				}()
 			}
@@ -755,18 +746,35 @@ func (s *BTService) SetUploadLimit(i int) {
 	}
 }
 
-// RestoreLimits ...
+// RestoreLimits sets limits, active for current time, after they were lifted for buffering
 func (s *BTService) RestoreLimits() {
-	if s.config.DownloadRateLimit > 0 {
-		s.SetDownloadLimit(s.config.DownloadRateLimit)
-		log.Infof("Rate limiting download to %dkB/s", s.config.DownloadRateLimit/1024)
+	s.limitsMu.Lock()
+	s.limitsLifted = false
+	s.limitsMu.Unlock()
+
+	s.applyLimits()
+}
+
+// applyLimits sets limits from bandwidth schedule or from default settings,
+// download limit is not changed while it's lifted for buffering.
+func (s *BTService) applyLimits() {
+	download, upload := s.currentLimits()
+
+	s.limitsMu.Lock()
+	defer s.limitsMu.Unlock()
+
+	if s.limitsLifted {
+		log.Info("Keeping download unlimited while buffering")
+	} else if download > 0 {
+		s.SetDownloadLimit(download)
+		log.Infof("Rate limiting download to %dkB/s", download/1024)
 	} else {
 		s.SetDownloadLimit(0)
 	}
 
-	if s.config.UploadRateLimit > 0 {
-		s.SetUploadLimit(s.config.UploadRateLimit)
-		log.Infof("Rate limiting upload to %dkB/s", s.config.UploadRateLimit/1024)
+	if upload > 0 {
+		s.SetUploadLimit(upload)
+		log.Infof("Rate limiting upload to %dkB/s", upload/1024)
 	} else {
 		s.SetUploadLimit(0)
 	}
@@ -775,20 +783,15 @@ func (s *BTService) RestoreLimits() {
 // SetBufferingLimits ...
 func (s *BTService) SetBufferingLimits() {
 	if s.config.LimitAfterBuffering {
+		s.limitsMu.Lock()
+		defer s.limitsMu.Unlock()
+
+		s.limitsLifted = true
 		s.SetDownloadLimit(0)
 		log.Info("Resetting rate limited download for buffering")
 	}
 }
 
-// GetSeedTime ...
-func (s *BTService) GetSeedTime() int64 {
-	if s.config.DisableUpload {
-		return 0
-	}
-
-	return int64(s.config.SeedTimeLimit)
-}
-
 // GetBufferSize ...
 func (s *BTService) GetBufferSize() int64 {
 	b := int64(s.config.BufferSize)
@@ -841,7 +844,8 @@ func (s *BTService) AttachPlayer(p *BTPlayer) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if _, ok := s.Players[p.Torrent.InfoHash()]; ok {
+	// Closing player could still be attached, when next episode is played from the same torrent
+	if existing, ok := s.Players[p.Torrent.InfoHash()]; ok && !existing.closed {
 		return
 	}
 
@@ -857,7 +861,9 @@ func (s *BTService) DetachPlayer(p *BTPlayer) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	delete(s.Players, p.Torrent.InfoHash())
+	if s.Players[p.Torrent.InfoHash()] == p {
+		delete(s.Players, p.Torrent.InfoHash())
+	}
 }
 
 // GetPlayer searches for player with desired TMDB id
@@ -949,6 +955,30 @@ func (s *BTService) HasTorrentByQuery(query string) string {
 	return ""
 }
 
+// HasTorrentByHash checks whether there is active torrent with queried infohash
+func (s *BTService) HasTorrentByHash(infoHash string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t, ok := s.Torrents[infoHash]; ok && t != nil {
+		return t.InfoHash()
+	}
+
+	return ""
+}
+
+// GetTorrentByHash returns active torrent with queried infohash
+func (s *BTService) GetTorrentByHash(infoHash string) *Torrent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t, ok := s.Torrents[infoHash]; ok {
+		return t
+	}
+
+	return nil
+}
+
 // HasTorrentBySeason checks whether there is active torrent for queried season
 func (s *BTService) HasTorrentBySeason(tmdbID int, season int) string {
 	s.mu.Lock()
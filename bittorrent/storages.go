@@ -0,0 +1,162 @@
+package bittorrent
+
+import (
+	"errors"
+	"fmt"
+
+	gotorrent "github.com/anacrolix/torrent"
+
+	"github.com/elgatito/elementum/database"
+	estorage "github.com/elgatito/elementum/storage"
+	memory "github.com/elgatito/elementum/storage/memory_v2"
+)
+
+// StorageOptions selects storage of the torrent, instead of storage from settings.
+// Negative type means storage type from settings, empty path means download path from settings
+type StorageOptions struct {
+	Type int    `json:"type"`
+	Path string `json:"path"`
+
+	// MediaType is used to place torrents without selected path on download volumes
+	MediaType string `json:"media_type,omitempty"`
+}
+
+// NewStorageOptions checks storage type and returns options for AddTorrent
+func NewStorageOptions(storageType int, path string) (*StorageOptions, error) {
+	if _, ok := estorage.Storages[storageType]; !ok && storageType >= 0 {
+		return nil, fmt.Errorf("Unknown storage type %d", storageType)
+	}
+
+	return &StorageOptions{Type: storageType, Path: path}, nil
+}
+
+// AutoStorage returns options, which place the torrent on download volume for the media type
+func AutoStorage(mediaType string) *StorageOptions {
+	return &StorageOptions{Type: -1, MediaType: mediaType}
+}
+
+// auto checks whether options leave storage and path to settings and download volumes
+func (o *StorageOptions) auto() bool {
+	return o.Type < 0 && o.Path == ""
+}
+
+// String ...
+func (o *StorageOptions) String() string {
+	name := "default storage"
+	if o.Type >= 0 {
+		name = estorage.Storages[o.Type]
+	}
+
+	if o.Path == "" {
+		return name
+	}
+	return fmt.Sprintf("%s at %s", name, o.Path)
+}
+
+// savedStorageOptions returns storage, selected for the torrent before restart
+func savedStorageOptions(infoHash string) *StorageOptions {
+	if i := database.Get().GetBTItem(infoHash); i != nil && (i.StorageType >= 0 || i.StoragePath != "") {
+		return &StorageOptions{Type: i.StorageType, Path: i.StoragePath}
+	}
+	return nil
+}
+
+// storageType returns type of the storage, nil options mean storage from settings
+func (s *BTService) storageType(o *StorageOptions) int {
+	if o == nil || o.Type < 0 {
+		return s.config.DownloadStorage
+	}
+	return o.Type
+}
+
+// storagePath returns path, where storage keeps files, nil options mean storage from settings
+func (s *BTService) storagePath(o *StorageOptions) string {
+	if o == nil || o.Path == "" {
+		return s.config.DownloadPath
+	}
+	return o.Path
+}
+
+// getStorage returns storage for the options, torrents with the same options share the storage
+func (s *BTService) getStorage(o *StorageOptions) estorage.ElementumStorage {
+	storageType := s.storageType(o)
+	if o == nil || (storageType == s.config.DownloadStorage && s.storagePath(o) == s.config.DownloadPath) {
+		return s.DefaultStorage
+	}
+
+	key := fmt.Sprintf("%d:%s", storageType, s.storagePath(o))
+
+	s.storagesMu.Lock()
+	defer s.storagesMu.Unlock()
+
+	if st, ok := s.storages[key]; ok {
+		return st
+	}
+
+	log.Infof("Creating storage %s", o)
+	st := s.newStorage(storageType, s.storagePath(o))
+	st.SetReadaheadSize(s.GetBufferSize())
+	s.storages[key] = st
+
+	return st
+}
+
+// newStorage creates storage of the type, keeping files in the path
+func (s *BTService) newStorage(storageType int, path string) estorage.ElementumStorage {
+	if estorage.IsMemoryStorage(storageType) {
+		memSize := int64(s.config.MemorySize)
+		needSize := int64(s.config.BufferSize) + endBufferSize + 6*1024*1024
+
+		if memSize < needSize {
+			log.Noticef("Raising memory size (%d) to fit all the buffer (%d)", memSize, needSize)
+			memSize = needSize
+		}
+
+		if storageType == estorage.StorageMemoryDisk {
+			return memory.NewMemoryDiskStorage(memSize, s.config.DiskCachePath, int64(s.config.DiskCacheSize))
+		} else if storageType == estorage.StorageMemoryKeep {
+			return memory.NewMemoryKeepStorage(memSize, path)
+		}
+		return memory.NewMemoryStorage(memSize)
+	} else if storageType == estorage.StorageFat32 {
+		return estorage.NewFat32Storage(path)
+	} else if storageType == estorage.StorageMMap {
+		return estorage.NewMMapStorage(path, s.PieceCompletion)
+	}
+
+	return estorage.NewFileStorage(path, s.PieceCompletion)
+}
+
+// resetStorages forgets storages of torrents, they are created again for new client
+func (s *BTService) resetStorages() {
+	s.storagesMu.Lock()
+	defer s.storagesMu.Unlock()
+
+	s.storages = map[string]estorage.ElementumStorage{}
+}
+
+// addTorrentSpec adds torrent to the client with selected storage
+func (s *BTService) addTorrentSpec(spec *gotorrent.TorrentSpec, o *StorageOptions) (*gotorrent.Torrent, error) {
+	if o != nil {
+		spec.Storage = s.getStorage(o)
+	}
+
+	t, _, err := s.Client.AddTorrentSpec(spec)
+	if err != nil {
+		return nil, err
+	} else if t == nil {
+		return nil, errors.New("Could not add torrent")
+	}
+
+	return t, nil
+}
+
+// StorageType returns type of the storage, used by the torrent
+func (t *Torrent) StorageType() int {
+	return t.Service.storageType(t.storageOptions)
+}
+
+// DownloadPath returns path, where storage of the torrent keeps files
+func (t *Torrent) DownloadPath() string {
+	return t.Service.storagePath(t.storageOptions)
+}
@@ -0,0 +1,241 @@
+package bittorrent
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elgatito/elementum/config"
+	"github.com/elgatito/elementum/osdb"
+	"github.com/elgatito/elementum/subtitles"
+	"github.com/elgatito/elementum/tmdb"
+	"github.com/elgatito/elementum/tracks"
+	"github.com/elgatito/elementum/xbmc"
+)
+
+var (
+	tracksMu    sync.Mutex
+	tracksFiles = map[string]*tracksFile{}
+)
+
+type tracksFile struct {
+	*tracks.File
+	t *Torrent
+}
+
+// GetEmbeddedSubtitles returns subtitle tracks of torrent file, file index is used like in HLS URLs.
+// Only track headers are read, so just a few pieces are downloaded
+func GetEmbeddedSubtitles(s *BTService, infoHash string, file string) ([]*tracks.Track, error) {
+	f, err := getTracksFile(s, infoHash, file)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.Subtitles(), nil
+}
+
+// ExtractEmbeddedSubtitle writes text subtitle track of torrent file, and returns subtitle format.
+// Pieces are downloaded only for subtitle blocks, found with file index
+func ExtractEmbeddedSubtitle(s *BTService, infoHash string, file string, track int, w io.Writer) (string, error) {
+	f, err := getTracksFile(s, infoHash, file)
+	if err != nil {
+		return "", err
+	}
+
+	return f.Extract(track, w)
+}
+
+func getTracksFile(s *BTService, infoHash string, file string) (*tracksFile, error) {
+	t, f, err := getTorrentFile(s, infoHash, file)
+	if err != nil {
+		return nil, err
+	}
+
+	key := infoHash + "/" + file
+
+	tracksMu.Lock()
+	if tf, ok := tracksFiles[key]; ok && tf.t == t {
+		tracksMu.Unlock()
+		return tf, nil
+	}
+	tracksMu.Unlock()
+
+	// Probing waits for pieces, so other files are not blocked meanwhile
+	probed, err := tracks.Probe(f.Path(), &fileSource{t: t, f: f})
+	if err != nil {
+		return nil, err
+	}
+
+	tracksMu.Lock()
+	defer tracksMu.Unlock()
+
+	if tf, ok := tracksFiles[key]; ok && tf.t == t {
+		return tf, nil
+	}
+	tracksFiles[key] = &tracksFile{File: probed, t: t}
+	return tracksFiles[key], nil
+}
+
+// ChosenFileIndex returns index of the playing file in the torrent, used in embedded subtitles URLs
+func (btp *BTPlayer) ChosenFileIndex() string {
+	if btp.chosenFile == nil {
+		return ""
+	}
+
+	for i, f := range btp.Torrent.Files() {
+		if f.Path() == btp.chosenFile.Path() {
+			return strconv.Itoa(i)
+		}
+	}
+	return ""
+}
+
+// ReadAt reads exact range of the file, used for OpenSubtitles hash
+func (s *fileSource) ReadAt(b []byte, off int64) (int, error) {
+	r, err := s.Open(off, int64(len(b)))
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	return io.ReadFull(r, b)
+}
+
+// fetchSubtitles downloads the best subtitle in preferred languages and attaches it to playback.
+// OpenSubtitles hash needs only first and last 64KB of the file, which are buffered before playback anyway
+func (btp *BTPlayer) fetchSubtitles() {
+	if !config.Get().AutoSubtitles || btp.subtitlesFile != nil || btp.chosenFile == nil {
+		return
+	}
+
+	languages := subtitleLanguages()
+	if len(languages) == 0 {
+		return
+	}
+
+	query := &subtitles.Query{
+		FileName:  btp.fileName,
+		Size:      btp.fileSize,
+		Languages: languages,
+	}
+	if !btp.fillSubtitlesQuery(query, languages[0]) {
+		btp.log.Infof("Original language is preferred, not downloading subtitles")
+		return
+	}
+
+	hash, err := osdb.Hash(&fileSource{t: btp.Torrent, f: btp.chosenFile}, btp.fileSize)
+	if err != nil {
+		btp.log.Warningf("Could not calculate subtitles hash: %s", err)
+	}
+	query.Hash = hash
+
+	var best *subtitles.Subtitle
+	for _, sub := range subtitles.Search(subtitles.Enabled(), query) {
+		if containsString(languages, sub.Language) {
+			best = sub
+			break
+		}
+	}
+	if best == nil {
+		btp.log.Infof("No subtitles found for %s", btp.fileName)
+		return
+	}
+
+	format := best.Format
+	if format == "" {
+		format = "srt"
+	}
+	path := filepath.Join(subtitles.DownloadPath(), strings.TrimSuffix(btp.fileName, filepath.Ext(btp.fileName))+"."+best.Language+"."+format)
+	out, err := os.Create(path)
+	if err != nil {
+		btp.log.Warningf("Could not save subtitles: %s", err)
+		return
+	}
+	err = subtitles.Download(best, out)
+	out.Close()
+	if err != nil {
+		btp.log.Warningf("Could not download subtitles %s: %s", best.FileName, err)
+		os.Remove(path)
+		return
+	}
+
+	btp.log.Infof("Downloaded subtitles %s from %s", best.FileName, best.Provider)
+
+	// Subtitles can only be attached when Kodi plays the file
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+	for {
+		if btp.Torrent.IsPlaying {
+			xbmc.PlayerSetSubtitles(path)
+			return
+		}
+
+		select {
+		case <-btp.closing:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// fillSubtitlesQuery adds titles of the playing item, and returns false
+// when original language of the item is the preferred subtitles language
+func (btp *BTPlayer) fillSubtitlesQuery(query *subtitles.Query, preferred string) bool {
+	originalLanguage := ""
+	if btp.p.ContentType == episodeType && btp.p.ShowID > 0 {
+		if show := tmdb.GetShow(btp.p.ShowID, config.Get().Language); show != nil {
+			originalLanguage = show.OriginalLanguage
+			query.ShowTitle = show.OriginalName
+			query.Season = btp.p.Season
+			query.Episode = btp.p.Episode
+		}
+	} else if btp.p.TMDBId > 0 {
+		if movie := tmdb.GetMovie(btp.p.TMDBId, config.Get().Language); movie != nil {
+			originalLanguage = movie.OriginalLanguage
+			query.Title = movie.OriginalTitle
+			query.IMDBId = movie.IMDBId
+			if len(movie.ReleaseDate) >= 4 {
+				query.Year = movie.ReleaseDate[0:4]
+			}
+		}
+	}
+
+	return originalLanguage == "" || originalLanguage != xbmc.ConvertLanguage(preferred, xbmc.Iso639_1)
+}
+
+// subtitleLanguages returns ISO 639-2 codes of languages for automatic subtitles
+func subtitleLanguages() []string {
+	list := config.Get().AutoSubtitlesLanguages
+	if list == "" {
+		list = config.Get().OSDBLanguage
+	}
+
+	ret := []string{}
+	for _, lang := range strings.Split(list, ",") {
+		lang = strings.TrimSpace(lang)
+		if lang == "" {
+			continue
+		}
+		if lang == "Portuguese (Brazil)" {
+			ret = append(ret, "pob")
+		} else if isoLang := xbmc.ConvertLanguage(lang, xbmc.Iso639_2); isoLang == "gre" {
+			ret = append(ret, "ell")
+		} else if isoLang != "" {
+			ret = append(ret, isoLang)
+		}
+	}
+	return ret
+}
+
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
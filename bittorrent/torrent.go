@@ -17,16 +17,19 @@ import (
 	"github.com/elgatito/elementum/bittorrent/reader"
 	"github.com/elgatito/elementum/config"
 	"github.com/elgatito/elementum/database"
+	"github.com/elgatito/elementum/numbering"
 	estorage "github.com/elgatito/elementum/storage"
+	"github.com/elgatito/elementum/tmdb"
 )
 
 // Torrent ...
 type Torrent struct {
 	*gotorrent.Torrent
 
-	infoHash      string
-	readers       map[int64]*FileReader
-	bufferReaders map[string]*FileReader
+	infoHash        string
+	readers         map[int64]*FileReader
+	bufferReaders   map[string]*FileReader
+	prefetchReaders map[string]*FileReader
 
 	ChosenFiles []*gotorrent.File
 	TorrentPath string
@@ -45,13 +48,24 @@ type Torrent struct {
 	IsPaused    bool
 	IsBuffering bool
 	IsSeeding   bool
+	IsQueued    bool
 	// IsDownloadStarted used to mark started downloads to avoid getting
 	// "Checked" status when one piece is in checking state
 	IsDownloadStarted bool
 
 	IsRarArchive bool
+	// prefetched marks torrents, holding next episode, prefetched during playback.
+	// Decision of the player, closed meanwhile, is applied if the episode is not played
+	prefetched     bool
+	prefetchRemove bool
+	prefetchDelete bool
 
 	needSeeding bool
+	// moving is set while files are moved to another volume, handle is dropped meanwhile
+	moving bool
+
+	// storageOptions is storage, selected for the torrent, nil means storage from settings
+	storageOptions *StorageOptions
 
 	DBItem *database.BTItem
 
@@ -69,12 +83,20 @@ type Torrent struct {
 
 	pieceLength float64
 
+	downloadStarted  time.Time
+	downloadDuration time.Duration
+	seedingSince     time.Time
+	seedingStopped   bool
+	seedPolicy       *database.SeedPolicy
+	seedStatsSaved   time.Time
+	// uploadedBefore is uploaded size, saved before restart or with previous handle
+	uploadedBefore int64
+
 	closing        chan struct{}
 	bufferFinished chan struct{}
 
 	progressTicker *time.Ticker
 	bufferTicker   *time.Ticker
-	seedTicker     *time.Ticker
 }
 
 // NewTorrent ...
@@ -87,6 +109,7 @@ func NewTorrent(service *BTService, handle *gotorrent.Torrent, path string) *Tor
 		TorrentPath: path,
 
 		bufferReaders:        map[string]*FileReader{},
+		prefetchReaders:      map[string]*FileReader{},
 		readers:              map[int64]*FileReader{},
 		BufferPiecesProgress: map[int]float64{},
 		BufferProgress:       -1,
@@ -100,8 +123,6 @@ func NewTorrent(service *BTService, handle *gotorrent.Torrent, path string) *Tor
 		muReaders: &sync.Mutex{},
 
 		closing: make(chan struct{}),
-
-		seedTicker: &time.Ticker{},
 	}
 
 	log.Debugf("Waiting for information fetched for torrent: %#v", handle.InfoHash().HexString())
@@ -113,7 +134,7 @@ func NewTorrent(service *BTService, handle *gotorrent.Torrent, path string) *Tor
 
 // Storage ...
 func (t *Torrent) Storage() estorage.ElementumStorage {
-	return t.Service.DefaultStorage.GetTorrentStorage(t.infoHash)
+	return t.Service.getStorage(t.storageOptions).GetTorrentStorage(t.infoHash)
 }
 
 // Watch ...
@@ -133,10 +154,10 @@ func (t *Torrent) Watch() {
 	t.uploadedSize = 0
 
 	t.pieceLength = float64(t.Torrent.Info().PieceLength)
+	t.loadSeedStats()
 
 	defer t.progressTicker.Stop()
 	defer t.bufferTicker.Stop()
-	defer t.seedTicker.Stop()
 	defer close(t.bufferFinished)
 
 	for {
@@ -148,11 +169,11 @@ func (t *Torrent) Watch() {
 			go t.bufferFinishedEvent()
 
 		case <-t.progressTicker.C:
+			if t.IsMoving() {
+				continue
+			}
 			go t.progressEvent()
 
-		case <-t.seedTicker.C:
-			go t.seedTickerEvent()
-
 		case <-t.closing:
 			log.Debug("Stopping watch events")
 			return
@@ -264,17 +285,17 @@ func (t *Torrent) progressEvent() {
 	// t.lastDownRate = t.downloadedSize
 
 	log.Debugf("%.6s: %s/%s | %s (%.2f%%)", t.infoHash, humanize.Bytes(uint64(t.DownloadRate)), humanize.Bytes(uint64(t.UploadRate)), t.GetStateString(), t.GetProgress())
-	if t.needSeeding && t.Service.GetSeedTime() > 0 && t.GetProgress() >= 100 {
-		t.muSeeding.Lock()
-		seedingTime := time.Duration(t.Service.GetSeedTime()) * time.Hour
-		log.Debugf("Starting seeding timer (%s) for: %s", seedingTime, t.Info().Name)
-
-		t.IsSeeding = true
-		t.needSeeding = false
-		t.seedTicker = time.NewTicker(seedingTime)
-
-		t.muSeeding.Unlock()
+	if t.needSeeding && t.GetProgress() >= 100 {
+		if t.seedingEnabled() {
+			t.startSeeding()
+		} else if !t.seedingStopped {
+			// Seeding limits are applied per torrent, this one has no limits
+			t.stopSeeding()
+		}
+	} else if t.IsSeeding {
+		t.checkSeedPolicy()
 	}
+	t.trackSeedStats()
 
 	if t.DBItem == nil {
 		t.GetDBItem()
@@ -300,13 +321,6 @@ func (t *Torrent) bufferFinishedEvent() {
 	t.bufferReaders = map[string]*FileReader{}
 }
 
-func (t *Torrent) seedTickerEvent() {
-	log.Debugf("Stopping seeding for: %s", t.Info().Name)
-	t.Torrent.SetMaxEstablishedConns(0)
-	t.IsSeeding = false
-	t.seedTicker.Stop()
-}
-
 // Buffer defines buffer pieces for downloading prior to sending file to Kodi.
 // Kodi sends two requests, one for onecoming file read handler,
 // another for a piece of file from the end (probably to get codec descriptors and so on)
@@ -316,6 +330,7 @@ func (t *Torrent) Buffer(file *gotorrent.File) {
 		return
 	}
 
+	t.closePrefetch()
 	t.startBufferTicker()
 
 	preBufferStart, preBufferEnd, preBufferOffset, preBufferSize := t.getBufferSize(file, 0, t.Service.GetBufferSize())
@@ -359,6 +374,139 @@ func (t *Torrent) Buffer(file *gotorrent.File) {
 	t.bufferReaders["post"].SetReadahead(postBufferSize)
 }
 
+// Prefetch requests start and end pieces of the file, without waiting for them,
+// so the file, which is going to be played next, starts without buffering
+func (t *Torrent) Prefetch(file *gotorrent.File) {
+	if file == nil {
+		return
+	}
+
+	t.closePrefetch()
+
+	_, _, preBufferOffset, preBufferSize := t.getBufferSize(file, 0, t.Service.GetBufferSize())
+	_, _, postBufferOffset, postBufferSize := t.getBufferSize(file, file.Length()-endBufferSize, endBufferSize)
+
+	log.Debugf("Prefetching file: %s, Pre: %s, Post: %s", file.DisplayPath(), humanize.Bytes(uint64(preBufferSize)), humanize.Bytes(uint64(postBufferSize)))
+
+	t.muBuffer.Lock()
+	defer t.muBuffer.Unlock()
+
+	t.prefetchReaders["pre"], _ = newFileReader(t, file, "")
+	t.prefetchReaders["pre"].Seek(preBufferOffset, io.SeekStart)
+	t.prefetchReaders["pre"].SetReadahead(preBufferSize)
+
+	t.prefetchReaders["post"], _ = newFileReader(t, file, "")
+	t.prefetchReaders["post"].Seek(postBufferOffset, io.SeekStart)
+	t.prefetchReaders["post"].SetReadahead(postBufferSize)
+}
+
+// SeasonPackFile returns file of the episode, if torrent is a known season pack of the season
+func (t *Torrent) SeasonPackFile(showID, season, episode int) *gotorrent.File {
+	pack := database.Get().GetSeasonPack(showID, season)
+	if pack == nil || pack.InfoHash != t.InfoHash() {
+		return nil
+	}
+
+	path, ok := pack.Files[episode]
+	if !ok {
+		return nil
+	}
+	for _, f := range t.Files() {
+		if f.Path() == path {
+			return f
+		}
+	}
+
+	return nil
+}
+
+// EpisodeFile returns file of the episode, found with season pack files or episode numbering of the show
+func (t *Torrent) EpisodeFile(show *tmdb.Show, season, episode int) *gotorrent.File {
+	if f := t.SeasonPackFile(show.ID, season, episode); f != nil {
+		return f
+	}
+
+	number := numbering.Get(show).Episode(season, episode)
+	var matched *gotorrent.File
+	for _, f := range t.Files() {
+		if f.Length() < minCandidateSize || !number.Match(filepath.Base(f.Path())) {
+			continue
+		}
+		if matched != nil {
+			return nil
+		}
+		matched = f
+	}
+
+	return matched
+}
+
+// IsPrefetched checks whether torrent holds next episode, prefetched during playback
+func (t *Torrent) IsPrefetched() bool {
+	t.muBuffer.RLock()
+	defer t.muBuffer.RUnlock()
+
+	return t.prefetched
+}
+
+// SetPrefetched marks torrent as holding prefetched episode, unmarked torrent forgets player's decision
+func (t *Torrent) SetPrefetched(prefetched bool) {
+	t.muBuffer.Lock()
+	defer t.muBuffer.Unlock()
+
+	t.prefetched = prefetched
+	if !prefetched {
+		t.prefetchRemove = false
+		t.prefetchDelete = false
+	}
+}
+
+// keepPrefetched saves decision of closed player, to apply it when prefetched episode is not played
+func (t *Torrent) keepPrefetched(remove, deleteFiles bool) {
+	t.muBuffer.Lock()
+	defer t.muBuffer.Unlock()
+
+	t.prefetchRemove = remove
+	t.prefetchDelete = deleteFiles
+}
+
+// DropPrefetch stops prefetching of not played episode, and removes the torrent,
+// if the player has decided to remove it
+func (t *Torrent) DropPrefetch() {
+	t.muBuffer.Lock()
+	remove, deleteFiles := t.prefetchRemove, t.prefetchDelete
+	t.prefetched = false
+	t.prefetchRemove = false
+	t.prefetchDelete = false
+	t.muBuffer.Unlock()
+
+	t.closePrefetch()
+	if !remove {
+		return
+	}
+
+	savedFilePath := filepath.Join(t.Service.config.TorrentsPath, fmt.Sprintf("%s.torrent", t.InfoHash()))
+	if _, err := os.Stat(savedFilePath); err == nil {
+		log.Infof("Deleting saved torrent file at %s", savedFilePath)
+		defer os.Remove(savedFilePath)
+	}
+
+	log.Infof("Removing the torrent with not played prefetched episode: %s", t.Name())
+	t.Service.RemoveTorrent(t, deleteFiles)
+}
+
+func (t *Torrent) closePrefetch() {
+	t.muBuffer.Lock()
+	defer t.muBuffer.Unlock()
+
+	for _, r := range t.prefetchReaders {
+		if r != nil {
+			r.Close()
+		}
+	}
+	t.prefetchReaders = map[string]*FileReader{}
+}
+
 func (t *Torrent) getBufferSize(f *gotorrent.File, off, length int64) (startPiece, endPiece int, offset, size int64) {
 	if off < 0 {
 		off = 0
@@ -401,8 +549,12 @@ func (t *Torrent) getBufferSize(f *gotorrent.File, off, length int64) (startPiec
 func (t *Torrent) GetState() int {
 	// log.Debugf("Status: %#v, %#v, %#v, %#v ", t.IsBuffering, t.BytesCompleted(), t.BytesMissing(), t.Stats())
 
-	if t.IsPaused {
+	if t.IsMoving() {
+		return StatusMoving
+	} else if t.IsPaused {
 		return StatusPaused
+	} else if t.IsQueued {
+		return StatusQueued
 	} else if t.IsBuffering {
 		return StatusBuffering
 	}
@@ -485,11 +637,7 @@ func (t *Torrent) GetProgress() float64 {
 		return 0
 	}
 
-	var total int64
-	for _, f := range t.ChosenFiles {
-		total += f.Length()
-	}
-
+	total := t.chosenLength()
 	if total == 0 {
 		return 0
 	}
@@ -510,7 +658,10 @@ func (t *Torrent) DownloadFile(f *gotorrent.File) {
 	t.ChosenFiles = append(t.ChosenFiles, f)
 	log.Debugf("Choosing file for download: %s", f.DisplayPath())
 	// TODO: Change this in general to be able to use per-torrent storage
-	if t.Storage() != nil && t.Service.config.DownloadStorage != estorage.StorageMemory {
+	if t.Storage() != nil && estorage.KeepsFiles(t.StorageType()) {
+		if k, ok := t.Storage().(estorage.FileKeeper); ok {
+			k.KeepFile(f.Path())
+		}
 		f.Download()
 	}
 }
@@ -543,6 +694,8 @@ func (t *Torrent) Drop(removeFiles bool) {
 
 	t.closing <- struct{}{}
 
+	forgetStreams(t)
+	t.closePrefetch()
 	for _, r := range t.readers {
 		if r != nil {
 			r.Close()
@@ -553,13 +706,13 @@ func (t *Torrent) Drop(removeFiles bool) {
 		t.Torrent.Drop()
 
 		defer func() {
-			if s := t.Storage(); s != nil && t.Service.config.DownloadStorage == estorage.StorageMemory {
+			if s := t.Storage(); s != nil && estorage.IsMemoryStorage(t.StorageType()) {
 				log.Debugf("Invoking storage.Close()")
 				s.Close()
 			}
 		}()
 
-		if !removeFiles || t.Service.config.DownloadStorage == estorage.StorageMemory {
+		if !removeFiles || !estorage.KeepsFiles(t.StorageType()) {
 			return
 		}
 
@@ -571,7 +724,7 @@ func (t *Torrent) Drop(removeFiles bool) {
 		for i := 1; i <= 4; i++ {
 			left = 0
 			for _, f := range files {
-				path := filepath.Join(t.Service.ClientConfig.DataDir, f)
+				path := filepath.Join(t.DownloadPath(), f)
 				if _, err := os.Stat(path); err == nil {
 					log.Infof("Deleting torrent file at %s", path)
 					if errRm := os.Remove(path); errRm != nil {
@@ -594,6 +747,31 @@ func (t *Torrent) Drop(removeFiles bool) {
 	}()
 }
 
+// IsMoving checks whether torrent files are being moved to another volume
+func (t *Torrent) IsMoving() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.moving
+}
+
+// setMoving marks torrent as moving, returns false if it is already moving
+func (t *Torrent) setMoving(moving bool) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if moving && t.moving {
+		return false
+	}
+	t.moving = moving
+	return true
+}
+
+// allowsConnections checks whether torrent is not paused, queued or done with seeding
+func (t *Torrent) allowsConnections() bool {
+	return !t.IsPaused && !t.IsQueued && !t.seedingStopped
+}
+
 // Pause ...
 func (t *Torrent) Pause() {
 	if t.Torrent != nil {
@@ -617,8 +795,8 @@ func (t *Torrent) GetDBItem() *database.BTItem {
 
 // SaveMetainfo ...
 func (t *Torrent) SaveMetainfo(path string) error {
-	// Not saving torrent for memory storage
-	if t.Service.config.DownloadStorage == estorage.StorageMemory {
+	// Not saving torrent for memory storage, that does not keep files
+	if !estorage.KeepsFiles(t.StorageType()) {
 		return nil
 	}
 	if t.Torrent == nil {
@@ -643,7 +821,7 @@ func (t *Torrent) SaveMetainfo(path string) error {
 // GetReadaheadSize ...
 func (t *Torrent) GetReadaheadSize() int64 {
 	defaultRA := int64(50 * 1024 * 1024)
-	if t.Service.config.DownloadStorage != estorage.StorageMemory {
+	if !estorage.IsMemoryStorage(t.StorageType()) {
 		return defaultRA
 	}
 
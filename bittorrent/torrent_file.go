@@ -51,6 +51,8 @@ type TorrentFile struct {
 	SceneRating int    `json:"scene_rating"`
 
 	hasResolved bool
+	// resolutionGuessed is set, when name has no resolution tag, and default resolution is used
+	resolutionGuessed bool
 }
 
 // Used to avoid infinite recursion in UnmarshalJSON
@@ -288,6 +290,29 @@ func NewTorrentFile(uri string) *TorrentFile {
 	return t
 }
 
+// NewTorrentFileFromRelease creates TorrentFile for a link with known release name,
+// like items of RSS feeds, so quality tags are parsed from the name
+func NewTorrentFileFromRelease(uri string, name string, size uint64) *TorrentFile {
+	t := &TorrentFile{
+		URI:   uri,
+		Name:  name,
+		Title: name,
+	}
+	if size > 0 {
+		t.Size = humanize.Bytes(size)
+	}
+	t.initialize()
+	if size > 0 {
+		t.SizeParsed = size
+	}
+	return t
+}
+
+// HasResolution checks whether resolution is known, and is not a default one
+func (t *TorrentFile) HasResolution() bool {
+	return !t.resolutionGuessed
+}
+
 func (t *TorrentFile) initialize() {
 	if t.IsMagnet() {
 		t.initializeFromMagnet()
@@ -297,6 +322,7 @@ func (t *TorrentFile) initialize() {
 		t.Resolution = matchLowerTags(t, resolutionTags)
 		if t.Resolution == ResolutionUnknown {
 			t.Resolution = Resolution480p
+			t.resolutionGuessed = true
 		}
 	}
 	if t.VideoCodec == CodecUnknown {
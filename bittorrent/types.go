@@ -27,6 +27,8 @@ const (
 	StatusAllocating
 	// StatusStalled ...
 	StatusStalled
+	// StatusMoving ...
+	StatusMoving
 )
 
 // StatusStrings ...
@@ -41,6 +43,7 @@ var StatusStrings = []string{
 	"Seeding",
 	"Allocating",
 	"Stalled",
+	"Moving",
 }
 
 const (
@@ -0,0 +1,335 @@
+package bittorrent
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	gotorrent "github.com/anacrolix/torrent"
+	"github.com/dustin/go-humanize"
+
+	"github.com/elgatito/elementum/config"
+	"github.com/elgatito/elementum/database"
+	"github.com/elgatito/elementum/diskusage"
+	estorage "github.com/elgatito/elementum/storage"
+	"github.com/elgatito/elementum/util"
+	"github.com/elgatito/elementum/xbmc"
+)
+
+// Volume is a download location with optional quota and preferred media types
+type Volume struct {
+	Path string `json:"path"`
+	// Quota is the most space, torrents can take on the volume, 0 means no quota
+	Quota      int64    `json:"quota"`
+	MediaTypes []string `json:"media_types"`
+}
+
+// VolumeStatus shows free space of the volume and space, taken by torrents
+type VolumeStatus struct {
+	*Volume
+
+	Free      int64  `json:"free"`
+	Used      int64  `json:"used"`
+	Available int64  `json:"available"`
+	Torrents  int    `json:"torrents"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ParseVolumes parses volumes like "/mnt/disk1,500,movie;/mnt/disk2,,episode",
+// quota is in GB, volumes without media types are used for everything
+func ParseVolumes(list string) ([]*Volume, error) {
+	ret := []*Volume{}
+	for _, entry := range strings.Split(list, ";") {
+		fields := strings.Split(entry, ",")
+		v := &Volume{Path: strings.TrimSpace(fields[0]), MediaTypes: []string{}}
+		if v.Path == "" {
+			continue
+		}
+
+		if len(fields) > 1 && strings.TrimSpace(fields[1]) != "" {
+			quota, err := strconv.ParseFloat(strings.TrimSpace(fields[1]), 64)
+			if err != nil || quota < 0 {
+				return nil, fmt.Errorf("Wrong quota of volume %s: %s", v.Path, fields[1])
+			}
+			v.Quota = int64(quota * 1024 * 1024 * 1024)
+		}
+		for _, mediaType := range fields[min(len(fields), 2):] {
+			if mediaType = strings.ToLower(strings.TrimSpace(mediaType)); mediaType != "" {
+				v.MediaTypes = append(v.MediaTypes, mediaType)
+			}
+		}
+
+		ret = append(ret, v)
+	}
+
+	return ret, nil
+}
+
+// Volumes returns configured download volumes, download path is always one of them
+func (s *BTService) Volumes() []*Volume {
+	volumes, err := ParseVolumes(s.config.DownloadVolumes)
+	if err != nil {
+		log.Warningf("Cannot parse download volumes: %s", err)
+		volumes = []*Volume{}
+	}
+
+	for _, v := range volumes {
+		if samePath(v.Path, s.config.DownloadPath) {
+			return volumes
+		}
+	}
+	if s.config.DownloadPath == "." {
+		return volumes
+	}
+
+	return append([]*Volume{{Path: s.config.DownloadPath, MediaTypes: []string{}}}, volumes...)
+}
+
+// GetVolumesStatus returns free and used space of download volumes
+func (s *BTService) GetVolumesStatus() []*VolumeStatus {
+	ret := []*VolumeStatus{}
+	for _, v := range s.Volumes() {
+		ret = append(ret, s.volumeStatus(v, nil))
+	}
+	return ret
+}
+
+// volumeStatus calculates space, available on the volume, excluded torrent is not counted
+func (s *BTService) volumeStatus(v *Volume, exclude *Torrent) *VolumeStatus {
+	ret := &VolumeStatus{Volume: v}
+
+	s.mu.Lock()
+	for _, t := range s.Torrents {
+		if t == exclude || t.Torrent == nil || t.Info() == nil || !estorage.KeepsFiles(t.StorageType()) || !samePath(t.DownloadPath(), v.Path) {
+			continue
+		}
+		ret.Used += t.BytesCompleted() + t.BytesMissing()
+		ret.Torrents++
+	}
+	s.mu.Unlock()
+
+	diskStatus, err := diskusage.DiskUsage(v.Path)
+	if err != nil {
+		ret.Error = err.Error()
+		return ret
+	}
+
+	ret.Free = diskStatus.Free
+	ret.Available = ret.Free
+	if v.Quota > 0 && v.Quota-ret.Used < ret.Available {
+		ret.Available = max64(v.Quota-ret.Used, 0)
+	}
+	return ret
+}
+
+// placeTorrent returns volume with enough space for the torrent, preferring volumes of the media type.
+// Size of magnets is not known, so they are placed on the volume with most available space
+func (s *BTService) placeTorrent(mediaType string, size int64) *Volume {
+	volumes := s.Volumes()
+	if len(volumes) < 2 {
+		return nil
+	}
+
+	preference := func(v *Volume) int {
+		if len(v.MediaTypes) == 0 {
+			return 1
+		}
+		for _, t := range v.MediaTypes {
+			if t == mediaType {
+				return 0
+			}
+		}
+		return 2
+	}
+
+	candidates := []*VolumeStatus{}
+	for _, v := range volumes {
+		status := s.volumeStatus(v, nil)
+		if status.Error != "" {
+			log.Warningf("Cannot use volume %s: %s", v.Path, status.Error)
+			continue
+		}
+		if status.Available <= size {
+			log.Debugf("Not enough space on volume %s: %s available", v.Path, humanize.Bytes(uint64(status.Available)))
+			continue
+		}
+		candidates = append(candidates, status)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if pi, pj := preference(candidates[i].Volume), preference(candidates[j].Volume); pi != pj {
+			return pi < pj
+		}
+		return candidates[i].Available > candidates[j].Available
+	})
+
+	return candidates[0].Volume
+}
+
+// MoveTorrentStorage moves files of the torrent to another volume in the background. Torrent is added back
+// to the client with the same readers, so it can be moved while it is downloading, but not while it is playing
+func (s *BTService) MoveTorrentStorage(t *Torrent, path string) error {
+	if t == nil || t.Torrent == nil || t.Info() == nil {
+		return errors.New("Torrent is not available")
+	} else if t.IsMoving() {
+		return errors.New("Torrent is already moving")
+	} else if s.isPlaying(t) {
+		return errors.New("Torrent is playing")
+	}
+	if !estorage.KeepsFiles(t.StorageType()) {
+		return errors.New("Storage of the torrent does not keep files")
+	}
+	if err := config.IsWritablePath(path); err != nil {
+		return err
+	}
+
+	oldPath := t.DownloadPath()
+	if samePath(oldPath, path) {
+		return nil
+	}
+
+	for _, v := range s.Volumes() {
+		if !samePath(v.Path, path) {
+			continue
+		}
+		if status := s.volumeStatus(v, t); status.Error == "" && status.Available < t.BytesCompleted()+t.BytesMissing() {
+			return fmt.Errorf("Not enough space on %s, has %s", path, humanize.Bytes(uint64(status.Available)))
+		}
+	}
+
+	if !t.setMoving(true) {
+		return errors.New("Torrent is already moving")
+	}
+
+	go func() {
+		defer t.setMoving(false)
+		defer xbmc.Refresh()
+
+		if err := s.moveTorrentStorage(t, oldPath, path); err != nil {
+			xbmc.Notify("Elementum", err.Error(), config.AddonIcon())
+		}
+	}()
+
+	return nil
+}
+
+// isPlaying checks whether any player, or any stream, is reading the torrent
+func (s *BTService) isPlaying(t *Torrent) bool {
+	t.muReaders.Lock()
+	readers := len(t.readers)
+	t.muReaders.Unlock()
+	if readers > 0 {
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, p := range s.Players {
+		if p != nil && p.Torrent == t {
+			return true
+		}
+	}
+	return false
+}
+
+// moveTorrentStorage drops the torrent, moves its files and adds it back.
+// When files can't be moved, or torrent can't be added in new path, it is added back in old path
+func (s *BTService) moveTorrentStorage(t *Torrent, oldPath, path string) error {
+	options := &StorageOptions{Type: -1, Path: path}
+	if t.storageOptions != nil {
+		options.Type = t.storageOptions.Type
+	}
+
+	files := []string{}
+	for _, f := range t.Torrent.Files() {
+		files = append(files, f.Path())
+	}
+	mi := t.Torrent.Metainfo()
+
+	log.Infof("Moving torrent %s from %s to %s", t.Name(), oldPath, path)
+	t.Torrent.Drop()
+
+	moved, err := moveFiles(files, oldPath, path)
+	var handle *gotorrent.Torrent
+	if err == nil {
+		if handle, err = s.addTorrentSpec(gotorrent.TorrentSpecFromMetaInfo(&mi), options); err != nil {
+			log.Errorf("Could not add moved torrent %s: %s", t.Name(), err)
+		}
+	} else {
+		log.Warningf("Could not move torrent %s: %s", t.Name(), err)
+	}
+
+	if err != nil {
+		if _, errBack := moveFiles(moved, path, oldPath); errBack != nil {
+			log.Errorf("Could not move files back to %s: %s", oldPath, errBack)
+		}
+
+		handle, errAdd := s.addTorrentSpec(gotorrent.TorrentSpecFromMetaInfo(&mi), t.storageOptions)
+		if errAdd != nil {
+			log.Errorf("Could not add torrent %s back: %s", t.Name(), errAdd)
+			s.mu.Lock()
+			delete(s.Torrents, t.infoHash)
+			s.mu.Unlock()
+
+			// Watch and readers of dropped torrent are closed
+			t.Drop(false)
+			return errAdd
+		}
+
+		s.replaceHandle(t, handle)
+		return err
+	}
+
+	t.storageOptions = options
+	s.replaceHandle(t, handle)
+	database.Get().UpdateStorageBTItem(t.infoHash, options.Type, options.Path)
+
+	return nil
+}
+
+// moveFiles moves existing files between download paths, returns moved files
+func moveFiles(files []string, from, to string) ([]string, error) {
+	moved := []string{}
+	for _, f := range files {
+		src := filepath.Join(from, f)
+		if _, err := os.Stat(src); err != nil {
+			continue
+		}
+
+		dst := filepath.Join(to, f)
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return moved, err
+		}
+		if _, err := util.Move(src, dst); err != nil {
+			return moved, err
+		}
+		moved = append(moved, f)
+
+		// Removing folders, left empty
+		for dir := filepath.Dir(src); !samePath(dir, from) && strings.HasPrefix(dir, filepath.Clean(from)); dir = filepath.Dir(dir) {
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+	}
+	return moved, nil
+}
+
+func samePath(a, b string) bool {
+	return filepath.Clean(a) == filepath.Clean(b)
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
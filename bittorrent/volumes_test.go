@@ -0,0 +1,100 @@
+package bittorrent
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/elgatito/elementum/config"
+)
+
+func TestParseVolumes(t *testing.T) {
+	gb := int64(1024 * 1024 * 1024)
+
+	tests := []struct {
+		list    string
+		want    []*Volume
+		wantErr bool
+	}{
+		{list: "", want: []*Volume{}},
+		{list: "/mnt/disk1", want: []*Volume{{Path: "/mnt/disk1", MediaTypes: []string{}}}},
+		{list: "/mnt/disk1,500,movie;/mnt/disk2,,Episode, anime", want: []*Volume{
+			{Path: "/mnt/disk1", Quota: 500 * gb, MediaTypes: []string{"movie"}},
+			{Path: "/mnt/disk2", MediaTypes: []string{"episode", "anime"}},
+		}},
+		{list: " /mnt/disk1 , 0.5 ;; ,100,movie", want: []*Volume{{Path: "/mnt/disk1", Quota: gb / 2, MediaTypes: []string{}}}},
+		{list: "/mnt/disk1,,,movie,", want: []*Volume{{Path: "/mnt/disk1", MediaTypes: []string{"movie"}}}},
+		{list: "/mnt/disk1,abc", wantErr: true},
+		{list: "/mnt/disk1,-1,movie", wantErr: true},
+	}
+
+	for _, test := range tests {
+		got, err := ParseVolumes(test.list)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%q: expected error", test.list)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", test.list, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: parsed %+v, expected %+v", test.list, got, test.want)
+		}
+	}
+}
+
+func TestPlaceTorrent(t *testing.T) {
+	root := t.TempDir()
+	paths := map[string]string{}
+	for _, name := range []string{"default", "movies", "episodes"} {
+		paths[name] = filepath.Join(root, name)
+		if err := os.MkdirAll(paths[name], 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Episodes volume has a quota of about 1kB
+	s := &BTService{
+		config: &config.Configuration{
+			DownloadPath:    paths["default"],
+			DownloadVolumes: paths["movies"] + ",,movie;" + paths["episodes"] + ",0.000001,episode",
+		},
+		Torrents: map[string]*Torrent{},
+	}
+
+	tests := []struct {
+		mediaType string
+		size      int64
+		want      string
+	}{
+		{"movie", 100, "movies"},
+		{"episode", 100, "episodes"},
+		{"episode", 5000, "default"},
+		{"anime", 100, "default"},
+		{"", 0, "default"},
+		{"movie", 1 << 62, ""},
+	}
+
+	for _, test := range tests {
+		got := ""
+		if v := s.placeTorrent(test.mediaType, test.size); v != nil {
+			for name, path := range paths {
+				if samePath(v.Path, path) {
+					got = name
+				}
+			}
+		}
+		if got != test.want {
+			t.Errorf("%s of %d bytes is placed on %q, expected %q", test.mediaType, test.size, got, test.want)
+		}
+	}
+
+	s.config.DownloadVolumes = ""
+	if v := s.placeTorrent("movie", 100); v != nil {
+		t.Errorf("Torrent is placed on %s without download volumes", v.Path)
+	}
+}
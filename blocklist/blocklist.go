@@ -0,0 +1,243 @@
+package blocklist
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent/iplist"
+	"github.com/op/go-logging"
+
+	"github.com/elgatito/elementum/bittorrent"
+	"github.com/elgatito/elementum/config"
+	"github.com/elgatito/elementum/scrape"
+)
+
+const (
+	defaultFrequency = 24
+	fetchTimeout     = 2 * time.Minute
+)
+
+var log = logging.MustGetLogger("blocklist")
+
+// Status describes loaded blocklists and results of the last update
+type Status struct {
+	Enabled bool            `json:"enabled"`
+	Running bool            `json:"running"`
+	Updated time.Time       `json:"updated"`
+	Rules   int             `json:"rules"`
+	Bundled int             `json:"bundled"`
+	Sources []*SourceStatus `json:"sources"`
+}
+
+// SourceStatus is a user blocklist with number of rules, loaded from it
+type SourceStatus struct {
+	Source  string    `json:"source"`
+	Rules   int       `json:"rules"`
+	Updated time.Time `json:"updated"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// Blocklist loads user blocklists and replaces blocklist of the client
+type Blocklist struct {
+	s  *bittorrent.BTService
+	mu sync.Mutex
+
+	sources string
+	ranges  map[string][]iplist.Range
+	// packed is the memory-mapped bundled blocklist, given to the client with the last update
+	packed iplist.Ranger
+	status Status
+}
+
+var blocklist *Blocklist
+
+// Init loads blocklists and updates them with configured frequency
+func Init(s *bittorrent.BTService) {
+	blocklist = &Blocklist{
+		s:      s,
+		ranges: map[string][]iplist.Range{},
+	}
+
+	if config.Get().BlocklistSources != "" {
+		if err := Update(); err != nil {
+			log.Warningf("Blocklist update failed: %s", err)
+		}
+	}
+
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if s.ShuttingDown {
+			return
+		}
+
+		conf := config.Get()
+		frequency := conf.BlocklistFrequency
+		if frequency <= 0 {
+			frequency = defaultFrequency
+		}
+
+		blocklist.mu.Lock()
+		updated := blocklist.status.Updated
+		changed := blocklist.sources != conf.BlocklistSources
+		blocklist.mu.Unlock()
+		if !changed && (conf.BlocklistSources == "" || time.Since(updated) < time.Duration(frequency)*time.Hour) {
+			continue
+		}
+
+		if err := Update(); err != nil {
+			log.Warningf("Blocklist update failed: %s", err)
+		}
+	}
+}
+
+// GetStatus returns loaded blocklists and results of the last update
+func GetStatus() *Status {
+	ret := &Status{}
+	if blocklist != nil {
+		blocklist.mu.Lock()
+		*ret = blocklist.status
+		blocklist.mu.Unlock()
+	}
+	ret.Enabled = config.Get().BlocklistSources != ""
+	if ret.Sources == nil {
+		ret.Sources = []*SourceStatus{}
+	}
+
+	return ret
+}
+
+// Update reloads user blocklists and replaces blocklist of the client
+func Update() error {
+	if blocklist == nil {
+		return errors.New("Blocklist is not initialized")
+	}
+
+	return blocklist.update()
+}
+
+func (b *Blocklist) update() error {
+	b.mu.Lock()
+	if b.status.Running {
+		b.mu.Unlock()
+		return errors.New("Blocklist update is already running")
+	}
+	b.status.Running = true
+	previous := b.status.Sources
+	b.mu.Unlock()
+
+	defer func() {
+		b.mu.Lock()
+		b.status.Running = false
+		b.mu.Unlock()
+	}()
+
+	conf := config.Get()
+	sources := []*SourceStatus{}
+	ranges := map[string][]iplist.Range{}
+	user := []iplist.Range{}
+	for _, source := range strings.Split(conf.BlocklistSources, ",") {
+		source = strings.TrimSpace(source)
+		if source == "" {
+			continue
+		}
+
+		status := &SourceStatus{Source: source}
+		list, err := load(source)
+		if err != nil {
+			log.Warningf("Could not load blocklist %s: %s", source, err)
+			status.Error = err.Error()
+
+			// Keep previously loaded rules, if the list is not available right now
+			b.mu.Lock()
+			list = b.ranges[source]
+			b.mu.Unlock()
+			for _, p := range previous {
+				if p.Source == source {
+					status.Updated = p.Updated
+				}
+			}
+		} else {
+			log.Infof("Loaded %d rules from blocklist %s", len(list), source)
+			status.Updated = time.Now()
+		}
+
+		status.Rules = len(list)
+		ranges[source] = list
+		user = append(user, list...)
+		sources = append(sources, status)
+	}
+
+	ranger := multiRanger{}
+	bundled := 0
+	packed, err := iplist.MMapPackedFile(filepath.Join(conf.Info.Path, "resources", "misc", "pack-iplist"))
+	if err == nil && packed != nil {
+		ranger = append(ranger, packed)
+		bundled = packed.NumRanges()
+	} else if err != nil {
+		log.Warningf("Could not load bundled blocklist: %s", err)
+	}
+	if len(user) > 0 {
+		ranger = append(ranger, newRangeList(user))
+	}
+
+	b.s.SetIPBlocklist(ranger)
+
+	b.mu.Lock()
+	previousPacked := b.packed
+	b.packed = packed
+	b.sources = conf.BlocklistSources
+	b.ranges = ranges
+	b.status.Updated = time.Now()
+	b.status.Rules = ranger.NumRanges()
+	b.status.Bundled = bundled
+	b.status.Sources = sources
+	b.mu.Unlock()
+
+	// Client is not using previous list anymore, so its mapping can be released
+	if c, ok := previousPacked.(io.Closer); ok && previousPacked != packed {
+		if err := c.Close(); err != nil {
+			log.Warningf("Could not close bundled blocklist: %s", err)
+		}
+	}
+
+	log.Noticef("Blocklist updated with %d rules", ranger.NumRanges())
+	return nil
+}
+
+// load reads blocklist from URL or local path
+func load(source string) ([]iplist.Range, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		f, err := os.Open(source)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		return Parse(f)
+	}
+
+	client := &http.Client{
+		Timeout:   fetchTimeout,
+		Transport: scrape.GetClient().Transport,
+	}
+	resp, err := client.Get(source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Bad status: %d", resp.StatusCode)
+	}
+
+	return Parse(resp.Body)
+}
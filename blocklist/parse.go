@@ -0,0 +1,248 @@
+package blocklist
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/anacrolix/torrent/iplist"
+)
+
+// maxBlockedLevel is the highest access level of DAT rules, which still blocks the range
+const maxBlockedLevel = 127
+
+// Parse reads blocklist in P2P, DAT or CIDR format, format is detected for every line,
+// gzip-compressed lists are decompressed
+func Parse(r io.Reader) ([]iplist.Range, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		br = bufio.NewReader(gz)
+	}
+
+	ret := []iplist.Range{}
+	scanner := bufio.NewScanner(br)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//") {
+			continue
+		}
+
+		r, ok, err := parseLine(line)
+		if err != nil {
+			log.Debugf("Skipping blocklist line %d: %s", number, err)
+			continue
+		}
+		if ok {
+			ret = append(ret, r)
+		}
+	}
+
+	return ret, scanner.Err()
+}
+
+// parseLine returns range of the line, ok is false for DAT rules, which allow the range
+func parseLine(line string) (r iplist.Range, ok bool, err error) {
+	// 001.002.004.000 - 001.002.004.255 , 000 , Description
+	if parts := strings.SplitN(line, ",", 3); len(parts) >= 2 {
+		if first, last, errRange := parseRange(parts[0]); errRange == nil {
+			level, errLevel := strconv.Atoi(strings.TrimSpace(parts[1]))
+			if errLevel != nil {
+				return r, false, fmt.Errorf("Wrong access level: %s", parts[1])
+			}
+			if level > maxBlockedLevel {
+				return r, false, nil
+			}
+			r.First, r.Last = first, last
+			if len(parts) == 3 {
+				r.Description = strings.TrimSpace(parts[2])
+			}
+			return checkRange(r, line)
+		}
+	}
+
+	switch {
+	// 1.2.3.0/24
+	case strings.Contains(line, "/"):
+		fields := strings.Fields(line)
+		_, network, errCIDR := net.ParseCIDR(fields[0])
+		if errCIDR != nil {
+			return r, false, errCIDR
+		}
+		r.First = normalize(network.IP)
+		r.Last = make(net.IP, len(r.First))
+		for i := range r.First {
+			r.Last[i] = r.First[i] | ^network.Mask[len(network.Mask)-len(r.First)+i]
+		}
+		if len(fields) > 1 {
+			r.Description = strings.TrimLeft(strings.Join(fields[1:], " "), "#; ")
+		}
+
+	// Description:1.2.4.0-1.2.4.255
+	case strings.Contains(line, ":") && strings.Contains(line, "-"):
+		r.Description, r.First, r.Last, err = parseP2P(line)
+
+	default:
+		r.First, r.Last, err = parseRange(line)
+	}
+
+	if err != nil {
+		return r, false, err
+	}
+	return checkRange(r, line)
+}
+
+// parseP2P splits description from the range. Both description and IPv6 addresses can contain colons,
+// so the range starts after the first colon, which leaves a valid range, or at the beginning of the line
+func parseP2P(line string) (description string, first, last net.IP, err error) {
+	for i := -1; i < len(line); {
+		if rest := line[i+1:]; !strings.Contains(rest, "-") {
+			break
+		} else if first, last, err = parseRange(rest); err == nil && len(first) == len(last) {
+			if i >= 0 {
+				description = strings.TrimSpace(line[:i])
+			}
+			return
+		}
+
+		next := strings.Index(line[i+1:], ":")
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+
+	return "", nil, nil, fmt.Errorf("Wrong range: %s", line)
+}
+
+func checkRange(r iplist.Range, line string) (iplist.Range, bool, error) {
+	if len(r.First) != len(r.Last) || bytes.Compare(r.First, r.Last) > 0 {
+		return r, false, fmt.Errorf("Wrong range: %s", line)
+	}
+	return r, true, nil
+}
+
+// parseRange parses "first - last", or a single address
+func parseRange(s string) (first, last net.IP, err error) {
+	parts := strings.SplitN(s, "-", 2)
+	if first = parseIP(parts[0]); first == nil {
+		return nil, nil, fmt.Errorf("Wrong address: %s", parts[0])
+	}
+	if len(parts) == 1 {
+		return first, first, nil
+	}
+	if last = parseIP(parts[1]); last == nil {
+		return nil, nil, fmt.Errorf("Wrong address: %s", parts[1])
+	}
+	return first, last, nil
+}
+
+// parseIP parses address, IPv4 octets of DAT lists can have leading zeros
+func parseIP(s string) net.IP {
+	s = strings.TrimSpace(s)
+	if strings.Count(s, ".") == 3 && !strings.Contains(s, ":") {
+		octets := strings.Split(s, ".")
+		for i, o := range octets {
+			if trimmed := strings.TrimLeft(o, "0"); trimmed != "" {
+				octets[i] = trimmed
+			} else {
+				octets[i] = "0"
+			}
+		}
+		s = strings.Join(octets, ".")
+	}
+
+	return normalize(net.ParseIP(s))
+}
+
+// normalize keeps IPv4 addresses in 4 bytes, so they are compared with IPv4 ranges only
+func normalize(ip net.IP) net.IP {
+	if ip == nil {
+		return nil
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4
+	}
+	return ip.To16()
+}
+
+// rangeList is a sorted list of ranges without overlaps, it is looked up with binary search
+type rangeList []iplist.Range
+
+func newRangeList(ranges []iplist.Range) rangeList {
+	sort.Slice(ranges, func(i, j int) bool {
+		if len(ranges[i].First) != len(ranges[j].First) {
+			return len(ranges[i].First) < len(ranges[j].First)
+		}
+		return bytes.Compare(ranges[i].First, ranges[j].First) < 0
+	})
+
+	ret := rangeList{}
+	for _, r := range ranges {
+		if n := len(ret); n > 0 && len(ret[n-1].Last) == len(r.First) && bytes.Compare(r.First, ret[n-1].Last) <= 0 {
+			if bytes.Compare(r.Last, ret[n-1].Last) > 0 {
+				ret[n-1].Last = r.Last
+			}
+			continue
+		}
+		ret = append(ret, r)
+	}
+	return ret
+}
+
+// Lookup ...
+func (l rangeList) Lookup(ip net.IP) (iplist.Range, bool) {
+	ip = normalize(ip)
+	if ip == nil {
+		return iplist.Range{}, false
+	}
+
+	i := sort.Search(len(l), func(i int) bool {
+		if len(l[i].Last) != len(ip) {
+			return len(l[i].Last) > len(ip)
+		}
+		return bytes.Compare(l[i].Last, ip) >= 0
+	})
+	if i < len(l) && len(l[i].First) == len(ip) && bytes.Compare(l[i].First, ip) <= 0 {
+		return l[i], true
+	}
+	return iplist.Range{}, false
+}
+
+// NumRanges ...
+func (l rangeList) NumRanges() int {
+	return len(l)
+}
+
+// multiRanger looks up address in bundled and user blocklists
+type multiRanger []iplist.Ranger
+
+// Lookup ...
+func (m multiRanger) Lookup(ip net.IP) (iplist.Range, bool) {
+	for _, r := range m {
+		if found, ok := r.Lookup(ip); ok {
+			return found, true
+		}
+	}
+	return iplist.Range{}, false
+}
+
+// NumRanges ...
+func (m multiRanger) NumRanges() int {
+	ret := 0
+	for _, r := range m {
+		ret += r.NumRanges()
+	}
+	return ret
+}
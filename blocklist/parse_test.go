@@ -0,0 +1,132 @@
+package blocklist
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		line        string
+		first       string
+		last        string
+		description string
+		ok          bool
+		wantErr     bool
+	}{
+		{line: "Bad Guys, Inc:1.2.4.0-1.2.4.255", first: "1.2.4.0", last: "1.2.4.255", description: "Bad Guys, Inc", ok: true},
+		{line: "Time: 12:00:1.2.4.0 - 1.2.4.255", first: "1.2.4.0", last: "1.2.4.255", description: "Time: 12:00", ok: true},
+		{line: "IPv6 list:2001:db8::1-2001:db8::ff", first: "2001:db8::1", last: "2001:db8::ff", description: "IPv6 list", ok: true},
+		{line: "2001:db8::1-2001:db8::ff", first: "2001:db8::1", last: "2001:db8::ff", ok: true},
+		{line: "001.002.003.000 - 001.002.003.255 , 000 , Some DAT", first: "1.2.3.0", last: "1.2.3.255", description: "Some DAT", ok: true},
+		{line: "001.002.003.000 - 001.002.003.255 , 127", first: "1.2.3.0", last: "1.2.3.255", ok: true},
+		{line: "005.000.000.000 - 005.000.000.255 , 200 , allowed"},
+		{line: "10.0.0.0/8 private", first: "10.0.0.0", last: "10.255.255.255", description: "private", ok: true},
+		{line: "2001:db8::/32", first: "2001:db8::", last: "2001:db8:ffff:ffff:ffff:ffff:ffff:ffff", ok: true},
+		{line: "9.9.9.9", first: "9.9.9.9", last: "9.9.9.9", ok: true},
+		{line: "Mixed:1.2.3.4-2001:db8::1", wantErr: true},
+		{line: "Reversed:1.2.4.255-1.2.4.0", wantErr: true},
+		{line: "Broken:1.2.4-1.2.4.255", wantErr: true},
+		{line: "1.2.3.0 - 1.2.3.255 , high , DAT", wantErr: true},
+		{line: "10.0.0.0/33", wantErr: true},
+		{line: "garbage", wantErr: true},
+	}
+
+	for _, test := range tests {
+		r, ok, err := parseLine(test.line)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%q: expected error", test.line)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", test.line, err)
+			continue
+		}
+		if ok != test.ok {
+			t.Errorf("%q: blocking is %v, expected %v", test.line, ok, test.ok)
+		}
+		if !ok {
+			continue
+		}
+		if !r.First.Equal(net.ParseIP(test.first)) || !r.Last.Equal(net.ParseIP(test.last)) {
+			t.Errorf("%q: range is %s-%s, expected %s-%s", test.line, r.First, r.Last, test.first, test.last)
+		}
+		if r.Description != test.description {
+			t.Errorf("%q: description is %q, expected %q", test.line, r.Description, test.description)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	list := `# comment
+// another comment
+Bad Guys, Inc:1.2.4.0-1.2.4.255
+
+005.000.000.000 - 005.000.000.255 , 200 , allowed
+garbage
+10.0.0.0/8 private
+`
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write([]byte(list))
+	gz.Close()
+
+	for name, data := range map[string][]byte{"plain": []byte(list), "gzip": compressed.Bytes()} {
+		ranges, err := Parse(bytes.NewReader(data))
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if len(ranges) != 2 {
+			t.Errorf("%s: parsed %d ranges, expected 2", name, len(ranges))
+		}
+	}
+
+	if _, err := Parse(strings.NewReader("\x1f\x8bbroken")); err == nil {
+		t.Error("Expected error for broken gzip")
+	}
+}
+
+func TestRangeListLookup(t *testing.T) {
+	ranges, err := Parse(strings.NewReader(strings.Join([]string{
+		"A:1.2.4.0-1.2.4.255",
+		"B:1.2.4.128-1.2.5.10",
+		"C:10.0.0.0-10.0.0.10",
+		"D:2001:db8::1-2001:db8::ff",
+	}, "\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := newRangeList(ranges)
+	if l.NumRanges() != 3 {
+		t.Errorf("List has %d ranges, expected 3 after merging overlaps", l.NumRanges())
+	}
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"1.2.4.0", true},
+		{"1.2.5.10", true},
+		{"1.2.5.11", false},
+		{"1.2.3.255", false},
+		{"10.0.0.5", true},
+		{"::ffff:10.0.0.5", true},
+		{"2001:db8::80", true},
+		{"2001:db8::100", false},
+		{"::", false},
+	}
+
+	for _, test := range tests {
+		if _, got := l.Lookup(net.ParseIP(test.ip)); got != test.want {
+			t.Errorf("%s: blocked is %v, expected %v", test.ip, got, test.want)
+		}
+	}
+}
@@ -58,6 +58,9 @@ type Configuration struct {
 	ShowUnairedSeasons        bool
 	ShowUnairedEpisodes       bool
 	SmartEpisodeMatch         bool
+	SeasonPackPrefetch        bool
+	PrefetchNextEpisode       bool
+	PrefetchNextPercent       int
 	LibraryUpdate             int
 	StrmLanguage              string
 	LibraryNFOMovies          bool
@@ -67,19 +70,25 @@ type Configuration struct {
 	AutoMemorySize            bool
 	AutoMemorySizeStrategy    int
 	MemorySize                int
+	DiskCacheSize             int
+	DiskCachePath             string
 	BufferSize                int
 	KodiBufferSize            int
 	UploadRateLimit           int
 	DownloadRateLimit         int
+	BandwidthScheduleEnabled  bool
+	BandwidthSchedule         string
 	AutoloadTorrents          bool
+	MaxActiveDownloads        int
 	LimitAfterBuffering       bool
 	ConnectionsLimit          int
 	ConnTrackerLimit          int
 	ConnTrackerLimitAuto      bool
 	// SessionSave         int
-	// ShareRatioLimit     int
-	// SeedTimeRatioLimit  int
+	ShareRatioLimit      int
+	SeedTimeRatioLimit   int
 	SeedTimeLimit        int
+	SeedLimitAction      int
 	DisableUpload        bool
 	DisableDHT           bool
 	DisableTCP           bool
@@ -105,6 +114,7 @@ type Configuration struct {
 	TraktSyncUserlists             bool
 	TraktSyncWatched               bool
 	TraktSyncWatchedBack           bool
+	TraktSyncPlayback              bool
 	TraktSyncAddedMovies           bool
 	TraktSyncAddedMoviesLocation   int
 	TraktSyncAddedMoviesList       int
@@ -140,16 +150,26 @@ type Configuration struct {
 	OSDBPass         string
 	OSDBLanguage     string
 	OSDBAutoLanguage bool
+	OSDBAPIKey       string
+	SubtitlesFolder  string
+
+	AutoSubtitles bool
+	// AutoSubtitlesLanguages is a comma-separated list of languages, in the order of preference
+	AutoSubtitlesLanguages string
 
 	SortingModeMovies           int
 	SortingModeShows            int
 	ResolutionPreferenceMovies  int
 	ResolutionPreferenceShows   int
+	ScoringEnabled              bool
 	PercentageAdditionalSeeders int
 
 	CustomProviderTimeoutEnabled bool
 	CustomProviderTimeout        int
 
+	TorznabEnabled   bool
+	TorznabProviders string
+
 	InternalDNSEnabled bool
 
 	InternalProxyEnabled bool
@@ -172,6 +192,32 @@ type Configuration struct {
 	CompletedMoviesPath string
 	CompletedShowsPath  string
 
+	// CompletedRename renames completed files with patterns like "{title} ({year})/{title} - S{season}E{episode}"
+	CompletedRename        bool
+	CompletedMoviesPattern string
+	CompletedShowsPattern  string
+	CompletedHardlink      bool
+	CompletedSkipSamples   bool
+	CompletedNFO           bool
+	CompletedScan          bool
+
+	// DownloadVolumes is a list of download locations like "path,quota in GB,media types",
+	// separated with ";"
+	DownloadVolumes string
+
+	FeedsEnabled   bool
+	FeedsFrequency int
+
+	MonitorEnabled   bool
+	MonitorFrequency int
+	MonitorBacklog   int
+
+	HLSEnabled bool
+
+	// BlocklistSources is a comma-separated list of paths and URLs of IP blocklists
+	BlocklistSources   string
+	BlocklistFrequency int
+
 	LocalOnlyClient bool
 }
 
@@ -303,9 +349,10 @@ func Reload() *Configuration {
 	downloadPath := TranslatePath(xbmc.GetSettingString("download_path"))
 	libraryPath := TranslatePath(xbmc.GetSettingString("library_path"))
 	torrentsPath := TranslatePath(xbmc.GetSettingString("torrents_path"))
+	diskCachePath := TranslatePath(xbmc.GetSettingString("disk_cache_path"))
 	downloadStorage := xbmc.GetSettingInt("download_storage")
 
-	if downloadStorage != 1 {
+	if downloadStorage != 1 && downloadStorage != 4 {
 		if downloadPath == "." {
 			settingsWarning = "LOCALIZE[30113]"
 			panic(settingsWarning)
@@ -317,6 +364,22 @@ func Reload() *Configuration {
 	}
 	log.Infof("Using download path: %s", downloadPath)
 
+	if diskCachePath == "." {
+		diskCachePath = filepath.Join(info.Profile, "piece_cache")
+	}
+	if downloadStorage == 4 {
+		if err := os.MkdirAll(diskCachePath, 0777); err != nil {
+			log.Errorf("Could not create disk cache directory: %#v", err)
+			settingsWarning = err.Error()
+			panic(settingsWarning)
+		} else if err := IsWritablePath(diskCachePath); err != nil {
+			log.Errorf("Cannot write to disk cache location '%s': %#v", diskCachePath, err)
+			settingsWarning = err.Error()
+			panic(settingsWarning)
+		}
+		log.Infof("Using disk cache path: %s", diskCachePath)
+	}
+
 	if libraryPath == "." {
 		settingsWarning = "LOCALIZE[30220]"
 		panic(settingsWarning)
@@ -399,10 +462,15 @@ func Reload() *Configuration {
 		AutoMemorySize:            settings["auto_memory_size"].(bool),
 		AutoMemorySizeStrategy:    settings["auto_memory_size_strategy"].(int),
 		MemorySize:                settings["memory_size"].(int) * 1024 * 1024,
+		DiskCacheSize:             settings["disk_cache_size"].(int) * 1024 * 1024,
+		DiskCachePath:             diskCachePath,
 		BufferSize:                settings["buffer_size"].(int) * 1024 * 1024,
 		UploadRateLimit:           settings["max_upload_rate"].(int) * 1024,
 		DownloadRateLimit:         settings["max_download_rate"].(int) * 1024,
+		BandwidthScheduleEnabled:  settings["bandwidth_schedule_enabled"].(bool),
+		BandwidthSchedule:         settings["bandwidth_schedule"].(string),
 		AutoloadTorrents:          settings["autoload_torrents"].(bool),
+		MaxActiveDownloads:        settings["max_active_downloads"].(int),
 		SpoofUserAgent:            settings["spoof_user_agent"].(int),
 		LimitAfterBuffering:       settings["limit_after_buffering"].(bool),
 		KeepDownloading:           settings["keep_downloading"].(int),
@@ -426,24 +494,28 @@ func Reload() *Configuration {
 		ShowUnairedEpisodes:       settings["unaired_episodes"].(bool),
 		PlaybackPercent:           settings["playback_percent"].(int),
 		SmartEpisodeMatch:         settings["smart_episode_match"].(bool),
+		SeasonPackPrefetch:        settings["season_pack_prefetch"].(bool),
+		PrefetchNextEpisode:       settings["prefetch_next_episode"].(bool),
+		PrefetchNextPercent:       settings["prefetch_next_percent"].(int),
 		LibraryUpdate:             settings["library_update"].(int),
 		StrmLanguage:              settings["strm_language"].(string),
 		LibraryNFOMovies:          settings["library_nfo_movies"].(bool),
 		LibraryNFOShows:           settings["library_nfo_shows"].(bool),
-		// ShareRatioLimit:     settings["share_ratio_limit"].(int),
-		// SeedTimeRatioLimit:  settings["seed_time_ratio_limit"].(int),
-		SeedTimeLimit:        settings["seed_time_limit"].(int),
-		DisableUpload:        settings["disable_upload"].(bool),
-		DisableDHT:           settings["disable_dht"].(bool),
-		DisableTCP:           settings["disable_tcp"].(bool),
-		DisableUTP:           settings["disable_utp"].(bool),
-		DisableUPNP:          settings["disable_upnp"].(bool),
-		EncryptionPolicy:     settings["encryption_policy"].(int),
-		ListenPortMin:        settings["listen_port_min"].(int),
-		ListenPortMax:        settings["listen_port_max"].(int),
-		ListenInterfaces:     settings["listen_interfaces"].(string),
-		ListenAutoDetectIP:   settings["listen_autodetect_ip"].(bool),
-		ListenAutoDetectPort: settings["listen_autodetect_port"].(bool),
+		ShareRatioLimit:           settings["share_ratio_limit"].(int),
+		SeedTimeRatioLimit:        settings["seed_time_ratio_limit"].(int),
+		SeedTimeLimit:             settings["seed_time_limit"].(int),
+		SeedLimitAction:           settings["seed_limit_action"].(int),
+		DisableUpload:             settings["disable_upload"].(bool),
+		DisableDHT:                settings["disable_dht"].(bool),
+		DisableTCP:                settings["disable_tcp"].(bool),
+		DisableUTP:                settings["disable_utp"].(bool),
+		DisableUPNP:               settings["disable_upnp"].(bool),
+		EncryptionPolicy:          settings["encryption_policy"].(int),
+		ListenPortMin:             settings["listen_port_min"].(int),
+		ListenPortMax:             settings["listen_port_max"].(int),
+		ListenInterfaces:          settings["listen_interfaces"].(string),
+		ListenAutoDetectIP:        settings["listen_autodetect_ip"].(bool),
+		ListenAutoDetectPort:      settings["listen_autodetect_port"].(bool),
 		// OutgoingInterfaces: settings["outgoing_interfaces"].(string),
 		// TunedStorage:        settings["tuned_storage"].(bool),
 		ConnectionsLimit:     settings["connections_limit"].(int),
@@ -462,6 +534,7 @@ func Reload() *Configuration {
 		TraktSyncUserlists:             settings["trakt_sync_userlists"].(bool),
 		TraktSyncWatched:               settings["trakt_sync_watched"].(bool),
 		TraktSyncWatchedBack:           settings["trakt_sync_watchedback"].(bool),
+		TraktSyncPlayback:              settings["trakt_sync_playback"].(bool),
 		TraktSyncAddedMovies:           settings["trakt_sync_added_movies"].(bool),
 		TraktSyncAddedMoviesLocation:   settings["trakt_sync_added_movies_location"].(int),
 		TraktSyncAddedMoviesList:       settings["trakt_sync_added_movies_list"].(int),
@@ -496,16 +569,25 @@ func Reload() *Configuration {
 		OSDBPass:         settings["osdb_pass"].(string),
 		OSDBLanguage:     settings["osdb_language"].(string),
 		OSDBAutoLanguage: settings["osdb_auto_language"].(bool),
+		OSDBAPIKey:       settings["osdb_api_key"].(string),
+		SubtitlesFolder:  settings["subtitles_folder"].(string),
+
+		AutoSubtitles:          settings["auto_subtitles"].(bool),
+		AutoSubtitlesLanguages: settings["auto_subtitles_languages"].(string),
 
 		SortingModeMovies:           settings["sorting_mode_movies"].(int),
 		SortingModeShows:            settings["sorting_mode_shows"].(int),
 		ResolutionPreferenceMovies:  settings["resolution_preference_movies"].(int),
 		ResolutionPreferenceShows:   settings["resolution_preference_shows"].(int),
+		ScoringEnabled:              settings["scoring_enabled"].(bool),
 		PercentageAdditionalSeeders: settings["percentage_additional_seeders"].(int),
 
 		CustomProviderTimeoutEnabled: settings["custom_provider_timeout_enabled"].(bool),
 		CustomProviderTimeout:        settings["custom_provider_timeout"].(int),
 
+		TorznabEnabled:   settings["torznab_enabled"].(bool),
+		TorznabProviders: settings["torznab_providers"].(string),
+
 		InternalDNSEnabled: settings["internal_dns_enabled"].(bool),
 
 		InternalProxyEnabled: settings["internal_proxy_enabled"].(bool),
@@ -527,12 +609,34 @@ func Reload() *Configuration {
 		CompletedMoviesPath: settings["completed_movies_path"].(string),
 		CompletedShowsPath:  settings["completed_shows_path"].(string),
 
+		CompletedRename:        settings["completed_rename"].(bool),
+		CompletedMoviesPattern: settings["completed_movies_pattern"].(string),
+		CompletedShowsPattern:  settings["completed_shows_pattern"].(string),
+		CompletedHardlink:      settings["completed_hardlink"].(bool),
+		CompletedSkipSamples:   settings["completed_skip_samples"].(bool),
+		CompletedNFO:           settings["completed_nfo"].(bool),
+		CompletedScan:          settings["completed_scan"].(bool),
+
+		DownloadVolumes: settings["download_volumes"].(string),
+
+		FeedsEnabled:   settings["feeds_enabled"].(bool),
+		FeedsFrequency: settings["feeds_frequency"].(int),
+
+		MonitorEnabled:   settings["monitor_enabled"].(bool),
+		MonitorFrequency: settings["monitor_frequency"].(int),
+		MonitorBacklog:   settings["monitor_backlog"].(int),
+
+		HLSEnabled: settings["hls_enabled"].(bool),
+
+		BlocklistSources:   settings["blocklist_sources"].(string),
+		BlocklistFrequency: settings["blocklist_frequency"].(int),
+
 		LocalOnlyClient: settings["local_only_client"].(bool),
 	}
 
 	// For memory storage we are changing configuration
 	// 	to stop downloading after playback has stopped and so on
-	if newConfig.DownloadStorage == 1 {
+	if newConfig.DownloadStorage == 1 || newConfig.DownloadStorage == 4 {
 		newConfig.CompletedMove = false
 		newConfig.KeepDownloading = 2
 		newConfig.KeepFilesFinished = 2
@@ -540,7 +644,11 @@ func Reload() *Configuration {
 
 		// TODO: Do we need this?
 		// newConfig.SeedTimeLimit = 0
+	}
 
+	// "Stream then keep" storage is using memory buffers as well,
+	// but it saves files to download path, so file settings are applied.
+	if newConfig.DownloadStorage == 1 || newConfig.DownloadStorage == 4 || newConfig.DownloadStorage == 5 {
 		// Calculate possible memory size, depending of selected strategy
 		if newConfig.AutoMemorySize {
 			if newConfig.AutoMemorySizeStrategy == 0 {
@@ -2,12 +2,24 @@ package database
 
 var schemaChanges = []schemaChange{
 	schemaV1,
+	schemaV2,
+	schemaV3,
+	schemaV4,
+	schemaV5,
+	schemaV6,
+	schemaV7,
+	schemaV8,
+	schemaV9,
+	schemaV10,
+	schemaV11,
+	schemaV12,
 }
 
 func schemaV1(previousVersion *int, db *SqliteDatabase) (success bool, err error) {
 	version := 1
 
-	if *previousVersion > version {
+	if *previousVersion >= version {
+		success = true
 		return
 	}
 
@@ -89,3 +101,339 @@ CREATE INDEX IF NOT EXISTS library_uids_idx2 ON library_uids (mediaType, tmdb);
 
 	return
 }
+
+func schemaV2(previousVersion *int, db *SqliteDatabase) (success bool, err error) {
+	version := 2
+
+	if *previousVersion >= version {
+		success = true
+		return
+	}
+
+	sql := `
+
+-- Download queue state of torrents
+ALTER TABLE tinfo ADD COLUMN queue_state INT NOT NULL DEFAULT 0;
+ALTER TABLE tinfo ADD COLUMN queue_priority INT NOT NULL DEFAULT 0;
+ALTER TABLE tinfo ADD COLUMN queue_position INT NOT NULL DEFAULT 0;
+CREATE INDEX IF NOT EXISTS tinfo_queue_idx ON tinfo (queue_state, queue_priority DESC, queue_position);
+
+`
+
+	if _, err = db.Exec(sql); err == nil {
+		*previousVersion = version
+		success = true
+	}
+
+	return
+}
+
+func schemaV3(previousVersion *int, db *SqliteDatabase) (success bool, err error) {
+	version := 3
+
+	if *previousVersion >= version {
+		success = true
+		return
+	}
+
+	sql := `
+
+-- Table stores per-torrent overrides of seeding limits, -1 means global setting is used
+CREATE TABLE IF NOT EXISTS tseed (
+  infohash TEXT NOT NULL UNIQUE,
+  ratio_limit INT NOT NULL DEFAULT -1,
+  seed_time_limit INT NOT NULL DEFAULT -1,
+  time_ratio_limit INT NOT NULL DEFAULT -1,
+  action INT NOT NULL DEFAULT -1
+);
+CREATE INDEX IF NOT EXISTS tseed_idx ON tseed (infohash);
+
+`
+
+	if _, err = db.Exec(sql); err == nil {
+		*previousVersion = version
+		success = true
+	}
+
+	return
+}
+
+func schemaV4(previousVersion *int, db *SqliteDatabase) (success bool, err error) {
+	version := 4
+
+	if *previousVersion >= version {
+		success = true
+		return
+	}
+
+	sql := `
+
+-- Table stores items of watched feeds, that were already processed
+CREATE TABLE IF NOT EXISTS feed_items (
+  feed TEXT NOT NULL DEFAULT "",
+  guid TEXT NOT NULL DEFAULT "",
+  infohash TEXT NOT NULL DEFAULT "",
+  title TEXT NOT NULL DEFAULT "",
+  dt INT NOT NULL DEFAULT 0
+);
+CREATE UNIQUE INDEX IF NOT EXISTS feed_items_idx ON feed_items (feed, guid);
+CREATE INDEX IF NOT EXISTS feed_items_infohash_idx ON feed_items (infohash);
+
+`
+
+	if _, err = db.Exec(sql); err == nil {
+		*previousVersion = version
+		success = true
+	}
+
+	return
+}
+
+func schemaV5(previousVersion *int, db *SqliteDatabase) (success bool, err error) {
+	version := 5
+
+	if *previousVersion >= version {
+		success = true
+		return
+	}
+
+	sql := `
+
+-- Table stores library shows, monitored for new episodes
+CREATE TABLE IF NOT EXISTS library_monitor (
+  showId INTEGER NOT NULL UNIQUE,
+  dt INT NOT NULL DEFAULT 0
+);
+
+-- Table stores episodes, grabbed by the monitor
+CREATE TABLE IF NOT EXISTS monitor_history (
+  showId INTEGER NOT NULL DEFAULT 0,
+  season INTEGER NOT NULL DEFAULT 0,
+  episode INTEGER NOT NULL DEFAULT 0,
+  infohash TEXT NOT NULL DEFAULT "",
+  title TEXT NOT NULL DEFAULT "",
+  dt INT NOT NULL DEFAULT 0
+);
+CREATE UNIQUE INDEX IF NOT EXISTS monitor_history_idx ON monitor_history (showId, season, episode);
+
+`
+
+	if _, err = db.Exec(sql); err == nil {
+		*previousVersion = version
+		success = true
+	}
+
+	return
+}
+
+func schemaV6(previousVersion *int, db *SqliteDatabase) (success bool, err error) {
+	version := 6
+
+	if *previousVersion >= version {
+		success = true
+		return
+	}
+
+	sql := `
+
+-- Table stores Trakt requests, waiting to be sent while Trakt is not reachable
+CREATE TABLE IF NOT EXISTS trakt_outbox (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  endpoint TEXT NOT NULL DEFAULT "",
+  payload TEXT NOT NULL DEFAULT "",
+  status INT NOT NULL DEFAULT 0,
+  attempts INT NOT NULL DEFAULT 0,
+  next_try INT NOT NULL DEFAULT 0,
+  error TEXT NOT NULL DEFAULT "",
+  dt INT NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS trakt_outbox_idx ON trakt_outbox (status, id);
+
+`
+
+	if _, err = db.Exec(sql); err == nil {
+		*previousVersion = version
+		success = true
+	}
+
+	return
+}
+
+func schemaV7(previousVersion *int, db *SqliteDatabase) (success bool, err error) {
+	version := 7
+
+	if *previousVersion >= version {
+		success = true
+		return
+	}
+
+	sql := `
+
+-- Table stores episode numbering mode, selected for the show
+CREATE TABLE IF NOT EXISTS show_numbering (
+  showId INTEGER NOT NULL UNIQUE,
+  mode INT NOT NULL DEFAULT 0,
+  dt INT NOT NULL DEFAULT 0
+);
+
+-- Table stores scene numbering of show episodes, uploaded by user
+CREATE TABLE IF NOT EXISTS show_scene_mapping (
+  showId INTEGER NOT NULL DEFAULT 0,
+  season INTEGER NOT NULL DEFAULT 0,
+  episode INTEGER NOT NULL DEFAULT 0,
+  scene_season INTEGER NOT NULL DEFAULT 0,
+  scene_episode INTEGER NOT NULL DEFAULT 0,
+  scene_absolute INTEGER NOT NULL DEFAULT 0
+);
+CREATE UNIQUE INDEX IF NOT EXISTS show_scene_mapping_idx ON show_scene_mapping (showId, season, episode);
+
+`
+
+	if _, err = db.Exec(sql); err == nil {
+		*previousVersion = version
+		success = true
+	}
+
+	return
+}
+
+func schemaV8(previousVersion *int, db *SqliteDatabase) (success bool, err error) {
+	version := 8
+
+	if *previousVersion >= version {
+		success = true
+		return
+	}
+
+	sql := `
+
+-- Table stores torrents, known to contain whole season of the show
+CREATE TABLE IF NOT EXISTS season_packs (
+  showId INTEGER NOT NULL DEFAULT 0,
+  season INTEGER NOT NULL DEFAULT 0,
+  infohash TEXT NOT NULL DEFAULT "",
+  metainfo BLOB NOT NULL DEFAULT "",
+  dt INT NOT NULL DEFAULT 0
+);
+CREATE UNIQUE INDEX IF NOT EXISTS season_packs_idx ON season_packs (showId, season);
+
+-- Table stores files of season packs, matched with episodes
+CREATE TABLE IF NOT EXISTS season_pack_files (
+  showId INTEGER NOT NULL DEFAULT 0,
+  season INTEGER NOT NULL DEFAULT 0,
+  episode INTEGER NOT NULL DEFAULT 0,
+  path TEXT NOT NULL DEFAULT ""
+);
+CREATE UNIQUE INDEX IF NOT EXISTS season_pack_files_idx ON season_pack_files (showId, season, episode);
+
+`
+
+	if _, err = db.Exec(sql); err == nil {
+		*previousVersion = version
+		success = true
+	}
+
+	return
+}
+
+func schemaV9(previousVersion *int, db *SqliteDatabase) (success bool, err error) {
+	version := 9
+
+	if *previousVersion >= version {
+		success = true
+		return
+	}
+
+	sql := `
+
+-- Storage, selected for the torrent, -1 means storage from settings is used
+ALTER TABLE tinfo ADD COLUMN storage_type INT NOT NULL DEFAULT -1;
+ALTER TABLE tinfo ADD COLUMN storage_path TEXT NOT NULL DEFAULT "";
+
+`
+
+	if _, err = db.Exec(sql); err == nil {
+		*previousVersion = version
+		success = true
+	}
+
+	return
+}
+
+func schemaV10(previousVersion *int, db *SqliteDatabase) (success bool, err error) {
+	version := 10
+
+	if *previousVersion >= version {
+		success = true
+		return
+	}
+
+	sql := `
+
+-- Table stores results of post-processing steps, run for completed torrents
+CREATE TABLE IF NOT EXISTS tpostprocess (
+  infohash TEXT NOT NULL DEFAULT "",
+  step TEXT NOT NULL DEFAULT "",
+  status INT NOT NULL DEFAULT 0,
+  message TEXT NOT NULL DEFAULT "",
+  dt INT NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS tpostprocess_idx ON tpostprocess (infohash);
+
+`
+
+	if _, err = db.Exec(sql); err == nil {
+		*previousVersion = version
+		success = true
+	}
+
+	return
+}
+
+func schemaV11(previousVersion *int, db *SqliteDatabase) (success bool, err error) {
+	version := 11
+
+	if *previousVersion >= version {
+		success = true
+		return
+	}
+
+	sql := `
+
+-- Seeding stats, kept between restarts: download time in seconds, seeding start time and uploaded bytes
+ALTER TABLE tinfo ADD COLUMN download_duration INT NOT NULL DEFAULT 0;
+ALTER TABLE tinfo ADD COLUMN seeding_since INT NOT NULL DEFAULT 0;
+ALTER TABLE tinfo ADD COLUMN uploaded INT NOT NULL DEFAULT 0;
+
+`
+
+	if _, err = db.Exec(sql); err == nil {
+		*previousVersion = version
+		success = true
+	}
+
+	return
+}
+
+func schemaV12(previousVersion *int, db *SqliteDatabase) (success bool, err error) {
+	version := 12
+
+	if *previousVersion >= version {
+		success = true
+		return
+	}
+
+	sql := `
+
+-- Name of the torrent, to list results of torrents, removed after post-processing
+ALTER TABLE tpostprocess ADD COLUMN name TEXT NOT NULL DEFAULT "";
+
+`
+
+	if _, err = db.Exec(sql); err == nil {
+		*previousVersion = version
+		success = true
+	}
+
+	return
+}
@@ -226,7 +226,7 @@ func (d *SqliteDatabase) GetBTItem(infoHash string) *BTItem {
 	fileStr := ""
 	infoStr := ""
 
-	d.QueryRow(`SELECT rowid, state, mediaID, mediaType, files, infos FROM tinfo WHERE infohash = ?`, infoHash).Scan(&rowid, &item.State, &item.ID, &item.Type, &fileStr, &infoStr)
+	d.QueryRow(`SELECT rowid, state, mediaID, mediaType, files, infos, queue_state, queue_priority, queue_position, storage_type, storage_path FROM tinfo WHERE infohash = ?`, infoHash).Scan(&rowid, &item.State, &item.ID, &item.Type, &fileStr, &infoStr, &item.QueueState, &item.QueuePriority, &item.QueuePosition, &item.StorageType, &item.StoragePath)
 	if rowid == 0 {
 		return nil
 	}
@@ -275,15 +275,498 @@ func (d *SqliteDatabase) UpdateBTItem(infoHash string, mediaID int, mediaType st
 	}
 	infoStr += query
 
-	_, err := d.Exec(`INSERT OR REPLACE INTO tinfo (infohash, state, mediaID, mediaType, files, infos) VALUES (?, ?, ?, ?, ?, ?)`, infoHash, StatusActive, mediaID, mediaType, fileStr, infoStr)
+	// Updating existing row first, to keep queue state of the torrent
+	res, err := d.Exec(`UPDATE tinfo SET state = ?, mediaID = ?, mediaType = ?, files = ?, infos = ? WHERE infohash = ?`, StatusActive, mediaID, mediaType, fileStr, infoStr, infoHash)
+	if err == nil {
+		if affected, _ := res.RowsAffected(); affected > 0 {
+			return nil
+		}
+
+		_, err = d.Exec(`INSERT INTO tinfo (infohash, state, mediaID, mediaType, files, infos) VALUES (?, ?, ?, ?, ?, ?)`, infoHash, StatusActive, mediaID, mediaType, fileStr, infoStr)
+	}
 	if err != nil {
 		log.Debugf("UpdateBTItem failed: %s", err)
 	}
 	return err
 }
 
+// UpdateQueueBTItem saves download queue state of the torrent
+func (d *SqliteDatabase) UpdateQueueBTItem(infoHash string, state, priority, position int) error {
+	_, err := d.Exec(`UPDATE tinfo SET queue_state = ?, queue_priority = ?, queue_position = ? WHERE infohash = ?`, state, priority, position, infoHash)
+	if err != nil {
+		log.Debugf("UpdateQueueBTItem failed: %s", err)
+	}
+	return err
+}
+
+// UpdateStorageBTItem saves storage, selected for the torrent, row is created if torrent is not saved yet
+func (d *SqliteDatabase) UpdateStorageBTItem(infoHash string, storageType int, storagePath string) error {
+	res, err := d.Exec(`UPDATE tinfo SET storage_type = ?, storage_path = ? WHERE infohash = ?`, storageType, storagePath, infoHash)
+	if err == nil {
+		if affected, _ := res.RowsAffected(); affected > 0 {
+			return nil
+		}
+
+		_, err = d.Exec(`INSERT INTO tinfo (infohash, state, storage_type, storage_path) VALUES (?, ?, ?, ?)`, infoHash, StatusActive, storageType, storagePath)
+	}
+	if err != nil {
+		log.Debugf("UpdateStorageBTItem failed: %s", err)
+	}
+	return err
+}
+
+// GetSeedStats returns saved seeding stats of the torrent
+func (d *SqliteDatabase) GetSeedStats(infoHash string) *SeedStats {
+	var duration, since int64
+	ret := &SeedStats{}
+
+	if err := d.QueryRow(`SELECT download_duration, seeding_since, uploaded FROM tinfo WHERE infohash = ?`, infoHash).Scan(&duration, &since, &ret.Uploaded); err != nil {
+		return nil
+	}
+
+	ret.DownloadDuration = time.Duration(duration) * time.Second
+	if since > 0 {
+		ret.SeedingSince = time.Unix(since, 0)
+	}
+	return ret
+}
+
+// UpdateSeedStats saves seeding stats of the torrent, stats are kept only for saved torrents
+func (d *SqliteDatabase) UpdateSeedStats(infoHash string, stats *SeedStats) error {
+	since := int64(0)
+	if !stats.SeedingSince.IsZero() {
+		since = stats.SeedingSince.Unix()
+	}
+	duration := int64(stats.DownloadDuration / time.Second)
+
+	_, err := d.Exec(`UPDATE tinfo SET download_duration = ?, seeding_since = ?, uploaded = ? WHERE infohash = ?`, duration, since, stats.Uploaded, infoHash)
+	if err != nil {
+		log.Debugf("UpdateSeedStats failed: %s", err)
+	}
+	return err
+}
+
 // DeleteBTItem ...
 func (d *SqliteDatabase) DeleteBTItem(infoHash string) error {
+	d.DeleteSeedPolicy(infoHash)
+	d.DeletePostProcessSteps(infoHash)
+
 	_, err := d.Exec(`DELETE FROM tinfo WHERE infohash = ?`, infoHash)
 	return err
 }
+
+// GetSeedPolicy returns per-torrent override of seeding limits,
+// fields, that are not overridden, are set to -1
+func (d *SqliteDatabase) GetSeedPolicy(infoHash string) *SeedPolicy {
+	p := &SeedPolicy{}
+	rowid := 0
+
+	d.QueryRow(`SELECT rowid, ratio_limit, seed_time_limit, time_ratio_limit, action FROM tseed WHERE infohash = ?`, infoHash).Scan(&rowid, &p.RatioLimit, &p.SeedTimeLimit, &p.TimeRatioLimit, &p.Action)
+	if rowid == 0 {
+		return nil
+	}
+
+	return p
+}
+
+// SetSeedPolicy saves per-torrent override of seeding limits
+func (d *SqliteDatabase) SetSeedPolicy(infoHash string, p *SeedPolicy) error {
+	_, err := d.Exec(`INSERT OR REPLACE INTO tseed (infohash, ratio_limit, seed_time_limit, time_ratio_limit, action) VALUES (?, ?, ?, ?, ?)`, infoHash, p.RatioLimit, p.SeedTimeLimit, p.TimeRatioLimit, p.Action)
+	if err != nil {
+		log.Debugf("SetSeedPolicy failed: %s", err)
+	}
+	return err
+}
+
+// DeleteSeedPolicy removes per-torrent override of seeding limits
+func (d *SqliteDatabase) DeleteSeedPolicy(infoHash string) error {
+	_, err := d.Exec(`DELETE FROM tseed WHERE infohash = ?`, infoHash)
+	return err
+}
+
+// GetPostProcessSteps returns results of post-processing steps of the torrent, in the order they were run
+func (d *SqliteDatabase) GetPostProcessSteps(infoHash string) []*PostProcessStep {
+	ret := []*PostProcessStep{}
+
+	rows, err := d.Query(`SELECT step, status, message, dt FROM tpostprocess WHERE infohash = ? ORDER BY rowid`, infoHash)
+	if err != nil {
+		log.Debugf("GetPostProcessSteps failed: %s", err)
+		return ret
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var dt int64
+		s := &PostProcessStep{}
+		if err := rows.Scan(&s.Step, &s.Status, &s.Message, &dt); err != nil {
+			continue
+		}
+		s.Updated = time.Unix(dt, 0)
+		ret = append(ret, s)
+	}
+
+	return ret
+}
+
+// GetPostProcessedItems returns results of post-processing of torrents, that are not in the client anymore
+func (d *SqliteDatabase) GetPostProcessedItems() []*PostProcessedItem {
+	ret := []*PostProcessedItem{}
+
+	rows, err := d.Query(`SELECT infohash, name, step, status, message, dt FROM tpostprocess WHERE infohash NOT IN (SELECT infohash FROM tinfo) ORDER BY rowid`)
+	if err != nil {
+		log.Debugf("GetPostProcessedItems failed: %s", err)
+		return ret
+	}
+	defer rows.Close()
+
+	items := map[string]*PostProcessedItem{}
+	for rows.Next() {
+		var infoHash, name string
+		var dt int64
+		s := &PostProcessStep{}
+		if err := rows.Scan(&infoHash, &name, &s.Step, &s.Status, &s.Message, &dt); err != nil {
+			continue
+		}
+		s.Updated = time.Unix(dt, 0)
+
+		item, ok := items[infoHash]
+		if !ok {
+			item = &PostProcessedItem{InfoHash: infoHash, Name: name}
+			items[infoHash] = item
+			ret = append(ret, item)
+		}
+		item.Steps = append(item.Steps, s)
+	}
+
+	return ret
+}
+
+// AddPostProcessStep saves result of post-processing step of the torrent
+func (d *SqliteDatabase) AddPostProcessStep(infoHash, name string, s *PostProcessStep) error {
+	_, err := d.Exec(`INSERT INTO tpostprocess (infohash, name, step, status, message, dt) VALUES (?, ?, ?, ?, ?, ?)`, infoHash, name, s.Step, s.Status, s.Message, s.Updated.Unix())
+	if err != nil {
+		log.Debugf("AddPostProcessStep failed: %s", err)
+	}
+	return err
+}
+
+// DeletePostProcessSteps removes results of post-processing, so the torrent is processed again
+func (d *SqliteDatabase) DeletePostProcessSteps(infoHash string) error {
+	_, err := d.Exec(`DELETE FROM tpostprocess WHERE infohash = ?`, infoHash)
+	return err
+}
+
+// HasFeedItem checks whether feed item, or a torrent with the same infohash,
+// was already processed by the feed watcher
+func (d *SqliteDatabase) HasFeedItem(feed, guid, infoHash string) bool {
+	count := 0
+	if infoHash != "" {
+		d.QueryRow(`SELECT COUNT(*) FROM feed_items WHERE (feed = ? AND guid = ?) OR infohash = ?`, feed, guid, infoHash).Scan(&count)
+	} else {
+		d.QueryRow(`SELECT COUNT(*) FROM feed_items WHERE feed = ? AND guid = ?`, feed, guid).Scan(&count)
+	}
+
+	return count > 0
+}
+
+// AddFeedItem remembers processed feed item
+func (d *SqliteDatabase) AddFeedItem(feed, guid, infoHash, title string) error {
+	_, err := d.Exec(`INSERT OR REPLACE INTO feed_items (feed, guid, infohash, title, dt) VALUES (?, ?, ?, ?, ?)`, feed, guid, infoHash, title, time.Now().Unix())
+	if err != nil {
+		log.Debugf("AddFeedItem failed: %s", err)
+	}
+	return err
+}
+
+// GetMonitoredShows returns library shows, monitored for new episodes
+func (d *SqliteDatabase) GetMonitoredShows() []*MonitoredShow {
+	ret := []*MonitoredShow{}
+
+	rows, err := d.Query(`SELECT showId, dt FROM library_monitor ORDER BY dt`)
+	if err != nil {
+		log.Debugf("GetMonitoredShows failed: %s", err)
+		return ret
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var dt int64
+		s := &MonitoredShow{}
+		if err := rows.Scan(&s.ShowID, &dt); err != nil {
+			continue
+		}
+		s.Since = time.Unix(dt, 0)
+		ret = append(ret, s)
+	}
+
+	return ret
+}
+
+// IsShowMonitored checks whether library show is monitored for new episodes
+func (d *SqliteDatabase) IsShowMonitored(showID int) bool {
+	count := 0
+	d.QueryRow(`SELECT COUNT(*) FROM library_monitor WHERE showId = ?`, showID).Scan(&count)
+	return count > 0
+}
+
+// SetShowMonitored enables or disables monitoring of new episodes for library show
+func (d *SqliteDatabase) SetShowMonitored(showID int, monitored bool) (err error) {
+	if monitored {
+		_, err = d.Exec(`INSERT OR IGNORE INTO library_monitor (showId, dt) VALUES (?, ?)`, showID, time.Now().Unix())
+	} else {
+		_, err = d.Exec(`DELETE FROM library_monitor WHERE showId = ?`, showID)
+	}
+	if err != nil {
+		log.Debugf("SetShowMonitored failed: %s", err)
+	}
+	return err
+}
+
+// HasMonitorHistory checks whether episode was already grabbed by the monitor
+func (d *SqliteDatabase) HasMonitorHistory(showID, season, episode int) bool {
+	count := 0
+	d.QueryRow(`SELECT COUNT(*) FROM monitor_history WHERE showId = ? AND season = ? AND episode = ?`, showID, season, episode).Scan(&count)
+	return count > 0
+}
+
+// AddMonitorHistory remembers episode, grabbed by the monitor
+func (d *SqliteDatabase) AddMonitorHistory(showID, season, episode int, infoHash, title string) error {
+	_, err := d.Exec(`INSERT OR REPLACE INTO monitor_history (showId, season, episode, infohash, title, dt) VALUES (?, ?, ?, ?, ?, ?)`, showID, season, episode, infoHash, title, time.Now().Unix())
+	if err != nil {
+		log.Debugf("AddMonitorHistory failed: %s", err)
+	}
+	return err
+}
+
+// GetMonitorHistory returns episodes of the show, grabbed by the monitor
+func (d *SqliteDatabase) GetMonitorHistory(showID int) []*MonitorHistoryItem {
+	ret := []*MonitorHistoryItem{}
+
+	rows, err := d.Query(`SELECT showId, season, episode, infohash, title, dt FROM monitor_history WHERE showId = ? ORDER BY season, episode`, showID)
+	if err != nil {
+		log.Debugf("GetMonitorHistory failed: %s", err)
+		return ret
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var dt int64
+		i := &MonitorHistoryItem{}
+		if err := rows.Scan(&i.ShowID, &i.Season, &i.Episode, &i.InfoHash, &i.Title, &dt); err != nil {
+			continue
+		}
+		i.Added = time.Unix(dt, 0)
+		ret = append(ret, i)
+	}
+
+	return ret
+}
+
+// AddTraktOutbox queues Trakt request to be sent later
+func (d *SqliteDatabase) AddTraktOutbox(endPoint, payload string) error {
+	_, err := d.Exec(`INSERT INTO trakt_outbox (endpoint, payload, status, dt) VALUES (?, ?, ?, ?)`, endPoint, payload, OutboxPending, time.Now().Unix())
+	if err != nil {
+		log.Debugf("AddTraktOutbox failed: %s", err)
+	}
+	return err
+}
+
+// GetTraktOutbox returns queued Trakt requests in the order they were added,
+// only pending requests are returned if pendingOnly is set
+func (d *SqliteDatabase) GetTraktOutbox(pendingOnly bool) []*TraktOutboxItem {
+	ret := []*TraktOutboxItem{}
+
+	query := `SELECT id, endpoint, payload, status, attempts, next_try, error, dt FROM trakt_outbox`
+	if pendingOnly {
+		query += ` WHERE status = ` + strconv.Itoa(OutboxPending)
+	}
+	rows, err := d.Query(query + ` ORDER BY id`)
+	if err != nil {
+		log.Debugf("GetTraktOutbox failed: %s", err)
+		return ret
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var nextTry, dt int64
+		i := &TraktOutboxItem{}
+		if err := rows.Scan(&i.ID, &i.EndPoint, &i.Payload, &i.Status, &i.Attempts, &nextTry, &i.Error, &dt); err != nil {
+			continue
+		}
+		i.NextTry = time.Unix(nextTry, 0)
+		i.Added = time.Unix(dt, 0)
+		ret = append(ret, i)
+	}
+
+	return ret
+}
+
+// CountTraktOutbox returns number of pending Trakt requests
+func (d *SqliteDatabase) CountTraktOutbox() (count int) {
+	d.QueryRow(`SELECT COUNT(*) FROM trakt_outbox WHERE status = ?`, OutboxPending).Scan(&count)
+	return
+}
+
+// UpdateTraktOutbox saves status and attempts of queued Trakt request
+func (d *SqliteDatabase) UpdateTraktOutbox(i *TraktOutboxItem) error {
+	_, err := d.Exec(`UPDATE trakt_outbox SET status = ?, attempts = ?, next_try = ?, error = ? WHERE id = ?`, i.Status, i.Attempts, i.NextTry.Unix(), i.Error, i.ID)
+	if err != nil {
+		log.Debugf("UpdateTraktOutbox failed: %s", err)
+	}
+	return err
+}
+
+// RetryTraktOutbox resets Trakt request to pending without backoff, all of them if id is 0
+func (d *SqliteDatabase) RetryTraktOutbox(id int) (err error) {
+	if id == 0 {
+		_, err = d.Exec(`UPDATE trakt_outbox SET status = ?, attempts = 0, next_try = 0, error = ""`, OutboxPending)
+	} else {
+		_, err = d.Exec(`UPDATE trakt_outbox SET status = ?, attempts = 0, next_try = 0, error = "" WHERE id = ?`, OutboxPending, id)
+	}
+	if err != nil {
+		log.Debugf("RetryTraktOutbox failed: %s", err)
+	}
+	return err
+}
+
+// DeleteTraktOutbox removes queued Trakt request
+func (d *SqliteDatabase) DeleteTraktOutbox(id int) error {
+	_, err := d.Exec(`DELETE FROM trakt_outbox WHERE id = ?`, id)
+	if err != nil {
+		log.Debugf("DeleteTraktOutbox failed: %s", err)
+	}
+	return err
+}
+
+// GetShowNumbering returns numbering mode, selected for the show
+func (d *SqliteDatabase) GetShowNumbering(showID int) int {
+	mode := NumberingAired
+	d.QueryRow(`SELECT mode FROM show_numbering WHERE showId = ?`, showID).Scan(&mode)
+	return mode
+}
+
+// SetShowNumbering sets numbering mode for the show, aired mode removes the setting
+func (d *SqliteDatabase) SetShowNumbering(showID, mode int) (err error) {
+	if mode == NumberingAired {
+		_, err = d.Exec(`DELETE FROM show_numbering WHERE showId = ?`, showID)
+	} else {
+		_, err = d.Exec(`INSERT OR REPLACE INTO show_numbering (showId, mode, dt) VALUES (?, ?, ?)`, showID, mode, time.Now().Unix())
+	}
+	if err != nil {
+		log.Debugf("SetShowNumbering failed: %s", err)
+	}
+	return err
+}
+
+// GetSceneMapping returns scene mapping of show episodes
+func (d *SqliteDatabase) GetSceneMapping(showID int) []*SceneMapping {
+	ret := []*SceneMapping{}
+
+	rows, err := d.Query(`SELECT season, episode, scene_season, scene_episode, scene_absolute FROM show_scene_mapping WHERE showId = ? ORDER BY season, episode`, showID)
+	if err != nil {
+		log.Debugf("GetSceneMapping failed: %s", err)
+		return ret
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		m := &SceneMapping{}
+		if err := rows.Scan(&m.Season, &m.Episode, &m.SceneSeason, &m.SceneEpisode, &m.SceneAbsolute); err != nil {
+			continue
+		}
+		ret = append(ret, m)
+	}
+
+	return ret
+}
+
+// SetSceneMapping replaces scene mapping of show episodes
+func (d *SqliteDatabase) SetSceneMapping(showID int, mapping []*SceneMapping) error {
+	tx, err := d.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM show_scene_mapping WHERE showId = ?`, showID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, m := range mapping {
+		if _, err := tx.Exec(`INSERT OR REPLACE INTO show_scene_mapping (showId, season, episode, scene_season, scene_episode, scene_absolute) VALUES (?, ?, ?, ?, ?, ?)`, showID, m.Season, m.Episode, m.SceneSeason, m.SceneEpisode, m.SceneAbsolute); err != nil {
+			log.Debugf("SetSceneMapping failed: %s", err)
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetSeasonPack returns season pack, known for the season of the show, or nil
+func (d *SqliteDatabase) GetSeasonPack(showID, season int) *SeasonPack {
+	var dt int64
+	p := &SeasonPack{
+		ShowID: showID,
+		Season: season,
+		Files:  map[int]string{},
+	}
+	if err := d.QueryRow(`SELECT infohash, metainfo, dt FROM season_packs WHERE showId = ? AND season = ?`, showID, season).Scan(&p.InfoHash, &p.Metainfo, &dt); err != nil {
+		return nil
+	}
+	p.Added = time.Unix(dt, 0)
+
+	rows, err := d.Query(`SELECT episode, path FROM season_pack_files WHERE showId = ? AND season = ?`, showID, season)
+	if err != nil {
+		log.Debugf("GetSeasonPack failed: %s", err)
+		return nil
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var episode int
+		var path string
+		if err := rows.Scan(&episode, &path); err != nil {
+			continue
+		}
+		p.Files[episode] = path
+	}
+
+	return p
+}
+
+// AddSeasonPack remembers season pack, replacing previous pack of the season
+func (d *SqliteDatabase) AddSeasonPack(p *SeasonPack) error {
+	tx, err := d.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`INSERT OR REPLACE INTO season_packs (showId, season, infohash, metainfo, dt) VALUES (?, ?, ?, ?, ?)`, p.ShowID, p.Season, p.InfoHash, p.Metainfo, time.Now().Unix()); err != nil {
+		log.Debugf("AddSeasonPack failed: %s", err)
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM season_pack_files WHERE showId = ? AND season = ?`, p.ShowID, p.Season); err != nil {
+		tx.Rollback()
+		return err
+	}
+	for episode, path := range p.Files {
+		if _, err := tx.Exec(`INSERT INTO season_pack_files (showId, season, episode, path) VALUES (?, ?, ?, ?)`, p.ShowID, p.Season, episode, path); err != nil {
+			log.Debugf("AddSeasonPack failed: %s", err)
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DeleteSeasonPack forgets season pack of the season
+func (d *SqliteDatabase) DeleteSeasonPack(showID, season int) error {
+	d.Exec(`DELETE FROM season_pack_files WHERE showId = ? AND season = ?`, showID, season)
+	_, err := d.Exec(`DELETE FROM season_packs WHERE showId = ? AND season = ?`, showID, season)
+	if err != nil {
+		log.Debugf("DeleteSeasonPack failed: %s", err)
+	}
+	return err
+}
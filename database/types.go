@@ -3,6 +3,7 @@ package database
 import (
 	"database/sql"
 	"sync"
+	"time"
 
 	"github.com/boltdb/bolt"
 	"github.com/op/go-logging"
@@ -46,6 +47,96 @@ type BTItem struct {
 	Season  int      `json:"season"`
 	Episode int      `json:"episode"`
 	Query   string   `json:"query"`
+
+	QueueState    int `json:"queue_state"`
+	QueuePriority int `json:"queue_priority"`
+	QueuePosition int `json:"queue_position"`
+
+	// StorageType is storage, selected for the torrent, -1 means storage from settings
+	StorageType int    `json:"storage_type"`
+	StoragePath string `json:"storage_path"`
+}
+
+// SeedPolicy defines limits for seeding a torrent
+type SeedPolicy struct {
+	// RatioLimit is upload to download ratio, in percents
+	RatioLimit int `json:"ratio_limit"`
+	// SeedTimeLimit is seeding time, in hours
+	SeedTimeLimit int `json:"seed_time_limit"`
+	// TimeRatioLimit is seeding time to download time ratio, in percents
+	TimeRatioLimit int `json:"time_ratio_limit"`
+	// Action is what happens when any limit is reached
+	Action int `json:"action"`
+}
+
+// SeedStats are seeding stats of the torrent, kept between restarts
+type SeedStats struct {
+	// DownloadDuration is time, spent on downloading, 0 means it is not known
+	DownloadDuration time.Duration `json:"download_duration"`
+	SeedingSince     time.Time     `json:"seeding_since"`
+	Uploaded         int64         `json:"uploaded"`
+}
+
+// PostProcessStep is a result of post-processing step, run for completed torrent
+type PostProcessStep struct {
+	Step    string    `json:"step"`
+	Status  int       `json:"status"`
+	Message string    `json:"message"`
+	Updated time.Time `json:"updated"`
+}
+
+// PostProcessedItem is a torrent, removed from the client after post-processing, with results of the steps
+type PostProcessedItem struct {
+	InfoHash string             `json:"infohash"`
+	Name     string             `json:"name"`
+	Steps    []*PostProcessStep `json:"steps"`
+}
+
+// MonitoredShow is a library show, monitored for new episodes
+type MonitoredShow struct {
+	ShowID int       `json:"show_id"`
+	Since  time.Time `json:"since"`
+}
+
+// MonitorHistoryItem is an episode, grabbed by the monitor
+type MonitorHistoryItem struct {
+	ShowID   int       `json:"show_id"`
+	Season   int       `json:"season"`
+	Episode  int       `json:"episode"`
+	InfoHash string    `json:"infohash"`
+	Title    string    `json:"title"`
+	Added    time.Time `json:"added"`
+}
+
+// TraktOutboxItem is a Trakt request, queued while Trakt is not reachable
+type TraktOutboxItem struct {
+	ID       int       `json:"id"`
+	EndPoint string    `json:"endpoint"`
+	Payload  string    `json:"payload"`
+	Status   int       `json:"status"`
+	Attempts int       `json:"attempts"`
+	NextTry  time.Time `json:"next_try"`
+	Error    string    `json:"error"`
+	Added    time.Time `json:"added"`
+}
+
+// SceneMapping maps aired episode number to the number, used by release groups
+type SceneMapping struct {
+	Season        int `json:"season"`
+	Episode       int `json:"episode"`
+	SceneSeason   int `json:"scene_season"`
+	SceneEpisode  int `json:"scene_episode"`
+	SceneAbsolute int `json:"scene_absolute"`
+}
+
+// SeasonPack is a torrent with whole season of the show, and paths of episode files in it
+type SeasonPack struct {
+	ShowID   int            `json:"show_id"`
+	Season   int            `json:"season"`
+	InfoHash string         `json:"infohash"`
+	Metainfo []byte         `json:"-"`
+	Files    map[int]string `json:"files"`
+	Added    time.Time      `json:"added"`
 }
 
 var (
@@ -72,6 +163,53 @@ const (
 	StatusActive
 )
 
+const (
+	// QueueNone torrent is not managed by download queue
+	QueueNone = iota
+	// QueueWaiting torrent is waiting for a free download slot
+	QueueWaiting
+	// QueueActive torrent is downloading
+	QueueActive
+	// QueuePaused torrent is paused by user and skipped by queue
+	QueuePaused
+	// QueueDone torrent has finished downloading
+	QueueDone
+)
+
+const (
+	// SeedActionStop stops seeding after limit is reached
+	SeedActionStop = iota
+	// SeedActionRemove removes torrent, keeping downloaded files
+	SeedActionRemove
+)
+
+const (
+	// PostProcessDone step has finished
+	PostProcessDone = iota
+	// PostProcessSkipped step had nothing to do
+	PostProcessSkipped
+	// PostProcessFailed step has failed, next steps are not run
+	PostProcessFailed
+)
+
+const (
+	// OutboxPending request is waiting to be sent
+	OutboxPending = iota
+	// OutboxFailed request was rejected or ran out of attempts, and needs manual retry
+	OutboxFailed
+)
+
+const (
+	// NumberingAired uses aired season and episode numbers
+	NumberingAired = iota
+	// NumberingAbsolute uses absolute episode numbers from TVDB
+	NumberingAbsolute
+	// NumberingDVD uses DVD season and episode numbers from TVDB
+	NumberingDVD
+	// NumberingScene uses scene mapping, uploaded for the show
+	NumberingScene
+)
+
 const (
 	historyMaxSize = 50
 )
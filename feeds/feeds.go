@@ -0,0 +1,296 @@
+package feeds
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/op/go-logging"
+
+	"github.com/elgatito/elementum/bittorrent"
+	"github.com/elgatito/elementum/config"
+	"github.com/elgatito/elementum/database"
+	estorage "github.com/elgatito/elementum/storage"
+	"github.com/elgatito/elementum/tmdb"
+)
+
+const (
+	// TypeRSS is a plain RSS feed with torrent links
+	TypeRSS = "rss"
+	// TypeTorznab is a Torznab indexer, like Jackett, with search parameters
+	TypeTorznab = "torznab"
+
+	feedsFile        = "feeds.json"
+	defaultFrequency = 15
+)
+
+var log = logging.MustGetLogger("feeds")
+
+// Feed is a watched RSS or Torznab feed
+type Feed struct {
+	Name       string `json:"name"`
+	URL        string `json:"url"`
+	Type       string `json:"type"`
+	APIKey     string `json:"api_key"`
+	Categories string `json:"categories"`
+}
+
+// Settings is the content of feeds file, stored in addon's profile folder
+type Settings struct {
+	Feeds []*Feed `json:"feeds"`
+	Rules []*Rule `json:"rules"`
+}
+
+// Status describes results of the last feeds check
+type Status struct {
+	Enabled   bool              `json:"enabled"`
+	Running   bool              `json:"running"`
+	LastCheck time.Time         `json:"last_check"`
+	Added     int               `json:"added"`
+	Errors    map[string]string `json:"errors"`
+	Settings  *Settings         `json:"settings"`
+}
+
+// Watcher polls feeds and adds torrents, matching the rules
+type Watcher struct {
+	s  *bittorrent.BTService
+	mu sync.Mutex
+
+	status Status
+}
+
+var watcher *Watcher
+
+// SettingsPath returns location of the feeds file
+func SettingsPath() string {
+	return filepath.Join(config.Get().Info.Profile, feedsFile)
+}
+
+// LoadSettings reads feeds and rules from the feeds file
+func LoadSettings() (*Settings, error) {
+	b, err := ioutil.ReadFile(SettingsPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &Settings{}, nil
+		}
+		return nil, err
+	}
+
+	settings := &Settings{}
+	if err := json.Unmarshal(b, settings); err != nil {
+		return nil, fmt.Errorf("Could not parse %s: %s", feedsFile, err)
+	}
+
+	for _, f := range settings.Feeds {
+		if f.Type == "" {
+			f.Type = TypeRSS
+		}
+		if f.Name == "" {
+			f.Name = f.URL
+		}
+	}
+	for _, r := range settings.Rules {
+		if err := r.compile(); err != nil {
+			return nil, err
+		}
+	}
+
+	return settings, nil
+}
+
+// Init starts feeds watcher, feeds are checked with configured frequency
+func Init(s *bittorrent.BTService) {
+	watcher = &Watcher{
+		s: s,
+	}
+
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if s.ShuttingDown {
+			return
+		}
+
+		conf := config.Get()
+		if !conf.FeedsEnabled {
+			continue
+		}
+
+		frequency := conf.FeedsFrequency
+		if frequency <= 0 {
+			frequency = defaultFrequency
+		}
+
+		watcher.mu.Lock()
+		lastCheck := watcher.status.LastCheck
+		watcher.mu.Unlock()
+		if time.Since(lastCheck) < time.Duration(frequency)*time.Minute {
+			continue
+		}
+
+		if _, err := Check(); err != nil {
+			log.Warningf("Feeds check failed: %s", err)
+		}
+	}
+}
+
+// GetStatus returns results of the last feeds check
+func GetStatus() *Status {
+	ret := &Status{
+		Enabled: config.Get().FeedsEnabled,
+	}
+	if watcher != nil {
+		watcher.mu.Lock()
+		*ret = watcher.status
+		ret.Enabled = config.Get().FeedsEnabled
+		watcher.mu.Unlock()
+	}
+
+	if settings, err := LoadSettings(); err == nil {
+		ret.Settings = settings
+	}
+
+	return ret
+}
+
+// Check polls all feeds and adds matching items, returns number of added torrents
+func Check() (int, error) {
+	if watcher == nil {
+		return 0, errors.New("Feeds watcher is not running")
+	}
+
+	return watcher.check()
+}
+
+func (w *Watcher) check() (int, error) {
+	w.mu.Lock()
+	if w.status.Running {
+		w.mu.Unlock()
+		return 0, errors.New("Feeds check is already running")
+	}
+	w.status.Running = true
+	w.status.LastCheck = time.Now()
+	w.mu.Unlock()
+
+	added := 0
+	errs := map[string]string{}
+	defer func() {
+		w.mu.Lock()
+		w.status.Running = false
+		w.status.Added = added
+		w.status.Errors = errs
+		w.mu.Unlock()
+	}()
+
+	settings, err := LoadSettings()
+	if err != nil {
+		return 0, err
+	}
+	if len(settings.Feeds) == 0 || len(settings.Rules) == 0 {
+		return 0, nil
+	}
+
+	for _, f := range settings.Feeds {
+		log.Debugf("Checking feed %s", f.Name)
+
+		items, err := f.fetch()
+		if err != nil {
+			log.Warningf("Could not fetch feed %s: %s", f.Name, err)
+			errs[f.Name] = err.Error()
+			continue
+		}
+
+		for _, item := range items {
+			if w.s.ShuttingDown {
+				return added, nil
+			}
+
+			if database.Get().HasFeedItem(item.Feed, item.GUID, item.Torrent.InfoHash) {
+				continue
+			}
+
+			var rule *Rule
+			for _, r := range settings.Rules {
+				if r.Matches(item) {
+					rule = r
+					break
+				}
+			}
+			if rule == nil {
+				continue
+			}
+
+			if err := w.add(item, rule); err != nil {
+				log.Warningf("Could not add %s from feed %s: %s", item.Title, item.Feed, err)
+				errs[item.Title] = err.Error()
+				continue
+			}
+			added++
+		}
+	}
+
+	if added > 0 {
+		log.Noticef("Added %d torrents from feeds", added)
+	}
+
+	return added, nil
+}
+
+// add sends matched item to the torrent client and remembers it
+func (w *Watcher) add(item *Item, rule *Rule) error {
+	if item.Torrent.InfoHash != "" && w.s.GetTorrentByHash(item.Torrent.InfoHash) != nil {
+		database.Get().AddFeedItem(item.Feed, item.GUID, item.Torrent.InfoHash, item.Title)
+		return nil
+	}
+
+	log.Infof("Feed item %s matches rule %s", item.Title, rule.Name)
+
+	// Media type is resolved first, to place the torrent on the download volume for it
+	mediaType, tmdbID := resolve(item, rule)
+	placement := episodeType
+	if mediaType == movieType {
+		placement = movieType
+	}
+
+	t, err := w.s.QueueTorrent(item.Torrent.URI, rule.Priority, bittorrent.AutoStorage(placement))
+	if err != nil {
+		return err
+	}
+
+	// Storage is known after the torrent is added, it can be kept from previous runs
+	if !estorage.KeepsFiles(t.StorageType()) {
+		w.s.RemoveTorrent(t, true)
+		return errors.New("Feeds are not available for memory storage")
+	}
+
+	infoHash := t.InfoHash()
+	database.Get().AddFeedItem(item.Feed, item.GUID, infoHash, item.Title)
+
+	if tmdbID == 0 {
+		log.Debugf("Could not resolve TMDB id for %s", item.Title)
+		return nil
+	}
+
+	files := t.Torrent.Files()
+	if mediaType == movieType {
+		database.Get().UpdateBTItem(infoHash, tmdbID, movieType, files, item.Title)
+	} else if item.Episode > 0 {
+		episodeID := 0
+		if episode := tmdb.GetEpisode(tmdbID, item.Season, item.Episode, config.Get().Language); episode != nil {
+			episodeID = episode.ID
+		}
+		database.Get().UpdateBTItem(infoHash, episodeID, episodeType, files, item.Title, tmdbID, item.Season, item.Episode)
+	} else {
+		database.Get().UpdateBTItem(infoHash, 0, "", files, item.Title, tmdbID, item.Season, 0)
+	}
+	t.DBItem = database.Get().GetBTItem(infoHash)
+
+	log.Infof("Resolved %s as %s with TMDB id %d", item.Title, mediaType, tmdbID)
+	return nil
+}
@@ -0,0 +1,90 @@
+package feeds
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/elgatito/elementum/config"
+	"github.com/elgatito/elementum/tmdb"
+)
+
+const (
+	movieType   = "movie"
+	showType    = "show"
+	episodeType = "episode"
+)
+
+var (
+	movieNameMatcher   = regexp.MustCompile(`^(.+?)[\s\.\(\[_-]+((?:19|20)\d{2})(?:[\s\.\)\]_-]|$)`)
+	episodeNameMatcher = regexp.MustCompile(`(?i)^(.+?)[\s\.\[_-]+S(\d{1,2})\s*E(\d{1,3})`)
+	nameCleaner        = regexp.MustCompile(`[\s\._]+`)
+)
+
+// resolve finds TMDB entry for the item, using ids from the rule or from Torznab
+// attributes, and searching TMDB by the release name as a last resort.
+// Episode numbers are taken from Torznab attributes or parsed from the name.
+func resolve(item *Item, rule *Rule) (mediaType string, tmdbID int) {
+	name := item.Title
+	if m := episodeNameMatcher.FindStringSubmatch(name); m != nil {
+		name = m[1]
+		if item.Season == 0 && item.Episode == 0 {
+			item.Season, _ = strconv.Atoi(m[2])
+			item.Episode, _ = strconv.Atoi(m[3])
+		}
+	}
+
+	mediaType = rule.MediaType
+	if mediaType == "" {
+		mediaType = movieType
+		if item.Episode > 0 || item.TVDBID > 0 {
+			mediaType = showType
+		}
+	}
+
+	if rule.TMDBID > 0 {
+		return mediaType, rule.TMDBID
+	}
+	if item.TMDBID > 0 {
+		return mediaType, item.TMDBID
+	}
+
+	if item.IMDBID != "" {
+		imdbID := item.IMDBID
+		if !strings.HasPrefix(imdbID, "tt") {
+			imdbID = "tt" + imdbID
+		}
+		if r := tmdb.Find(imdbID, "imdb_id"); r != nil {
+			if mediaType == movieType && len(r.MovieResults) > 0 {
+				return mediaType, r.MovieResults[0].ID
+			} else if mediaType == showType && len(r.TVResults) > 0 {
+				return mediaType, r.TVResults[0].ID
+			}
+		}
+	}
+	if item.TVDBID > 0 && mediaType == showType {
+		if r := tmdb.Find(strconv.Itoa(item.TVDBID), "tvdb_id"); r != nil && len(r.TVResults) > 0 {
+			return mediaType, r.TVResults[0].ID
+		}
+	}
+
+	language := config.Get().Language
+	if mediaType == showType {
+		if shows, _ := tmdb.SearchShows(cleanName(name), language, 1); len(shows) > 0 && shows[0] != nil {
+			return mediaType, shows[0].ID
+		}
+	} else if m := movieNameMatcher.FindStringSubmatch(name); m != nil {
+		movies, _ := tmdb.SearchMovies(cleanName(m[1]), language, 1)
+		for _, movie := range movies {
+			if movie != nil && strings.HasPrefix(movie.ReleaseDate, m[2]) {
+				return mediaType, movie.ID
+			}
+		}
+	}
+
+	return mediaType, 0
+}
+
+func cleanName(name string) string {
+	return strings.TrimSpace(nameCleaner.ReplaceAllString(name, " "))
+}
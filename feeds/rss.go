@@ -0,0 +1,105 @@
+package feeds
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/elgatito/elementum/bittorrent"
+	"github.com/elgatito/elementum/providers"
+	"github.com/elgatito/elementum/scrape"
+)
+
+// Item is a parsed entry of a feed
+type Item struct {
+	Feed    string
+	GUID    string
+	Title   string
+	Torrent *bittorrent.TorrentFile
+
+	TMDBID  int
+	IMDBID  string
+	TVDBID  int
+	Season  int
+	Episode int
+}
+
+// feedURL builds request URL, Torznab feeds get search parameters
+func (f *Feed) feedURL() (string, error) {
+	u, err := url.Parse(f.URL)
+	if err != nil {
+		return "", err
+	}
+
+	if f.Type == TypeTorznab {
+		q := u.Query()
+		if q.Get("t") == "" {
+			q.Set("t", "search")
+		}
+		if f.APIKey != "" {
+			q.Set("apikey", f.APIKey)
+		}
+		if f.Categories != "" {
+			q.Set("cat", f.Categories)
+		}
+		u.RawQuery = q.Encode()
+	}
+
+	return u.String(), nil
+}
+
+// fetch downloads the feed and parses its items
+func (f *Feed) fetch() ([]*Item, error) {
+	uri, err := f.feedURL()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := scrape.GetClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Request failed with code: %d", resp.StatusCode)
+	}
+
+	feedItems, err := providers.ParseTorznab(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*Item, 0, len(feedItems))
+	for _, i := range feedItems {
+		t := i.TorrentFile(f.Name)
+		if t == nil {
+			continue
+		}
+
+		item := &Item{
+			Feed:    f.Name,
+			GUID:    i.GUID,
+			Title:   t.Name,
+			Torrent: t,
+
+			TMDBID:  i.IntAttr("tmdbid"),
+			IMDBID:  i.Attr("imdbid"),
+			TVDBID:  i.IntAttr("tvdbid"),
+			Season:  i.IntAttr("season"),
+			Episode: i.IntAttr("episode"),
+		}
+		if item.GUID == "" {
+			item.GUID = t.URI
+		}
+
+		items = append(items, item)
+	}
+
+	return items, nil
+}
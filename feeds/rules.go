@@ -0,0 +1,135 @@
+package feeds
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+
+	"github.com/elgatito/elementum/bittorrent"
+)
+
+// Rule describes which feed items should be downloaded
+type Rule struct {
+	Name string `json:"name"`
+	// Feeds limits the rule to feeds with these names, empty means all feeds
+	Feeds []string `json:"feeds"`
+	// Title and Exclude are regular expressions, matched against item's title
+	Title   string `json:"title"`
+	Exclude string `json:"exclude"`
+	// Resolutions and RipTypes are names, like "1080p" or "WebDL"
+	Resolutions []string `json:"resolutions"`
+	RipTypes    []string `json:"rip_types"`
+	// MinSize and MaxSize are human readable sizes, like "700 MB"
+	MinSize string `json:"min_size"`
+	MaxSize string `json:"max_size"`
+
+	// MediaType is "movie" or "show", used to resolve TMDB id
+	MediaType string `json:"media_type"`
+	TMDBID    int    `json:"tmdb_id"`
+	Priority  int    `json:"priority"`
+
+	title       *regexp.Regexp
+	exclude     *regexp.Regexp
+	resolutions map[int]bool
+	ripTypes    map[int]bool
+	minSize     uint64
+	maxSize     uint64
+}
+
+// compile prepares rule for matching, and validates its fields
+func (r *Rule) compile() (err error) {
+	if r.Title != "" {
+		if r.title, err = regexp.Compile("(?i)" + r.Title); err != nil {
+			return fmt.Errorf("Wrong title expression in rule %s: %s", r.Name, err)
+		}
+	}
+	if r.Exclude != "" {
+		if r.exclude, err = regexp.Compile("(?i)" + r.Exclude); err != nil {
+			return fmt.Errorf("Wrong exclude expression in rule %s: %s", r.Name, err)
+		}
+	}
+
+	if r.resolutions, err = lookupNames(r.Resolutions, bittorrent.Resolutions); err != nil {
+		return fmt.Errorf("Wrong resolution in rule %s: %s", r.Name, err)
+	}
+	if r.ripTypes, err = lookupNames(r.RipTypes, bittorrent.Rips); err != nil {
+		return fmt.Errorf("Wrong rip type in rule %s: %s", r.Name, err)
+	}
+
+	if r.MinSize != "" {
+		if r.minSize, err = humanize.ParseBytes(r.MinSize); err != nil {
+			return fmt.Errorf("Wrong minimal size in rule %s: %s", r.Name, err)
+		}
+	}
+	if r.MaxSize != "" {
+		if r.maxSize, err = humanize.ParseBytes(r.MaxSize); err != nil {
+			return fmt.Errorf("Wrong maximal size in rule %s: %s", r.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// Matches checks whether feed item satisfies all conditions of the rule
+func (r *Rule) Matches(item *Item) bool {
+	if len(r.Feeds) > 0 {
+		found := false
+		for _, f := range r.Feeds {
+			if strings.EqualFold(f, item.Feed) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	t := item.Torrent
+	if r.title != nil && !r.title.MatchString(item.Title) {
+		return false
+	}
+	if r.exclude != nil && r.exclude.MatchString(item.Title) {
+		return false
+	}
+	// Items without resolution tag only match rules without resolutions
+	if len(r.resolutions) > 0 && (!t.HasResolution() || !r.resolutions[t.Resolution]) {
+		return false
+	}
+	if len(r.ripTypes) > 0 && !r.ripTypes[t.RipType] {
+		return false
+	}
+
+	// Items without size are not filtered by size limits
+	if t.SizeParsed > 0 {
+		if r.minSize > 0 && t.SizeParsed < r.minSize {
+			return false
+		}
+		if r.maxSize > 0 && t.SizeParsed > r.maxSize {
+			return false
+		}
+	}
+
+	return true
+}
+
+func lookupNames(names []string, list []string) (map[int]bool, error) {
+	ret := map[int]bool{}
+	for _, name := range names {
+		found := false
+		for i, n := range list {
+			if n != "" && strings.EqualFold(n, name) {
+				ret[i] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown value %s", name)
+		}
+	}
+
+	return ret, nil
+}
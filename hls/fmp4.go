@@ -0,0 +1,170 @@
+package hls
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+const (
+	sampleFlagsSync    = 0x02000000
+	sampleFlagsNonSync = 0x01010000
+
+	trunFlags = 0x000001 | 0x000100 | 0x000200 | 0x000400 | 0x000800
+)
+
+// box builds a box of given type from payload parts
+func box(typ string, parts ...[]byte) []byte {
+	size := 8
+	for _, p := range parts {
+		size += len(p)
+	}
+
+	b := make([]byte, 8, size)
+	binary.BigEndian.PutUint32(b[0:4], uint32(size))
+	copy(b[4:8], typ)
+	for _, p := range parts {
+		b = append(b, p...)
+	}
+	return b
+}
+
+// fullBox builds a box with version and flags
+func fullBox(typ string, version byte, flags uint32, parts ...[]byte) []byte {
+	header := []byte{version, byte(flags >> 16), byte(flags >> 8), byte(flags)}
+	return box(typ, append([][]byte{header}, parts...)...)
+}
+
+func u32(values ...uint32) []byte {
+	b := make([]byte, 4*len(values))
+	for i, v := range values {
+		binary.BigEndian.PutUint32(b[4*i:], v)
+	}
+	return b
+}
+
+func u64(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+// init builds initialization segment, with sample descriptions and without samples
+func (s *mp4Stream) init() []byte {
+	ftyp := box("ftyp", []byte("iso6"), u32(0), []byte("iso6"), []byte("iso5"), []byte("mp41"), []byte("dash"))
+
+	traks := [][]byte{s.mvhd}
+	trexs := [][]byte{}
+	for _, t := range s.tracks {
+		dinf := t.dinf
+		if dinf == nil {
+			dinf = box("dinf", fullBox("dref", 0, 0, u32(1), fullBox("url ", 0, 1)))
+		}
+
+		stbl := box("stbl",
+			t.stsd,
+			fullBox("stts", 0, 0, u32(0)),
+			fullBox("stsc", 0, 0, u32(0)),
+			fullBox("stsz", 0, 0, u32(0, 0)),
+			fullBox("stco", 0, 0, u32(0)),
+		)
+		minf := box("minf", t.header, dinf, stbl)
+		traks = append(traks, box("trak", t.tkhd, box("mdia", t.mdhd, t.hdlr, minf)))
+
+		trexs = append(trexs, fullBox("trex", 0, 0, u32(t.id, 1, 0, 0, 0)))
+	}
+	traks = append(traks, box("mvex", trexs...))
+
+	return append(ftyp, box("moov", traks...)...)
+}
+
+// writeSegment builds a fragment with samples of all tracks, starting at the cut point
+func (s *mp4Stream) writeSegment(w io.Writer, index int) error {
+	type fragment struct {
+		t        *mp4Track
+		from, to int
+		data     []byte
+	}
+
+	fragments := []*fragment{}
+	for _, t := range s.tracks {
+		from, to := s.samples(t, index)
+		if from >= to {
+			continue
+		}
+
+		// Samples of a track within a segment are usually stored close to each other,
+		// reading the whole range prioritises pieces, needed for the segment
+		begin, end := t.offsets[from], t.offsets[from]
+		for i := from; i < to; i++ {
+			if t.offsets[i] < begin {
+				begin = t.offsets[i]
+			}
+			if e := t.offsets[i] + int64(t.sizes[i]); e > end {
+				end = e
+			}
+		}
+
+		b, err := readAt(s.src, begin, end-begin)
+		if err != nil {
+			return err
+		} else if int64(len(b)) < end-begin {
+			return io.ErrUnexpectedEOF
+		}
+
+		data := make([]byte, 0, end-begin)
+		for i := from; i < to; i++ {
+			offset := t.offsets[i] - begin
+			data = append(data, b[offset:offset+int64(t.sizes[i])]...)
+		}
+
+		fragments = append(fragments, &fragment{t: t, from: from, to: to, data: data})
+	}
+
+	// Size of moof is needed for data offsets, so trafs are built twice
+	build := func(moofSize int) []byte {
+		trafs := [][]byte{fullBox("mfhd", 0, 0, u32(uint32(index+1)))}
+		offset := moofSize + 8
+		for _, f := range fragments {
+			t := f.t
+			samples := make([]byte, 0, 16*(f.to-f.from))
+			for i := f.from; i < f.to; i++ {
+				flags := uint32(sampleFlagsNonSync)
+				if t.sync[i] {
+					flags = sampleFlagsSync
+				}
+				samples = append(samples, u32(t.durations[i], t.sizes[i], flags, uint32(t.cts[i]))...)
+			}
+
+			trafs = append(trafs, box("traf",
+				fullBox("tfhd", 0, 0x020000, u32(t.id)),
+				fullBox("tfdt", 1, 0, u64(t.dts[f.from])),
+				fullBox("trun", 1, trunFlags, u32(uint32(f.to-f.from), uint32(offset)), samples),
+			))
+			offset += len(f.data)
+		}
+		return box("moof", trafs...)
+	}
+	moof := build(len(build(0)))
+
+	size := 8
+	for _, f := range fragments {
+		size += len(f.data)
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, len(moof)+8))
+	buf.Write(moof)
+	buf.Write(u32(uint32(size)))
+	buf.WriteString("mdat")
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+
+	for _, f := range fragments {
+		if _, err := w.Write(f.data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
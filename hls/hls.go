@@ -0,0 +1,213 @@
+package hls
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/op/go-logging"
+)
+
+const (
+	// SegmentDuration is a target duration of segments, in seconds
+	SegmentDuration = 6
+
+	// PlaylistName is a name of the playlist, requested by clients
+	PlaylistName = "index.m3u8"
+	// InitName is a name of the initialization segment for fragmented MP4
+	InitName = "init.mp4"
+)
+
+const (
+	formatTS = iota
+	formatMP4
+)
+
+var log = logging.MustGetLogger("hls")
+
+// ErrUnsupported is returned for files, that can't be remuxed into HLS
+var ErrUnsupported = errors.New("File format is not supported for HLS")
+
+// Source gives access to the file contents. Open returns a reader of the given range,
+// so underlying storage can prioritise downloading of needed pieces.
+type Source interface {
+	Open(offset, length int64) (io.ReadCloser, error)
+	Length() int64
+}
+
+// Segment is a part of the stream, served as a separate file
+type Segment struct {
+	Index    int
+	Duration float64
+}
+
+// Stream remuxes a file into HLS segments, segments are generated on demand
+type Stream struct {
+	mu sync.Mutex
+
+	src    Source
+	format int
+	probed bool
+
+	ts  *tsStream
+	mp4 *mp4Stream
+
+	segments []*Segment
+}
+
+// NewStream checks whether file is supported, file is not read until playlist is requested
+func NewStream(name string, src Source) (*Stream, error) {
+	s := &Stream{
+		src: src,
+	}
+
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".ts", ".m2ts", ".mts":
+		s.format = formatTS
+	case ".mp4", ".m4v", ".mov":
+		s.format = formatMP4
+	default:
+		return nil, ErrUnsupported
+	}
+
+	return s, nil
+}
+
+// probe reads file index, and splits the file into segments
+func (s *Stream) probe() (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.probed {
+		return nil
+	}
+
+	if s.format == formatTS {
+		if s.ts, err = probeTS(s.src); err == nil {
+			s.segments = s.ts.segments()
+		}
+	} else {
+		if s.mp4, err = probeMP4(s.src); err == nil {
+			s.segments = s.mp4.segments()
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if len(s.segments) == 0 {
+		return errors.New("Stream has no segments")
+	}
+
+	log.Infof("Split stream into %d segments", len(s.segments))
+	s.probed = true
+	return nil
+}
+
+// ContentType returns MIME type of segments
+func (s *Stream) ContentType() string {
+	if s.format == formatTS {
+		return "video/mp2t"
+	}
+	return "video/mp4"
+}
+
+// SegmentName returns file name of the segment, used in the playlist
+func (s *Stream) SegmentName(index int) string {
+	if s.format == formatTS {
+		return fmt.Sprintf("segment%d.ts", index)
+	}
+	return fmt.Sprintf("segment%d.m4s", index)
+}
+
+// ParseSegmentName returns index of the segment from its file name
+func (s *Stream) ParseSegmentName(name string) (index int, err error) {
+	_, err = fmt.Sscanf(strings.TrimSuffix(strings.TrimSuffix(name, ".ts"), ".m4s"), "segment%d", &index)
+	return
+}
+
+// WritePlaylist writes VOD playlist with all segments of the stream
+func (s *Stream) WritePlaylist(w io.Writer) error {
+	if err := s.probe(); err != nil {
+		return err
+	}
+
+	target := 0.0
+	for _, seg := range s.segments {
+		target = math.Max(target, seg.Duration)
+	}
+
+	version := 3
+	if s.format == formatMP4 {
+		version = 7
+	}
+
+	fmt.Fprintf(w, "#EXTM3U\n")
+	fmt.Fprintf(w, "#EXT-X-VERSION:%d\n", version)
+	fmt.Fprintf(w, "#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(target)))
+	fmt.Fprintf(w, "#EXT-X-MEDIA-SEQUENCE:0\n")
+	fmt.Fprintf(w, "#EXT-X-PLAYLIST-TYPE:VOD\n")
+	if s.format == formatMP4 {
+		fmt.Fprintf(w, "#EXT-X-MAP:URI=\"%s\"\n", InitName)
+	}
+	for _, seg := range s.segments {
+		fmt.Fprintf(w, "#EXTINF:%.3f,\n%s\n", seg.Duration, s.SegmentName(seg.Index))
+	}
+	_, err := fmt.Fprintf(w, "#EXT-X-ENDLIST\n")
+
+	return err
+}
+
+// WriteInit writes initialization segment, only fragmented MP4 streams have it
+func (s *Stream) WriteInit(w io.Writer) error {
+	if err := s.probe(); err != nil {
+		return err
+	}
+	if s.format != formatMP4 {
+		return errors.New("Stream has no initialization segment")
+	}
+
+	_, err := w.Write(s.mp4.init())
+	return err
+}
+
+// WriteSegment reads parts of the file, needed for the segment, and writes remuxed segment
+func (s *Stream) WriteSegment(w io.Writer, index int) error {
+	if err := s.probe(); err != nil {
+		return err
+	}
+	if index < 0 || index >= len(s.segments) {
+		return fmt.Errorf("Segment %d does not exist", index)
+	}
+
+	if s.format == formatTS {
+		return s.ts.writeSegment(w, index)
+	}
+	return s.mp4.writeSegment(w, index)
+}
+
+// readAt reads exact range of the source
+func readAt(src Source, offset, length int64) ([]byte, error) {
+	if offset+length > src.Length() {
+		length = src.Length() - offset
+	}
+	if length <= 0 {
+		return nil, io.EOF
+	}
+
+	r, err := src.Open(offset, length)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	b := make([]byte, length)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
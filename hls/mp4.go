@@ -0,0 +1,430 @@
+package hls
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+const (
+	maxMoovSize = 64 * 1024 * 1024
+)
+
+// mp4Box is a parsed box, raw contains header and payload
+type mp4Box struct {
+	typ     string
+	raw     []byte
+	payload []byte
+}
+
+// mp4Track is a track with resolved sample tables
+type mp4Track struct {
+	id        uint32
+	handler   string
+	timescale uint32
+
+	// Boxes, copied into initialization segment as is
+	tkhd   []byte
+	mdhd   []byte
+	hdlr   []byte
+	header []byte
+	dinf   []byte
+	stsd   []byte
+
+	offsets   []int64
+	sizes     []uint32
+	dts       []uint64
+	durations []uint32
+	cts       []int32
+	sync      []bool
+}
+
+// mp4Stream remuxes progressive MP4 into fragmented MP4 segments
+type mp4Stream struct {
+	src Source
+
+	mvhd   []byte
+	tracks []*mp4Track
+	// cuts are start times of segments, in seconds
+	cuts []float64
+	end  float64
+}
+
+func probeMP4(src Source) (*mp4Stream, error) {
+	s := &mp4Stream{
+		src: src,
+	}
+
+	var moov []byte
+	for offset := int64(0); offset+8 <= src.Length(); {
+		header, err := readAt(src, offset, 16)
+		if err != nil {
+			return nil, err
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[0:4]))
+		typ := string(header[4:8])
+		headerSize := int64(8)
+		if size == 1 && len(header) >= 16 {
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		} else if size == 0 {
+			size = src.Length() - offset
+		}
+		if size < headerSize {
+			return nil, fmt.Errorf("Wrong size of %s box", typ)
+		}
+
+		if typ == "moov" {
+			if size > maxMoovSize {
+				return nil, errors.New("MP4 index is too large")
+			}
+			if moov, err = readAt(src, offset+headerSize, size-headerSize); err != nil {
+				return nil, err
+			}
+			break
+		}
+
+		offset += size
+	}
+	if moov == nil {
+		return nil, errors.New("Could not find MP4 index")
+	}
+
+	for _, b := range parseBoxes(moov) {
+		switch b.typ {
+		case "mvhd":
+			s.mvhd = b.raw
+		case "trak":
+			t, err := parseTrack(b.payload)
+			if err != nil {
+				log.Debugf("Skipping MP4 track: %s", err)
+				continue
+			}
+			s.tracks = append(s.tracks, t)
+		}
+	}
+
+	s.tracks = pickTracks(s.tracks)
+	if s.mvhd == nil || len(s.tracks) == 0 {
+		return nil, errors.New("Could not find MP4 tracks")
+	}
+
+	s.split()
+	return s, nil
+}
+
+// pickTracks leaves first video and first audio tracks
+func pickTracks(tracks []*mp4Track) []*mp4Track {
+	ret := []*mp4Track{}
+	for _, handler := range []string{"vide", "soun"} {
+		for _, t := range tracks {
+			if t.handler == handler && len(t.sizes) > 0 {
+				ret = append(ret, t)
+				break
+			}
+		}
+	}
+	return ret
+}
+
+// split finds cut points, segments start at video sync samples
+func (s *mp4Stream) split() {
+	main := s.tracks[0]
+	for _, t := range s.tracks {
+		last := len(t.dts) - 1
+		if end := t.seconds(t.dts[last] + uint64(t.durations[last])); end > s.end {
+			s.end = end
+		}
+	}
+
+	s.cuts = []float64{0}
+	next := float64(SegmentDuration)
+	for i := range main.dts {
+		if !main.sync[i] {
+			continue
+		}
+		if at := main.seconds(main.dts[i]); at >= next && at < s.end {
+			s.cuts = append(s.cuts, at)
+			next = at + SegmentDuration
+		}
+	}
+}
+
+func (s *mp4Stream) segments() []*Segment {
+	ret := make([]*Segment, 0, len(s.cuts))
+	for i, begin := range s.cuts {
+		end := s.end
+		if i+1 < len(s.cuts) {
+			end = s.cuts[i+1]
+		}
+		ret = append(ret, &Segment{
+			Index:    i,
+			Duration: end - begin,
+		})
+	}
+	return ret
+}
+
+// samples returns range of track samples, belonging to the segment
+func (s *mp4Stream) samples(t *mp4Track, index int) (from, to int) {
+	begin := s.cuts[index]
+	end := s.end + 1
+	if index+1 < len(s.cuts) {
+		end = s.cuts[index+1]
+	}
+
+	if index > 0 {
+		from = sort.Search(len(t.dts), func(i int) bool { return t.seconds(t.dts[i]) >= begin })
+	}
+	to = sort.Search(len(t.dts), func(i int) bool { return t.seconds(t.dts[i]) >= end })
+	if to < from {
+		to = from
+	}
+	return
+}
+
+func (t *mp4Track) seconds(ts uint64) float64 {
+	return float64(ts) / float64(t.timescale)
+}
+
+func parseBoxes(b []byte) []*mp4Box {
+	ret := []*mp4Box{}
+	for len(b) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(b[0:4]))
+		headerSize := uint64(8)
+		if size == 1 && len(b) >= 16 {
+			size = binary.BigEndian.Uint64(b[8:16])
+			headerSize = 16
+		} else if size == 0 {
+			size = uint64(len(b))
+		}
+		if size < headerSize || size > uint64(len(b)) {
+			break
+		}
+
+		ret = append(ret, &mp4Box{
+			typ:     string(b[4:8]),
+			raw:     b[:size],
+			payload: b[headerSize:size],
+		})
+		b = b[size:]
+	}
+	return ret
+}
+
+func findBox(boxes []*mp4Box, typ string) *mp4Box {
+	for _, b := range boxes {
+		if b.typ == typ {
+			return b
+		}
+	}
+	return nil
+}
+
+// findPath returns nested box, like "mdia", "minf", "stbl"
+func findPath(b []byte, path ...string) *mp4Box {
+	var ret *mp4Box
+	for _, typ := range path {
+		if ret = findBox(parseBoxes(b), typ); ret == nil {
+			return nil
+		}
+		b = ret.payload
+	}
+	return ret
+}
+
+func parseTrack(trak []byte) (*mp4Track, error) {
+	t := &mp4Track{}
+
+	tkhd := findPath(trak, "tkhd")
+	mdhd := findPath(trak, "mdia", "mdhd")
+	hdlr := findPath(trak, "mdia", "hdlr")
+	minf := findPath(trak, "mdia", "minf")
+	stbl := findPath(trak, "mdia", "minf", "stbl")
+	if tkhd == nil || mdhd == nil || hdlr == nil || minf == nil || stbl == nil || len(tkhd.payload) < 24 || len(mdhd.payload) < 24 || len(hdlr.payload) < 12 {
+		return nil, errors.New("Track has no sample tables")
+	}
+
+	t.tkhd, t.mdhd, t.hdlr = tkhd.raw, mdhd.raw, hdlr.raw
+	t.handler = string(hdlr.payload[8:12])
+	if tkhd.payload[0] == 1 {
+		t.id = binary.BigEndian.Uint32(tkhd.payload[20:24])
+	} else {
+		t.id = binary.BigEndian.Uint32(tkhd.payload[12:16])
+	}
+	if mdhd.payload[0] == 1 {
+		t.timescale = binary.BigEndian.Uint32(mdhd.payload[20:24])
+	} else {
+		t.timescale = binary.BigEndian.Uint32(mdhd.payload[12:16])
+	}
+	if t.timescale == 0 {
+		return nil, errors.New("Track has no timescale")
+	}
+
+	minfBoxes := parseBoxes(minf.payload)
+	for _, typ := range []string{"vmhd", "smhd", "nmhd"} {
+		if b := findBox(minfBoxes, typ); b != nil {
+			t.header = b.raw
+		}
+	}
+	if b := findBox(minfBoxes, "dinf"); b != nil {
+		t.dinf = b.raw
+	}
+
+	boxes := parseBoxes(stbl.payload)
+	if b := findBox(boxes, "stsd"); b != nil {
+		t.stsd = b.raw
+	} else {
+		return nil, errors.New("Track has no sample descriptions")
+	}
+
+	if err := t.parseSampleTables(boxes); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (t *mp4Track) parseSampleTables(boxes []*mp4Box) error {
+	// Sample sizes
+	if b := findBox(boxes, "stsz"); b != nil && len(b.payload) >= 12 {
+		p := b.payload
+		size := binary.BigEndian.Uint32(p[4:8])
+		count := int(binary.BigEndian.Uint32(p[8:12]))
+		for i := 0; i < count; i++ {
+			if size != 0 {
+				t.sizes = append(t.sizes, size)
+			} else if 12+4*i+4 <= len(p) {
+				t.sizes = append(t.sizes, binary.BigEndian.Uint32(p[12+4*i:]))
+			}
+		}
+	} else if b := findBox(boxes, "stz2"); b != nil && len(b.payload) >= 12 {
+		p := b.payload
+		field := int(p[7])
+		count := int(binary.BigEndian.Uint32(p[8:12]))
+		if len(p) < 12+(count*field+7)/8 {
+			return errors.New("Track has wrong sample sizes")
+		}
+		for i := 0; i < count; i++ {
+			switch field {
+			case 4:
+				if v := p[12+i/2]; i%2 == 0 {
+					t.sizes = append(t.sizes, uint32(v>>4))
+				} else {
+					t.sizes = append(t.sizes, uint32(v&0x0f))
+				}
+			case 8:
+				t.sizes = append(t.sizes, uint32(p[12+i]))
+			case 16:
+				t.sizes = append(t.sizes, uint32(binary.BigEndian.Uint16(p[12+2*i:])))
+			}
+		}
+	}
+	count := len(t.sizes)
+	if count == 0 {
+		return errors.New("Track has no samples")
+	}
+
+	// Decoding times
+	stts := findBox(boxes, "stts")
+	if stts == nil || len(stts.payload) < 8 {
+		return errors.New("Track has no decoding times")
+	}
+	var dts uint64
+	for i, entries := 0, int(binary.BigEndian.Uint32(stts.payload[4:8])); i < entries && 16+8*i <= len(stts.payload); i++ {
+		n := int(binary.BigEndian.Uint32(stts.payload[8+8*i:]))
+		delta := binary.BigEndian.Uint32(stts.payload[12+8*i:])
+		for j := 0; j < n && len(t.dts) < count; j++ {
+			t.dts = append(t.dts, dts)
+			t.durations = append(t.durations, delta)
+			dts += uint64(delta)
+		}
+	}
+	for len(t.dts) < count {
+		t.dts = append(t.dts, dts)
+		t.durations = append(t.durations, 0)
+	}
+
+	// Composition offsets
+	t.cts = make([]int32, count)
+	if b := findBox(boxes, "ctts"); b != nil && len(b.payload) >= 8 {
+		sample := 0
+		for i, entries := 0, int(binary.BigEndian.Uint32(b.payload[4:8])); i < entries && 16+8*i <= len(b.payload); i++ {
+			n := int(binary.BigEndian.Uint32(b.payload[8+8*i:]))
+			offset := int32(binary.BigEndian.Uint32(b.payload[12+8*i:]))
+			for j := 0; j < n && sample < count; j++ {
+				t.cts[sample] = offset
+				sample++
+			}
+		}
+	}
+
+	// Sync samples, all samples are sync samples without the table
+	t.sync = make([]bool, count)
+	if b := findBox(boxes, "stss"); b != nil && len(b.payload) >= 8 {
+		for i, entries := 0, int(binary.BigEndian.Uint32(b.payload[4:8])); i < entries && 12+4*i <= len(b.payload); i++ {
+			if n := int(binary.BigEndian.Uint32(b.payload[8+4*i:])); n > 0 && n <= count {
+				t.sync[n-1] = true
+			}
+		}
+	} else {
+		for i := range t.sync {
+			t.sync[i] = true
+		}
+	}
+
+	// Chunk offsets
+	chunks := []int64{}
+	if b := findBox(boxes, "stco"); b != nil && len(b.payload) >= 8 {
+		for i, entries := 0, int(binary.BigEndian.Uint32(b.payload[4:8])); i < entries && 12+4*i <= len(b.payload); i++ {
+			chunks = append(chunks, int64(binary.BigEndian.Uint32(b.payload[8+4*i:])))
+		}
+	} else if b := findBox(boxes, "co64"); b != nil && len(b.payload) >= 8 {
+		for i, entries := 0, int(binary.BigEndian.Uint32(b.payload[4:8])); i < entries && 16+8*i <= len(b.payload); i++ {
+			chunks = append(chunks, int64(binary.BigEndian.Uint64(b.payload[8+8*i:])))
+		}
+	}
+
+	// Sample offsets, from samples to chunk mapping
+	stsc := findBox(boxes, "stsc")
+	if stsc == nil || len(stsc.payload) < 8 || len(chunks) == 0 {
+		return errors.New("Track has no chunks")
+	}
+	type stscEntry struct {
+		first, samples int
+	}
+	entries := []stscEntry{}
+	for i, n := 0, int(binary.BigEndian.Uint32(stsc.payload[4:8])); i < n && 20+12*i <= len(stsc.payload); i++ {
+		entries = append(entries, stscEntry{
+			first:   int(binary.BigEndian.Uint32(stsc.payload[8+12*i:])),
+			samples: int(binary.BigEndian.Uint32(stsc.payload[12+12*i:])),
+		})
+	}
+
+	sample := 0
+	for e, entry := range entries {
+		// Chunks are numbered from 1
+		if entry.first < 1 {
+			continue
+		}
+		last := len(chunks)
+		if e+1 < len(entries) {
+			last = entries[e+1].first - 1
+		}
+		for chunk := entry.first; chunk <= last && chunk <= len(chunks); chunk++ {
+			offset := chunks[chunk-1]
+			for j := 0; j < entry.samples && sample < count; j++ {
+				t.offsets = append(t.offsets, offset)
+				offset += int64(t.sizes[sample])
+				sample++
+			}
+		}
+	}
+	if len(t.offsets) < count {
+		return errors.New("Track has wrong chunks table")
+	}
+
+	return nil
+}
@@ -0,0 +1,219 @@
+package hls
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// memorySource is a Source of the file in memory
+type memorySource []byte
+
+func (m memorySource) Open(offset, length int64) (io.ReadCloser, error) {
+	if offset < 0 || offset+length > int64(len(m)) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return ioutil.NopCloser(bytes.NewReader(m[offset : offset+length])), nil
+}
+
+func (m memorySource) Length() int64 {
+	return int64(len(m))
+}
+
+const (
+	testSamples    = 20
+	testVideoSize  = 10
+	testAudioSize  = 4
+	testSampleTime = 1000
+)
+
+// testTrack builds a track with one sample per second, all samples are in a single chunk
+func testTrack(id uint32, handler string, size uint32, chunk uint32, syncSamples []uint32) []byte {
+	stbl := [][]byte{
+		fullBox("stsd", 0, 0, u32(1), box("avc1", []byte("description"))),
+		fullBox("stts", 0, 0, u32(1, testSamples, testSampleTime)),
+		fullBox("stsz", 0, 0, u32(size, testSamples)),
+		fullBox("stsc", 0, 0, u32(1, 1, testSamples, 1)),
+		fullBox("stco", 0, 0, u32(1, chunk)),
+	}
+	if syncSamples != nil {
+		stbl = append(stbl, fullBox("stss", 0, 0, u32(uint32(len(syncSamples))), u32(syncSamples...)))
+	}
+
+	header := fullBox("smhd", 0, 0, u32(0))
+	if handler == "vide" {
+		header = fullBox("vmhd", 0, 1, u32(0, 0))
+	}
+
+	return box("trak",
+		fullBox("tkhd", 0, 3, u32(0, 0, id, 0, 0)),
+		box("mdia",
+			fullBox("mdhd", 0, 0, u32(0, 0, testSampleTime, testSamples*testSampleTime, 0)),
+			fullBox("hdlr", 0, 0, u32(0), []byte(handler), u32(0, 0, 0), []byte("Handler\x00")),
+			box("minf", header, box("stbl", stbl...)),
+		),
+	)
+}
+
+// buildMP4 returns progressive MP4 with the index before the data,
+// video has keyframes every 6 seconds, video samples are filled with their number
+func buildMP4() []byte {
+	ftyp := box("ftyp", []byte("isom"), u32(0x200), []byte("isommp41"))
+	moov := func(data uint32) []byte {
+		return box("moov",
+			fullBox("mvhd", 0, 0, u32(0, 0, testSampleTime, testSamples*testSampleTime), make([]byte, 80)),
+			testTrack(1, "vide", testVideoSize, data, []uint32{1, 7, 13, 19}),
+			testTrack(2, "soun", testAudioSize, data+testSamples*testVideoSize, nil),
+			testTrack(3, "text", 1, data, nil),
+		)
+	}
+	data := uint32(len(ftyp) + len(moov(0)) + 8)
+
+	samples := []byte{}
+	for i := 0; i < testSamples; i++ {
+		samples = append(samples, bytes.Repeat([]byte{byte(i)}, testVideoSize)...)
+	}
+	for i := 0; i < testSamples; i++ {
+		samples = append(samples, bytes.Repeat([]byte{byte(100 + i)}, testAudioSize)...)
+	}
+
+	return bytes.Join([][]byte{ftyp, moov(data), box("mdat", samples)}, nil)
+}
+
+func TestMP4Segments(t *testing.T) {
+	s, err := NewStream("movie.mp4", memorySource(buildMP4()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var playlist bytes.Buffer
+	if err := s.WritePlaylist(&playlist); err != nil {
+		t.Fatal(err)
+	}
+	want := "#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-TARGETDURATION:6\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n" +
+		"#EXT-X-MAP:URI=\"init.mp4\"\n" +
+		"#EXTINF:6.000,\nsegment0.m4s\n#EXTINF:6.000,\nsegment1.m4s\n#EXTINF:6.000,\nsegment2.m4s\n#EXTINF:2.000,\nsegment3.m4s\n" +
+		"#EXT-X-ENDLIST\n"
+	if playlist.String() != want {
+		t.Errorf("Playlist is\n%s\nexpected\n%s", playlist.String(), want)
+	}
+
+	var init bytes.Buffer
+	if err := s.WriteInit(&init); err != nil {
+		t.Fatal(err)
+	}
+	moov := findBox(parseBoxes(init.Bytes()), "moov")
+	if moov == nil {
+		t.Fatal("Initialization segment has no moov")
+	}
+	traks := 0
+	for _, b := range parseBoxes(moov.payload) {
+		if b.typ == "trak" {
+			traks++
+		}
+	}
+	if traks != 2 || findPath(moov.payload, "mvex", "trex") == nil {
+		t.Errorf("Initialization segment has %d tracks, expected video and audio tracks with mvex", traks)
+	}
+
+	tests := []struct {
+		index int
+		from  int
+		to    int
+	}{
+		{0, 0, 6},
+		{1, 6, 12},
+		{2, 12, 18},
+		{3, 18, 20},
+	}
+
+	for _, test := range tests {
+		var w bytes.Buffer
+		if err := s.WriteSegment(&w, test.index); err != nil {
+			t.Errorf("Segment %d: %s", test.index, err)
+			continue
+		}
+
+		boxes := parseBoxes(w.Bytes())
+		if len(boxes) != 2 || boxes[0].typ != "moof" || boxes[1].typ != "mdat" {
+			t.Errorf("Segment %d is not a fragment", test.index)
+			continue
+		}
+
+		wantData := []byte{}
+		for i := test.from; i < test.to; i++ {
+			wantData = append(wantData, bytes.Repeat([]byte{byte(i)}, testVideoSize)...)
+		}
+		for i := test.from; i < test.to; i++ {
+			wantData = append(wantData, bytes.Repeat([]byte{byte(100 + i)}, testAudioSize)...)
+		}
+		if !bytes.Equal(boxes[1].payload, wantData) {
+			t.Errorf("Segment %d has wrong samples %v", test.index, boxes[1].payload)
+		}
+
+		tfdt := findPath(boxes[0].payload, "traf", "tfdt")
+		trun := findPath(boxes[0].payload, "traf", "trun")
+		if tfdt == nil || trun == nil {
+			t.Errorf("Segment %d has no fragment run", test.index)
+			continue
+		}
+		if dts := binary.BigEndian.Uint64(tfdt.payload[4:]); dts != uint64(test.from*testSampleTime) {
+			t.Errorf("Segment %d starts at %d, expected %d", test.index, dts, test.from*testSampleTime)
+		}
+		count := binary.BigEndian.Uint32(trun.payload[4:])
+		offset := binary.BigEndian.Uint32(trun.payload[8:])
+		if int(count) != test.to-test.from || int(offset) != len(boxes[0].raw)+8 {
+			t.Errorf("Segment %d has %d samples at %d, expected %d at %d", test.index, count, offset, test.to-test.from, len(boxes[0].raw)+8)
+		}
+		// Fragment starts with the keyframe
+		if flags := binary.BigEndian.Uint32(trun.payload[20:]); flags != sampleFlagsSync {
+			t.Errorf("Segment %d starts with sample flags %x", test.index, flags)
+		}
+	}
+
+	if err := s.WriteSegment(ioutil.Discard, 4); err == nil {
+		t.Error("Expected error for missing segment")
+	}
+}
+
+func TestMP4Malformed(t *testing.T) {
+	valid := buildMP4()
+
+	// Chunk numbers start from 1, zero chunk should not be looked up
+	zeroChunk := bytes.Replace(valid, fullBox("stsc", 0, 0, u32(1, 1, testSamples, 1)), fullBox("stsc", 0, 0, u32(1, 0, testSamples, 1)), -1)
+
+	tests := []struct {
+		name string
+		file []byte
+	}{
+		{"empty", []byte{}},
+		{"no index", box("ftyp", []byte("isom"))},
+		{"wrong box size", append(u32(4), []byte("ftyp")...)},
+		{"no tracks", box("moov", fullBox("mvhd", 0, 0, u32(0)))},
+		{"zero chunk", zeroChunk},
+	}
+
+	for _, test := range tests {
+		s, err := NewStream("movie.mp4", memorySource(test.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.WritePlaylist(ioutil.Discard); err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+	}
+
+	// Truncated files have the index, and samples are cut, segments return errors without panics
+	for cut := 0; cut < len(valid); cut++ {
+		s, _ := NewStream("movie.mp4", memorySource(valid[:cut]))
+		if s.WritePlaylist(ioutil.Discard) != nil {
+			continue
+		}
+		s.WriteInit(ioutil.Discard)
+		for i := range s.segments {
+			s.WriteSegment(ioutil.Discard, i)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package hls
+
+import (
+	"errors"
+	"io"
+	"math"
+)
+
+const (
+	tsPacketSize = 188
+	tsSyncByte   = 0x47
+	tsProbeSize  = 2 * 1024 * 1024
+	ptsClock     = 90000
+	ptsWrap      = 1 << 33
+)
+
+// tsStream splits MPEG-TS file on packet boundaries, segments start at keyframes when possible
+type tsStream struct {
+	src Source
+
+	// first is a position of the first packet, packetSize is 192 for M2TS files
+	first      int64
+	packetSize int64
+	prefix     int64
+	packets    int64
+
+	pat []byte
+	pmt []byte
+	pid int
+
+	duration       float64
+	segmentPackets int64
+}
+
+func probeTS(src Source) (*tsStream, error) {
+	head, err := readAt(src, 0, tsProbeSize)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	s := &tsStream{
+		src: src,
+		pid: -1,
+	}
+	if !s.sync(head) {
+		return nil, errors.New("Could not find MPEG-TS sync")
+	}
+	s.packets = (src.Length() - s.first) / s.packetSize
+
+	pmtPID := -1
+	streams := map[int]int{}
+	firstPTS := int64(-1)
+	s.eachPacket(head, s.first, func(p []byte) bool {
+		pid := packetPID(p)
+		switch {
+		case pid == 0 && s.pat == nil:
+			if program := parsePAT(p); program > 0 {
+				s.pat = append([]byte{}, p...)
+				pmtPID = program
+			}
+		case pid == pmtPID && s.pmt == nil:
+			if streams = parsePMT(p); len(streams) > 0 {
+				s.pmt = append([]byte{}, p...)
+				s.pid = pickStream(streams)
+			}
+		case pid == s.pid && s.pid >= 0:
+			if pts := packetPTS(p); pts >= 0 {
+				firstPTS = pts
+				return false
+			}
+		}
+		return true
+	})
+	if s.pat == nil || s.pmt == nil {
+		return nil, errors.New("Could not find MPEG-TS program tables")
+	}
+	if firstPTS < 0 {
+		return nil, errors.New("Could not find MPEG-TS timestamps")
+	}
+
+	tailOffset := s.first + (s.packets*s.packetSize - tsProbeSize)
+	if tailOffset < s.first {
+		tailOffset = s.first
+	}
+	tailOffset -= (tailOffset - s.first) % s.packetSize
+	tail, err := readAt(src, tailOffset, s.first+s.packets*s.packetSize-tailOffset)
+	if err != nil {
+		return nil, err
+	}
+
+	lastPTS := int64(-1)
+	s.eachPacket(tail, 0, func(p []byte) bool {
+		if packetPID(p) == s.pid {
+			if pts := packetPTS(p); pts >= 0 {
+				lastPTS = pts
+			}
+		}
+		return true
+	})
+	if lastPTS < 0 {
+		return nil, errors.New("Could not find MPEG-TS timestamps")
+	}
+
+	s.duration = float64((lastPTS-firstPTS+ptsWrap)%ptsWrap) / ptsClock
+	if s.duration <= 0 {
+		return nil, errors.New("Could not detect MPEG-TS duration")
+	}
+
+	// Segments have the same size, calculated from average bitrate
+	s.segmentPackets = int64(math.Ceil(float64(s.packets) * SegmentDuration / s.duration))
+	if s.segmentPackets < 1 {
+		s.segmentPackets = 1
+	}
+
+	return s, nil
+}
+
+// sync finds first packet and packet size
+func (s *tsStream) sync(b []byte) bool {
+	for _, size := range []int{tsPacketSize, tsPacketSize + 4} {
+		for i := 0; i < size && i+2*size < len(b); i++ {
+			if b[i] == tsSyncByte && b[i+size] == tsSyncByte && b[i+2*size] == tsSyncByte {
+				s.packetSize = int64(size)
+				s.prefix = int64(size - tsPacketSize)
+				s.first = int64(i) - s.prefix
+				if s.first < 0 {
+					s.first += s.packetSize
+				}
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// eachPacket calls f for each packet in b, b starts at position of a packet
+func (s *tsStream) eachPacket(b []byte, start int64, f func(p []byte) bool) {
+	for i := start; i+s.packetSize <= int64(len(b)); i += s.packetSize {
+		p := b[i+s.prefix : i+s.packetSize]
+		if p[0] != tsSyncByte {
+			continue
+		}
+		if !f(p) {
+			return
+		}
+	}
+}
+
+func (s *tsStream) segments() []*Segment {
+	count := int((s.packets + s.segmentPackets - 1) / s.segmentPackets)
+	ret := make([]*Segment, 0, count)
+	for i := 0; i < count; i++ {
+		packets := s.segmentPackets
+		if rest := s.packets - int64(i)*s.segmentPackets; rest < packets {
+			packets = rest
+		}
+		ret = append(ret, &Segment{
+			Index:    i,
+			Duration: s.duration * float64(packets) / float64(s.packets),
+		})
+	}
+	return ret
+}
+
+// writeSegment writes packets of the segment, with program tables at the beginning
+func (s *tsStream) writeSegment(w io.Writer, index int) error {
+	begin := int64(index) * s.segmentPackets
+	end := begin + s.segmentPackets
+	window := s.segmentPackets / 2
+
+	// Reading a bit more, to find the keyframe, which starts the next segment
+	count := end + window - begin
+	if begin+count > s.packets {
+		count = s.packets - begin
+	}
+
+	b, err := readAt(s.src, s.first+begin*s.packetSize, count*s.packetSize)
+	if err != nil {
+		return err
+	}
+
+	from := int64(0)
+	if index > 0 {
+		from = s.keyframe(b, 0, window)
+	}
+	to := count
+	if end < s.packets {
+		to = s.keyframe(b, end-begin, window)
+	}
+
+	if index > 0 {
+		if _, err := w.Write(s.pat); err != nil {
+			return err
+		}
+		if _, err := w.Write(s.pmt); err != nil {
+			return err
+		}
+	}
+
+	for i := from; i < to; i++ {
+		if _, err := w.Write(b[i*s.packetSize+s.prefix : (i+1)*s.packetSize]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// keyframe returns index of the first packet with random access point, not further than window
+func (s *tsStream) keyframe(b []byte, from, window int64) int64 {
+	for i := from; i < from+window && (i+1)*s.packetSize <= int64(len(b)); i++ {
+		p := b[i*s.packetSize+s.prefix : (i+1)*s.packetSize]
+		if packetPID(p) == s.pid && p[1]&0x40 != 0 && p[3]&0x20 != 0 && p[4] > 0 && p[5]&0x40 != 0 {
+			return i
+		}
+	}
+	return from
+}
+
+func packetPID(p []byte) int {
+	return int(p[1]&0x1f)<<8 | int(p[2])
+}
+
+// packetPayload returns payload of the packet, skipping adaptation field
+func packetPayload(p []byte) []byte {
+	if p[3]&0x10 == 0 {
+		return nil
+	}
+	offset := 4
+	if p[3]&0x20 != 0 {
+		offset += 1 + int(p[4])
+	}
+	if offset >= len(p) {
+		return nil
+	}
+	return p[offset:]
+}
+
+// sectionPayload returns PSI section of the packet, skipping pointer field
+func sectionPayload(p []byte) []byte {
+	if p[1]&0x40 == 0 {
+		return nil
+	}
+	payload := packetPayload(p)
+	if len(payload) < 1 || 1+int(payload[0]) >= len(payload) {
+		return nil
+	}
+	section := payload[1+int(payload[0]):]
+	if len(section) < 3 {
+		return nil
+	}
+	length := 3 + (int(section[1]&0x0f)<<8 | int(section[2]))
+	if length > len(section) {
+		return nil
+	}
+	return section[:length]
+}
+
+// parsePAT returns PID of the first program's PMT
+func parsePAT(p []byte) int {
+	section := sectionPayload(p)
+	if len(section) < 12 || section[0] != 0x00 {
+		return -1
+	}
+	for i := 8; i+4 <= len(section)-4; i += 4 {
+		program := int(section[i])<<8 | int(section[i+1])
+		if program != 0 {
+			return int(section[i+2]&0x1f)<<8 | int(section[i+3])
+		}
+	}
+	return -1
+}
+
+// parsePMT returns stream types of elementary streams by their PID
+func parsePMT(p []byte) map[int]int {
+	streams := map[int]int{}
+	section := sectionPayload(p)
+	if len(section) < 16 || section[0] != 0x02 {
+		return streams
+	}
+
+	i := 12 + (int(section[10]&0x0f)<<8 | int(section[11]))
+	for i+5 <= len(section)-4 {
+		pid := int(section[i+1]&0x1f)<<8 | int(section[i+2])
+		streams[pid] = int(section[i])
+		i += 5 + (int(section[i+3]&0x0f)<<8 | int(section[i+4]))
+	}
+	return streams
+}
+
+// pickStream prefers video stream for timestamps and keyframes
+func pickStream(streams map[int]int) int {
+	ret := -1
+	for pid, streamType := range streams {
+		switch streamType {
+		case 0x01, 0x02, 0x1b, 0x24, 0xea:
+			if ret < 0 || pid < ret {
+				ret = pid
+			}
+		}
+	}
+	if ret >= 0 {
+		return ret
+	}
+
+	for pid := range streams {
+		if ret < 0 || pid < ret {
+			ret = pid
+		}
+	}
+	return ret
+}
+
+// packetPTS returns presentation timestamp of PES, starting in the packet, or -1
+func packetPTS(p []byte) int64 {
+	if p[1]&0x40 == 0 {
+		return -1
+	}
+	pes := packetPayload(p)
+	if len(pes) < 14 || pes[0] != 0 || pes[1] != 0 || pes[2] != 1 || pes[7]&0x80 == 0 {
+		return -1
+	}
+
+	t := pes[9:14]
+	return int64(t[0]>>1&0x07)<<30 | int64(t[1])<<22 | int64(t[2]>>1)<<15 | int64(t[3])<<7 | int64(t[4]>>1)
+}
@@ -0,0 +1,204 @@
+package hls
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+const (
+	testPMTPID   = 0x100
+	testVideoPID = 0x101
+	testAudioPID = 0x102
+	// testGOP is a number of packets from one keyframe to the next one, keyframes are one second apart
+	testGOP = 10
+)
+
+// tsPacket builds a packet, keyframes start PES with timestamp and have random access flag
+func tsPacket(pid int, start bool, payload []byte) []byte {
+	p := bytes.Repeat([]byte{0xff}, tsPacketSize)
+	p[0] = tsSyncByte
+	p[1] = byte(pid>>8) & 0x1f
+	if start {
+		p[1] |= 0x40
+	}
+	p[2] = byte(pid)
+	p[3] = 0x10
+	copy(p[4:], payload)
+	return p
+}
+
+func tsKeyframe(pts int64) []byte {
+	p := tsPacket(testVideoPID, true, nil)
+	p[3] = 0x30
+	p[4], p[5] = 1, 0x40
+	copy(p[6:], []byte{0, 0, 1, 0xe0, 0, 0, 0x80, 0x80, 5,
+		byte(0x21 | pts>>29&0x0e), byte(pts >> 22), byte(pts>>14&0xfe | 1), byte(pts >> 7), byte(pts<<1&0xfe | 1)})
+	return p
+}
+
+// tsSection builds PSI section with pointer field and zero CRC
+func tsSection(tableID byte, body []byte) []byte {
+	length := len(body) + 4
+	section := append([]byte{0, tableID, 0xb0 | byte(length>>8), byte(length)}, body...)
+	return append(section, 0, 0, 0, 0)
+}
+
+// buildTS returns MPEG-TS file with program tables and a video stream of given seconds,
+// packets have prefix of given size, like 4 bytes timecodes of M2TS
+func buildTS(seconds int, prefix int, junk int) []byte {
+	pat := tsSection(0x00, []byte{0, 1, 0xc1, 0, 0, 0, 1, 0xe0 | testPMTPID>>8, testPMTPID & 0xff})
+	pmt := tsSection(0x02, []byte{
+		0, 1, 0xc1, 0, 0, 0xe0 | testVideoPID>>8, testVideoPID & 0xff, 0xf0, 0,
+		0x0f, 0xe0 | testAudioPID>>8, testAudioPID & 0xff, 0xf0, 0,
+		0x1b, 0xe0 | testVideoPID>>8, testVideoPID & 0xff, 0xf0, 0,
+	})
+
+	packets := [][]byte{tsPacket(0, true, pat), tsPacket(testPMTPID, true, pmt)}
+	for i := 0; i <= seconds; i++ {
+		packets = append(packets, tsKeyframe(int64(i)*ptsClock+ptsClock))
+		for j := 1; j < testGOP; j++ {
+			pid := testVideoPID
+			if j%3 == 0 {
+				pid = testAudioPID
+			}
+			packets = append(packets, tsPacket(pid, false, nil))
+		}
+	}
+
+	ret := make([]byte, junk)
+	for _, p := range packets {
+		ret = append(ret, make([]byte, prefix)...)
+		ret = append(ret, p...)
+	}
+	return ret
+}
+
+// splitTS returns packets of the segment, without prefixes
+func splitTS(b []byte) [][]byte {
+	ret := [][]byte{}
+	for ; len(b) >= tsPacketSize; b = b[tsPacketSize:] {
+		ret = append(ret, b[:tsPacketSize])
+	}
+	return ret
+}
+
+func TestTSSegments(t *testing.T) {
+	tests := []struct {
+		name   string
+		file   string
+		prefix int
+		junk   int
+	}{
+		{"ts", "movie.ts", 0, 0},
+		{"m2ts", "movie.m2ts", 4, 0},
+		{"leading junk", "movie.ts", 0, 50},
+	}
+
+	for _, test := range tests {
+		s, err := NewStream(test.file, memorySource(buildTS(20, test.prefix, test.junk)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var playlist bytes.Buffer
+		if err := s.WritePlaylist(&playlist); err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if !strings.Contains(playlist.String(), "#EXT-X-TARGETDURATION:7\n") || strings.Count(playlist.String(), "#EXTINF:") != 4 || strings.Contains(playlist.String(), "#EXT-X-MAP") {
+			t.Errorf("%s: wrong playlist\n%s", test.name, playlist.String())
+		}
+
+		// Segments together contain all packets, each segment after the first one starts with keyframe
+		total := 0
+		for i := 0; i < 4; i++ {
+			var w bytes.Buffer
+			if err := s.WriteSegment(&w, i); err != nil {
+				t.Errorf("%s: segment %d: %s", test.name, i, err)
+				continue
+			}
+			packets := splitTS(w.Bytes())
+			if i > 0 {
+				if len(packets) < 3 || packetPID(packets[0]) != 0 || packetPID(packets[1]) != testPMTPID {
+					t.Errorf("%s: segment %d has no program tables", test.name, i)
+					continue
+				}
+				packets = packets[2:]
+				if packetPTS(packets[0]) < 0 {
+					t.Errorf("%s: segment %d does not start with keyframe", test.name, i)
+				}
+			}
+			total += len(packets)
+		}
+		if want := 2 + 21*testGOP; total != want {
+			t.Errorf("%s: segments have %d packets, expected %d", test.name, total, want)
+		}
+
+		if err := s.WriteSegment(ioutil.Discard, 4); err == nil {
+			t.Errorf("%s: expected error for missing segment", test.name)
+		}
+	}
+}
+
+func TestTSMalformed(t *testing.T) {
+	valid := buildTS(20, 0, 0)
+	tables := valid[: 2*tsPacketSize : 2*tsPacketSize]
+
+	tests := []struct {
+		name string
+		file []byte
+	}{
+		{"empty", []byte{}},
+		{"no sync", make([]byte, 1000)},
+		{"no program tables", bytes.Join([][]byte{tsKeyframe(0), tsKeyframe(ptsClock), tsKeyframe(2 * ptsClock)}, nil)},
+		{"no timestamps", append(tables, bytes.Repeat(tsPacket(testVideoPID, true, nil), 3)...)},
+		{"single timestamp", valid[:(2+testGOP)*tsPacketSize]},
+	}
+
+	for _, test := range tests {
+		s, err := NewStream("movie.ts", memorySource(test.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.WritePlaylist(ioutil.Discard); err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+	}
+
+	for cut := 0; cut < len(valid); cut += 47 {
+		s, _ := NewStream("movie.ts", memorySource(valid[:cut]))
+		if s.WritePlaylist(ioutil.Discard) != nil {
+			continue
+		}
+		for i := range s.segments {
+			s.WriteSegment(ioutil.Discard, i)
+		}
+	}
+}
+
+func TestParseSegmentName(t *testing.T) {
+	s := &Stream{}
+	tests := []struct {
+		name    string
+		want    int
+		wantErr bool
+	}{
+		{"segment0.ts", 0, false},
+		{"segment12.m4s", 12, false},
+		{"segment", 0, true},
+		{"init.mp4", 0, true},
+	}
+
+	for _, test := range tests {
+		got, err := s.ParseSegmentName(test.name)
+		if (err != nil) != test.wantErr || (err == nil && got != test.want) {
+			t.Errorf("%s: parsed %d with error %v, expected %d", test.name, got, err, test.want)
+		}
+	}
+
+	if _, err := NewStream("movie.avi", memorySource(nil)); err != ErrUnsupported {
+		t.Errorf("Error is %v, expected %v", err, ErrUnsupported)
+	}
+}
@@ -0,0 +1,216 @@
+package library
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/elgatito/elementum/database"
+)
+
+// ExportVersion is a version of the export format, import accepts this and older versions
+const ExportVersion = 1
+
+// Export is a portable copy of the library state
+type Export struct {
+	Version  int              `json:"version"`
+	Created  time.Time        `json:"created"`
+	Items    []*ExportItem    `json:"items"`
+	UIDs     []*ExportUIDs    `json:"uids"`
+	Shows    []*ExportShow    `json:"shows"`
+	Torrents []*ExportTorrent `json:"torrents"`
+}
+
+// ExportItem is a row of library_items
+type ExportItem struct {
+	TMDBID    int `json:"tmdb_id"`
+	State     int `json:"state"`
+	MediaType int `json:"media_type"`
+	ShowID    int `json:"show_id"`
+}
+
+// ExportUIDs is a row of library_uids
+type ExportUIDs struct {
+	MediaType int    `json:"media_type"`
+	Kodi      int    `json:"kodi"`
+	TMDB      int    `json:"tmdb"`
+	TVDB      int    `json:"tvdb"`
+	Trakt     int    `json:"trakt"`
+	IMDB      string `json:"imdb"`
+	Playcount int    `json:"playcount"`
+}
+
+// ExportShow keeps show-level flags
+type ExportShow struct {
+	TMDBID    int  `json:"tmdb_id"`
+	Monitored bool `json:"monitored"`
+}
+
+// ExportTorrent is a torrent, remembered for library items
+type ExportTorrent struct {
+	InfoHash string `json:"infohash"`
+	Metainfo []byte `json:"metainfo"`
+	Items    []int  `json:"items"`
+}
+
+// ImportResult shows what was restored from the export
+type ImportResult struct {
+	Items    int      `json:"items"`
+	UIDs     int      `json:"uids"`
+	Shows    int      `json:"shows"`
+	Torrents int      `json:"torrents"`
+	Written  int      `json:"written"`
+	Errors   []string `json:"errors"`
+}
+
+// ExportLibrary collects library items, UIDs, show flags and torrent history
+func ExportLibrary() (*Export, error) {
+	e := &Export{
+		Version:  ExportVersion,
+		Created:  time.Now(),
+		Items:    []*ExportItem{},
+		UIDs:     []*ExportUIDs{},
+		Shows:    []*ExportShow{},
+		Torrents: []*ExportTorrent{},
+	}
+
+	rows, err := database.Get().Query(`SELECT tmdbId, state, mediaType, showId FROM library_items`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		i := &ExportItem{}
+		if err := rows.Scan(&i.TMDBID, &i.State, &i.MediaType, &i.ShowID); err != nil {
+			continue
+		}
+		e.Items = append(e.Items, i)
+	}
+	rows.Close()
+
+	rows, err = database.Get().Query(`SELECT mediaType, kodi, tmdb, tvdb, trakt, imdb, playcount FROM library_uids`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		u := &ExportUIDs{}
+		if err := rows.Scan(&u.MediaType, &u.Kodi, &u.TMDB, &u.TVDB, &u.Trakt, &u.IMDB, &u.Playcount); err != nil {
+			continue
+		}
+		e.UIDs = append(e.UIDs, u)
+	}
+	rows.Close()
+
+	for _, s := range database.Get().GetMonitoredShows() {
+		e.Shows = append(e.Shows, &ExportShow{TMDBID: s.ShowID, Monitored: true})
+	}
+
+	rows, err = database.Get().Query(`SELECT m.infohash, m.metainfo, a.item_id FROM thistory_metainfo m INNER JOIN thistory_assign a ON a.infohash_id = m.rowid ORDER BY m.rowid`)
+	if err != nil {
+		return nil, err
+	}
+	torrents := map[string]*ExportTorrent{}
+	for rows.Next() {
+		var infoHash string
+		var metainfo []byte
+		var itemID int
+		if err := rows.Scan(&infoHash, &metainfo, &itemID); err != nil {
+			continue
+		}
+
+		t, ok := torrents[infoHash]
+		if !ok {
+			t = &ExportTorrent{InfoHash: infoHash, Metainfo: metainfo}
+			torrents[infoHash] = t
+			e.Torrents = append(e.Torrents, t)
+		}
+		t.Items = append(t.Items, itemID)
+	}
+	rows.Close()
+
+	log.Noticef("Exported %d library items, %d UIDs, %d shows and %d torrents", len(e.Items), len(e.UIDs), len(e.Shows), len(e.Torrents))
+	return e, nil
+}
+
+// ImportLibrary restores library state from the export, and writes .strm and .nfo files for active items.
+// Kodi IDs are specific to Kodi database, so they are not restored, and are resolved again on library refresh.
+func ImportLibrary(e *Export) (*ImportResult, error) {
+	if e == nil || e.Version <= 0 || e.Version > ExportVersion {
+		return nil, fmt.Errorf("Unsupported library export version")
+	}
+	if err := checkMoviesPath(); err != nil {
+		return nil, err
+	}
+	if err := checkShowsPath(); err != nil {
+		return nil, err
+	}
+
+	res := &ImportResult{Errors: []string{}}
+
+	tx, err := database.Get().Begin()
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range e.Items {
+		if _, err := tx.Exec(`INSERT OR REPLACE INTO library_items (tmdbId, state, mediaType, showId) VALUES (?, ?, ?, ?)`, i.TMDBID, i.State, i.MediaType, i.ShowID); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		res.Items++
+	}
+	for _, u := range e.UIDs {
+		var count int
+		tx.QueryRow(`SELECT COUNT(*) FROM library_uids WHERE mediaType = ? AND tmdb = ? AND tvdb = ? AND trakt = ? AND imdb = ?`, u.MediaType, u.TMDB, u.TVDB, u.Trakt, u.IMDB).Scan(&count)
+		if count > 0 {
+			continue
+		}
+		if _, err := tx.Exec(`INSERT INTO library_uids (mediaType, kodi, tmdb, tvdb, trakt, imdb, playcount) VALUES (?, 0, ?, ?, ?, ?, ?)`, u.MediaType, u.TMDB, u.TVDB, u.Trakt, u.IMDB, u.Playcount); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		res.UIDs++
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	for _, s := range e.Shows {
+		if err := database.Get().SetShowMonitored(s.TMDBID, s.Monitored); err == nil {
+			res.Shows++
+		}
+	}
+
+	for _, t := range e.Torrents {
+		if t.InfoHash == "" || len(t.Metainfo) == 0 {
+			continue
+		}
+		for _, itemID := range t.Items {
+			database.Get().AddTorrentHistory(strconv.Itoa(itemID), t.InfoHash, t.Metainfo)
+		}
+		res.Torrents++
+	}
+
+	for _, i := range e.Items {
+		if i.State != StateActive {
+			continue
+		}
+
+		var err error
+		if i.MediaType == MovieType {
+			_, err = writeMovieStrm(strconv.Itoa(i.TMDBID), false)
+		} else if i.MediaType == ShowType {
+			_, err = writeShowStrm(i.TMDBID, false, false)
+		} else {
+			continue
+		}
+
+		if err != nil {
+			log.Warningf("Could not write library files for %d: %s", i.TMDBID, err)
+			res.Errors = append(res.Errors, fmt.Sprintf("%d: %s", i.TMDBID, err))
+		} else {
+			res.Written++
+		}
+	}
+
+	log.Noticef("Imported %d library items, %d UIDs, %d shows and %d torrents, written files for %d items", res.Items, res.UIDs, res.Shows, res.Torrents, res.Written)
+	return res, nil
+}
@@ -74,13 +74,22 @@ func GetMovieByTMDB(id int) (*Movie, error) {
 	l.mu.Movies.Lock()
 	defer l.mu.Movies.Unlock()
 
+	if m := findMovieByTMDB(id); m != nil {
+		return m, nil
+	}
+
+	return nil, errors.New("Not found")
+}
+
+// findMovieByTMDB expects l.mu.Movies to be held
+func findMovieByTMDB(id int) *Movie {
 	for _, m := range l.Movies {
 		if m != nil && m.UIDs.TMDB == id {
-			return m, nil
+			return m
 		}
 	}
 
-	return nil, errors.New("Not found")
+	return nil
 }
 
 // GetMovieByIMDB ...
@@ -102,13 +111,22 @@ func GetShowByTMDB(id int) (*Show, error) {
 	l.mu.Shows.Lock()
 	defer l.mu.Shows.Unlock()
 
+	if s := findShowByTMDB(id); s != nil {
+		return s, nil
+	}
+
+	return nil, errors.New("Not found")
+}
+
+// findShowByTMDB expects l.mu.Shows to be held
+func findShowByTMDB(id int) *Show {
 	for _, s := range l.Shows {
 		if s != nil && s.UIDs.TMDB == id {
-			return s, nil
+			return s
 		}
 	}
 
-	return nil, errors.New("Not found")
+	return nil
 }
 
 // GetShowByIMDB ...
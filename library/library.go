@@ -34,6 +34,8 @@ const (
 
 	resolveExpiration     = 7 * 24 * time.Hour
 	resolveFileExpiration = 60 * 24 * time.Hour
+
+	traktFullSyncInterval = 24 * time.Hour
 )
 
 const (
@@ -115,6 +117,9 @@ var (
 
 	initialized = false
 
+	// traktFullSynced is a time of the last sync of all Trakt categories
+	traktFullSynced time.Time
+
 	resolveRegexp = regexp.MustCompile(`^plugin://plugin.video.elementum.*?(\d+)(\W|$)`)
 )
 
@@ -1038,8 +1043,17 @@ func URLQuery(route string, query ...string) string {
 // Trakt syncs
 //
 
-// RefreshTrakt starts a trakt sync
+// RefreshTrakt starts a trakt sync, only categories changed on Trakt are synced
 func RefreshTrakt() error {
+	return refreshTrakt(false)
+}
+
+// RefreshTraktFull starts a trakt sync of all categories
+func RefreshTraktFull() error {
+	return refreshTrakt(true)
+}
+
+func refreshTrakt(full bool) error {
 	if config.Get().TraktToken == "" {
 		return nil
 	}
@@ -1062,50 +1076,135 @@ func RefreshTrakt() error {
 		return err
 	}
 
-	log.Debugf("TraktSync: Watched")
-	if changes, err := SyncTraktWatched(); err != nil {
-		log.Debugf("TraktSync: Got error from SyncTraktWatched: %#v", err)
-		// return err
-	} else if changes {
-		Refresh()
-		xbmc.Refresh()
+	// Watched state is kept in memory, so first sync after start should be full,
+	// and full sync is repeated periodically to catch removals from history
+	if traktFullSynced.IsZero() || time.Since(traktFullSynced) > traktFullSyncInterval {
+		full = true
+	}
+
+	activities, err := trakt.GetLastActivities()
+	if err != nil {
+		log.Warningf("TraktSync: Could not get last activities, syncing everything: %s", err)
+		activities = nil
+		full = true
+	}
+
+	// changed checks whether categories should be synced, synced stores their timestamps
+	changed := func(categories ...string) bool {
+		return full || activities.Changed(categories...)
+	}
+	synced := func(categories ...string) {
+		if activities != nil {
+			activities.MarkSynced(categories...)
+		}
+	}
+
+	if changed(trakt.ActivityMoviesWatched, trakt.ActivityEpisodesWatched) {
+		incremental := false
+		if !full {
+			log.Debugf("TraktSync: Watched history")
+			if changes, ok := SyncTraktHistory(activities); ok {
+				incremental = true
+				synced(trakt.ActivityMoviesWatched, trakt.ActivityEpisodesWatched)
+				if changes {
+					Refresh()
+					xbmc.Refresh()
+				}
+			}
+		}
+
+		if !incremental {
+			log.Debugf("TraktSync: Watched")
+			if changes, err := SyncTraktWatched(); err != nil {
+				log.Debugf("TraktSync: Got error from SyncTraktWatched: %#v", err)
+				// return err
+			} else {
+				synced(trakt.ActivityMoviesWatched, trakt.ActivityEpisodesWatched)
+				if changes {
+					Refresh()
+					xbmc.Refresh()
+				}
+			}
+		}
+	} else if config.Get().TraktSyncWatched {
+		log.Debugf("TraktSync: Watched is not changed, syncing back only")
+		syncTraktWatchedBack()
 	}
+
+	if config.Get().TraktSyncPlayback && changed(trakt.ActivityMoviesPaused, trakt.ActivityEpisodesPaused) {
+		log.Debugf("TraktSync: Playback progress")
+		if changes, err := SyncTraktPlayback(); err != nil {
+			log.Debugf("TraktSync: Got error from SyncTraktPlayback: %#v", err)
+		} else {
+			synced(trakt.ActivityMoviesPaused, trakt.ActivityEpisodesPaused)
+			if changes {
+				xbmc.Refresh()
+			}
+		}
+	}
+
 	if config.Get().TraktSyncWatchlist {
-		log.Debugf("TraktSync: Movies Watchlist")
-		if err := SyncMoviesList("watchlist", true); err != nil {
-			log.Debugf("TraktSync: Got error from SyncMoviesList: %#v", err)
-			// return err
+		if changed(trakt.ActivityMoviesWatchlist) {
+			log.Debugf("TraktSync: Movies Watchlist")
+			if err := SyncMoviesList("watchlist", true); err != nil {
+				log.Debugf("TraktSync: Got error from SyncMoviesList: %#v", err)
+				// return err
+			} else {
+				synced(trakt.ActivityMoviesWatchlist)
+			}
 		}
-		log.Debugf("TraktSync: Shows Watchlist")
-		if err := SyncShowsList("watchlist", true); err != nil {
-			log.Debugf("TraktSync: Got error from SyncShowsList: %#v", err)
-			// return err
+		if changed(trakt.ActivityShowsWatchlist) {
+			log.Debugf("TraktSync: Shows Watchlist")
+			if err := SyncShowsList("watchlist", true); err != nil {
+				log.Debugf("TraktSync: Got error from SyncShowsList: %#v", err)
+				// return err
+			} else {
+				synced(trakt.ActivityShowsWatchlist)
+			}
 		}
 	}
 	if config.Get().TraktSyncCollections {
-		log.Debugf("TraktSync: Movies Collections")
-		if err := SyncMoviesList("collection", true); err != nil {
-			log.Debugf("TraktSync: Got error from SyncMoviesList: %#v", err)
-			// return err
+		if changed(trakt.ActivityMoviesCollection) {
+			log.Debugf("TraktSync: Movies Collections")
+			if err := SyncMoviesList("collection", true); err != nil {
+				log.Debugf("TraktSync: Got error from SyncMoviesList: %#v", err)
+				// return err
+			} else {
+				synced(trakt.ActivityMoviesCollection)
+			}
 		}
-		log.Debugf("TraktSync: Shows Collections")
-		if err := SyncShowsList("collection", true); err != nil {
-			log.Debugf("TraktSync: Got error from SyncShowsList: %#v", err)
-			// return err
+		if changed(trakt.ActivityEpisodesCollection) {
+			log.Debugf("TraktSync: Shows Collections")
+			if err := SyncShowsList("collection", true); err != nil {
+				log.Debugf("TraktSync: Got error from SyncShowsList: %#v", err)
+				// return err
+			} else {
+				synced(trakt.ActivityEpisodesCollection)
+			}
 		}
 	}
 
-	if config.Get().TraktSyncUserlists {
+	if config.Get().TraktSyncUserlists && changed(trakt.ActivityLists) {
 		log.Debugf("TraktSync: Userlists")
+		failed := false
 		lists := trakt.Userlists()
 		for _, list := range lists {
 			if err := SyncMoviesList(strconv.Itoa(list.IDs.Trakt), true); err != nil {
+				failed = true
 				continue
 			}
 			if err := SyncShowsList(strconv.Itoa(list.IDs.Trakt), true); err != nil {
+				failed = true
 				continue
 			}
 		}
+		if !failed {
+			synced(trakt.ActivityLists)
+		}
+	}
+
+	if full {
+		traktFullSynced = time.Now()
 	}
 
 	log.Debugf("TraktSync: Finished")
@@ -1157,7 +1256,6 @@ func SyncTraktWatched() (haveChanges bool, err error) {
 	l.mu.Trakt.Lock()
 
 	l.WatchedTrakt = map[uint64]bool{}
-	watchedMovies := map[int]bool{}
 	for _, m := range movies {
 		l.WatchedTrakt[xxhash.Sum64String(fmt.Sprintf("%d_%d_%d", MovieType, TraktScraper, m.Movie.IDs.Trakt))] = true
 		l.WatchedTrakt[xxhash.Sum64String(fmt.Sprintf("%d_%d_%d", MovieType, TMDBScraper, m.Movie.IDs.TMDB))] = true
@@ -1174,8 +1272,6 @@ func SyncTraktWatched() (haveChanges bool, err error) {
 		if r == nil {
 			continue
 		} else if r != nil {
-			watchedMovies[r.UIDs.TMDB] = true
-
 			if r.UIDs.Playcount == 0 {
 				haveChanges = true
 				xbmc.SetMovieWatchedWithDate(r.UIDs.Kodi, 1, int(r.Resume.Position), int(r.Resume.Total), m.LastWatchedAt)
@@ -1219,7 +1315,6 @@ func SyncTraktWatched() (haveChanges bool, err error) {
 
 	l.mu.Trakt.Lock()
 
-	watchedShows := map[int]bool{}
 	for _, s := range shows {
 		tmdbShow := tmdb.GetShowByID(strconv.Itoa(s.Show.IDs.TMDB), config.Get().Language)
 		completedSeasons := 0
@@ -1258,7 +1353,6 @@ func SyncTraktWatched() (haveChanges bool, err error) {
 			continue
 		} else if r != nil {
 			if s.Watched {
-				watchedShows[r.UIDs.Kodi] = true
 				xbmc.SetShowWatchedWithDate(r.UIDs.Kodi, 1, s.LastWatchedAt)
 			}
 
@@ -1266,8 +1360,6 @@ func SyncTraktWatched() (haveChanges bool, err error) {
 				for _, episode := range season.Episodes {
 					e := r.GetEpisode(season.Number, episode.Number)
 					if e != nil {
-						watchedShows[e.UIDs.Kodi] = true
-
 						if e.UIDs.Playcount == 0 {
 							haveChanges = true
 							xbmc.SetEpisodeWatchedWithDate(e.UIDs.Kodi, 1, int(e.Resume.Position), int(e.Resume.Total), episode.LastWatchedAt)
@@ -1279,6 +1371,13 @@ func SyncTraktWatched() (haveChanges bool, err error) {
 	}
 	l.mu.Trakt.Unlock()
 
+	syncTraktWatchedBack()
+
+	return
+}
+
+// syncTraktWatchedBack marks on Trakt items, watched in Kodi library and not watched on Trakt
+func syncTraktWatchedBack() {
 	if !config.Get().TraktSyncWatchedBack {
 		return
 	}
@@ -1289,13 +1388,14 @@ func SyncTraktWatched() (haveChanges bool, err error) {
 	syncMovies := []*trakt.WatchedItem{}
 	syncShows := []*trakt.WatchedItem{}
 
+	l.mu.Trakt.Lock()
 	l.mu.Movies.Lock()
 	for _, m := range l.Movies {
 		if m.UIDs.TMDB == 0 {
 			continue
 		}
 		cacheKey := fmt.Sprintf("Synced_%d_%d", MovieType, m.UIDs.TMDB)
-		if _, ok := watchedMovies[m.UIDs.TMDB]; ok || m.UIDs.Playcount == 0 || database.GetCache().Has(database.CommonBucket, cacheKey) {
+		if isWatchedTrakt(fmt.Sprintf("%d_%d_%d", MovieType, TMDBScraper, m.UIDs.TMDB)) || (m.UIDs.IMDB != "" && isWatchedTrakt(fmt.Sprintf("%d_%d_%s", MovieType, IMDBScraper, m.UIDs.IMDB))) || m.UIDs.Playcount == 0 || database.GetCache().Has(database.CommonBucket, cacheKey) {
 			continue
 		}
 		database.GetCache().Set(database.CommonBucket, cacheKey, "1")
@@ -1313,12 +1413,12 @@ func SyncTraktWatched() (haveChanges bool, err error) {
 		if s.UIDs.TMDB == 0 {
 			continue
 		}
-		if _, ok := watchedShows[s.UIDs.Kodi]; ok {
+		if isWatchedTrakt(fmt.Sprintf("%d_%d_%d", ShowType, TMDBScraper, s.UIDs.TMDB)) {
 			continue
 		}
 
 		for _, e := range s.Episodes {
-			if _, ok := watchedShows[e.UIDs.Kodi]; ok || e.UIDs.Playcount == 0 {
+			if isWatchedTrakt(fmt.Sprintf("%d_%d_%d_%d_%d", EpisodeType, TMDBScraper, s.UIDs.TMDB, e.Season, e.Episode)) || e.UIDs.Playcount == 0 {
 				continue
 			}
 
@@ -1338,6 +1438,7 @@ func SyncTraktWatched() (haveChanges bool, err error) {
 		}
 	}
 	l.mu.Shows.Unlock()
+	l.mu.Trakt.Unlock()
 
 	if len(syncMovies) > 0 {
 		trakt.SetMultipleWatched(syncMovies)
@@ -1345,10 +1446,213 @@ func SyncTraktWatched() (haveChanges bool, err error) {
 	if len(syncShows) > 0 {
 		trakt.SetMultipleWatched(syncShows)
 	}
+}
+
+// isWatchedTrakt checks watched key, l.mu.Trakt should be locked
+func isWatchedTrakt(key string) bool {
+	return l.WatchedTrakt[xxhash.Sum64String(key)]
+}
+
+// SyncTraktHistory applies watched history, added on Trakt since the last sync, to the library.
+// History does not contain removals, so false is returned, if full sync is needed instead.
+func SyncTraktHistory(activities *trakt.UserActivities) (haveChanges bool, ok bool) {
+	if config.Get().TraktToken == "" || !config.Get().TraktSyncWatched {
+		return false, true
+	}
+
+	var movies, episodes []*trakt.HistoryItem
+	var err error
+	if activities.Changed(trakt.ActivityMoviesWatched) {
+		if movies, err = trakt.HistorySince("movies", trakt.SyncedActivity(trakt.ActivityMoviesWatched)); err != nil || len(movies) == 0 {
+			return false, false
+		}
+	}
+	if activities.Changed(trakt.ActivityEpisodesWatched) {
+		if episodes, err = trakt.HistorySince("episodes", trakt.SyncedActivity(trakt.ActivityEpisodesWatched)); err != nil || len(episodes) == 0 {
+			return false, false
+		}
+	}
+
+	started := time.Now()
+	TraktScanning = true
+	defer func() {
+		log.Debugf("Trakt sync history finished in %s, got %d movies and %d episodes", time.Since(started), len(movies), len(episodes))
+		TraktScanning = false
+		RefreshUIDs()
+	}()
+
+	l.mu.Trakt.Lock()
+	for _, h := range movies {
+		if h.Movie == nil || h.Movie.IDs == nil {
+			continue
+		}
+
+		ids := h.Movie.IDs
+		l.WatchedTrakt[xxhash.Sum64String(fmt.Sprintf("%d_%d_%d", MovieType, TraktScraper, ids.Trakt))] = true
+		l.WatchedTrakt[xxhash.Sum64String(fmt.Sprintf("%d_%d_%d", MovieType, TMDBScraper, ids.TMDB))] = true
+		l.WatchedTrakt[xxhash.Sum64String(fmt.Sprintf("%d_%d_%s", MovieType, IMDBScraper, ids.IMDB))] = true
+
+		var r *Movie
+		if ids.TMDB != 0 {
+			r, _ = GetMovieByTMDB(ids.TMDB)
+		}
+		if r == nil && ids.IMDB != "" {
+			r, _ = GetMovieByIMDB(ids.IMDB)
+		}
+
+		if r != nil && r.UIDs.Playcount == 0 {
+			haveChanges = true
+			xbmc.SetMovieWatchedWithDate(r.UIDs.Kodi, 1, int(r.Resume.Position), int(r.Resume.Total), h.WatchedAt)
+		}
+	}
+
+	// Seasons and shows are marked watched only by full sync, which checks all their episodes
+	for _, h := range episodes {
+		if h.Show == nil || h.Show.IDs == nil || h.Episode == nil {
+			continue
+		}
+
+		ids := h.Show.IDs
+		l.WatchedTrakt[xxhash.Sum64String(fmt.Sprintf("%d_%d_%d_%d_%d", EpisodeType, TMDBScraper, ids.TMDB, h.Episode.Season, h.Episode.Number))] = true
+		l.WatchedTrakt[xxhash.Sum64String(fmt.Sprintf("%d_%d_%d_%d_%d", EpisodeType, TraktScraper, ids.Trakt, h.Episode.Season, h.Episode.Number))] = true
+
+		var r *Show
+		if ids.TMDB != 0 {
+			r, _ = GetShowByTMDB(ids.TMDB)
+		}
+		if r == nil && ids.IMDB != "" {
+			r, _ = GetShowByIMDB(ids.IMDB)
+		}
+		if r == nil {
+			continue
+		}
+
+		if e := r.GetEpisode(h.Episode.Season, h.Episode.Number); e != nil && e.UIDs.Playcount == 0 {
+			haveChanges = true
+			xbmc.SetEpisodeWatchedWithDate(e.UIDs.Kodi, 1, int(e.Resume.Position), int(e.Resume.Total), h.WatchedAt)
+		}
+	}
+	l.mu.Trakt.Unlock()
+
+	syncTraktWatchedBack()
+
+	return haveChanges, true
+}
+
+// SyncTraktPlayback merges paused items from Trakt into Resume of library items,
+// Trakt position is used only if it is newer than the last playback in Kodi
+func SyncTraktPlayback() (haveChanges bool, err error) {
+	if config.Get().TraktToken == "" || !config.Get().TraktSyncPlayback {
+		return
+	}
+
+	items, err := trakt.PlaybackProgresses()
+	if err != nil {
+		return false, err
+	}
+
+	type progress struct {
+		movie    bool
+		kodiID   int
+		position float64
+		total    float64
+		pausedAt time.Time
+	}
+	updates := []progress{}
+
+	// Resume points are changed under the same locks, as refreshing of the library uses
+	l.mu.Movies.Lock()
+	l.mu.Shows.Lock()
+	for _, i := range items {
+		var kodiID int
+		var resume *Resume
+
+		if i.Movie != nil && i.Movie.IDs != nil {
+			r := findMovieByTMDB(i.Movie.IDs.TMDB)
+			if r == nil || r.UIDs.Playcount > 0 || r.Resume == nil {
+				continue
+			}
+			kodiID, resume = r.UIDs.Kodi, r.Resume
+		} else if i.Show != nil && i.Show.IDs != nil && i.Episode != nil {
+			r := findShowByTMDB(i.Show.IDs.TMDB)
+			if r == nil {
+				continue
+			}
+			e := r.GetEpisode(i.Episode.Season, i.Episode.Number)
+			if e == nil || e.UIDs.Playcount > 0 || e.Resume == nil {
+				continue
+			}
+			kodiID, resume = e.UIDs.Kodi, e.Resume
+		} else {
+			continue
+		}
+
+		if !i.PausedAt.After(resume.LastPlayed) {
+			continue
+		}
+
+		position, total := i.Position(resume.Total)
+		if total <= 0 || int(position) == int(resume.Position) {
+			continue
+		}
+
+		log.Debugf("Updating resume of %d from Trakt: %fs / %fs", kodiID, position, total)
+		resume.Position = position
+		resume.Total = total
+		resume.LastPlayed = i.PausedAt
+		haveChanges = true
+
+		updates = append(updates, progress{i.Movie != nil, kodiID, position, total, i.PausedAt})
+	}
+	l.mu.Shows.Unlock()
+	l.mu.Movies.Unlock()
+
+	for _, u := range updates {
+		if u.movie {
+			xbmc.SetMovieProgressWithDate(u.kodiID, int(u.position), int(u.total), u.pausedAt)
+		} else {
+			xbmc.SetEpisodeProgressWithDate(u.kodiID, int(u.position), int(u.total), u.pausedAt)
+		}
+	}
 
 	return
 }
 
+// GetTraktMovieResume returns Resume of the movie, paused on Trakt, for items not in the library
+func GetTraktMovieResume(tmdbID int) *Resume {
+	if config.Get().TraktToken == "" || !config.Get().TraktSyncPlayback {
+		return nil
+	}
+
+	return playbackResume(trakt.FindPlaybackMovie(tmdbID))
+}
+
+// GetTraktEpisodeResume returns Resume of the episode, paused on Trakt, for items not in the library
+func GetTraktEpisodeResume(showID, season, episode int) *Resume {
+	if config.Get().TraktToken == "" || !config.Get().TraktSyncPlayback {
+		return nil
+	}
+
+	return playbackResume(trakt.FindPlaybackEpisode(showID, season, episode))
+}
+
+func playbackResume(p *trakt.PlaybackProgress) *Resume {
+	if p == nil {
+		return nil
+	}
+
+	position, total := p.Position(0)
+	if total <= 0 {
+		return nil
+	}
+
+	return &Resume{
+		Position:   position,
+		Total:      total,
+		LastPlayed: p.PausedAt,
+	}
+}
+
 //
 // Movie internals
 //
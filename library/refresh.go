@@ -183,6 +183,7 @@ func RefreshMovies() error {
 		if m.Resume != nil {
 			l.Movies[m.ID].Resume.Position = m.Resume.Position
 			l.Movies[m.ID].Resume.Total = m.Resume.Total
+			l.Movies[m.ID].Resume.LastPlayed = parseLastPlayed(m.LastPlayed)
 		}
 	}
 
@@ -426,6 +427,7 @@ func RefreshEpisodes() error {
 		if e.Resume != nil {
 			l.Shows[e.TVShowID].Episodes[e.ID].Resume.Position = e.Resume.Position
 			l.Shows[e.TVShowID].Episodes[e.ID].Resume.Total = e.Resume.Total
+			l.Shows[e.TVShowID].Episodes[e.ID].Resume.LastPlayed = parseLastPlayed(e.LastPlayed)
 		}
 	}
 
@@ -929,3 +931,16 @@ func searchStrm(dir string) []string {
 
 	return ret
 }
+
+// parseLastPlayed parses Kodi's lastplayed value, which is stored in local time
+func parseLastPlayed(value string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+
+	t, err := time.ParseInLocation("2006-01-02 15:04:05", value, time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
@@ -2,6 +2,7 @@ package library
 
 import (
 	"sync"
+	"time"
 
 	"github.com/elgatito/elementum/xbmc"
 )
@@ -86,8 +87,9 @@ type Episode struct {
 
 // Resume shows watched progress information
 type Resume struct {
-	Position float64 `json:"position"`
-	Total    float64 `json:"total"`
+	Position   float64   `json:"position"`
+	Total      float64   `json:"total"`
+	LastPlayed time.Time `json:"last_played"`
 }
 
 // DBItem ...
@@ -19,10 +19,14 @@ import (
 
 	"github.com/elgatito/elementum/api"
 	"github.com/elgatito/elementum/bittorrent"
+	"github.com/elgatito/elementum/blocklist"
 	"github.com/elgatito/elementum/config"
 	"github.com/elgatito/elementum/database"
+	"github.com/elgatito/elementum/feeds"
 	"github.com/elgatito/elementum/library"
 	"github.com/elgatito/elementum/lockfile"
+	"github.com/elgatito/elementum/monitor"
+	"github.com/elgatito/elementum/prefetch"
 	"github.com/elgatito/elementum/trakt"
 	"github.com/elgatito/elementum/util"
 	"github.com/elgatito/elementum/xbmc"
@@ -155,6 +159,7 @@ func main() {
 	http.Handle("/debug/bundle", bittorrent.DebugBundle(btService))
 
 	http.Handle("/files/", bittorrent.ServeTorrent(btService, config.Get().DownloadPath))
+	http.Handle("/hls/", bittorrent.ServeHLS(btService))
 	http.Handle("/reload", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		btService.Reconfigure()
 	}))
@@ -190,7 +195,12 @@ func main() {
 	}()
 
 	go library.Init()
+	go feeds.Init(btService)
+	go monitor.Init(btService)
+	go prefetch.Init(btService)
+	go blocklist.Init(btService)
 	go trakt.TokenRefreshHandler()
+	go trakt.OutboxHandler()
 	go db.MaintenanceRefreshHandler()
 	go cacheDb.MaintenanceRefreshHandler()
 
@@ -0,0 +1,321 @@
+package monitor
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/op/go-logging"
+
+	"github.com/elgatito/elementum/bittorrent"
+	"github.com/elgatito/elementum/config"
+	"github.com/elgatito/elementum/database"
+	"github.com/elgatito/elementum/library"
+	"github.com/elgatito/elementum/providers"
+	estorage "github.com/elgatito/elementum/storage"
+	"github.com/elgatito/elementum/tmdb"
+	"github.com/elgatito/elementum/trakt"
+)
+
+const (
+	episodeType      = "episode"
+	defaultFrequency = 6
+	calendarDays     = 14
+)
+
+var log = logging.MustGetLogger("monitor")
+
+// Status describes monitored shows and results of the last check
+type Status struct {
+	Enabled   bool              `json:"enabled"`
+	Running   bool              `json:"running"`
+	LastCheck time.Time         `json:"last_check"`
+	Added     int               `json:"added"`
+	Errors    map[string]string `json:"errors"`
+	Shows     []*ShowStatus     `json:"shows"`
+}
+
+// ShowStatus is a monitored show with episodes, that were grabbed for it
+type ShowStatus struct {
+	ShowID  int                            `json:"show_id"`
+	Name    string                         `json:"name"`
+	Since   time.Time                      `json:"since"`
+	History []*database.MonitorHistoryItem `json:"history"`
+}
+
+// Monitor searches and queues aired episodes of monitored library shows
+type Monitor struct {
+	s  *bittorrent.BTService
+	mu sync.Mutex
+
+	status Status
+}
+
+type episodeKey struct {
+	season  int
+	episode int
+}
+
+var monitor *Monitor
+
+// Init starts episodes monitor, shows are checked with configured frequency
+func Init(s *bittorrent.BTService) {
+	monitor = &Monitor{
+		s: s,
+	}
+
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if s.ShuttingDown {
+			return
+		}
+
+		conf := config.Get()
+		if !conf.MonitorEnabled {
+			continue
+		}
+
+		frequency := conf.MonitorFrequency
+		if frequency <= 0 {
+			frequency = defaultFrequency
+		}
+
+		monitor.mu.Lock()
+		lastCheck := monitor.status.LastCheck
+		monitor.mu.Unlock()
+		if time.Since(lastCheck) < time.Duration(frequency)*time.Hour {
+			continue
+		}
+
+		if _, err := Check(); err != nil {
+			log.Warningf("Episodes monitor check failed: %s", err)
+		}
+	}
+}
+
+// Add enables monitoring of new episodes for library show
+func Add(showID int) error {
+	if !library.IsAddedToLibrary(strconv.Itoa(showID), library.ShowType) {
+		return errors.New("Show is not added to the library")
+	}
+
+	return database.Get().SetShowMonitored(showID, true)
+}
+
+// Remove disables monitoring of new episodes for library show
+func Remove(showID int) error {
+	return database.Get().SetShowMonitored(showID, false)
+}
+
+// GetStatus returns monitored shows and results of the last check
+func GetStatus() *Status {
+	ret := &Status{}
+	if monitor != nil {
+		monitor.mu.Lock()
+		*ret = monitor.status
+		monitor.mu.Unlock()
+	}
+	ret.Enabled = config.Get().MonitorEnabled
+
+	ret.Shows = []*ShowStatus{}
+	for _, ms := range database.Get().GetMonitoredShows() {
+		show := &ShowStatus{
+			ShowID:  ms.ShowID,
+			Since:   ms.Since,
+			History: database.Get().GetMonitorHistory(ms.ShowID),
+		}
+		if s := tmdb.GetShow(ms.ShowID, config.Get().Language); s != nil {
+			show.Name = s.Name
+		}
+		ret.Shows = append(ret.Shows, show)
+	}
+
+	return ret
+}
+
+// Check searches aired episodes of monitored shows, returns number of queued torrents
+func Check() (int, error) {
+	if monitor == nil {
+		return 0, errors.New("Episodes monitor is not running")
+	}
+
+	return monitor.check()
+}
+
+func (m *Monitor) check() (int, error) {
+	m.mu.Lock()
+	if m.status.Running {
+		m.mu.Unlock()
+		return 0, errors.New("Episodes monitor check is already running")
+	}
+	m.status.Running = true
+	m.status.LastCheck = time.Now()
+	m.mu.Unlock()
+
+	added := 0
+	errs := map[string]string{}
+	defer func() {
+		m.mu.Lock()
+		m.status.Running = false
+		m.status.Added = added
+		m.status.Errors = errs
+		m.mu.Unlock()
+	}()
+
+	shows := database.Get().GetMonitoredShows()
+	if len(shows) == 0 {
+		return 0, nil
+	}
+
+	calendar := traktAired()
+	for _, ms := range shows {
+		if m.s.ShuttingDown {
+			return added, nil
+		}
+		if !library.IsAddedToLibrary(strconv.Itoa(ms.ShowID), library.ShowType) {
+			log.Debugf("Show %d is not in the library, skipping", ms.ShowID)
+			continue
+		}
+
+		count, err := m.checkShow(ms, calendar[ms.ShowID])
+		added += count
+		if err != nil {
+			log.Warningf("Could not check show %d: %s", ms.ShowID, err)
+			errs[strconv.Itoa(ms.ShowID)] = err.Error()
+		}
+	}
+
+	if added > 0 {
+		log.Noticef("Queued %d new episodes", added)
+	}
+
+	return added, nil
+}
+
+// checkShow grabs episodes of the show, aired after monitoring was enabled
+func (m *Monitor) checkShow(ms *database.MonitoredShow, calendar map[episodeKey]bool) (int, error) {
+	language := config.Get().Language
+	show := tmdb.GetShow(ms.ShowID, language)
+	if show == nil {
+		return 0, errors.New("Unable to find show")
+	}
+
+	since := ms.Since.AddDate(0, 0, -config.Get().MonitorBacklog)
+	now := time.Now()
+
+	added := 0
+	for i := len(show.Seasons) - 1; i >= 0; i-- {
+		if show.Seasons[i] == nil || show.Seasons[i].Season == 0 {
+			continue
+		}
+
+		season := tmdb.GetSeason(ms.ShowID, show.Seasons[i].Season, language)
+		if season == nil {
+			continue
+		}
+
+		for _, episode := range season.Episodes {
+			key := episodeKey{episode.SeasonNumber, episode.EpisodeNumber}
+			aired, err := time.Parse("2006-01-02", episode.AirDate)
+			if err != nil && !calendar[key] {
+				continue
+			} else if err == nil && (aired.Before(since.Truncate(24*time.Hour)) || (aired.After(now) && !calendar[key])) {
+				continue
+			}
+
+			if database.Get().HasMonitorHistory(ms.ShowID, key.season, key.episode) {
+				continue
+			}
+			if m.s.HasTorrentByEpisode(ms.ShowID, key.season, key.episode) != "" {
+				continue
+			}
+
+			if err := m.grab(show, episode); err != nil {
+				log.Warningf("Could not grab %s S%02dE%02d: %s", show.Name, key.season, key.episode, err)
+				continue
+			}
+			added++
+		}
+
+		// Older seasons are not checked, if they started before monitoring
+		if airDate, err := time.Parse("2006-01-02", show.Seasons[i].AirDate); err == nil && airDate.Before(since) {
+			break
+		}
+	}
+
+	return added, nil
+}
+
+// grab searches episode with providers and queues the best result
+func (m *Monitor) grab(show *tmdb.Show, episode *tmdb.Episode) error {
+	searchers := providers.GetEpisodeSearchers()
+	if len(searchers) == 0 {
+		return errors.New("No providers enabled")
+	}
+
+	label := fmt.Sprintf("%s S%02dE%02d", show.Name, episode.SeasonNumber, episode.EpisodeNumber)
+	log.Infof("Searching aired episode %s", label)
+
+	torrents := providers.SearchEpisode(searchers, show, episode)
+	if len(torrents) == 0 {
+		return errors.New("Nothing found")
+	}
+
+	// Results are already ordered by sorting preferences or scoring profile
+	best := torrents[0]
+	t, err := m.s.QueueTorrent(best.URI, 0, bittorrent.AutoStorage(episodeType))
+	if err != nil {
+		return err
+	}
+
+	// Storage is known after the torrent is added, it can be kept from previous runs
+	if !estorage.KeepsFiles(t.StorageType()) {
+		m.s.RemoveTorrent(t, true)
+		return errors.New("Episodes monitor is not available for memory storage")
+	}
+
+	infoHash := t.InfoHash()
+	database.Get().UpdateBTItem(infoHash, episode.ID, episodeType, t.Torrent.Files(), label, show.ID, episode.SeasonNumber, episode.EpisodeNumber)
+	t.DBItem = database.Get().GetBTItem(infoHash)
+
+	database.Get().AddMonitorHistory(show.ID, episode.SeasonNumber, episode.EpisodeNumber, infoHash, best.Name)
+
+	log.Noticef("Queued %s for %s", best.Name, label)
+	return nil
+}
+
+// traktAired returns episodes of user's shows, aired recently according to Trakt calendar
+func traktAired() map[int]map[episodeKey]bool {
+	ret := map[int]map[episodeKey]bool{}
+	if config.Get().TraktToken == "" {
+		return ret
+	}
+
+	start := time.Now().AddDate(0, 0, -calendarDays).Format("2006-01-02")
+	shows, _, err := trakt.CalendarShows(fmt.Sprintf("my/shows/%s/%d", start, calendarDays+1), "1")
+	if err != nil {
+		log.Warningf("Could not get Trakt calendar: %s", err)
+		return ret
+	}
+
+	now := time.Now()
+	for _, s := range shows {
+		if s.Show == nil || s.Show.IDs == nil || s.Episode == nil {
+			continue
+		}
+		if aired, err := time.Parse(time.RFC3339, s.FirstAired); err != nil || aired.After(now) {
+			continue
+		}
+
+		if _, ok := ret[s.Show.IDs.TMDB]; !ok {
+			ret[s.Show.IDs.TMDB] = map[episodeKey]bool{}
+		}
+		ret[s.Show.IDs.TMDB][episodeKey{s.Episode.Season, s.Episode.Number}] = true
+	}
+
+	return ret
+}
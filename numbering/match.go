@@ -0,0 +1,127 @@
+package numbering
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/elgatito/elementum/database"
+)
+
+const maxEpisodeRange = 50
+
+var (
+	// S01E01, S01.E01, S01E01E02, S01E01-E02, S01E01-02, S01E0102
+	seasonEpisodeRegex = regexp.MustCompile(`(?i)(?:^|\W|_)S(\d{1,3})\W?E(\d{1,4})((?:-?E\d{1,4}|-\d{1,4})*)(?:\W|_|$)`)
+	// 1x01, 1x01-02, 1x01x02
+	crossEpisodeRegex = regexp.MustCompile(`(?i)(?:^|\W|_)(\d{1,2})x(\d{1,3})((?:-\d{1,3}|x\d{1,3})*)(?:\W|_|$)`)
+	// Show - 123, Show E123, Show Ep.123, [Group] Show - 123v2 [720p]
+	absoluteRegex = regexp.MustCompile(`(?i)(?:^|[\s_.\-\[(#])(?:EP?\.?|Episode\s?)?(\d{1,4})(?:v\d)?(?:$|[\s_.\-\])])`)
+	digitsRegex   = regexp.MustCompile(`\d+`)
+	// Codec and audio tags, followed by numbers, like "AAC 2.0", "DTS-HD 7.1", "H.264"
+	codecTagRegex = regexp.MustCompile(`(?i)(?:(?:^|[\W_])(?:AAC|E?AC3|DDP?|DD\+|DTS(?:-HD)?|TrueHD|Atmos|FLAC|MP3|Opus|PCM|HEVC|AVC|Hi10P?|\d+bit)\d?[\s_.\-]*|(?:^|[\W_])[HX]\.)$`)
+	// Parts of decimal numbers, like "5.1" or "2.0"
+	decimalAfterRegex  = regexp.MustCompile(`^\.\d(?:[^\pL\d]|$)`)
+	decimalBeforeRegex = regexp.MustCompile(`(?:^|[^\pL\d])\d\.$`)
+	// Release group and tags in brackets before the title
+	leadingTagsRegex = regexp.MustCompile(`^(?:\s*(?:\[[^\]]*\]|\([^)]*\)))*`)
+	letterRegex      = regexp.MustCompile(`\pL`)
+)
+
+// seasonEpisodes is a season with episodes, found in the file name
+type seasonEpisodes struct {
+	season   int
+	episodes []int
+}
+
+// Match checks whether file name contains the episode, including multi-episode files.
+// Absolute number is only checked for file names without season and episode numbers
+func (n *Number) Match(fileName string) bool {
+	found := parseEpisodes(fileName)
+	for _, se := range found {
+		if se.season != n.Season {
+			continue
+		}
+		for _, e := range se.episodes {
+			if e == n.Episode {
+				return true
+			}
+		}
+	}
+
+	if len(found) > 0 || n.Absolute <= 0 || n.Mode == database.NumberingDVD {
+		return false
+	}
+
+	for _, a := range absoluteNumbers(fileName) {
+		if a == n.Absolute {
+			return true
+		}
+	}
+
+	return false
+}
+
+// absoluteNumbers returns numbers, which follow the title or a dash, skipping numbers
+// of codec and audio tags, and parts of decimal numbers, like "5.1"
+func absoluteNumbers(fileName string) (ret []int) {
+	for _, m := range absoluteRegex.FindAllStringSubmatchIndex(fileName, -1) {
+		start, end := m[2], m[3]
+		prefix := fileName[:start]
+		if decimalAfterRegex.MatchString(fileName[end:]) || decimalBeforeRegex.MatchString(prefix) || codecTagRegex.MatchString(prefix) {
+			continue
+		}
+		if !letterRegex.MatchString(leadingTagsRegex.ReplaceAllString(prefix, "")) && !strings.HasSuffix(strings.TrimSpace(prefix), "-") {
+			continue
+		}
+
+		a, _ := strconv.Atoi(fileName[start:end])
+		ret = append(ret, a)
+	}
+
+	return
+}
+
+// parseEpisodes returns seasons and episodes, found in the file name,
+// multi-episode files return all episodes of the range
+func parseEpisodes(fileName string) (ret []*seasonEpisodes) {
+	for _, re := range []*regexp.Regexp{seasonEpisodeRegex, crossEpisodeRegex} {
+		for _, m := range re.FindAllStringSubmatch(fileName, -1) {
+			season, _ := strconv.Atoi(m[1])
+			episodes := []int{}
+
+			// S01E0102 is a double episode, unless it is a long show with 4-digit numbers
+			if len(m[2]) == 4 && m[3] == "" {
+				first, _ := strconv.Atoi(m[2][:2])
+				second, _ := strconv.Atoi(m[2][2:])
+				if first > 0 && second == first+1 {
+					ret = append(ret, &seasonEpisodes{season: season, episodes: []int{first, second}})
+					continue
+				}
+			}
+
+			episode, _ := strconv.Atoi(m[2])
+			episodes = append(episodes, episode)
+			last := episode
+			for _, part := range digitsRegex.FindAllString(m[3], -1) {
+				e, _ := strconv.Atoi(part)
+				episodes = append(episodes, e)
+				if e > last {
+					last = e
+				}
+			}
+
+			// Ranges like S01E01-E04 include episodes in between
+			if last > episode && last-episode <= maxEpisodeRange {
+				episodes = episodes[:0]
+				for e := episode; e <= last; e++ {
+					episodes = append(episodes, e)
+				}
+			}
+
+			ret = append(ret, &seasonEpisodes{season: season, episodes: episodes})
+		}
+	}
+
+	return
+}
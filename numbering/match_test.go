@@ -0,0 +1,105 @@
+package numbering
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/elgatito/elementum/database"
+)
+
+func TestParseEpisodes(t *testing.T) {
+	tests := []struct {
+		name string
+		want map[int][]int
+	}{
+		{"Show.Name.S01E02.1080p.mkv", map[int][]int{1: {2}}},
+		{"Show Name - S01.E02 - Title.mkv", map[int][]int{1: {2}}},
+		{"show_s2e10_720p.mkv", map[int][]int{2: {10}}},
+		{"Show.S01E01E02.mkv", map[int][]int{1: {1, 2}}},
+		{"Show.S01E01-E04.mkv", map[int][]int{1: {1, 2, 3, 4}}},
+		{"Show.S01E01-02.mkv", map[int][]int{1: {1, 2}}},
+		{"Show.S01E0102.mkv", map[int][]int{1: {1, 2}}},
+		{"Show.S20E1024.mkv", map[int][]int{20: {1024}}},
+		{"Show.S01E01-E99.mkv", map[int][]int{1: {1, 99}}},
+		{"Show 1x01.mkv", map[int][]int{1: {1}}},
+		{"Show 1x01-03.mkv", map[int][]int{1: {1, 2, 3}}},
+		{"Show 1x01x02.mkv", map[int][]int{1: {1, 2}}},
+		{"Show - 123 [1080p].mkv", map[int][]int{}},
+		{"Show.1920x1080.mkv", map[int][]int{}},
+		{"Show.SE01.mkv", map[int][]int{}},
+		{"S01E", map[int][]int{}},
+		{"", map[int][]int{}},
+	}
+
+	for _, test := range tests {
+		got := map[int][]int{}
+		for _, se := range parseEpisodes(test.name) {
+			got[se.season] = append(got[se.season], se.episodes...)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: parsed %v, expected %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestAbsoluteNumbers(t *testing.T) {
+	tests := []struct {
+		name string
+		want []int
+	}{
+		{"[SubsPlease] One Piece - 1071 (1080p) [ABCD].mkv", []int{1071}},
+		{"[Group] Show - 12v2 [720p].mkv", []int{12}},
+		{"Show E123 AAC 2.0.mkv", []int{123}},
+		{"Show Ep.45.mkv", []int{45}},
+		{"Show.Name.123.1080p.WEB.H.264-GRP.mkv", []int{123}},
+		{"Show Name 45 DD5.1 x264.mkv", []int{45}},
+		{"Show.12.AAC2.0.mkv", []int{12}},
+		{"Show - 08 [AAC 5.1].mkv", []int{8}},
+		{"[Group] - 07.mkv", []int{7}},
+		{"05 - Title.mkv", nil},
+		{"[Group] 07.mkv", nil},
+		{"Show DTS-HD 7.1.mkv", nil},
+		{"Show 10bit.mkv", nil},
+		{"Show - 12345.mkv", nil},
+		{"", nil},
+	}
+
+	for _, test := range tests {
+		if got := absoluteNumbers(test.name); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: found %v, expected %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestNumberMatch(t *testing.T) {
+	aired := &Number{Mode: database.NumberingAired, Season: 1, Episode: 2, Absolute: 14}
+	dvd := &Number{Mode: database.NumberingDVD, Season: 1, Episode: 2, Absolute: 14}
+
+	tests := []struct {
+		number *Number
+		name   string
+		want   bool
+	}{
+		{aired, "Show.S01E02.mkv", true},
+		{aired, "Show.S01E01-E03.mkv", true},
+		{aired, "Show.S01E03.mkv", false},
+		{aired, "Show.S02E02.mkv", false},
+		{aired, "Show 1x02.mkv", true},
+		{aired, "[Group] Show - 14 [1080p].mkv", true},
+		{aired, "[Group] Show - 15 [1080p].mkv", false},
+		// Absolute numbers are not checked, when season and episode are present
+		{aired, "Show.S02E01 - 14.mkv", false},
+		{aired, "Show 14 AAC 2.0.mkv", true},
+		{aired, "Show DTS 14.mkv", false},
+		{dvd, "Show.S01E02.mkv", true},
+		{dvd, "[Group] Show - 14 [1080p].mkv", false},
+		{&Number{Season: 1, Episode: 2}, "[Group] Show - 0 [1080p].mkv", false},
+		{aired, "", false},
+	}
+
+	for _, test := range tests {
+		if got := test.number.Match(test.name); got != test.want {
+			t.Errorf("%+v in %q: matches is %v, expected %v", *test.number, test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package numbering
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/op/go-logging"
+
+	"github.com/elgatito/elementum/config"
+	"github.com/elgatito/elementum/database"
+	"github.com/elgatito/elementum/tmdb"
+	"github.com/elgatito/elementum/tvdb"
+	"github.com/elgatito/elementum/util"
+)
+
+var log = logging.MustGetLogger("numbering")
+
+// Modes are names of numbering modes, used in API, in the order of mode values
+var Modes = []string{"aired", "absolute", "dvd", "scene"}
+
+// Scheme is a numbering of show episodes
+type Scheme struct {
+	ShowID int
+	Mode   int
+	Anime  bool
+	TVDB   *tvdb.Show
+
+	scene map[string]*database.SceneMapping
+}
+
+// Number is an episode number, as it is used by release groups
+type Number struct {
+	Mode     int
+	Season   int
+	Episode  int
+	Absolute int
+}
+
+// ModeName returns name of numbering mode
+func ModeName(mode int) string {
+	if mode < 0 || mode >= len(Modes) {
+		return Modes[database.NumberingAired]
+	}
+	return Modes[mode]
+}
+
+// ParseMode returns numbering mode by name
+func ParseMode(name string) (int, error) {
+	for mode, n := range Modes {
+		if strings.EqualFold(n, name) {
+			return mode, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown numbering mode: %s", name)
+}
+
+// Get returns numbering scheme, selected for the show.
+// Japanese animation, without selected mode, still gets absolute numbers for search
+func Get(show *tmdb.Show) *Scheme {
+	s := &Scheme{
+		ShowID: show.ID,
+		Mode:   database.Get().GetShowNumbering(show.ID),
+		Anime:  isAnime(show),
+	}
+
+	if s.Mode == database.NumberingScene {
+		s.scene = map[string]*database.SceneMapping{}
+		for _, m := range database.Get().GetSceneMapping(show.ID) {
+			s.scene[sceneKey(m.Season, m.Episode)] = m
+		}
+	}
+
+	if show.ExternalIDs != nil && (s.Mode == database.NumberingAbsolute || s.Mode == database.NumberingDVD || s.Anime) {
+		if tvdbID := util.StrInterfaceToInt(show.ExternalIDs.TVDBID); tvdbID > 0 {
+			tvdbShow, err := tvdb.GetShow(tvdbID, config.Get().Language)
+			if err != nil {
+				log.Warningf("Could not get TVDB show %d: %s", tvdbID, err)
+			} else {
+				s.TVDB = tvdbShow
+			}
+		}
+	}
+
+	return s
+}
+
+// Episode returns number of the episode, given by aired season and episode numbers
+func (s *Scheme) Episode(season, episode int) *Number {
+	n := &Number{
+		Mode:    s.Mode,
+		Season:  season,
+		Episode: episode,
+	}
+
+	tvdbEpisode := s.tvdbEpisode(season, episode)
+
+	switch s.Mode {
+	case database.NumberingAired:
+		if s.Anime && tvdbEpisode != nil {
+			n.Absolute = tvdbEpisode.AbsoluteNumber
+		}
+	case database.NumberingAbsolute:
+		if tvdbEpisode != nil {
+			n.Absolute = tvdbEpisode.AbsoluteNumber
+		}
+	case database.NumberingDVD:
+		if tvdbEpisode != nil {
+			if tvdbEpisode.DVDSeason > 0 && tvdbEpisode.DVDEpisode > 0 {
+				n.Season = tvdbEpisode.DVDSeason
+				n.Episode = tvdbEpisode.DVDEpisode
+			}
+			n.Absolute = tvdbEpisode.AbsoluteNumber
+		}
+	case database.NumberingScene:
+		if m, ok := s.scene[sceneKey(season, episode)]; ok {
+			if m.SceneSeason > 0 || m.SceneEpisode > 0 {
+				n.Season = m.SceneSeason
+				n.Episode = m.SceneEpisode
+			}
+			n.Absolute = m.SceneAbsolute
+		}
+	}
+
+	return n
+}
+
+func (s *Scheme) tvdbEpisode(season, episode int) *tvdb.Episode {
+	if s.TVDB == nil {
+		return nil
+	}
+
+	for _, se := range s.TVDB.Seasons {
+		if se.Season != season {
+			continue
+		}
+		for _, e := range se.Episodes {
+			if e.EpisodeNumber == episode {
+				return e
+			}
+		}
+	}
+
+	return nil
+}
+
+func isAnime(show *tmdb.Show) bool {
+	countryIsJP := false
+	for _, country := range show.OriginCountry {
+		if country == "JP" {
+			countryIsJP = true
+			break
+		}
+	}
+	genreIsAnim := false
+	for _, genre := range show.Genres {
+		if genre.Name == "Animation" {
+			genreIsAnim = true
+			break
+		}
+	}
+
+	return countryIsJP && genreIsAnim
+}
+
+func sceneKey(season, episode int) string {
+	return fmt.Sprintf("%d_%d", season, episode)
+}
@@ -0,0 +1,283 @@
+package prefetch
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	gotorrent "github.com/anacrolix/torrent"
+	"github.com/op/go-logging"
+
+	"github.com/elgatito/elementum/bittorrent"
+	"github.com/elgatito/elementum/config"
+	"github.com/elgatito/elementum/database"
+	"github.com/elgatito/elementum/providers"
+	"github.com/elgatito/elementum/tmdb"
+)
+
+const (
+	episodeType   = "episode"
+	checkInterval = 5 * time.Second
+	unusedTimeout = 30 * time.Minute
+	infoTimeout   = 2 * time.Minute
+	minFileSize   = 100 * 1024 * 1024
+)
+
+var log = logging.MustGetLogger("prefetch")
+
+// Item is a next episode, prefetched during playback
+type Item struct {
+	ShowID   int
+	Season   int
+	Episode  int
+	InfoHash string
+	// Added marks torrents, added only for prefetch, they are removed if the episode is not played
+	Added bool
+	Since time.Time
+}
+
+var (
+	mu    sync.Mutex
+	items = map[string]*Item{}
+	// checked keeps episodes, which playback already triggered prefetch, with the time of the check
+	checked = map[string]time.Time{}
+)
+
+// Init watches playing episodes, and prefetches next episodes, when playback reaches configured percentage.
+// Next episode from known season pack is prefetched right after playback starts
+func Init(s *bittorrent.BTService) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		check(s)
+		cleanup(s)
+	}
+}
+
+// Get returns infohash of the torrent with prefetched episode
+func Get(showID, season, episode int) string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if i, ok := items[key(showID, season, episode)]; ok {
+		return i.InfoHash
+	}
+	return ""
+}
+
+func check(s *bittorrent.BTService) {
+	prefetchNext := config.Get().PrefetchNextEpisode
+	prefetchPack := config.Get().SeasonPackPrefetch
+	if !prefetchNext && !prefetchPack {
+		return
+	}
+
+	player := s.GetActivePlayer()
+	if player == nil || player.Torrent == nil {
+		return
+	}
+
+	p := player.Params()
+	if p.ContentType != episodeType || p.ShowID == 0 || p.Episode == 0 {
+		return
+	}
+	if !prefetchPack || player.Torrent.SeasonPackFile(p.ShowID, p.Season, p.Episode+1) == nil {
+		if !prefetchNext || p.VideoDuration == 0 || p.WatchedTime/p.VideoDuration*100 < float64(config.Get().PrefetchNextPercent) {
+			return
+		}
+	}
+
+	current := key(p.ShowID, p.Season, p.Episode)
+	mu.Lock()
+	if _, ok := checked[current]; ok {
+		mu.Unlock()
+		return
+	}
+	checked[current] = time.Now()
+	mu.Unlock()
+
+	go prefetch(s, player, p.ShowID, p.Season, p.Episode)
+}
+
+func prefetch(s *bittorrent.BTService, player *bittorrent.BTPlayer, showID, season, episode int) {
+	current := player.Torrent
+	show := tmdb.GetShow(showID, config.Get().Language)
+	if show == nil {
+		return
+	}
+
+	next := nextEpisode(show, season, episode)
+	if next == nil {
+		log.Debugf("No aired episode after S%02dE%02d of %s", season, episode, show.Name)
+		return
+	}
+	label := fmt.Sprintf("%s S%02dE%02d", show.Name, next.SeasonNumber, next.EpisodeNumber)
+
+	// Next episode is often in the playing torrent
+	if f := current.EpisodeFile(show, next.SeasonNumber, next.EpisodeNumber); f != nil {
+		log.Infof("Prefetching %s from playing torrent: %s", label, f.DisplayPath())
+		current.SetPrefetched(true)
+		current.Prefetch(f)
+		add(&Item{ShowID: showID, Season: next.SeasonNumber, Episode: next.EpisodeNumber, InfoHash: current.InfoHash()})
+		return
+	}
+	if !config.Get().PrefetchNextEpisode {
+		return
+	}
+
+	searchers := providers.GetEpisodeSearchers()
+	if len(searchers) == 0 {
+		return
+	}
+
+	log.Infof("Searching %s for prefetch", label)
+	torrents := providers.SearchEpisode(searchers, show, next)
+	if len(torrents) == 0 {
+		log.Infof("Nothing found to prefetch %s", label)
+		return
+	}
+
+	// Results are already ordered by sorting preferences or scoring profile
+	t, err := s.AddTorrent(torrents[0].URI, bittorrent.AutoStorage(episodeType))
+	if err != nil {
+		log.Warningf("Could not add torrent to prefetch %s: %s", label, err)
+		return
+	}
+
+	// Files of magnets are known after metadata is received
+	select {
+	case <-t.GotInfo():
+	case <-time.After(infoTimeout):
+		log.Infof("Could not get metadata of %s in time, not prefetching %s", t.Name(), label)
+		s.RemoveTorrent(t, true)
+		return
+	case <-player.Closing():
+		log.Infof("Player is closed, not prefetching %s", label)
+		s.RemoveTorrent(t, true)
+		return
+	}
+
+	f := t.EpisodeFile(show, next.SeasonNumber, next.EpisodeNumber)
+	if f == nil {
+		f = singleFile(t)
+	}
+	if f == nil {
+		log.Infof("Could not find %s in %s, not prefetching", label, t.Name())
+		s.RemoveTorrent(t, true)
+		return
+	}
+
+	log.Infof("Prefetching %s from %s", label, f.DisplayPath())
+	t.SetPrefetched(true)
+	t.Prefetch(f)
+
+	infoHash := t.InfoHash()
+	database.Get().UpdateBTItem(infoHash, next.ID, episodeType, []*gotorrent.File{f}, label, showID, next.SeasonNumber, next.EpisodeNumber)
+	t.DBItem = database.Get().GetBTItem(infoHash)
+
+	add(&Item{ShowID: showID, Season: next.SeasonNumber, Episode: next.EpisodeNumber, InfoHash: infoHash, Added: true})
+}
+
+// cleanup forgets played episodes, and removes torrents, which were not played in time.
+// Playing torrent, which has the next episode, gets decision of the player, made when it was closed
+func cleanup(s *bittorrent.BTService) {
+	playing := ""
+	if player := s.GetActivePlayer(); player != nil {
+		if p := player.Params(); p.ContentType == episodeType {
+			playing = key(p.ShowID, p.Season, p.Episode)
+		}
+	}
+
+	type drop struct {
+		item    *Item
+		torrent *bittorrent.Torrent
+	}
+	dropped := []drop{}
+
+	mu.Lock()
+	for k, c := range checked {
+		if k != playing && time.Since(c) >= unusedTimeout {
+			delete(checked, k)
+		}
+	}
+
+	for k, i := range items {
+		t := s.GetTorrentByHash(i.InfoHash)
+		if t == nil || !t.IsPrefetched() {
+			delete(items, k)
+			continue
+		}
+		if time.Since(i.Since) < unusedTimeout || t.IsPlaying {
+			continue
+		}
+
+		delete(items, k)
+		dropped = append(dropped, drop{i, t})
+	}
+	mu.Unlock()
+
+	// Torrents are removed without holding the lock, so lookups of prefetched episodes are not waiting for it
+	for _, d := range dropped {
+		i, t := d.item, d.torrent
+		log.Infof("Prefetched episode S%02dE%02d was not played, dropping %s", i.Season, i.Episode, t.Name())
+		if i.Added {
+			t.SetPrefetched(false)
+			s.RemoveTorrent(t, true)
+		} else {
+			t.DropPrefetch()
+		}
+	}
+}
+
+// nextEpisode returns episode, following the given one, from the same or the next season, if it is already aired
+func nextEpisode(show *tmdb.Show, season, episode int) *tmdb.Episode {
+	if e := tmdb.GetEpisode(show.ID, season, episode+1, config.Get().Language); e != nil && isAired(e) {
+		return e
+	}
+
+	for _, s := range show.Seasons {
+		if s == nil || s.Season <= season || s.EpisodeCount == 0 {
+			continue
+		}
+		if e := tmdb.GetEpisode(show.ID, s.Season, 1, config.Get().Language); e != nil && isAired(e) {
+			return e
+		}
+		break
+	}
+
+	return nil
+}
+
+func isAired(e *tmdb.Episode) bool {
+	airDate, err := time.Parse("2006-01-02", e.AirDate)
+	return err == nil && airDate.Before(time.Now())
+}
+
+// singleFile returns the only video-sized file of the torrent, like the player does for single-episode torrents
+func singleFile(t *bittorrent.Torrent) *gotorrent.File {
+	var ret *gotorrent.File
+	for _, f := range t.Files() {
+		if f.Length() < minFileSize {
+			continue
+		}
+		if ret != nil {
+			return nil
+		}
+		ret = f
+	}
+
+	return ret
+}
+
+func add(i *Item) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	i.Since = time.Now()
+	items[key(i.ShowID, i.Season, i.Episode)] = i
+}
+
+func key(showID, season, episode int) string {
+	return fmt.Sprintf("%d_%d_%d", showID, season, episode)
+}
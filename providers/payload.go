@@ -58,6 +58,7 @@ type EpisodeSearchObject struct {
 	Year           int               `json:"year"`
 	Titles         map[string]string `json:"titles"`
 	AbsoluteNumber int               `json:"absolute_number"`
+	Numbering      string            `json:"numbering"`
 }
 
 func (sp *SearchPayload) String() string {
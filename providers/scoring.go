@@ -0,0 +1,282 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+
+	"github.com/elgatito/elementum/bittorrent"
+	"github.com/elgatito/elementum/config"
+)
+
+const scoringFile = "scoring.json"
+
+var releaseGroupMatcher = regexp.MustCompile(`-\s*([\w\.]+?)(\.(mkv|mp4|avi|ts))?\s*(\[[^\]]*\])?\s*$`)
+
+// releaseTokens are parts of source and codec tags with a dash, like "WEB-DL", "Blu-Ray" or "DTS-HD",
+// they are not release groups in names without a group
+var releaseTokens = map[string]bool{
+	"dl": true, "rip": true, "ray": true, "hd": true, "ma": true, "x": true, "es": true,
+	"web": true, "webrip": true, "hdtv": true, "bluray": true, "remux": true, "dvdrip": true,
+	"x264": true, "x265": true, "h264": true, "h265": true, "264": true, "265": true, "hevc": true, "avc": true,
+	"aac": true, "ac3": true, "dts": true, "dd": true, "ddp": true, "truehd": true, "atmos": true,
+	"10bit": true, "hdr": true, "720p": true, "1080p": true, "2160p": true,
+}
+
+// ScoringProfile is a user-defined set of weighted preferences and hard excludes,
+// used to order search results instead of sorting modes
+type ScoringProfile struct {
+	// Weights are added to the score, keys are names, like "1080p", "H.265", "DTS" or "WebDL"
+	Resolutions map[string]float64 `json:"resolutions"`
+	VideoCodecs map[string]float64 `json:"video_codecs"`
+	AudioCodecs map[string]float64 `json:"audio_codecs"`
+	RipTypes    map[string]float64 `json:"rip_types"`
+	Languages   map[string]float64 `json:"languages"`
+	Providers   map[string]float64 `json:"providers"`
+	// Seeds is a weight of seeders count, applied to logarithm of seeders
+	Seeds    float64 `json:"seeds"`
+	MinSeeds int64   `json:"min_seeds"`
+	// MinSize and MaxSize are human readable sizes, like "700 MB"
+	MinSize string `json:"min_size"`
+	MaxSize string `json:"max_size"`
+
+	Groups struct {
+		// Allow gets Weight added, Deny excludes results of release groups
+		Allow  []string `json:"allow"`
+		Deny   []string `json:"deny"`
+		Weight float64  `json:"weight"`
+	} `json:"groups"`
+
+	Exclude struct {
+		Resolutions []string `json:"resolutions"`
+		VideoCodecs []string `json:"video_codecs"`
+		AudioCodecs []string `json:"audio_codecs"`
+		RipTypes    []string `json:"rip_types"`
+		Languages   []string `json:"languages"`
+		Providers   []string `json:"providers"`
+	} `json:"exclude"`
+
+	minSize uint64
+	maxSize uint64
+}
+
+// Score is a result of scoring one torrent, with reasons for each added weight
+type Score struct {
+	Torrent  *bittorrent.TorrentFile `json:"-"`
+	Name     string                  `json:"name"`
+	Provider string                  `json:"provider"`
+	Group    string                  `json:"group"`
+	Score    float64                 `json:"score"`
+	Excluded bool                    `json:"excluded"`
+	Reasons  []string                `json:"reasons"`
+}
+
+// ScoringPath returns location of the scoring profile file
+func ScoringPath() string {
+	return filepath.Join(config.Get().Info.Profile, scoringFile)
+}
+
+// LoadScoringProfile reads scoring profile, returns nil if there is no profile file
+func LoadScoringProfile() (*ScoringProfile, error) {
+	b, err := ioutil.ReadFile(ScoringPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	p := &ScoringProfile{}
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, fmt.Errorf("Could not parse %s: %s", scoringFile, err)
+	}
+	if err := p.compile(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// GetScoringProfile returns scoring profile, if it is enabled in settings and defined
+func GetScoringProfile() *ScoringProfile {
+	if !config.Get().ScoringEnabled {
+		return nil
+	}
+
+	p, err := LoadScoringProfile()
+	if err != nil {
+		log.Warningf("Could not load scoring profile: %s", err)
+		return nil
+	}
+	return p
+}
+
+func (p *ScoringProfile) compile() (err error) {
+	if p.MinSize != "" {
+		if p.minSize, err = humanize.ParseBytes(p.MinSize); err != nil {
+			return fmt.Errorf("Wrong minimal size in scoring profile: %s", err)
+		}
+	}
+	if p.MaxSize != "" {
+		if p.maxSize, err = humanize.ParseBytes(p.MaxSize); err != nil {
+			return fmt.Errorf("Wrong maximal size in scoring profile: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// Score calculates score of the torrent, and checks whether it is excluded
+func (p *ScoringProfile) Score(t *bittorrent.TorrentFile) *Score {
+	s := &Score{
+		Torrent:  t,
+		Name:     t.Name,
+		Provider: t.Provider,
+		Group:    releaseGroup(t.Name),
+		Reasons:  []string{},
+	}
+
+	resolution := bittorrent.Resolutions[t.Resolution]
+	videoCodec := bittorrent.Codecs[t.VideoCodec]
+	audioCodec := bittorrent.Codecs[t.AudioCodec]
+	ripType := bittorrent.Rips[t.RipType]
+	providers := strings.Split(t.Provider, ", ")
+
+	s.exclude("resolution", p.Exclude.Resolutions, resolution)
+	s.exclude("video codec", p.Exclude.VideoCodecs, videoCodec)
+	s.exclude("audio codec", p.Exclude.AudioCodecs, audioCodec)
+	s.exclude("rip type", p.Exclude.RipTypes, ripType)
+	s.exclude("language", p.Exclude.Languages, t.Language)
+	s.exclude("release group", p.Groups.Deny, s.Group)
+	for _, provider := range providers {
+		s.exclude("provider", p.Exclude.Providers, provider)
+	}
+
+	if p.MinSeeds > 0 && t.Seeds < p.MinSeeds {
+		s.Excluded = true
+		s.Reasons = append(s.Reasons, fmt.Sprintf("excluded: %d seeders, less than %d", t.Seeds, p.MinSeeds))
+	}
+	if t.SizeParsed > 0 {
+		if p.minSize > 0 && t.SizeParsed < p.minSize {
+			s.Excluded = true
+			s.Reasons = append(s.Reasons, fmt.Sprintf("excluded: size %s is less than %s", humanize.Bytes(t.SizeParsed), p.MinSize))
+		}
+		if p.maxSize > 0 && t.SizeParsed > p.maxSize {
+			s.Excluded = true
+			s.Reasons = append(s.Reasons, fmt.Sprintf("excluded: size %s is more than %s", humanize.Bytes(t.SizeParsed), p.MaxSize))
+		}
+	}
+
+	s.weigh("resolution", p.Resolutions, resolution)
+	s.weigh("video codec", p.VideoCodecs, videoCodec)
+	s.weigh("audio codec", p.AudioCodecs, audioCodec)
+	s.weigh("rip type", p.RipTypes, ripType)
+	s.weigh("language", p.Languages, t.Language)
+	for _, provider := range providers {
+		s.weigh("provider", p.Providers, provider)
+	}
+
+	if s.Group != "" && p.Groups.Weight != 0 && containsFold(p.Groups.Allow, s.Group) {
+		s.add(p.Groups.Weight, fmt.Sprintf("release group %s", s.Group))
+	}
+	if p.Seeds != 0 && t.Seeds > 0 {
+		s.add(p.Seeds*math.Log2(float64(t.Seeds)+1), fmt.Sprintf("%d seeders", t.Seeds))
+	}
+
+	return s
+}
+
+// Explain scores all torrents, ordered by score, excluded torrents go last
+func (p *ScoringProfile) Explain(torrents []*bittorrent.TorrentFile) []*Score {
+	scores := make([]*Score, 0, len(torrents))
+	for _, t := range torrents {
+		scores = append(scores, p.Score(t))
+	}
+
+	sort.SliceStable(scores, func(i, j int) bool {
+		if scores[i].Excluded != scores[j].Excluded {
+			return !scores[i].Excluded
+		}
+		if scores[i].Score != scores[j].Score {
+			return scores[i].Score > scores[j].Score
+		}
+		return scores[i].Torrent.Seeds > scores[j].Torrent.Seeds
+	})
+
+	return scores
+}
+
+// Sort orders torrents by score and removes excluded torrents
+func (p *ScoringProfile) Sort(torrents []*bittorrent.TorrentFile) []*bittorrent.TorrentFile {
+	ret := make([]*bittorrent.TorrentFile, 0, len(torrents))
+	for _, s := range p.Explain(torrents) {
+		if s.Excluded {
+			log.Debugf("Excluding %s: %s", s.Name, strings.Join(s.Reasons, ", "))
+			continue
+		}
+		ret = append(ret, s.Torrent)
+	}
+
+	return ret
+}
+
+// SortAuto orders torrents to pick the best one for automatic playback,
+// using scoring profile if it is defined, or quality factor otherwise
+func SortAuto(torrents []*bittorrent.TorrentFile) []*bittorrent.TorrentFile {
+	if p := GetScoringProfile(); p != nil {
+		return p.Sort(torrents)
+	}
+
+	sort.Sort(sort.Reverse(ByQuality(torrents)))
+	return torrents
+}
+
+func (s *Score) add(weight float64, reason string) {
+	s.Score += weight
+	s.Reasons = append(s.Reasons, fmt.Sprintf("%+.1f %s", weight, reason))
+}
+
+func (s *Score) weigh(kind string, weights map[string]float64, value string) {
+	if value == "" {
+		return
+	}
+	for name, weight := range weights {
+		if strings.EqualFold(name, value) {
+			s.add(weight, fmt.Sprintf("%s %s", kind, value))
+			return
+		}
+	}
+}
+
+func (s *Score) exclude(kind string, names []string, value string) {
+	if value != "" && containsFold(names, value) {
+		s.Excluded = true
+		s.Reasons = append(s.Reasons, fmt.Sprintf("excluded: %s %s", kind, value))
+	}
+}
+
+// releaseGroup takes release group from the name, like "Movie.2018.1080p.WEB-DL.x264-GROUP"
+func releaseGroup(name string) string {
+	m := releaseGroupMatcher.FindStringSubmatch(name)
+	if len(m) < 2 || releaseTokens[strings.ToLower(strings.Split(m[1], ".")[0])] {
+		return ""
+	}
+	return m[1]
+}
+
+func containsFold(list []string, value string) bool {
+	for _, l := range list {
+		if strings.EqualFold(strings.TrimSpace(l), value) {
+			return true
+		}
+	}
+	return false
+}
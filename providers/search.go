@@ -439,6 +439,11 @@ func processLinks(torrentsChan chan *bittorrent.TorrentFile, sortType int) []*bi
 
 	}
 
+	// Scoring profile replaces sorting modes, when it is defined
+	if profile := GetScoringProfile(); profile != nil {
+		return profile.Sort(torrents)
+	}
+
 	// Sorting resulting list of torrents
 	conf := config.Get()
 	sortMode := conf.SortingModeMovies
@@ -0,0 +1,319 @@
+package providers
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/op/go-logging"
+
+	"github.com/elgatito/elementum/bittorrent"
+	"github.com/elgatito/elementum/config"
+	"github.com/elgatito/elementum/scrape"
+	"github.com/elgatito/elementum/tmdb"
+)
+
+const (
+	torznabMovieCategories = "2000"
+	torznabTVCategories    = "5000"
+)
+
+// TorznabSearcher is a native provider, querying Torznab-compatible indexers,
+// like Jackett, directly without Kodi provider add-ons
+type TorznabSearcher struct {
+	Name   string
+	URL    string
+	APIKey string
+	// Client is used for requests, proxy-aware scrape client by default
+	Client *http.Client
+	// Timeout of requests, provider timeout from settings by default
+	Timeout time.Duration
+
+	log *logging.Logger
+}
+
+// TorznabItem is an entry of Torznab response, or any RSS feed with torrents
+type TorznabItem struct {
+	Title     string `xml:"title"`
+	GUID      string `xml:"guid"`
+	Link      string `xml:"link"`
+	Size      uint64 `xml:"size"`
+	Enclosure struct {
+		URL    string `xml:"url,attr"`
+		Length uint64 `xml:"length,attr"`
+		Type   string `xml:"type,attr"`
+	} `xml:"enclosure"`
+	// Torznab and Newznab attributes, like <torznab:attr name="seeders" value="10" />
+	Attrs []struct {
+		Name  string `xml:"name,attr"`
+		Value string `xml:"value,attr"`
+	} `xml:"attr"`
+}
+
+type torznabResponse struct {
+	Channel struct {
+		Items []*TorznabItem `xml:"item"`
+	} `xml:"channel"`
+	// Torznab errors look like <error code="100" description="Invalid API Key" />
+	Code        string `xml:"code,attr"`
+	Description string `xml:"description,attr"`
+}
+
+// NewTorznabSearcher ...
+func NewTorznabSearcher(name, uri, apiKey string) *TorznabSearcher {
+	return &TorznabSearcher{
+		Name:   name,
+		URL:    uri,
+		APIKey: apiKey,
+		log:    logging.MustGetLogger(fmt.Sprintf("TorznabSearcher %s", name)),
+	}
+}
+
+// GetTorznabSearchers returns searchers for Torznab providers, defined in settings.
+// Each provider is defined as "name|url|apikey", separated by ";" or new lines.
+func GetTorznabSearchers() []*TorznabSearcher {
+	searchers := []*TorznabSearcher{}
+	if !config.Get().TorznabEnabled {
+		return searchers
+	}
+
+	for _, line := range strings.FieldsFunc(config.Get().TorznabProviders, func(r rune) bool { return r == ';' || r == '\n' }) {
+		fields := strings.Split(strings.TrimSpace(line), "|")
+		if len(fields) < 2 || fields[1] == "" {
+			continue
+		}
+
+		apiKey := ""
+		if len(fields) > 2 {
+			apiKey = strings.TrimSpace(fields[2])
+		}
+		searchers = append(searchers, NewTorznabSearcher(strings.TrimSpace(fields[0]), strings.TrimSpace(fields[1]), apiKey))
+	}
+
+	return searchers
+}
+
+// ParseTorznab reads items from Torznab response, or RSS feed
+func ParseTorznab(r io.Reader) ([]*TorznabItem, error) {
+	var resp torznabResponse
+	if err := xml.NewDecoder(r).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("Could not parse response: %s", err)
+	}
+	if resp.Code != "" {
+		return nil, fmt.Errorf("Torznab error %s: %s", resp.Code, resp.Description)
+	}
+
+	return resp.Channel.Items, nil
+}
+
+// Attr returns value of Torznab attribute
+func (i *TorznabItem) Attr(name string) string {
+	for _, a := range i.Attrs {
+		if strings.EqualFold(a.Name, name) {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// IntAttr returns value of numeric Torznab attribute
+func (i *TorznabItem) IntAttr(name string) int {
+	v, _ := strconv.Atoi(i.Attr(name))
+	return v
+}
+
+// URI returns the best link to the torrent, magnet links are preferred
+func (i *TorznabItem) URI() string {
+	if magnet := i.Attr("magneturl"); magnet != "" {
+		return magnet
+	}
+	if strings.HasPrefix(i.Link, "magnet:") {
+		return i.Link
+	}
+	if i.Enclosure.URL != "" {
+		return i.Enclosure.URL
+	}
+	if strings.HasPrefix(i.GUID, "magnet:") {
+		return i.GUID
+	}
+	return i.Link
+}
+
+// SizeBytes returns size of the torrent, taken from attributes or enclosure
+func (i *TorznabItem) SizeBytes() uint64 {
+	if v, err := strconv.ParseUint(i.Attr("size"), 10, 64); err == nil && v > 0 {
+		return v
+	}
+	if i.Size > 0 {
+		return i.Size
+	}
+	return i.Enclosure.Length
+}
+
+// TorrentFile converts item into a search result
+func (i *TorznabItem) TorrentFile(provider string) *bittorrent.TorrentFile {
+	uri := i.URI()
+	title := strings.TrimSpace(i.Title)
+	if uri == "" || title == "" {
+		return nil
+	}
+
+	t := bittorrent.NewTorrentFileFromRelease(uri, title, i.SizeBytes())
+	t.Provider = provider
+	t.Seeds = int64(i.IntAttr("seeders"))
+	if peers := int64(i.IntAttr("peers")); peers > t.Seeds {
+		t.Peers = peers - t.Seeds
+	} else {
+		t.Peers = int64(i.IntAttr("leechers"))
+	}
+	if t.InfoHash == "" {
+		t.InfoHash = strings.ToLower(i.Attr("infohash"))
+	}
+
+	return t
+}
+
+// MovieParams maps movie search object to Torznab query parameters
+func (ts *TorznabSearcher) MovieParams(o *MovieSearchObject) url.Values {
+	params := url.Values{}
+	params.Set("t", "movie")
+	params.Set("cat", torznabMovieCategories)
+	if o.IMDBId != "" {
+		params.Set("imdbid", o.IMDBId)
+	} else {
+		params.Set("q", strings.TrimSpace(fmt.Sprintf("%s %d", o.Title, o.Year)))
+	}
+	return params
+}
+
+// SeasonParams maps season search object to Torznab query parameters
+func (ts *TorznabSearcher) SeasonParams(o *SeasonSearchObject) url.Values {
+	params := url.Values{}
+	params.Set("t", "tvsearch")
+	params.Set("cat", torznabTVCategories)
+	ts.showParams(params, o.IMDBId, o.TVDBId, o.Title)
+	params.Set("season", strconv.Itoa(o.Season))
+	return params
+}
+
+// EpisodeParams maps episode search object to Torznab query parameters
+func (ts *TorznabSearcher) EpisodeParams(o *EpisodeSearchObject) url.Values {
+	params := url.Values{}
+	params.Set("t", "tvsearch")
+	params.Set("cat", torznabTVCategories)
+	ts.showParams(params, o.IMDBId, o.TVDBId, o.Title)
+	params.Set("season", strconv.Itoa(o.Season))
+	params.Set("ep", strconv.Itoa(o.Episode))
+	return params
+}
+
+func (ts *TorznabSearcher) showParams(params url.Values, imdbID string, tvdbID int, title string) {
+	if tvdbID > 0 {
+		params.Set("tvdbid", strconv.Itoa(tvdbID))
+	} else if imdbID != "" {
+		params.Set("imdbid", imdbID)
+	} else {
+		params.Set("q", title)
+	}
+}
+
+// Query sends search request with parameters and returns found torrents
+func (ts *TorznabSearcher) Query(params url.Values) ([]*bittorrent.TorrentFile, error) {
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	q := u.Query()
+	for k, v := range params {
+		q[k] = v
+	}
+	if ts.APIKey != "" {
+		q.Set("apikey", ts.APIKey)
+	}
+	u.RawQuery = q.Encode()
+
+	timeout := ts.Timeout
+	if timeout == 0 {
+		timeout = providerTimeout()
+		if config.Get().CustomProviderTimeoutEnabled == true {
+			timeout = time.Duration(config.Get().CustomProviderTimeout) * time.Second
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	client := ts.Client
+	if client == nil {
+		client = scrape.GetClient()
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Request failed with code: %d", resp.StatusCode)
+	}
+
+	items, err := ParseTorznab(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	torrents := make([]*bittorrent.TorrentFile, 0, len(items))
+	for _, i := range items {
+		if t := i.TorrentFile(ts.Name); t != nil {
+			torrents = append(torrents, t)
+		}
+	}
+
+	return torrents, nil
+}
+
+func (ts *TorznabSearcher) search(params url.Values) []*bittorrent.TorrentFile {
+	torrents, err := ts.Query(params)
+	if err != nil {
+		ts.log.Warningf("Search failed: %s", err)
+		return []*bittorrent.TorrentFile{}
+	}
+
+	ts.log.Debugf("Received %d results", len(torrents))
+	return torrents
+}
+
+// SearchLinks ...
+func (ts *TorznabSearcher) SearchLinks(query string) []*bittorrent.TorrentFile {
+	params := url.Values{}
+	params.Set("t", "search")
+	params.Set("q", query)
+	return ts.search(params)
+}
+
+// SearchMovieLinks ...
+func (ts *TorznabSearcher) SearchMovieLinks(movie *tmdb.Movie) []*bittorrent.TorrentFile {
+	return ts.search(ts.MovieParams(newMovieSearchObject(movie)))
+}
+
+// SearchSeasonLinks ...
+func (ts *TorznabSearcher) SearchSeasonLinks(show *tmdb.Show, season *tmdb.Season) []*bittorrent.TorrentFile {
+	return ts.search(ts.SeasonParams(newSeasonSearchObject(show, season)))
+}
+
+// SearchEpisodeLinks ...
+func (ts *TorznabSearcher) SearchEpisodeLinks(show *tmdb.Show, episode *tmdb.Episode) []*bittorrent.TorrentFile {
+	return ts.search(ts.EpisodeParams(newEpisodeSearchObject(show, episode)))
+}
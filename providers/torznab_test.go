@@ -0,0 +1,123 @@
+package providers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+const torznabTestResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:torznab="http://torznab.com/schemas/2015/feed">
+  <channel>
+    <item>
+      <title>Show.S02E05.1080p.WEB-DL.x264-GROUP</title>
+      <guid>https://indexer/details/1</guid>
+      <link>https://indexer/download/1</link>
+      <size>1073741824</size>
+      <torznab:attr name="seeders" value="42" />
+      <torznab:attr name="peers" value="50" />
+      <torznab:attr name="magneturl" value="magnet:?xt=urn:btih:0123456789abcdef0123456789abcdef01234567&amp;dn=Show" />
+    </item>
+  </channel>
+</rss>`
+
+func newTorznabTestServer(t *testing.T, want url.Values) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		for k := range want {
+			if q.Get(k) != want.Get(k) {
+				t.Errorf("Parameter %s is %q, expected %q", k, q.Get(k), want.Get(k))
+			}
+		}
+		fmt.Fprint(w, torznabTestResponse)
+	}))
+}
+
+func queryTorznabTestServer(t *testing.T, params url.Values, want url.Values) {
+	server := newTorznabTestServer(t, want)
+	defer server.Close()
+
+	ts := NewTorznabSearcher("test", server.URL+"/api", "secret")
+	ts.Client = server.Client()
+	ts.Timeout = 5 * time.Second
+
+	torrents, err := ts.Query(params)
+	if err != nil {
+		t.Fatalf("Query failed: %s", err)
+	}
+	if len(torrents) != 1 {
+		t.Fatalf("Expected 1 result, got %d", len(torrents))
+	}
+
+	torrent := torrents[0]
+	if torrent.Seeds != 42 {
+		t.Errorf("Expected 42 seeders, got %d", torrent.Seeds)
+	}
+	if torrent.Peers != 8 {
+		t.Errorf("Expected 8 peers, got %d", torrent.Peers)
+	}
+	if torrent.SizeParsed != 1073741824 {
+		t.Errorf("Expected size 1073741824, got %d", torrent.SizeParsed)
+	}
+	if torrent.Provider != "test" {
+		t.Errorf("Expected provider test, got %s", torrent.Provider)
+	}
+}
+
+func TestTorznabMovieQuery(t *testing.T) {
+	ts := NewTorznabSearcher("test", "", "")
+	params := ts.MovieParams(&MovieSearchObject{IMDBId: "tt0133093", Title: "The Matrix", Year: 1999})
+
+	queryTorznabTestServer(t, params, url.Values{
+		"t":      {"movie"},
+		"cat":    {torznabMovieCategories},
+		"imdbid": {"tt0133093"},
+		"q":      {""},
+		"apikey": {"secret"},
+	})
+}
+
+func TestTorznabEpisodeQuery(t *testing.T) {
+	ts := NewTorznabSearcher("test", "", "")
+	params := ts.EpisodeParams(&EpisodeSearchObject{IMDBId: "tt0903747", TVDBId: 81189, Title: "Show", Season: 2, Episode: 5})
+
+	queryTorznabTestServer(t, params, url.Values{
+		"t":      {"tvsearch"},
+		"cat":    {torznabTVCategories},
+		"tvdbid": {"81189"},
+		"imdbid": {""},
+		"season": {"2"},
+		"ep":     {"5"},
+		"apikey": {"secret"},
+	})
+}
+
+func TestTorznabShowQueryByIMDb(t *testing.T) {
+	ts := NewTorznabSearcher("test", "", "")
+	params := ts.SeasonParams(&SeasonSearchObject{IMDBId: "tt0903747", Title: "Show", Season: 3})
+
+	queryTorznabTestServer(t, params, url.Values{
+		"t":      {"tvsearch"},
+		"imdbid": {"tt0903747"},
+		"season": {"3"},
+		"ep":     {""},
+	})
+}
+
+func TestTorznabError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><error code="100" description="Invalid API Key" />`)
+	}))
+	defer server.Close()
+
+	ts := NewTorznabSearcher("test", server.URL, "wrong")
+	ts.Client = server.Client()
+	ts.Timeout = 5 * time.Second
+
+	if _, err := ts.Query(url.Values{"t": {"search"}}); err == nil {
+		t.Error("Expected Torznab error")
+	}
+}
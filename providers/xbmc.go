@@ -12,8 +12,8 @@ import (
 
 	"github.com/elgatito/elementum/bittorrent"
 	"github.com/elgatito/elementum/config"
+	"github.com/elgatito/elementum/numbering"
 	"github.com/elgatito/elementum/tmdb"
-	"github.com/elgatito/elementum/tvdb"
 	"github.com/elgatito/elementum/util"
 	"github.com/elgatito/elementum/xbmc"
 	"github.com/gin-gonic/gin"
@@ -80,6 +80,9 @@ func getSearchers() []interface{} {
 			list = append(list, NewAddonSearcher(addon.ID))
 		}
 	}
+	for _, searcher := range GetTorznabSearchers() {
+		list = append(list, searcher)
+	}
 	return list
 }
 
@@ -129,6 +132,10 @@ func NewAddonSearcher(addonID string) *AddonSearcher {
 
 // GetQuerySearchObject ...
 func (as *AddonSearcher) GetQuerySearchObject(query string) *QuerySearchObject {
+	return newQuerySearchObject(query)
+}
+
+func newQuerySearchObject(query string) *QuerySearchObject {
 	sObject := &QuerySearchObject{
 		Query: query,
 	}
@@ -142,6 +149,10 @@ func (as *AddonSearcher) GetQuerySearchObject(query string) *QuerySearchObject {
 
 // GetMovieSearchObject ...
 func (as *AddonSearcher) GetMovieSearchObject(movie *tmdb.Movie) *MovieSearchObject {
+	return newMovieSearchObject(movie)
+}
+
+func newMovieSearchObject(movie *tmdb.Movie) *MovieSearchObject {
 	year, _ := strconv.Atoi(strings.Split(movie.ReleaseDate, "-")[0])
 	title := movie.Title
 	if config.Get().UseOriginalTitle && movie.OriginalTitle != "" {
@@ -174,6 +185,10 @@ func (as *AddonSearcher) GetMovieSearchObject(movie *tmdb.Movie) *MovieSearchObj
 
 // GetSeasonSearchObject ...
 func (as *AddonSearcher) GetSeasonSearchObject(show *tmdb.Show, season *tmdb.Season) *SeasonSearchObject {
+	return newSeasonSearchObject(show, season)
+}
+
+func newSeasonSearchObject(show *tmdb.Show, season *tmdb.Season) *SeasonSearchObject {
 	year, _ := strconv.Atoi(strings.Split(season.AirDate, "-")[0])
 	title := show.Name
 	if config.Get().UseOriginalTitle && show.OriginalName != "" {
@@ -205,6 +220,10 @@ func (as *AddonSearcher) GetSeasonSearchObject(show *tmdb.Show, season *tmdb.Sea
 
 // GetEpisodeSearchObject ...
 func (as *AddonSearcher) GetEpisodeSearchObject(show *tmdb.Show, episode *tmdb.Episode) *EpisodeSearchObject {
+	return newEpisodeSearchObject(show, episode)
+}
+
+func newEpisodeSearchObject(show *tmdb.Show, episode *tmdb.Episode) *EpisodeSearchObject {
 	year, _ := strconv.Atoi(strings.Split(episode.AirDate, "-")[0])
 	title := show.Name
 	if config.Get().UseOriginalTitle && show.OriginalName != "" {
@@ -213,36 +232,10 @@ func (as *AddonSearcher) GetEpisodeSearchObject(show *tmdb.Show, episode *tmdb.E
 
 	tvdbID := util.StrInterfaceToInt(show.ExternalIDs.TVDBID)
 
-	// Is this an Anime?
-	absoluteNumber := 0
-	if tvdbID > 0 {
-		countryIsJP := false
-		for _, country := range show.OriginCountry {
-			if country == "JP" {
-				countryIsJP = true
-				break
-			}
-		}
-		genreIsAnim := false
-		for _, genre := range show.Genres {
-			if genre.Name == "Animation" {
-				genreIsAnim = true
-				break
-			}
-		}
-		if countryIsJP && genreIsAnim {
-			tvdbShow, err := tvdb.GetShow(tvdbID, config.Get().Language)
-			if err == nil && len(tvdbShow.Seasons) >= episode.SeasonNumber+1 {
-				tvdbSeason := tvdbShow.Seasons[episode.SeasonNumber]
-				if len(tvdbSeason.Episodes) >= episode.EpisodeNumber {
-					tvdbEpisode := tvdbSeason.Episodes[episode.EpisodeNumber-1]
-					if tvdbEpisode.AbsoluteNumber > 0 {
-						absoluteNumber = tvdbEpisode.AbsoluteNumber
-					}
-					title = tvdbShow.SeriesName
-				}
-			}
-		}
+	scheme := numbering.Get(show)
+	number := scheme.Episode(episode.SeasonNumber, episode.EpisodeNumber)
+	if scheme.Anime && scheme.TVDB != nil && number.Absolute > 0 {
+		title = scheme.TVDB.SeriesName
 	}
 
 	sObject := &EpisodeSearchObject{
@@ -250,10 +243,11 @@ func (as *AddonSearcher) GetEpisodeSearchObject(show *tmdb.Show, episode *tmdb.E
 		TVDBId:         tvdbID,
 		Title:          NormalizeTitle(title),
 		Titles:         map[string]string{"original": NormalizeTitle(show.OriginalName), "source": show.OriginalName},
-		Season:         episode.SeasonNumber,
-		Episode:        episode.EpisodeNumber,
+		Season:         number.Season,
+		Episode:        number.Episode,
 		Year:           year,
-		AbsoluteNumber: absoluteNumber,
+		AbsoluteNumber: number.Absolute,
+		Numbering:      numbering.ModeName(number.Mode),
 	}
 	if show.AlternativeTitles != nil && show.AlternativeTitles.Titles != nil {
 		for _, title := range show.AlternativeTitles.Titles {
@@ -44,6 +44,8 @@ type Cache struct {
 
 	readerPieces *roaring.Bitmap
 	readers      []*reader.PositionReader
+
+	keep *Keeper
 }
 
 // SetCapacity ...
@@ -72,6 +74,13 @@ func (c *Cache) SetReaders(readers []*reader.PositionReader) {
 	c.readers = readers
 }
 
+// KeepFile marks torrent file to be saved to disk, when storage is keeping files
+func (c *Cache) KeepFile(path string) {
+	if c.keep != nil {
+		c.keep.KeepFile(path)
+	}
+}
+
 // GetTorrentStorage ...
 func (c *Cache) GetTorrentStorage(hash string) estorage.TorrentStorage {
 	return nil
@@ -144,6 +153,10 @@ func (c *Cache) Init(info *metainfo.Info) {
 
 // Close ...
 func (c *Cache) Close() error {
+	if c.keep != nil {
+		c.keep.Close()
+	}
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -175,12 +188,30 @@ func (c *Cache) remove(pi int) {
 
 	// log.Debugf("Removing element: %#v", pi)
 
+	c.spill(c.pieces[pi])
+
 	c.pieces[pi].b.Reset()
 	c.pieces[pi].Reset()
 
 	c.bufferUsed--
 }
 
+// spill saves completed piece to the disk tier before its buffer is reused
+func (c *Cache) spill(p *Piece) {
+	if c.s.disk == nil {
+		return
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if !p.completed || p.size < p.length {
+		return
+	}
+
+	c.s.disk.Schedule(c.id, p.index, p.b.buffer[:p.length])
+}
+
 func (c *Cache) trim() {
 	if c.capacity < 0 || c.bufferUsed < c.bufferLimit {
 		return
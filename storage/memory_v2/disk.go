@@ -0,0 +1,337 @@
+package memory
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/anacrolix/sync"
+	humanize "github.com/dustin/go-humanize"
+)
+
+// diskQueueSize is the most pieces, waiting to be written, pieces above it are not saved
+const diskQueueSize = 32
+
+// Disk is a size-bounded on-disk tier for pieces, evicted from memory buffers.
+// Pieces are stored as separate files in a per-torrent folder,
+// oldest accessed pieces are removed when capacity is exceeded.
+type Disk struct {
+	mu *sync.Mutex
+	// ioMu serializes file operations, so mu is only held for bookkeeping
+	ioMu *sync.Mutex
+
+	path     string
+	capacity int64
+	used     int64
+
+	items map[diskKey]*diskItem
+
+	// pending pieces are copied from evicted buffers and wait for writer
+	pending map[diskKey][]byte
+	queue   chan diskKey
+}
+
+type diskKey struct {
+	hash  string
+	index int
+}
+
+type diskItem struct {
+	size     int64
+	accessed time.Time
+}
+
+// NewDisk initializes disk tier in a path, with capacity in bytes
+func NewDisk(path string, capacity int64) *Disk {
+	log.Infof("Initializing disk cache at %s of size: %s", path, humanize.Bytes(uint64(capacity)))
+
+	if err := os.MkdirAll(path, 0755); err != nil {
+		log.Warningf("Cannot create disk cache folder %s: %s", path, err)
+		return nil
+	}
+
+	d := &Disk{
+		mu:       &sync.Mutex{},
+		ioMu:     &sync.Mutex{},
+		path:     path,
+		capacity: capacity,
+		items:    map[diskKey]*diskItem{},
+		pending:  map[diskKey][]byte{},
+		queue:    make(chan diskKey, diskQueueSize),
+	}
+	d.load()
+
+	go d.writer()
+
+	return d
+}
+
+// load collects pieces, stored during previous runs
+func (d *Disk) load() {
+	d.ioMu.Lock()
+	defer d.ioMu.Unlock()
+
+	d.mu.Lock()
+
+	dirs, err := ioutil.ReadDir(d.path)
+	if err != nil {
+		d.mu.Unlock()
+		return
+	}
+
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+
+		files, err := ioutil.ReadDir(filepath.Join(d.path, dir.Name()))
+		if err != nil {
+			continue
+		}
+
+		for _, f := range files {
+			index, err := strconv.Atoi(f.Name())
+			if err != nil || f.IsDir() {
+				continue
+			}
+
+			d.items[diskKey{dir.Name(), index}] = &diskItem{
+				size:     f.Size(),
+				accessed: f.ModTime(),
+			}
+			d.used += f.Size()
+		}
+	}
+
+	log.Debugf("Loaded %d pieces from disk cache, using %s", len(d.items), humanize.Bytes(uint64(d.used)))
+	removed := d.trim()
+	d.mu.Unlock()
+
+	d.unlink(removed...)
+}
+
+func (d *Disk) piecePath(hash string, index int) string {
+	return filepath.Join(d.path, hash, strconv.Itoa(index))
+}
+
+// Has checks whether piece is stored on disk
+func (d *Disk) Has(hash string, index int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := diskKey{hash, index}
+	if _, ok := d.pending[key]; ok {
+		return true
+	}
+	_, ok := d.items[key]
+	return ok
+}
+
+// Schedule copies piece contents and writes them in the background,
+// so buffers are evicted without waiting for disk
+func (d *Disk) Schedule(hash string, index int, b []byte) {
+	if len(b) == 0 || int64(len(b)) > d.capacity {
+		return
+	}
+
+	key := diskKey{hash, index}
+
+	d.mu.Lock()
+	if item, ok := d.items[key]; ok {
+		item.accessed = time.Now()
+		d.mu.Unlock()
+		return
+	} else if _, ok := d.pending[key]; ok {
+		d.mu.Unlock()
+		return
+	}
+
+	data := make([]byte, len(b))
+	copy(data, b)
+	d.pending[key] = data
+	d.mu.Unlock()
+
+	select {
+	case d.queue <- key:
+	default:
+		log.Debugf("Disk cache is busy, not saving piece %d", index)
+
+		d.mu.Lock()
+		delete(d.pending, key)
+		d.mu.Unlock()
+	}
+}
+
+func (d *Disk) writer() {
+	for key := range d.queue {
+		d.mu.Lock()
+		data := d.pending[key]
+		d.mu.Unlock()
+		if data == nil {
+			continue
+		}
+
+		if err := d.Put(key.hash, key.index, data); err != nil {
+			log.Debugf("Cannot save piece %d to disk: %s", key.index, err)
+		}
+
+		d.mu.Lock()
+		delete(d.pending, key)
+		d.mu.Unlock()
+	}
+}
+
+// Put stores piece contents on disk
+func (d *Disk) Put(hash string, index int, b []byte) error {
+	size := int64(len(b))
+	if size == 0 || size > d.capacity {
+		return errors.New("piece does not fit disk cache")
+	}
+
+	d.ioMu.Lock()
+	defer d.ioMu.Unlock()
+
+	key := diskKey{hash, index}
+
+	d.mu.Lock()
+	if item, ok := d.items[key]; ok {
+		item.accessed = time.Now()
+		d.mu.Unlock()
+		return nil
+	}
+	d.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Join(d.path, hash), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(d.piecePath(hash, index), b, 0644); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	d.items[key] = &diskItem{
+		size:     size,
+		accessed: time.Now(),
+	}
+	d.used += size
+	removed := d.trim()
+	d.mu.Unlock()
+
+	d.unlink(removed...)
+	return nil
+}
+
+// ReadAt reads whole piece contents from disk into b
+func (d *Disk) ReadAt(hash string, index int, b []byte) (n int, err error) {
+	key := diskKey{hash, index}
+
+	d.mu.Lock()
+	if data, ok := d.pending[key]; ok {
+		n = copy(b, data)
+		d.mu.Unlock()
+		return n, nil
+	}
+	d.mu.Unlock()
+
+	d.ioMu.Lock()
+	defer d.ioMu.Unlock()
+
+	d.mu.Lock()
+	item, ok := d.items[key]
+	var size int64
+	if ok {
+		size = item.size
+	}
+	d.mu.Unlock()
+	if !ok {
+		return 0, errors.New("piece is not stored on disk")
+	}
+
+	f, err := os.Open(d.piecePath(hash, index))
+	if err == nil {
+		n, err = f.ReadAt(b, 0)
+		f.Close()
+	}
+	if err != nil && int64(n) != size {
+		d.mu.Lock()
+		d.remove(key)
+		d.mu.Unlock()
+
+		d.unlink(key)
+		return
+	}
+
+	d.mu.Lock()
+	item.accessed = time.Now()
+	d.mu.Unlock()
+	return n, nil
+}
+
+// Remove deletes piece from disk, file is deleted in the background,
+// so callers, holding piece locks, are not waiting for disk
+func (d *Disk) Remove(hash string, index int) {
+	key := diskKey{hash, index}
+
+	d.mu.Lock()
+	_, stored := d.items[key]
+	d.remove(key)
+	d.mu.Unlock()
+
+	if stored {
+		go func() {
+			d.ioMu.Lock()
+			defer d.ioMu.Unlock()
+
+			d.unlink(key)
+		}()
+	}
+}
+
+// remove forgets about the piece, its file is deleted with unlink
+func (d *Disk) remove(key diskKey) {
+	delete(d.pending, key)
+
+	item, ok := d.items[key]
+	if !ok {
+		return
+	}
+
+	d.used -= item.size
+	delete(d.items, key)
+}
+
+// unlink deletes files of removed pieces, should be called with ioMu locked
+func (d *Disk) unlink(keys ...diskKey) {
+	for _, key := range keys {
+		d.mu.Lock()
+		_, stored := d.items[key]
+		d.mu.Unlock()
+
+		if !stored {
+			os.Remove(d.piecePath(key.hash, key.index))
+		}
+	}
+}
+
+// trim removes oldest accessed pieces above capacity, and returns them
+func (d *Disk) trim() (removed []diskKey) {
+	for d.used > d.capacity && len(d.items) > 0 {
+		var minKey diskKey
+		var minTime time.Time
+
+		for k, i := range d.items {
+			if minTime.IsZero() || i.accessed.Before(minTime) {
+				minKey = k
+				minTime = i.accessed
+			}
+		}
+
+		d.remove(minKey)
+		removed = append(removed, minKey)
+	}
+
+	return
+}
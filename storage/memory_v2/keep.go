@@ -0,0 +1,370 @@
+package memory
+
+import (
+	"crypto/sha1"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/anacrolix/sync"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Keeper saves completed pieces of selected files into their places in download path,
+// so files, streamed from memory buffers, are left on disk when they are complete.
+// Pieces are written in the background, and are read back when evicted from memory.
+type Keeper struct {
+	mu *sync.Mutex
+	// ioMu guards file handles, so disk access does not block piece lookups
+	ioMu *sync.Mutex
+
+	path        string
+	pieceLength int64
+
+	files   []*keepFile
+	handles map[string]*os.File
+
+	// kept pieces are fully written to disk, pending are waiting for writer
+	kept    map[int]bool
+	pending map[int][]byte
+
+	wake    chan struct{}
+	closing chan struct{}
+	closed  chan struct{}
+}
+
+type keepFile struct {
+	path     string
+	offset   int64
+	length   int64
+	selected bool
+}
+
+// newKeeper prepares files layout of the torrent, same as file storage is using,
+// and collects pieces, saved during previous runs
+func newKeeper(path string, info *metainfo.Info) *Keeper {
+	k := &Keeper{
+		mu:          &sync.Mutex{},
+		ioMu:        &sync.Mutex{},
+		path:        path,
+		pieceLength: info.PieceLength,
+		handles:     map[string]*os.File{},
+		kept:        map[int]bool{},
+		pending:     map[int][]byte{},
+		wake:        make(chan struct{}, 1),
+		closing:     make(chan struct{}),
+		closed:      make(chan struct{}),
+	}
+
+	for _, f := range info.UpvertedFiles() {
+		k.files = append(k.files, &keepFile{
+			path:   strings.Join(append([]string{info.Name}, f.Path...), "/"),
+			offset: f.Offset(info),
+			length: f.Length,
+		})
+	}
+
+	k.load(info)
+
+	go k.writer()
+
+	return k
+}
+
+// load verifies pieces, that are already present in files on disk
+func (k *Keeper) load(info *metainfo.Info) {
+	sizes := map[string]int64{}
+	for _, f := range k.files {
+		if fi, err := os.Stat(filepath.Join(k.path, filepath.FromSlash(f.path))); err == nil && !fi.IsDir() {
+			sizes[f.path] = fi.Size()
+		}
+	}
+	if len(sizes) == 0 {
+		return
+	}
+
+	for i := 0; i < info.NumPieces(); i++ {
+		piece := info.Piece(i)
+		if !k.present(i, piece.Length(), sizes) {
+			continue
+		}
+
+		b := make([]byte, piece.Length())
+		if _, err := k.io(i, b, false); err != nil {
+			continue
+		}
+		if metainfo.Hash(sha1.Sum(b)) == piece.Hash() {
+			k.kept[i] = true
+		}
+	}
+
+	log.Debugf("Found %d kept pieces of %s in %s", len(k.kept), info.Name, k.path)
+}
+
+// present checks whether all files, piece is spanning over, are long enough to contain it
+func (k *Keeper) present(index int, length int64, sizes map[string]int64) bool {
+	begin := int64(index) * k.pieceLength
+	end := begin + length
+
+	for _, f := range k.files {
+		if f.offset >= end || f.offset+f.length <= begin {
+			continue
+		}
+
+		to := end
+		if f.offset+f.length < to {
+			to = f.offset + f.length
+		}
+		if size, ok := sizes[f.path]; !ok || size < to-f.offset {
+			return false
+		}
+	}
+
+	return true
+}
+
+// KeepFile selects torrent file, which pieces should be saved to disk
+func (k *Keeper) KeepFile(path string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	for _, f := range k.files {
+		if f.path == path && !f.selected {
+			log.Infof("Saving %s to %s", path, k.path)
+			f.selected = true
+		}
+	}
+}
+
+// wanted checks whether piece belongs to any of selected files
+func (k *Keeper) wanted(index int) bool {
+	begin := int64(index) * k.pieceLength
+	end := begin + k.pieceLength
+
+	for _, f := range k.files {
+		if f.selected && f.offset < end && f.offset+f.length > begin {
+			return true
+		}
+	}
+
+	return false
+}
+
+// covered checks whether piece lies only in selected files,
+// other pieces are written partially and can't be read back
+func (k *Keeper) covered(index int) bool {
+	begin := int64(index) * k.pieceLength
+	end := begin + k.pieceLength
+
+	for _, f := range k.files {
+		if !f.selected && f.length > 0 && f.offset < end && f.offset+f.length > begin {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Put schedules completed piece to be written to disk, without waiting for the writer
+func (k *Keeper) Put(index int, b []byte) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if !k.wanted(index) || k.kept[index] || k.pending[index] != nil {
+		return
+	}
+
+	data := make([]byte, len(b))
+	copy(data, b)
+	k.pending[index] = data
+
+	select {
+	case k.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Has checks whether piece is kept on disk, or waiting to be written
+func (k *Keeper) Has(index int) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return k.kept[index] || k.pending[index] != nil
+}
+
+// Remove forgets about kept piece, it will be written again when completed
+func (k *Keeper) Remove(index int) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	delete(k.kept, index)
+}
+
+// ReadAt reads whole piece contents into b
+func (k *Keeper) ReadAt(index int, b []byte) (n int, err error) {
+	k.mu.Lock()
+	if data := k.pending[index]; data != nil {
+		n = copy(b, data)
+		k.mu.Unlock()
+		return n, nil
+	} else if !k.kept[index] {
+		k.mu.Unlock()
+		return 0, errors.New("piece is not kept on disk")
+	}
+	k.mu.Unlock()
+
+	k.ioMu.Lock()
+	n, err = k.io(index, b, false)
+	k.ioMu.Unlock()
+
+	if err != nil {
+		k.mu.Lock()
+		delete(k.kept, index)
+		k.mu.Unlock()
+	}
+	return
+}
+
+// Close waits for pending pieces and closes opened files
+func (k *Keeper) Close() {
+	k.mu.Lock()
+	select {
+	case <-k.closing:
+		k.mu.Unlock()
+		return
+	default:
+		close(k.closing)
+	}
+	k.mu.Unlock()
+
+	<-k.closed
+
+	k.ioMu.Lock()
+	defer k.ioMu.Unlock()
+
+	for path, h := range k.handles {
+		h.Close()
+		delete(k.handles, path)
+	}
+}
+
+func (k *Keeper) writer() {
+	defer close(k.closed)
+
+	for {
+		select {
+		case <-k.wake:
+			k.flush()
+		case <-k.closing:
+			// Flushing pieces, that are already scheduled
+			k.flush()
+			return
+		}
+	}
+}
+
+// flush writes pending pieces until none are left
+func (k *Keeper) flush() {
+	for {
+		index := -1
+		var data []byte
+
+		k.mu.Lock()
+		for i, d := range k.pending {
+			index, data = i, d
+			break
+		}
+		covered := index >= 0 && k.covered(index)
+		k.mu.Unlock()
+
+		if index < 0 {
+			return
+		}
+
+		k.ioMu.Lock()
+		_, err := k.io(index, data, true)
+		k.ioMu.Unlock()
+
+		k.mu.Lock()
+		if err != nil {
+			log.Warningf("Cannot save piece %d to disk: %s", index, err)
+		} else if covered {
+			k.kept[index] = true
+		}
+		delete(k.pending, index)
+		k.mu.Unlock()
+	}
+}
+
+// io reads or writes piece contents, spanning over torrent files.
+// Writes are clipped to selected files, so neighbouring files are not created.
+func (k *Keeper) io(index int, b []byte, write bool) (n int, err error) {
+	begin := int64(index) * k.pieceLength
+	end := begin + int64(len(b))
+
+	for _, f := range k.files {
+		if f.offset >= end || f.offset+f.length <= begin {
+			continue
+		} else if write && !k.selected(f) {
+			continue
+		}
+
+		from := begin
+		if f.offset > from {
+			from = f.offset
+		}
+		to := end
+		if f.offset+f.length < to {
+			to = f.offset + f.length
+		}
+
+		h, err := k.handle(f.path, write)
+		if err != nil {
+			return n, err
+		}
+
+		var c int
+		if write {
+			c, err = h.WriteAt(b[from-begin:to-begin], from-f.offset)
+		} else {
+			c, err = h.ReadAt(b[from-begin:to-begin], from-f.offset)
+		}
+		n += c
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+func (k *Keeper) selected(f *keepFile) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return f.selected
+}
+
+func (k *Keeper) handle(path string, create bool) (*os.File, error) {
+	if h, ok := k.handles[path]; ok {
+		return h, nil
+	}
+
+	filePath := filepath.Join(k.path, filepath.FromSlash(path))
+	flags := os.O_RDWR
+	if create {
+		flags |= os.O_CREATE
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	h, err := os.OpenFile(filePath, flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	k.handles[path] = h
+	return h, nil
+}
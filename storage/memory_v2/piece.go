@@ -31,11 +31,19 @@ func (p *Piece) Completion() storage.Completion {
 	defer p.mu.Unlock()
 
 	return storage.Completion{
-		Complete: p.completed,
+		Complete: p.completed || p.onDisk(),
 		Ok:       true,
 	}
 }
 
+func (p *Piece) onDisk() bool {
+	return !p.buffered() && (p.kept() || p.c.s.disk != nil && p.c.s.disk.Has(p.c.id, p.index))
+}
+
+func (p *Piece) kept() bool {
+	return p.c.keep != nil && p.c.keep.Has(p.index)
+}
+
 // MarkComplete ...
 func (p *Piece) MarkComplete() error {
 	defer perf.ScopeTimer()()
@@ -51,6 +59,10 @@ func (p *Piece) MarkComplete() error {
 
 	p.completed = true
 
+	if p.c.keep != nil && p.size >= p.length {
+		p.c.keep.Put(p.index, p.b.buffer[:p.length])
+	}
+
 	// log.Debugf("Complete: %#v", p.index)
 
 	return nil
@@ -65,6 +77,13 @@ func (p *Piece) MarkNotComplete() error {
 	p.read = false
 	p.size = 0
 
+	if p.c.s.disk != nil {
+		p.c.s.disk.Remove(p.c.id, p.index)
+	}
+	if p.c.keep != nil {
+		p.c.keep.Remove(p.index)
+	}
+
 	// log.Debugf("Not complete: %#v", p.index)
 
 	return nil
@@ -168,10 +187,109 @@ func (p *Piece) WriteAt(b []byte, off int64) (n int, err error) {
 	return
 }
 
+// restore reads evicted piece from the disk tier back into a memory buffer.
+// Disk is read without holding piece lock, and the buffer is attached
+// with cache buffers locked first, same as trim() does.
+func (p *Piece) restore() bool {
+	p.mu.RLock()
+	if p.buffered() {
+		p.mu.RUnlock()
+		return true
+	} else if !p.onDisk() {
+		p.mu.RUnlock()
+		return false
+	}
+	kept := p.kept()
+	p.mu.RUnlock()
+
+	b := p.takeBuffer()
+	if b == nil {
+		return false
+	}
+
+	var n int
+	var err error
+	if kept {
+		n, err = p.c.keep.ReadAt(p.index, b.buffer[:p.length])
+	} else {
+		n, err = p.c.s.disk.ReadAt(p.c.id, p.index, b.buffer[:p.length])
+	}
+	if err != nil || int64(n) != p.length {
+		log.Debugf("Cannot restore piece %d from disk: %v", p.index, err)
+
+		p.c.bmu.Lock()
+		p.releaseBuffer(b)
+		p.c.bmu.Unlock()
+
+		return false
+	}
+
+	p.c.bmu.Lock()
+	p.mu.Lock()
+	if p.buffered() {
+		// Piece got a buffer while we were reading the disk
+		p.releaseBuffer(b)
+	} else {
+		b.pi = p.index
+		b.accessed = time.Now()
+
+		p.b = b
+		p.size = p.length
+		p.completed = true
+	}
+	p.mu.Unlock()
+	trim := p.c.bufferUsed >= p.c.bufferLimit
+	p.c.bmu.Unlock()
+
+	if trim {
+		go p.c.trim()
+	}
+
+	return true
+}
+
+// takeBuffer reserves free buffer for the piece, without assigning it,
+// so trim() does not evict it until piece is attached
+func (p *Piece) takeBuffer() *Buffer {
+	p.c.bmu.Lock()
+	defer p.c.bmu.Unlock()
+
+	for _, b := range p.c.buffers {
+		if b.used {
+			continue
+		}
+
+		b.used = true
+		if p.c.reservedPieces.ContainsInt(p.index) {
+			p.c.bufferLimit--
+		} else {
+			p.c.bufferUsed++
+		}
+
+		return b
+	}
+
+	return nil
+}
+
+// releaseBuffer returns buffer, taken with takeBuffer, should be called with bmu locked
+func (p *Piece) releaseBuffer(b *Buffer) {
+	if p.c.reservedPieces.ContainsInt(p.index) {
+		p.c.bufferLimit++
+	} else {
+		p.c.bufferUsed--
+	}
+	b.Reset()
+}
+
 // ReadAt File-like implementation
 func (p *Piece) ReadAt(b []byte, off int64) (n int, err error) {
 	defer perf.ScopeTimer()()
 
+	if (p.c.s.disk != nil || p.c.keep != nil) && !p.buffered() {
+		p.restore()
+	}
+
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
@@ -32,6 +32,9 @@ type Storage struct {
 	items map[string]*Cache
 
 	capacity int64
+
+	disk     *Disk
+	keepPath string
 }
 
 // NewMemoryStorage initializer function
@@ -47,6 +50,26 @@ func NewMemoryStorage(maxMemorySize int64) *Storage {
 	return s
 }
 
+// NewMemoryDiskStorage initializes memory storage with an on-disk tier,
+// where evicted pieces are stored and read back from
+func NewMemoryDiskStorage(maxMemorySize int64, diskPath string, maxDiskSize int64) *Storage {
+	s := NewMemoryStorage(maxMemorySize)
+	if maxDiskSize > 0 {
+		s.disk = NewDisk(diskPath, maxDiskSize)
+	}
+
+	return s
+}
+
+// NewMemoryKeepStorage initializes memory storage, which saves completed pieces
+// of selected files to the path, so files are kept after streaming
+func NewMemoryKeepStorage(maxMemorySize int64, keepPath string) *Storage {
+	s := NewMemoryStorage(maxMemorySize)
+	s.keepPath = keepPath
+
+	return s
+}
+
 // GetTorrentStorage ...
 func (s *Storage) GetTorrentStorage(hash string) estorage.TorrentStorage {
 	if i, ok := s.items[hash]; ok {
@@ -97,6 +120,9 @@ func (s *Storage) OpenTorrent(info *metainfo.Info, infoHash metainfo.Hash) (stor
 	}
 
 	c.Init(info)
+	if s.keepPath != "" {
+		c.keep = newKeeper(s.keepPath, info)
+	}
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
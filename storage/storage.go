@@ -17,14 +17,30 @@ const (
 	StorageFat32
 	// StorageMMap MMap file storage
 	StorageMMap
+	// StorageMemoryDisk In-memory storage with on-disk tier for evicted pieces
+	StorageMemoryDisk
+	// StorageMemoryKeep In-memory storage, saving selected files to download path
+	StorageMemoryKeep
 )
 
 // Storages lists basic names of used storage engines
 var Storages = map[int]string{
-	StorageFile:   "File",
-	StorageMMap:   "MMap",
-	StorageFat32:  "Fat32",
-	StorageMemory: "Memory",
+	StorageFile:       "File",
+	StorageMMap:       "MMap",
+	StorageFat32:      "Fat32",
+	StorageMemory:     "Memory",
+	StorageMemoryDisk: "Memory+Disk",
+	StorageMemoryKeep: "Memory+Keep",
+}
+
+// IsMemoryStorage returns whether storage type keeps torrent data in memory buffers
+func IsMemoryStorage(storageType int) bool {
+	return storageType == StorageMemory || storageType == StorageMemoryDisk || storageType == StorageMemoryKeep
+}
+
+// KeepsFiles returns whether storage type leaves downloaded files in download path
+func KeepsFiles(storageType int) bool {
+	return !IsMemoryStorage(storageType) || storageType == StorageMemoryKeep
 }
 
 // ElementumStorage basic interface for storages, used in the plugin
@@ -46,6 +62,11 @@ type TorrentStorage interface {
 	ElementumStorage
 }
 
+// FileKeeper is implemented by storages, which can save selected files to disk
+type FileKeeper interface {
+	KeepFile(path string)
+}
+
 // DummyStorage ...
 type DummyStorage struct {
 	storage.ClientImpl
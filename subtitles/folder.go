@@ -0,0 +1,140 @@
+package subtitles
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/elgatito/elementum/config"
+	"github.com/elgatito/elementum/xbmc"
+)
+
+const maxFolderDepth = 4
+
+var (
+	subtitleExtensions = map[string]bool{".srt": true, ".ass": true, ".ssa": true, ".sub": true, ".vtt": true, ".smi": true}
+	// Movie.en.srt, Movie.eng.forced.srt
+	languageSuffixRegex = regexp.MustCompile(`(?i)[._\-\s]([a-z]{2,3})(?:[._\-\s](?:forced|sdh|hi|cc))?$`)
+	nonWordRegex        = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// Folder finds subtitles in a local folder, like a downloaded collection
+type Folder struct{}
+
+func init() {
+	Register(&Folder{})
+}
+
+// Name ...
+func (p *Folder) Name() string {
+	return "folder"
+}
+
+// Enabled ...
+func (p *Folder) Enabled() bool {
+	return config.Get().SubtitlesFolder != ""
+}
+
+// Search matches subtitle file names against playing file name, or episode and movie titles.
+// Subtitles, named after the playing file, are made for that release, so they count as hash matches
+func (p *Folder) Search(q *Query) ([]*Subtitle, error) {
+	root := config.Get().SubtitlesFolder
+	if _, err := os.Stat(root); err != nil {
+		return nil, err
+	}
+
+	base := normalize(strings.TrimSuffix(q.FileName, filepath.Ext(q.FileName)))
+	terms := []string{}
+	switch {
+	case q.Text != "":
+		terms = append(terms, normalize(q.Text))
+	case q.ShowTitle != "" && q.Episode > 0:
+		terms = append(terms, normalize(fmt.Sprintf("%s S%02dE%02d", q.ShowTitle, q.Season, q.Episode)))
+	case q.Title != "":
+		terms = append(terms, normalize(fmt.Sprintf("%s %s", q.Title, q.Year)))
+	}
+
+	ret := []*Subtitle{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if rel, _ := filepath.Rel(root, path); strings.Count(rel, string(filepath.Separator)) >= maxFolderDepth {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		ext := strings.ToLower(filepath.Ext(path))
+		if !subtitleExtensions[ext] {
+			return nil
+		}
+
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		normalized := normalize(name)
+		hashMatch := base != "" && strings.HasPrefix(normalized, base)
+		matched := hashMatch
+		for _, t := range terms {
+			if t != "" && strings.Contains(normalized, t) {
+				matched = true
+			}
+		}
+		if !matched {
+			return nil
+		}
+
+		s := &Subtitle{
+			ID:        filepath.Base(path),
+			FileName:  filepath.Base(path),
+			Format:    strings.TrimPrefix(ext, "."),
+			HashMatch: hashMatch,
+			URL:       path,
+		}
+		if m := languageSuffixRegex.FindStringSubmatch(name); m != nil {
+			s.Language = xbmc.ConvertLanguage(strings.ToLower(m[1]), xbmc.Iso639_2)
+			s.LanguageName = xbmc.ConvertLanguage(strings.ToLower(m[1]), xbmc.EnglishName)
+			s.ISO639 = xbmc.ConvertLanguage(strings.ToLower(m[1]), xbmc.Iso639_1)
+		}
+		if s.LanguageName == "" {
+			s.LanguageName = "Unknown"
+		}
+
+		ret = append(ret, s)
+		return nil
+	})
+
+	return ret, err
+}
+
+// Download copies found file, only files inside subtitles folder are allowed
+func (p *Folder) Download(s *Subtitle, w io.Writer) error {
+	root, err := filepath.Abs(config.Get().SubtitlesFolder)
+	if err != nil {
+		return err
+	}
+	path, err := filepath.Abs(s.URL)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(path, root+string(filepath.Separator)) {
+		return fmt.Errorf("Subtitle %s is outside of subtitles folder", s.URL)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// normalize leaves lowercase words, separated with a single space
+func normalize(s string) string {
+	return strings.TrimSpace(nonWordRegex.ReplaceAllString(strings.ToLower(s), " "))
+}
@@ -0,0 +1,134 @@
+package subtitles
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/elgatito/elementum/config"
+	"github.com/elgatito/elementum/osdb"
+	"github.com/elgatito/elementum/scrape"
+)
+
+// OpenSubtitles searches opensubtitles.org with XML-RPC client
+type OpenSubtitles struct{}
+
+func init() {
+	Register(&OpenSubtitles{})
+}
+
+// Name ...
+func (p *OpenSubtitles) Name() string {
+	return "opensubtitles"
+}
+
+// Enabled ...
+func (p *OpenSubtitles) Enabled() bool {
+	return true
+}
+
+// Search ...
+func (p *OpenSubtitles) Search(q *Query) ([]*Subtitle, error) {
+	client, err := osdb.NewClient()
+	if err != nil {
+		return nil, err
+	}
+	if err := client.LogIn(config.Get().OSDBUser, config.Get().OSDBPass, config.Get().OSDBLanguage); err != nil {
+		return nil, err
+	}
+	defer client.LogOut()
+
+	payloads := searchPayloads(q)
+	log.Infof("Subtitles payload: %+v", payloads)
+
+	results, err := client.SearchSubtitles(payloads)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]*Subtitle, 0, len(results))
+	for _, sub := range results {
+		rating, _ := strconv.ParseFloat(sub.SubRating, 64)
+		downloads, _ := strconv.Atoi(sub.SubDownloadsCnt)
+		ret = append(ret, &Subtitle{
+			ID:              sub.IDSubtitleFile,
+			FileName:        sub.SubFileName,
+			Language:        sub.SubLanguageID,
+			LanguageName:    sub.LanguageName,
+			ISO639:          sub.ISO639,
+			Format:          sub.SubFormat,
+			Rating:          rating,
+			Downloads:       downloads,
+			HashMatch:       sub.MatchedBy == "moviehash",
+			HearingImpaired: sub.SubHearingImpaired == "1",
+			URL:             sub.SubDownloadLink,
+		})
+	}
+
+	return ret, nil
+}
+
+// Download ...
+func (p *OpenSubtitles) Download(s *Subtitle, w io.Writer) error {
+	if s.URL == "" {
+		return errors.New("Subtitle has no download link")
+	}
+
+	resp, err := scrape.GetClient().Get(s.URL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	reader, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	_, err = io.Copy(w, reader)
+	return err
+}
+
+// searchPayloads makes XML-RPC queries: by file hash, file name, and episode or movie title
+func searchPayloads(q *Query) []osdb.SearchPayload {
+	payloads := []osdb.SearchPayload{}
+	if q.Text != "" {
+		payloads = append(payloads, osdb.SearchPayload{
+			Query: q.Text,
+		})
+	} else {
+		if q.Hash != "" {
+			payloads = append(payloads, osdb.SearchPayload{
+				Hash: q.Hash,
+				Size: q.Size,
+			})
+		}
+		if q.FileName != "" {
+			payloads = append(payloads, osdb.SearchPayload{
+				Query: strings.TrimSuffix(q.FileName, filepath.Ext(q.FileName)),
+			})
+		}
+
+		if q.ShowTitle != "" {
+			if q.Season >= 0 && q.Episode > 0 {
+				payloads = append(payloads, osdb.SearchPayload{
+					Query: fmt.Sprintf("%s S%02dE%02d", q.ShowTitle, q.Season, q.Episode),
+				})
+			}
+		} else if q.Title != "" {
+			payloads = append(payloads, osdb.SearchPayload{
+				Query: fmt.Sprintf("%s %s", q.Title, q.Year),
+			})
+		}
+	}
+
+	for i := range payloads {
+		payloads[i].Languages = strings.Join(q.Languages, ",")
+	}
+	return payloads
+}
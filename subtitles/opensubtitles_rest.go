@@ -0,0 +1,240 @@
+package subtitles
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jmcvetta/napping"
+
+	"github.com/elgatito/elementum/config"
+	"github.com/elgatito/elementum/scrape"
+	"github.com/elgatito/elementum/util"
+	"github.com/elgatito/elementum/xbmc"
+)
+
+const restEndpoint = "https://api.opensubtitles.com/api/v1"
+
+// OpenSubtitlesREST searches opensubtitles.com with REST API, it needs user's API key
+type OpenSubtitlesREST struct {
+	mu    sync.Mutex
+	token string
+}
+
+type restSubtitles struct {
+	Data []struct {
+		ID         string `json:"id"`
+		Attributes struct {
+			Language        string  `json:"language"`
+			DownloadCount   int     `json:"download_count"`
+			Ratings         float64 `json:"ratings"`
+			HearingImpaired bool    `json:"hearing_impaired"`
+			MovieHashMatch  bool    `json:"moviehash_match"`
+			Release         string  `json:"release"`
+			Files           []struct {
+				FileID   int    `json:"file_id"`
+				FileName string `json:"file_name"`
+			} `json:"files"`
+		} `json:"attributes"`
+	} `json:"data"`
+}
+
+type restDownload struct {
+	Link     string `json:"link"`
+	FileName string `json:"file_name"`
+}
+
+type restLogin struct {
+	Token string `json:"token"`
+}
+
+func init() {
+	Register(&OpenSubtitlesREST{})
+}
+
+// Name ...
+func (p *OpenSubtitlesREST) Name() string {
+	return "opensubtitles_rest"
+}
+
+// Enabled ...
+func (p *OpenSubtitlesREST) Enabled() bool {
+	return config.Get().OSDBAPIKey != ""
+}
+
+// Search ...
+func (p *OpenSubtitlesREST) Search(q *Query) ([]*Subtitle, error) {
+	params := url.Values{}
+	if q.Text != "" {
+		params.Set("query", q.Text)
+	} else {
+		if q.Hash != "" {
+			params.Set("moviehash", q.Hash)
+		}
+		if id := strings.TrimPrefix(q.IMDBId, "tt"); id != "" {
+			params.Set("imdb_id", id)
+		}
+		if q.ShowTitle != "" {
+			params.Set("query", q.ShowTitle)
+			if q.Season >= 0 && q.Episode > 0 {
+				params.Set("season_number", strconv.Itoa(q.Season))
+				params.Set("episode_number", strconv.Itoa(q.Episode))
+			}
+		} else if q.Title != "" {
+			params.Set("query", q.Title)
+			params.Set("year", q.Year)
+		}
+	}
+
+	languages := make([]string, 0, len(q.Languages))
+	for _, l := range q.Languages {
+		if code := restLanguage(l); code != "" {
+			languages = append(languages, code)
+		}
+	}
+	params.Set("languages", strings.Join(languages, ","))
+
+	var results restSubtitles
+	if err := p.request("GET", "/subtitles", &params, nil, &results); err != nil {
+		return nil, err
+	}
+
+	ret := []*Subtitle{}
+	for _, d := range results.Data {
+		a := d.Attributes
+		if len(a.Files) == 0 {
+			continue
+		}
+
+		fileName := a.Files[0].FileName
+		if fileName == "" {
+			fileName = a.Release
+		}
+		ret = append(ret, &Subtitle{
+			ID:              strconv.Itoa(a.Files[0].FileID),
+			FileName:        fileName,
+			Language:        xbmc.ConvertLanguage(a.Language, xbmc.Iso639_2),
+			LanguageName:    xbmc.ConvertLanguage(a.Language, xbmc.EnglishName),
+			ISO639:          a.Language,
+			Format:          "srt",
+			Rating:          a.Ratings,
+			Downloads:       a.DownloadCount,
+			HashMatch:       a.MovieHashMatch,
+			HearingImpaired: a.HearingImpaired,
+		})
+	}
+
+	return ret, nil
+}
+
+// Download requests temporary link for the file, downloads are counted against user's quota
+func (p *OpenSubtitlesREST) Download(s *Subtitle, w io.Writer) error {
+	fileID, err := strconv.Atoi(s.ID)
+	if err != nil {
+		return err
+	}
+
+	var download restDownload
+	if err := p.request("POST", "/download", nil, map[string]int{"file_id": fileID}, &download); err != nil {
+		return err
+	}
+	if download.Link == "" {
+		return errors.New("Subtitle has no download link")
+	}
+
+	resp, err := scrape.GetClient().Get(download.Link)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+func (p *OpenSubtitlesREST) request(method string, endPoint string, params *url.Values, payload interface{}, result interface{}) error {
+	header := http.Header{
+		"Content-Type": []string{"application/json"},
+		"Accept":       []string{"application/json"},
+		"Api-Key":      []string{config.Get().OSDBAPIKey},
+		"User-Agent":   []string{fmt.Sprintf("Elementum v%s", util.GetVersion())},
+	}
+	if token := p.login(header); token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+
+	req := napping.Request{
+		Url:     restEndpoint + endPoint,
+		Method:  method,
+		Params:  params,
+		Payload: payload,
+		Result:  result,
+		Header:  &header,
+	}
+
+	resp, err := napping.Send(&req)
+	if err != nil {
+		return err
+	}
+	if resp.Status() == 401 {
+		p.mu.Lock()
+		p.token = ""
+		p.mu.Unlock()
+	}
+	if resp.Status() != 200 {
+		return fmt.Errorf("OpenSubtitles API returned %d for %s", resp.Status(), endPoint)
+	}
+
+	return nil
+}
+
+// login returns user token, it is needed only for downloads over anonymous quota
+func (p *OpenSubtitlesREST) login(header http.Header) string {
+	if config.Get().OSDBUser == "" || config.Get().OSDBPass == "" {
+		return ""
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.token != "" {
+		return p.token
+	}
+
+	var result restLogin
+	req := napping.Request{
+		Url:    restEndpoint + "/login",
+		Method: "POST",
+		Payload: map[string]string{
+			"username": config.Get().OSDBUser,
+			"password": config.Get().OSDBPass,
+		},
+		Result: &result,
+		Header: &header,
+	}
+	if resp, err := napping.Send(&req); err != nil || resp.Status() != 200 {
+		log.Warningf("Could not log in to OpenSubtitles API: %v", err)
+		return ""
+	}
+
+	p.token = result.Token
+	return p.token
+}
+
+// restLanguage converts ISO 639-2 code into language code of the API
+func restLanguage(language string) string {
+	switch language {
+	case "pob":
+		return "pt-br"
+	case "por":
+		return "pt-pt"
+	case "chi":
+		return "zh-cn"
+	}
+	return xbmc.ConvertLanguage(language, xbmc.Iso639_1)
+}
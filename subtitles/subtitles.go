@@ -0,0 +1,213 @@
+package subtitles
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/op/go-logging"
+
+	"github.com/elgatito/elementum/config"
+)
+
+var log = logging.MustGetLogger("subtitles")
+
+// ErrNotFound is returned when provider is not registered
+var ErrNotFound = errors.New("Subtitles provider not found")
+
+// Query is a subtitles search, shared by all providers.
+// Languages are ISO 639-2 codes, in the order of preference
+type Query struct {
+	Text      string
+	Hash      string
+	Size      int64
+	FilePath  string
+	FileName  string
+	IMDBId    string
+	Title     string
+	Year      string
+	ShowTitle string
+	Season    int
+	Episode   int
+	Languages []string
+}
+
+// Subtitle is a search result of a provider
+type Subtitle struct {
+	Provider        string  `json:"provider"`
+	ID              string  `json:"id"`
+	FileName        string  `json:"file_name"`
+	Language        string  `json:"language"`
+	LanguageName    string  `json:"language_name"`
+	ISO639          string  `json:"iso639"`
+	Format          string  `json:"format"`
+	Rating          float64 `json:"rating"`
+	Downloads       int     `json:"downloads"`
+	HashMatch       bool    `json:"hash_match"`
+	HearingImpaired bool    `json:"hearing_impaired"`
+	URL             string  `json:"url"`
+}
+
+// Provider is a subtitles backend
+type Provider interface {
+	Name() string
+	Enabled() bool
+	Search(q *Query) ([]*Subtitle, error)
+	Download(s *Subtitle, w io.Writer) error
+}
+
+var (
+	mu        sync.RWMutex
+	providers = []Provider{}
+)
+
+// Register adds subtitles provider, providers register themselves on init
+func Register(p Provider) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	providers = append(providers, p)
+}
+
+// Get returns registered provider by name
+func Get(name string) (Provider, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	for _, p := range providers {
+		if p.Name() == name {
+			return p, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Enabled returns providers, enabled in settings
+func Enabled() []Provider {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	ret := []Provider{}
+	for _, p := range providers {
+		if p.Enabled() {
+			ret = append(ret, p)
+		}
+	}
+	return ret
+}
+
+// Search queries providers in parallel, and returns merged results,
+// ranked by hash match, language preference and rating
+func Search(list []Provider, q *Query) []*Subtitle {
+	var wg sync.WaitGroup
+	results := make([][]*Subtitle, len(list))
+	for i, p := range list {
+		wg.Add(1)
+		go func(i int, p Provider) {
+			defer wg.Done()
+
+			subs, err := p.Search(q)
+			if err != nil {
+				log.Warningf("Could not search subtitles with %s: %s", p.Name(), err)
+				return
+			}
+			for _, s := range subs {
+				s.Provider = p.Name()
+			}
+			log.Debugf("Found %d subtitles with %s", len(subs), p.Name())
+			results[i] = subs
+		}(i, p)
+	}
+	wg.Wait()
+
+	merged := []*Subtitle{}
+	for _, subs := range results {
+		merged = append(merged, subs...)
+	}
+
+	return rank(deduplicate(merged), q.Languages)
+}
+
+// Download writes subtitle contents, using provider of the search result
+func Download(s *Subtitle, w io.Writer) error {
+	p, err := Get(s.Provider)
+	if err != nil {
+		return err
+	}
+	return p.Download(s, w)
+}
+
+// DownloadPath returns Subtitles folder in download path, or in temporary path for memory storage
+func DownloadPath() string {
+	ret := filepath.Join(config.Get().DownloadPath, "Subtitles")
+	if config.Get().DownloadPath == "." {
+		ret = filepath.Join(config.Get().TemporaryPath, "Subtitles")
+	}
+	if _, errStat := os.Stat(ret); os.IsNotExist(errStat) {
+		if errMk := os.Mkdir(ret, 0755); errMk != nil {
+			log.Error("Unable to create Subtitles folder")
+		}
+	}
+
+	return ret
+}
+
+// deduplicate merges the same subtitle, found by several providers, keeping the better result
+func deduplicate(subs []*Subtitle) []*Subtitle {
+	ret := []*Subtitle{}
+	seen := map[string]*Subtitle{}
+	for _, s := range subs {
+		key := strings.ToLower(s.Language + "/" + strings.TrimSuffix(s.FileName, filepath.Ext(s.FileName)))
+		existing, ok := seen[key]
+		if !ok {
+			seen[key] = s
+			ret = append(ret, s)
+			continue
+		}
+
+		hashMatch := existing.HashMatch || s.HashMatch
+		if better(s, existing) {
+			*existing = *s
+		}
+		existing.HashMatch = hashMatch
+	}
+	return ret
+}
+
+func rank(subs []*Subtitle, languages []string) []*Subtitle {
+	order := map[string]int{}
+	for i, l := range languages {
+		order[strings.ToLower(l)] = i
+	}
+	language := func(s *Subtitle) int {
+		if i, ok := order[strings.ToLower(s.Language)]; ok {
+			return i
+		}
+		return len(languages)
+	}
+
+	sort.SliceStable(subs, func(i, j int) bool {
+		if subs[i].HashMatch != subs[j].HashMatch {
+			return subs[i].HashMatch
+		}
+		if li, lj := language(subs[i]), language(subs[j]); li != lj {
+			return li < lj
+		}
+		return better(subs[i], subs[j])
+	})
+	return subs
+}
+
+func better(a, b *Subtitle) bool {
+	if a.HashMatch != b.HashMatch {
+		return a.HashMatch
+	}
+	if a.Rating != b.Rating {
+		return a.Rating > b.Rating
+	}
+	return a.Downloads > b.Downloads
+}
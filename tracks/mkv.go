@@ -0,0 +1,659 @@
+package tracks
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Matroska element IDs, with marker bits
+const (
+	idEBML    = 0x1A45DFA3
+	idSegment = 0x18538067
+
+	idSeekHead     = 0x114D9B74
+	idSeek         = 0x4DBB
+	idSeekID       = 0x53AB
+	idSeekPosition = 0x53AC
+
+	idInfo          = 0x1549A966
+	idTimecodeScale = 0x2AD7B1
+
+	idTracks       = 0x1654AE6B
+	idTrackEntry   = 0xAE
+	idTrackNumber  = 0xD7
+	idTrackType    = 0x83
+	idCodecID      = 0x86
+	idCodecPrivate = 0x63A2
+	idLanguage     = 0x22B59C
+	idLanguageIETF = 0x22B59D
+	idName         = 0x536E
+	idFlagDefault  = 0x88
+	idFlagForced   = 0x55AA
+
+	idContentEncodings    = 0x6D80
+	idContentEncoding     = 0x6240
+	idContentCompression  = 0x5034
+	idContentCompAlgo     = 0x4254
+	idContentCompSettings = 0x4255
+
+	idCues                = 0x1C53BB6B
+	idCuePoint            = 0xBB
+	idCueTime             = 0xB3
+	idCueTrackPositions   = 0xB7
+	idCueTrack            = 0xF7
+	idCueClusterPosition  = 0xF1
+	idCueRelativePosition = 0xF0
+	idCueDuration         = 0xB2
+
+	idCluster       = 0x1F43B675
+	idTimecode      = 0xE7
+	idSimpleBlock   = 0xA3
+	idBlockGroup    = 0xA0
+	idBlock         = 0xA1
+	idBlockDuration = 0x9B
+
+	trackTypeSubtitle = 0x11
+
+	compressionNone   = -1
+	compressionZlib   = 0
+	compressionHeader = 3
+
+	mkvHeadSize     = 64 * 1024
+	mkvBlockRead    = 4 * 1024
+	maxElementSize  = 16 * 1024 * 1024
+	maxClusterSize  = 8 * 1024 * 1024
+	maxHeaderLength = 12
+)
+
+// mkvTrack is a subtitle track with data, needed to decode its blocks
+type mkvTrack struct {
+	*Track
+	private     []byte
+	compression int
+	stripped    []byte
+}
+
+// mkvCue is a position of subtitle block, from Cues element
+type mkvCue struct {
+	time     uint64
+	duration uint64
+	cluster  int64
+	relative int64
+}
+
+// mkvFile is a parsed Matroska file header
+type mkvFile struct {
+	src Source
+
+	// segment is the offset of Segment data, positions in the file are relative to it
+	segment int64
+	scale   uint64
+	cues    int64
+	tracks  []*mkvTrack
+}
+
+// ebmlElement is an element, parsed from the buffer
+type ebmlElement struct {
+	id   uint32
+	data []byte
+}
+
+func probeMKV(src Source) (*mkvFile, error) {
+	m := &mkvFile{
+		src:   src,
+		scale: 1000000,
+	}
+
+	head, err := readAt(src, 0, mkvHeadSize)
+	if err != nil {
+		return nil, err
+	}
+
+	id, size, n := readElementHeader(head)
+	if id != idEBML || n == 0 || size < 0 {
+		return nil, errors.New("File is not a Matroska file")
+	}
+	pos := int64(n) + size
+	if pos >= int64(len(head)) {
+		return nil, errors.New("Matroska header is too large")
+	}
+
+	id, size, n = readElementHeader(head[pos:])
+	if id != idSegment || n == 0 {
+		return nil, errors.New("Could not find Matroska segment")
+	}
+	m.segment = pos + int64(n)
+	end := src.Length()
+	if size >= 0 && m.segment+size < end {
+		end = m.segment + size
+	}
+
+	seeks := map[uint32]int64{}
+	for pos = m.segment; pos < end; {
+		header, err := readAt(src, pos, maxHeaderLength)
+		if err != nil {
+			return nil, err
+		}
+		id, size, n := readElementHeader(header)
+		if n == 0 {
+			return nil, fmt.Errorf("Wrong Matroska element at %d", pos)
+		}
+		data := pos + int64(n)
+
+		// Clusters are not read, the rest of elements is found with SeekHead
+		if id == idCluster || size < 0 {
+			break
+		}
+
+		switch id {
+		case idSeekHead, idInfo, idTracks:
+			if size > maxElementSize {
+				return nil, fmt.Errorf("Matroska element %x is too large", id)
+			}
+			b, err := readAt(src, data, size)
+			if err != nil {
+				return nil, err
+			}
+			m.parseElement(id, b, seeks)
+		case idCues:
+			m.cues = pos
+		}
+
+		pos = data + size
+	}
+
+	if m.tracks == nil {
+		if p, ok := seeks[idTracks]; ok {
+			if err := m.readElement(p, idTracks, seeks); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if m.cues == 0 {
+		if p, ok := seeks[idCues]; ok {
+			m.cues = p
+		}
+	}
+
+	return m, nil
+}
+
+// readElement reads top-level element, found with SeekHead
+func (m *mkvFile) readElement(pos int64, expected uint32, seeks map[uint32]int64) error {
+	header, err := readAt(m.src, pos, maxHeaderLength)
+	if err != nil {
+		return err
+	}
+	id, size, n := readElementHeader(header)
+	if id != expected || size < 0 || size > maxElementSize {
+		return fmt.Errorf("Wrong Matroska element %x at %d", id, pos)
+	}
+
+	b, err := readAt(m.src, pos+int64(n), size)
+	if err != nil {
+		return err
+	}
+	m.parseElement(id, b, seeks)
+	return nil
+}
+
+func (m *mkvFile) parseElement(id uint32, b []byte, seeks map[uint32]int64) {
+	switch id {
+	case idSeekHead:
+		for _, seek := range parseElements(b) {
+			if seek.id != idSeek {
+				continue
+			}
+			var seekID uint32
+			var position int64 = -1
+			for _, e := range parseElements(seek.data) {
+				switch e.id {
+				case idSeekID:
+					seekID = uint32(readUint(e.data))
+				case idSeekPosition:
+					position = int64(readUint(e.data))
+				}
+			}
+			if _, ok := seeks[seekID]; !ok && position >= 0 {
+				seeks[seekID] = m.segment + position
+			}
+		}
+	case idInfo:
+		for _, e := range parseElements(b) {
+			if e.id == idTimecodeScale {
+				if scale := readUint(e.data); scale > 0 {
+					m.scale = scale
+				}
+			}
+		}
+	case idTracks:
+		m.tracks = []*mkvTrack{}
+		for _, e := range parseElements(b) {
+			if e.id != idTrackEntry {
+				continue
+			}
+			if t := parseMKVTrack(e.data); t != nil {
+				m.tracks = append(m.tracks, t)
+			}
+		}
+	}
+}
+
+func parseMKVTrack(b []byte) *mkvTrack {
+	t := &mkvTrack{
+		Track: &Track{
+			Language: "eng",
+			Default:  true,
+		},
+		compression: compressionNone,
+	}
+
+	var typ uint64
+	var ietf string
+	for _, e := range parseElements(b) {
+		switch e.id {
+		case idTrackNumber:
+			t.Number = int(readUint(e.data))
+		case idTrackType:
+			typ = readUint(e.data)
+		case idCodecID:
+			t.Codec = readString(e.data)
+		case idCodecPrivate:
+			t.private = e.data
+		case idLanguage:
+			t.Language = readString(e.data)
+		case idLanguageIETF:
+			ietf = readString(e.data)
+		case idName:
+			t.Name = readString(e.data)
+		case idFlagDefault:
+			t.Default = readUint(e.data) == 1
+		case idFlagForced:
+			t.Forced = readUint(e.data) == 1
+		case idContentEncodings:
+			t.parseEncodings(e.data)
+		}
+	}
+	if typ != trackTypeSubtitle || t.Number == 0 {
+		return nil
+	}
+	if t.Language == "und" && ietf != "" {
+		t.Language = ietf
+	}
+
+	switch t.Codec {
+	case "S_TEXT/UTF8", "S_TEXT/ASCII", "S_TEXT/WEBVTT":
+		t.Format, t.Text = FormatSRT, true
+	case "S_TEXT/ASS", "S_TEXT/SSA", "S_ASS", "S_SSA":
+		t.Format, t.Text = FormatASS, true
+	case "S_VOBSUB":
+		t.Format = FormatVobSub
+	case "S_HDMV/PGS":
+		t.Format = FormatPGS
+	default:
+		t.Format = strings.ToLower(strings.TrimPrefix(t.Codec, "S_"))
+	}
+
+	return t
+}
+
+func (t *mkvTrack) parseEncodings(b []byte) {
+	for _, encoding := range parseElements(b) {
+		if encoding.id != idContentEncoding {
+			continue
+		}
+		for _, compression := range parseElements(encoding.data) {
+			if compression.id != idContentCompression {
+				continue
+			}
+			t.compression = compressionZlib
+			for _, e := range parseElements(compression.data) {
+				switch e.id {
+				case idContentCompAlgo:
+					t.compression = int(readUint(e.data))
+				case idContentCompSettings:
+					t.stripped = e.data
+				}
+			}
+		}
+	}
+}
+
+func (m *mkvFile) subtitles() []*Track {
+	ret := make([]*Track, 0, len(m.tracks))
+	for _, t := range m.tracks {
+		ret = append(ret, t.Track)
+	}
+	return ret
+}
+
+// extract reads subtitle blocks, located with Cues, only clusters with the track blocks are read
+func (m *mkvFile) extract(track *Track) ([]*cue, string, error) {
+	var t *mkvTrack
+	for _, mt := range m.tracks {
+		if mt.Track == track {
+			t = mt
+		}
+	}
+	if t == nil || m.cues == 0 {
+		return nil, "", ErrNoIndex
+	}
+
+	positions, err := m.readCues(t.Number)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(positions) == 0 {
+		return nil, "", ErrNoIndex
+	}
+
+	ret := []*cue{}
+	clusters := map[int64]int64{}
+	scanned := map[int64]bool{}
+	for _, p := range positions {
+		data, ok := clusters[p.cluster]
+		if !ok {
+			if data, err = m.clusterData(p.cluster); err != nil {
+				log.Debugf("Could not read Matroska cluster at %d: %s", p.cluster, err)
+				continue
+			}
+			clusters[p.cluster] = data
+		}
+
+		if p.relative == 0 {
+			// Old muxers don't write relative positions, the whole cluster is read then
+			if scanned[p.cluster] {
+				continue
+			}
+			scanned[p.cluster] = true
+			cues, err := m.scanCluster(t, p.cluster, data)
+			if err != nil {
+				log.Debugf("Could not read Matroska cluster at %d: %s", p.cluster, err)
+			}
+			ret = append(ret, cues...)
+			continue
+		}
+
+		c, err := m.readBlock(t, data+p.relative, p)
+		if err != nil {
+			log.Debugf("Could not read Matroska block at %d: %s", data+p.relative, err)
+			continue
+		}
+		if c != nil {
+			ret = append(ret, c)
+		}
+	}
+
+	return ret, string(t.private), nil
+}
+
+func (m *mkvFile) readCues(number int) ([]*mkvCue, error) {
+	header, err := readAt(m.src, m.cues, maxHeaderLength)
+	if err != nil {
+		return nil, err
+	}
+	id, size, n := readElementHeader(header)
+	if id != idCues || size <= 0 || size > maxElementSize {
+		return nil, ErrNoIndex
+	}
+	b, err := readAt(m.src, m.cues+int64(n), size)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []*mkvCue{}
+	for _, point := range parseElements(b) {
+		if point.id != idCuePoint {
+			continue
+		}
+		var cueTime uint64
+		positions := []*mkvCue{}
+		for _, e := range parseElements(point.data) {
+			switch e.id {
+			case idCueTime:
+				cueTime = readUint(e.data)
+			case idCueTrackPositions:
+				p := &mkvCue{cluster: -1}
+				track := 0
+				for _, pe := range parseElements(e.data) {
+					switch pe.id {
+					case idCueTrack:
+						track = int(readUint(pe.data))
+					case idCueClusterPosition:
+						p.cluster = m.segment + int64(readUint(pe.data))
+					case idCueRelativePosition:
+						p.relative = int64(readUint(pe.data))
+					case idCueDuration:
+						p.duration = readUint(pe.data)
+					}
+				}
+				if track == number && p.cluster >= 0 {
+					positions = append(positions, p)
+				}
+			}
+		}
+		for _, p := range positions {
+			p.time = cueTime
+			ret = append(ret, p)
+		}
+	}
+
+	return ret, nil
+}
+
+// clusterData returns offset of the cluster data, block positions are relative to it
+func (m *mkvFile) clusterData(pos int64) (int64, error) {
+	header, err := readAt(m.src, pos, maxHeaderLength)
+	if err != nil {
+		return 0, err
+	}
+	id, _, n := readElementHeader(header)
+	if id != idCluster {
+		return 0, fmt.Errorf("Wrong cluster element %x", id)
+	}
+	return pos + int64(n), nil
+}
+
+// readBlock reads SimpleBlock or BlockGroup at the position
+func (m *mkvFile) readBlock(t *mkvTrack, pos int64, p *mkvCue) (*cue, error) {
+	b, err := readAt(m.src, pos, mkvBlockRead)
+	if err != nil {
+		return nil, err
+	}
+	id, size, n := readElementHeader(b)
+	if n == 0 || size < 0 || size > maxElementSize {
+		return nil, errors.New("Wrong block element")
+	}
+	if int64(n)+size > int64(len(b)) {
+		if b, err = readAt(m.src, pos, int64(n)+size); err != nil {
+			return nil, err
+		}
+	}
+	if int64(n)+size > int64(len(b)) {
+		return nil, errors.New("Block is truncated")
+	}
+
+	e := &ebmlElement{id: id, data: b[n : int64(n)+size]}
+	start := time.Duration(p.time * m.scale)
+	c, err := m.decodeBlock(t, e, start, false)
+	if c != nil && c.end <= c.start && p.duration > 0 {
+		c.end = start + time.Duration(p.duration*m.scale)
+	}
+	return c, err
+}
+
+// scanCluster reads the whole cluster and decodes all blocks of the track
+func (m *mkvFile) scanCluster(t *mkvTrack, pos int64, data int64) ([]*cue, error) {
+	header, err := readAt(m.src, pos, maxHeaderLength)
+	if err != nil {
+		return nil, err
+	}
+	_, size, _ := readElementHeader(header)
+	if size < 0 || size > maxClusterSize {
+		return nil, errors.New("Cluster is too large")
+	}
+	b, err := readAt(m.src, data, size)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []*cue{}
+	var timecode uint64
+	for _, e := range parseElements(b) {
+		switch e.id {
+		case idTimecode:
+			timecode = readUint(e.data)
+		case idSimpleBlock, idBlockGroup:
+			c, err := m.decodeBlock(t, e, time.Duration(timecode*m.scale), true)
+			if err != nil {
+				return ret, err
+			}
+			if c != nil {
+				ret = append(ret, c)
+			}
+		}
+	}
+	return ret, nil
+}
+
+// decodeBlock returns subtitle of the block, or nil for blocks of other tracks.
+// Relative timecode of the block is added to the start for blocks found by cluster scan,
+// blocks found by Cues already have their own time
+func (m *mkvFile) decodeBlock(t *mkvTrack, e *ebmlElement, start time.Duration, relative bool) (*cue, error) {
+	block := e.data
+	var duration uint64
+	if e.id == idBlockGroup {
+		block = nil
+		for _, be := range parseElements(e.data) {
+			switch be.id {
+			case idBlock:
+				block = be.data
+			case idBlockDuration:
+				duration = readUint(be.data)
+			}
+		}
+	} else if e.id != idSimpleBlock {
+		return nil, fmt.Errorf("Wrong block element %x", e.id)
+	}
+
+	track, n := readVint(block, false)
+	if n == 0 || len(block) < n+3 {
+		return nil, errors.New("Wrong block header")
+	}
+	if int(track) != t.Number {
+		return nil, nil
+	}
+	if relative {
+		start += time.Duration(int64(int16(binary.BigEndian.Uint16(block[n:])))) * time.Duration(m.scale)
+	}
+
+	payload, err := t.decode(block[n+3:])
+	if err != nil {
+		return nil, err
+	}
+
+	c := &cue{
+		start: start,
+		text:  strings.TrimRight(string(payload), "\x00"),
+	}
+	if duration > 0 {
+		c.end = start + time.Duration(duration*m.scale)
+	}
+	if t.Format == FormatASS {
+		if i := strings.Index(c.text, ","); i > 0 {
+			c.order, _ = strconv.Atoi(c.text[:i])
+		}
+	}
+	return c, nil
+}
+
+// decode removes content compression of the block
+func (t *mkvTrack) decode(b []byte) ([]byte, error) {
+	switch t.compression {
+	case compressionZlib:
+		r, err := zlib.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	case compressionHeader:
+		return append(append([]byte{}, t.stripped...), b...), nil
+	}
+	return b, nil
+}
+
+// readElementHeader returns ID, size and length of the header, size is -1 for unknown size
+func readElementHeader(b []byte) (id uint32, size int64, n int) {
+	v, idLen := readVint(b, true)
+	if idLen == 0 || idLen > 4 {
+		return 0, 0, 0
+	}
+	s, sizeLen := readVint(b[idLen:], false)
+	if sizeLen == 0 {
+		return 0, 0, 0
+	}
+
+	size = int64(s)
+	if s == (1<<uint(7*sizeLen))-1 {
+		size = -1
+	}
+	return uint32(v), size, idLen + sizeLen
+}
+
+// readVint reads EBML variable length integer, IDs keep their length marker
+func readVint(b []byte, marker bool) (uint64, int) {
+	if len(b) == 0 || b[0] == 0 {
+		return 0, 0
+	}
+
+	length := 1
+	for mask := byte(0x80); b[0]&mask == 0; mask >>= 1 {
+		length++
+	}
+	if length > 8 || length > len(b) {
+		return 0, 0
+	}
+
+	v := uint64(b[0])
+	if !marker {
+		v &= uint64(0xff >> uint(length))
+	}
+	for i := 1; i < length; i++ {
+		v = v<<8 | uint64(b[i])
+	}
+	return v, length
+}
+
+// parseElements returns children of the master element, elements with unknown size are skipped
+func parseElements(b []byte) []*ebmlElement {
+	ret := []*ebmlElement{}
+	for len(b) > 0 {
+		id, size, n := readElementHeader(b)
+		if n == 0 || size < 0 || int64(n)+size > int64(len(b)) {
+			break
+		}
+		ret = append(ret, &ebmlElement{id: id, data: b[n : int64(n)+size]})
+		b = b[int64(n)+size:]
+	}
+	return ret
+}
+
+func readUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+func readString(b []byte) string {
+	return strings.TrimRight(string(b), "\x00")
+}
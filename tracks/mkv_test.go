@@ -0,0 +1,254 @@
+package tracks
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// memorySource is a Source of the file in memory
+type memorySource []byte
+
+func (m memorySource) Open(offset, length int64) (io.ReadCloser, error) {
+	if offset < 0 || offset+length > int64(len(m)) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return ioutil.NopCloser(bytes.NewReader(m[offset : offset+length])), nil
+}
+
+func (m memorySource) Length() int64 {
+	return int64(len(m))
+}
+
+// ebml builds Matroska element, size is always written in 4 bytes,
+// so positions of elements do not depend on their contents
+func ebml(id uint32, data ...[]byte) []byte {
+	d := bytes.Join(data, nil)
+	ret := ebmlID(id)
+	ret = append(ret, 0x10, byte(len(d)>>16), byte(len(d)>>8), byte(len(d)))
+	return append(ret, d...)
+}
+
+func ebmlID(id uint32) []byte {
+	switch {
+	case id > 0xFFFFFF:
+		return []byte{byte(id >> 24), byte(id >> 16), byte(id >> 8), byte(id)}
+	case id > 0xFFFF:
+		return []byte{byte(id >> 16), byte(id >> 8), byte(id)}
+	case id > 0xFF:
+		return []byte{byte(id >> 8), byte(id)}
+	}
+	return []byte{byte(id)}
+}
+
+func ebmlUint(v uint64) []byte {
+	return []byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}
+}
+
+// mkvSubtitle is a subtitle block, time is relative to the cluster
+type mkvSubtitle struct {
+	time     int
+	duration uint64
+	payload  string
+}
+
+// buildMKV returns a file with a video track and a subtitle track number 3 in a single cluster,
+// cues point to subtitle blocks, or to the cluster only, like old muxers write them
+func buildMKV(codec string, encoding []byte, subtitles []mkvSubtitle, relative bool) []byte {
+	const clusterTime = 1000
+
+	subtitleTrack := [][]byte{
+		ebml(idTrackNumber, ebmlUint(3)),
+		ebml(idTrackType, ebmlUint(trackTypeSubtitle)),
+		ebml(idCodecID, []byte(codec)),
+		ebml(idLanguage, []byte("ger")),
+		ebml(idName, []byte("Forced")),
+		ebml(idFlagDefault, ebmlUint(0)),
+		ebml(idFlagForced, ebmlUint(1)),
+	}
+	if codec == "S_TEXT/ASS" {
+		subtitleTrack = append(subtitleTrack, ebml(idCodecPrivate, []byte("[Script Info]\r\nTitle: Test")))
+	}
+	if encoding != nil {
+		subtitleTrack = append(subtitleTrack, encoding)
+	}
+
+	info := ebml(idInfo, ebml(idTimecodeScale, ebmlUint(1000000)))
+	tracks := ebml(idTracks,
+		ebml(idTrackEntry, ebml(idTrackNumber, ebmlUint(1)), ebml(idTrackType, ebmlUint(1)), ebml(idCodecID, []byte("V_MPEG4/ISO/AVC"))),
+		ebml(idTrackEntry, subtitleTrack...),
+	)
+
+	clusterData := [][]byte{
+		ebml(idTimecode, ebmlUint(clusterTime)),
+		ebml(idSimpleBlock, []byte{0x81, 0, 0, 0x80}, []byte("video")),
+	}
+	offsets := []int{}
+	size := len(clusterData[0]) + len(clusterData[1])
+	for _, s := range subtitles {
+		block := ebml(idBlockGroup,
+			ebml(idBlock, []byte{0x83, byte(s.time >> 8), byte(s.time), 0}, []byte(s.payload)),
+			ebml(idBlockDuration, ebmlUint(s.duration)),
+		)
+		offsets = append(offsets, size)
+		size += len(block)
+		clusterData = append(clusterData, block)
+	}
+	cluster := ebml(idCluster, clusterData...)
+
+	// SeekHead has the same size for any position, it is written before other elements
+	seekHeadSize := len(ebml(idSeekHead, ebml(idSeek, ebml(idSeekID, ebmlID(idCues)), ebml(idSeekPosition, ebmlUint(0)))))
+	clusterPosition := uint64(seekHeadSize + len(info) + len(tracks))
+
+	points := [][]byte{}
+	for i, s := range subtitles {
+		positions := [][]byte{ebml(idCueTrack, ebmlUint(3)), ebml(idCueClusterPosition, ebmlUint(clusterPosition))}
+		if relative {
+			positions = append(positions, ebml(idCueRelativePosition, ebmlUint(uint64(offsets[i]))))
+		}
+		points = append(points, ebml(idCuePoint, ebml(idCueTime, ebmlUint(uint64(clusterTime+s.time))), ebml(idCueTrackPositions, positions...)))
+	}
+	cues := ebml(idCues, points...)
+
+	seekHead := ebml(idSeekHead, ebml(idSeek, ebml(idSeekID, ebmlID(idCues)), ebml(idSeekPosition, ebmlUint(clusterPosition+uint64(len(cluster))))))
+	return append(ebml(idEBML, ebml(0x4282, []byte("matroska"))), ebml(idSegment, seekHead, info, tracks, cluster, cues)...)
+}
+
+func TestMKVExtract(t *testing.T) {
+	subtitles := []mkvSubtitle{
+		{time: 0, duration: 1500, payload: "Hello"},
+		{time: 2000, duration: 1500, payload: "World"},
+	}
+	srt := "1\r\n00:00:01,000 --> 00:00:02,500\r\nHello\r\n\r\n2\r\n00:00:03,000 --> 00:00:04,500\r\nWorld\r\n\r\n"
+	stripped := ebml(idContentEncodings, ebml(idContentEncoding, ebml(idContentCompression,
+		ebml(idContentCompAlgo, ebmlUint(compressionHeader)),
+		ebml(idContentCompSettings, []byte("Wor")),
+	)))
+
+	tests := []struct {
+		name      string
+		file      []byte
+		format    string
+		want      string
+		wantError error
+	}{
+		{
+			name:   "blocks from cues",
+			file:   buildMKV("S_TEXT/UTF8", nil, subtitles, true),
+			format: FormatSRT,
+			want:   srt,
+		},
+		{
+			name:   "cluster scan",
+			file:   buildMKV("S_TEXT/UTF8", nil, subtitles, false),
+			format: FormatSRT,
+			want:   srt,
+		},
+		{
+			name:   "header stripping",
+			file:   buildMKV("S_TEXT/UTF8", stripped, []mkvSubtitle{{time: 500, payload: "ld"}}, true),
+			format: FormatSRT,
+			want:   "1\r\n00:00:01,500 --> 00:00:11,500\r\nWorld\r\n\r\n",
+		},
+		{
+			name:   "ass events",
+			file:   buildMKV("S_TEXT/ASS", nil, []mkvSubtitle{{time: 0, duration: 2000, payload: "1,0,Default,,0,0,0,,Hi, there"}}, true),
+			format: FormatASS,
+			want:   "[Script Info]\r\nTitle: Test\r\n\r\n[Events]\r\nFormat: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\r\nDialogue: 0,0:00:01.00,0:00:03.00,Default,,0,0,0,,Hi, there\r\n",
+		},
+		{
+			name:      "bitmap subtitles",
+			file:      buildMKV("S_HDMV/PGS", nil, subtitles, true),
+			wantError: ErrNotText,
+		},
+		{
+			name:      "no cues",
+			file:      buildMKV("S_TEXT/UTF8", nil, nil, true),
+			wantError: ErrNoIndex,
+		},
+	}
+
+	for _, test := range tests {
+		f, err := Probe("movie.mkv", memorySource(test.file))
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+
+		subs := f.Subtitles()
+		if len(subs) != 1 {
+			t.Errorf("%s: found %d subtitle tracks, expected 1", test.name, len(subs))
+			continue
+		}
+		if s := subs[0]; s.Number != 3 || s.Language != "ger" || s.Name != "Forced" || s.Default || !s.Forced {
+			t.Errorf("%s: wrong track %+v", test.name, s)
+		}
+
+		var w bytes.Buffer
+		format, err := f.Extract(3, &w)
+		if err != test.wantError {
+			t.Errorf("%s: error is %v, expected %v", test.name, err, test.wantError)
+			continue
+		}
+		if format != test.format || w.String() != test.want {
+			t.Errorf("%s: extracted %s\n%q\nexpected %s\n%q", test.name, format, w.String(), test.format, test.want)
+		}
+	}
+}
+
+func TestMKVMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		file []byte
+	}{
+		{"empty", []byte{}},
+		{"zero byte", []byte{0}},
+		{"not matroska", []byte("RIFF....AVI LIST")},
+		{"no segment", ebml(idEBML, ebml(0x4282, []byte("matroska")))},
+		{"huge header", append(ebmlID(idEBML), 0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFE)},
+	}
+	for _, test := range tests {
+		if _, err := Probe("movie.mkv", memorySource(test.file)); err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+	}
+
+	// Truncated files return error or tracks, which are found before the cut, without panics
+	file := buildMKV("S_TEXT/UTF8", nil, []mkvSubtitle{{time: 0, duration: 1500, payload: "Hello"}}, true)
+	for cut := 0; cut < len(file); cut++ {
+		f, err := Probe("movie.mkv", memorySource(file[:cut]))
+		if err != nil {
+			continue
+		}
+		for _, s := range f.Subtitles() {
+			f.Extract(s.Number, ioutil.Discard)
+		}
+	}
+}
+
+func TestReadElementHeader(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+		id   uint32
+		size int64
+		n    int
+	}{
+		{"one byte id", []byte{0xA3, 0x85}, idSimpleBlock, 5, 2},
+		{"four byte id", []byte{0x1A, 0x45, 0xDF, 0xA3, 0x42, 0x00}, idEBML, 0x200, 6},
+		{"unknown size", []byte{0x18, 0x53, 0x80, 0x67, 0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}, idSegment, -1, 12},
+		{"truncated size", []byte{0x1A, 0x45, 0xDF, 0xA3, 0x42}, 0, 0, 0},
+		{"truncated id", []byte{0x1A, 0x45}, 0, 0, 0},
+		{"too long id", []byte{0x08, 0x00, 0x00, 0x00, 0x00, 0x81}, 0, 0, 0},
+		{"zero byte", []byte{0x00, 0x81}, 0, 0, 0},
+		{"empty", nil, 0, 0, 0},
+	}
+
+	for _, test := range tests {
+		id, size, n := readElementHeader(test.b)
+		if id != test.id || size != test.size || n != test.n {
+			t.Errorf("%s: read %x, %d, %d, expected %x, %d, %d", test.name, id, size, n, test.id, test.size, test.n)
+		}
+	}
+}
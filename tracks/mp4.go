@@ -0,0 +1,377 @@
+package tracks
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	maxMoovSize   = 64 * 1024 * 1024
+	maxSampleSize = 64 * 1024
+)
+
+// mp4Box is a parsed box
+type mp4Box struct {
+	typ     string
+	payload []byte
+}
+
+// mp4Track is a text track with resolved sample positions
+type mp4Track struct {
+	*Track
+	timescale uint32
+
+	offsets   []int64
+	sizes     []uint32
+	starts    []uint64
+	durations []uint32
+}
+
+// mp4File is a parsed MP4 index
+type mp4File struct {
+	src    Source
+	tracks []*mp4Track
+}
+
+func probeMP4(src Source) (*mp4File, error) {
+	m := &mp4File{
+		src: src,
+	}
+
+	var moov []byte
+	for offset := int64(0); offset+8 <= src.Length(); {
+		header, err := readAt(src, offset, 16)
+		if err != nil {
+			return nil, err
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[0:4]))
+		typ := string(header[4:8])
+		headerSize := int64(8)
+		if size == 1 && len(header) >= 16 {
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		} else if size == 0 {
+			size = src.Length() - offset
+		}
+		if size < headerSize {
+			return nil, fmt.Errorf("Wrong size of %s box", typ)
+		}
+
+		if typ == "moov" {
+			if size > maxMoovSize {
+				return nil, errors.New("MP4 index is too large")
+			}
+			if moov, err = readAt(src, offset+headerSize, size-headerSize); err != nil {
+				return nil, err
+			}
+			break
+		}
+
+		offset += size
+	}
+	if moov == nil {
+		return nil, errors.New("Could not find MP4 index")
+	}
+
+	number := 0
+	for _, b := range parseBoxes(moov) {
+		if b.typ != "trak" {
+			continue
+		}
+		number++
+		if t := parseMP4Track(b.payload, number); t != nil {
+			m.tracks = append(m.tracks, t)
+		}
+	}
+
+	return m, nil
+}
+
+// parseMP4Track returns subtitle track, number is used when track header has no ID
+func parseMP4Track(trak []byte, number int) *mp4Track {
+	tkhd := findPath(trak, "tkhd")
+	mdhd := findPath(trak, "mdia", "mdhd")
+	hdlr := findPath(trak, "mdia", "hdlr")
+	stbl := findPath(trak, "mdia", "minf", "stbl")
+	if tkhd == nil || mdhd == nil || hdlr == nil || stbl == nil || len(tkhd.payload) < 24 || len(mdhd.payload) < 24 || len(hdlr.payload) < 12 {
+		return nil
+	}
+
+	switch string(hdlr.payload[8:12]) {
+	case "text", "sbtl", "subt":
+	default:
+		return nil
+	}
+
+	t := &mp4Track{
+		Track: &Track{},
+	}
+	if tkhd.payload[0] == 1 {
+		t.Number = int(binary.BigEndian.Uint32(tkhd.payload[20:24]))
+	} else {
+		t.Number = int(binary.BigEndian.Uint32(tkhd.payload[12:16]))
+	}
+	if t.Number == 0 {
+		t.Number = number
+	}
+	// Track is enabled, when it should be displayed by default
+	t.Default = tkhd.payload[3]&1 == 1
+
+	var lang uint16
+	if mdhd.payload[0] == 1 && len(mdhd.payload) >= 34 {
+		t.timescale = binary.BigEndian.Uint32(mdhd.payload[20:24])
+		lang = binary.BigEndian.Uint16(mdhd.payload[32:34])
+	} else {
+		t.timescale = binary.BigEndian.Uint32(mdhd.payload[12:16])
+		lang = binary.BigEndian.Uint16(mdhd.payload[20:22])
+	}
+	t.Language = parseLanguage(lang)
+	if len(hdlr.payload) > 24 {
+		if name := strings.TrimRight(string(hdlr.payload[24:]), "\x00"); !strings.HasPrefix(name, "Core Media") {
+			t.Name = name
+		}
+	}
+
+	boxes := parseBoxes(stbl.payload)
+	if stsd := findBox(boxes, "stsd"); stsd != nil && len(stsd.payload) >= 16 {
+		t.Codec = string(stsd.payload[12:16])
+	}
+	switch t.Codec {
+	case "tx3g", "wvtt":
+		t.Format, t.Text = FormatSRT, true
+	default:
+		t.Format = t.Codec
+	}
+
+	if t.Text {
+		if err := t.parseSampleTables(boxes); err != nil || t.timescale == 0 {
+			log.Debugf("MP4 subtitle track %d has no samples: %v", t.Number, err)
+			t.Text = false
+		}
+	}
+
+	return t
+}
+
+func (m *mp4File) subtitles() []*Track {
+	ret := make([]*Track, 0, len(m.tracks))
+	for _, t := range m.tracks {
+		ret = append(ret, t.Track)
+	}
+	return ret
+}
+
+// extract reads text samples of the track, only pieces with samples are read
+func (m *mp4File) extract(track *Track) ([]*cue, error) {
+	var t *mp4Track
+	for _, mt := range m.tracks {
+		if mt.Track == track {
+			t = mt
+		}
+	}
+	if t == nil {
+		return nil, ErrNoIndex
+	}
+
+	ret := []*cue{}
+	for i, offset := range t.offsets {
+		// Empty samples are gaps between subtitles
+		if t.sizes[i] <= 2 || t.sizes[i] > maxSampleSize {
+			continue
+		}
+
+		b, err := readAt(m.src, offset, int64(t.sizes[i]))
+		if err != nil {
+			log.Debugf("Could not read MP4 sample at %d: %s", offset, err)
+			continue
+		}
+
+		var text string
+		if t.Codec == "wvtt" {
+			lines := []string{}
+			for _, vttc := range parseBoxes(b) {
+				if vttc.typ != "vttc" {
+					continue
+				}
+				if payl := findBox(parseBoxes(vttc.payload), "payl"); payl != nil {
+					lines = append(lines, string(payl.payload))
+				}
+			}
+			text = strings.Join(lines, "\n")
+		} else if len(b) >= 2 {
+			length := int(binary.BigEndian.Uint16(b[0:2]))
+			if length+2 > len(b) {
+				length = len(b) - 2
+			}
+			text = string(b[2 : 2+length])
+		}
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+
+		ret = append(ret, &cue{
+			start: t.duration(t.starts[i]),
+			end:   t.duration(t.starts[i] + uint64(t.durations[i])),
+			order: i,
+			text:  text,
+		})
+	}
+
+	return ret, nil
+}
+
+func (t *mp4Track) duration(ts uint64) time.Duration {
+	return time.Duration(float64(ts) / float64(t.timescale) * float64(time.Second))
+}
+
+func (t *mp4Track) parseSampleTables(boxes []*mp4Box) error {
+	// Sample sizes
+	stsz := findBox(boxes, "stsz")
+	if stsz == nil || len(stsz.payload) < 12 {
+		return errors.New("Track has no sample sizes")
+	}
+	size := binary.BigEndian.Uint32(stsz.payload[4:8])
+	count := int(binary.BigEndian.Uint32(stsz.payload[8:12]))
+	for i := 0; i < count; i++ {
+		if size != 0 {
+			t.sizes = append(t.sizes, size)
+		} else if 12+4*i+4 <= len(stsz.payload) {
+			t.sizes = append(t.sizes, binary.BigEndian.Uint32(stsz.payload[12+4*i:]))
+		}
+	}
+	count = len(t.sizes)
+
+	// Decoding times, text samples have no composition offsets
+	stts := findBox(boxes, "stts")
+	if stts == nil || len(stts.payload) < 8 {
+		return errors.New("Track has no decoding times")
+	}
+	var ts uint64
+	for i, entries := 0, int(binary.BigEndian.Uint32(stts.payload[4:8])); i < entries && 16+8*i <= len(stts.payload); i++ {
+		n := int(binary.BigEndian.Uint32(stts.payload[8+8*i:]))
+		delta := binary.BigEndian.Uint32(stts.payload[12+8*i:])
+		for j := 0; j < n && len(t.starts) < count; j++ {
+			t.starts = append(t.starts, ts)
+			t.durations = append(t.durations, delta)
+			ts += uint64(delta)
+		}
+	}
+	for len(t.starts) < count {
+		t.starts = append(t.starts, ts)
+		t.durations = append(t.durations, 0)
+	}
+
+	// Chunk offsets
+	chunks := []int64{}
+	if b := findBox(boxes, "stco"); b != nil && len(b.payload) >= 8 {
+		for i, entries := 0, int(binary.BigEndian.Uint32(b.payload[4:8])); i < entries && 12+4*i <= len(b.payload); i++ {
+			chunks = append(chunks, int64(binary.BigEndian.Uint32(b.payload[8+4*i:])))
+		}
+	} else if b := findBox(boxes, "co64"); b != nil && len(b.payload) >= 8 {
+		for i, entries := 0, int(binary.BigEndian.Uint32(b.payload[4:8])); i < entries && 16+8*i <= len(b.payload); i++ {
+			chunks = append(chunks, int64(binary.BigEndian.Uint64(b.payload[8+8*i:])))
+		}
+	}
+
+	// Sample offsets, from samples to chunk mapping
+	stsc := findBox(boxes, "stsc")
+	if stsc == nil || len(stsc.payload) < 8 || len(chunks) == 0 {
+		return errors.New("Track has no chunks")
+	}
+	type stscEntry struct {
+		first, samples int
+	}
+	entries := []stscEntry{}
+	for i, n := 0, int(binary.BigEndian.Uint32(stsc.payload[4:8])); i < n && 20+12*i <= len(stsc.payload); i++ {
+		entries = append(entries, stscEntry{
+			first:   int(binary.BigEndian.Uint32(stsc.payload[8+12*i:])),
+			samples: int(binary.BigEndian.Uint32(stsc.payload[12+12*i:])),
+		})
+	}
+
+	sample := 0
+	for e, entry := range entries {
+		// Chunks are numbered from 1
+		if entry.first < 1 {
+			continue
+		}
+		last := len(chunks)
+		if e+1 < len(entries) {
+			last = entries[e+1].first - 1
+		}
+		for chunk := entry.first; chunk <= last && chunk <= len(chunks); chunk++ {
+			offset := chunks[chunk-1]
+			for j := 0; j < entry.samples && sample < count; j++ {
+				t.offsets = append(t.offsets, offset)
+				offset += int64(t.sizes[sample])
+				sample++
+			}
+		}
+	}
+	if len(t.offsets) != count {
+		return errors.New("Track has wrong chunk mapping")
+	}
+
+	return nil
+}
+
+// parseLanguage decodes packed ISO 639-2/T language code of mdhd box
+func parseLanguage(lang uint16) string {
+	if lang == 0 || lang == 0x7fff {
+		return "und"
+	}
+	return string([]byte{
+		byte(lang>>10&0x1f) + 0x60,
+		byte(lang>>5&0x1f) + 0x60,
+		byte(lang&0x1f) + 0x60,
+	})
+}
+
+func parseBoxes(b []byte) []*mp4Box {
+	ret := []*mp4Box{}
+	for len(b) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(b[0:4]))
+		headerSize := uint64(8)
+		if size == 1 && len(b) >= 16 {
+			size = binary.BigEndian.Uint64(b[8:16])
+			headerSize = 16
+		} else if size == 0 {
+			size = uint64(len(b))
+		}
+		if size < headerSize || size > uint64(len(b)) {
+			break
+		}
+
+		ret = append(ret, &mp4Box{
+			typ:     string(b[4:8]),
+			payload: b[headerSize:size],
+		})
+		b = b[size:]
+	}
+	return ret
+}
+
+func findBox(boxes []*mp4Box, typ string) *mp4Box {
+	for _, b := range boxes {
+		if b.typ == typ {
+			return b
+		}
+	}
+	return nil
+}
+
+// findPath returns nested box, like "mdia", "minf", "stbl"
+func findPath(b []byte, path ...string) *mp4Box {
+	var ret *mp4Box
+	for _, typ := range path {
+		if ret = findBox(parseBoxes(b), typ); ret == nil {
+			return nil
+		}
+		b = ret.payload
+	}
+	return ret
+}
@@ -0,0 +1,215 @@
+package tracks
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+)
+
+// box builds MP4 box of the given type
+func box(typ string, data ...[]byte) []byte {
+	d := bytes.Join(data, nil)
+	ret := make([]byte, 8, 8+len(d))
+	binary.BigEndian.PutUint32(ret, uint32(8+len(d)))
+	copy(ret[4:], typ)
+	return append(ret, d...)
+}
+
+func uint32s(values ...uint32) []byte {
+	ret := make([]byte, 4*len(values))
+	for i, v := range values {
+		binary.BigEndian.PutUint32(ret[4*i:], v)
+	}
+	return ret
+}
+
+// mp4Sample is a subtitle sample with its duration in milliseconds
+type mp4Sample struct {
+	text     string
+	duration uint32
+}
+
+// buildMP4 returns a file with a video track and a subtitle track with ID 2,
+// samples of subtitle track are stored in two chunks
+func buildMP4(codec string, samples []mp4Sample) []byte {
+	ftyp := box("ftyp", []byte("isom"), uint32s(0x200), []byte("isommp41"))
+
+	data := [][]byte{}
+	for _, s := range samples {
+		switch {
+		case s.text == "":
+			data = append(data, []byte{0, 0})
+		case codec == "wvtt":
+			data = append(data, box("vttc", box("payl", []byte(s.text))))
+		default:
+			data = append(data, append([]byte{0, byte(len(s.text))}, s.text...))
+		}
+	}
+	mdat := box("mdat", bytes.Join(data, nil))
+
+	sizes := []uint32{0, 0, uint32(len(samples))}
+	durations := []uint32{0, uint32(len(samples))}
+	for _, s := range data {
+		sizes = append(sizes, uint32(len(s)))
+	}
+	for _, s := range samples {
+		durations = append(durations, 1, s.duration)
+	}
+	// First chunk has one sample, the rest are in the second one
+	first := uint32(len(ftyp) + 8)
+	chunks := uint32s(0, 2, first, first+uint32(len(data[0])))
+	stsc := uint32s(0, 2, 1, 1, 1, 2, uint32(len(samples)-1), 1)
+
+	// English, packed into 5 bits per letter
+	lang := uint32('e'-0x60)<<10 | uint32('n'-0x60)<<5 | uint32('g'-0x60)
+	subtitles := box("trak",
+		box("tkhd", uint32s(1, 0, 0, 2, 0, 0)),
+		box("mdia",
+			box("mdhd", uint32s(0, 0, 0, 1000, 0, lang<<16)),
+			box("hdlr", uint32s(0, 0), []byte("sbtl"), uint32s(0, 0, 0), []byte("Commentary\x00")),
+			box("minf", box("stbl",
+				box("stsd", uint32s(0, 1), box(codec)),
+				box("stsz", uint32s(sizes...)),
+				box("stts", uint32s(durations...)),
+				box("stsc", stsc),
+				box("stco", chunks),
+			)),
+		),
+	)
+	video := box("trak",
+		box("tkhd", uint32s(1, 0, 0, 1, 0, 0)),
+		box("mdia",
+			box("mdhd", uint32s(0, 0, 0, 1000, 0, 0)),
+			box("hdlr", uint32s(0, 0), []byte("vide"), uint32s(0, 0, 0)),
+		),
+	)
+
+	return bytes.Join([][]byte{ftyp, mdat, box("moov", box("mvhd", uint32s(0)), video, subtitles)}, nil)
+}
+
+func TestMP4Extract(t *testing.T) {
+	samples := []mp4Sample{
+		{text: "Hello", duration: 1500},
+		{duration: 1500},
+		{text: "World", duration: 1500},
+	}
+	srt := "1\r\n00:00:00,000 --> 00:00:01,500\r\nHello\r\n\r\n2\r\n00:00:03,000 --> 00:00:04,500\r\nWorld\r\n\r\n"
+
+	tests := []struct {
+		name    string
+		codec   string
+		samples []mp4Sample
+		text    bool
+		want    string
+	}{
+		{"tx3g", "tx3g", samples, true, srt},
+		{"webvtt", "wvtt", samples, true, srt},
+		{"single sample", "tx3g", []mp4Sample{{text: "Hi", duration: 500}}, true, "1\r\n00:00:00,000 --> 00:00:00,500\r\nHi\r\n\r\n"},
+		{"bitmap", "mp4s", samples, false, ""},
+	}
+
+	for _, test := range tests {
+		f, err := Probe("movie.mp4", memorySource(buildMP4(test.codec, test.samples)))
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+
+		subs := f.Subtitles()
+		if len(subs) != 1 {
+			t.Errorf("%s: found %d subtitle tracks, expected 1", test.name, len(subs))
+			continue
+		}
+		if s := subs[0]; s.Number != 2 || s.Language != "eng" || s.Name != "Commentary" || !s.Default || s.Text != test.text {
+			t.Errorf("%s: wrong track %+v", test.name, s)
+		}
+
+		var w bytes.Buffer
+		format, err := f.Extract(2, &w)
+		if !test.text {
+			if err != ErrNotText {
+				t.Errorf("%s: error is %v, expected %v", test.name, err, ErrNotText)
+			}
+			continue
+		}
+		if err != nil || format != FormatSRT || w.String() != test.want {
+			t.Errorf("%s: extracted %s with error %v\n%q\nexpected\n%q", test.name, format, err, w.String(), test.want)
+		}
+	}
+}
+
+func TestMP4Malformed(t *testing.T) {
+	valid := buildMP4("tx3g", []mp4Sample{{text: "Hello", duration: 1500}, {text: "World", duration: 1500}})
+
+	// Sample count, larger than the chunk mapping, leaves the track without samples
+	broken := bytes.Replace(valid, box("stsz", uint32s(0, 0, 2, 7, 7)), box("stsz", uint32s(0, 7, 5, 0, 0)), 1)
+	// Chunks at the end of the file have truncated samples
+	first := uint32(len(box("ftyp", []byte("isom"), uint32s(0x200), []byte("isommp41"))) + 8)
+	outside := bytes.Replace(valid, box("stco", uint32s(0, 2, first, first+7)), box("stco", uint32s(0, 2, uint32(len(valid)-1), uint32(len(valid)+10))), 1)
+	// Chunk numbers start from 1, zero chunk should not be looked up
+	zeroChunk := bytes.Replace(valid, box("stsc", uint32s(0, 2, 1, 1, 1, 2, 1, 1)), box("stsc", uint32s(0, 2, 0, 1, 1, 2, 1, 1)), 1)
+
+	tests := []struct {
+		name    string
+		file    []byte
+		wantErr bool
+		text    bool
+	}{
+		{"empty", []byte{}, true, false},
+		{"no index", box("ftyp", []byte("isom")), true, false},
+		{"wrong box size", append(uint32s(4), []byte("ftyp")...), true, false},
+		{"wrong sample table", broken, false, false},
+		{"samples outside of the file", outside, false, true},
+		{"zero chunk", zeroChunk, false, false},
+	}
+
+	for _, test := range tests {
+		f, err := Probe("movie.mp4", memorySource(test.file))
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: error is %v, expected error: %v", test.name, err, test.wantErr)
+		}
+		if err != nil {
+			continue
+		}
+		for _, s := range f.Subtitles() {
+			if s.Text != test.text {
+				t.Errorf("%s: track %d is text: %v, expected %v", test.name, s.Number, s.Text, test.text)
+			}
+			if s.Text {
+				if _, err := f.Extract(s.Number, ioutil.Discard); err != nil {
+					t.Errorf("%s: %s", test.name, err)
+				}
+			}
+		}
+	}
+
+	// Truncated files return error or tracks, which are found before the cut, without panics
+	for cut := 0; cut < len(valid); cut++ {
+		f, err := Probe("movie.mp4", memorySource(valid[:cut]))
+		if err != nil {
+			continue
+		}
+		for _, s := range f.Subtitles() {
+			f.Extract(s.Number, ioutil.Discard)
+		}
+	}
+}
+
+func TestParseLanguage(t *testing.T) {
+	tests := []struct {
+		lang uint16
+		want string
+	}{
+		{0x15C7, "eng"},
+		{0x2A0E, "jpn"},
+		{0, "und"},
+		{0x7fff, "und"},
+	}
+
+	for _, test := range tests {
+		if got := parseLanguage(test.lang); got != test.want {
+			t.Errorf("%x: language is %s, expected %s", test.lang, got, test.want)
+		}
+	}
+}
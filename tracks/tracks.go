@@ -0,0 +1,224 @@
+package tracks
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/op/go-logging"
+)
+
+const (
+	// FormatSRT is a SubRip text subtitle
+	FormatSRT = "srt"
+	// FormatASS is an Advanced SubStation Alpha text subtitle
+	FormatASS = "ass"
+	// FormatVobSub is a DVD bitmap subtitle, it is listed, but can't be extracted
+	FormatVobSub = "vobsub"
+	// FormatPGS is a Blu-ray bitmap subtitle, it is listed, but can't be extracted
+	FormatPGS = "pgs"
+
+	// maxGap limits duration of cues, which have no duration in the file
+	maxGap = 10 * time.Second
+)
+
+var log = logging.MustGetLogger("tracks")
+
+var (
+	// ErrUnsupported is returned for files, that are not MKV or MP4
+	ErrUnsupported = errors.New("File format is not supported for embedded subtitles")
+	// ErrNotText is returned when bitmap subtitle is requested for extraction
+	ErrNotText = errors.New("Subtitle track is not a text track")
+	// ErrNoIndex is returned when subtitle blocks can't be located without reading the whole file
+	ErrNoIndex = errors.New("File has no index for subtitle track")
+)
+
+// Source gives access to the file contents. Open returns a reader of the given range,
+// so underlying storage can prioritise downloading of needed pieces.
+type Source interface {
+	Open(offset, length int64) (io.ReadCloser, error)
+	Length() int64
+}
+
+// Track is a subtitle track, embedded into the file
+type Track struct {
+	Number   int    `json:"number"`
+	Codec    string `json:"codec"`
+	Format   string `json:"format"`
+	Language string `json:"language"`
+	Name     string `json:"name"`
+	Default  bool   `json:"default"`
+	Forced   bool   `json:"forced"`
+	Text     bool   `json:"text"`
+}
+
+// File is a probed MKV or MP4 file
+type File struct {
+	mu sync.Mutex
+
+	src Source
+	mkv *mkvFile
+	mp4 *mp4File
+
+	tracks []*Track
+}
+
+// cue is a single subtitle, times are from the start of the file
+type cue struct {
+	start time.Duration
+	end   time.Duration
+	order int
+	text  string
+}
+
+// Probe reads track headers of the file, file contents are not read
+func Probe(name string, src Source) (f *File, err error) {
+	f = &File{src: src}
+
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".mkv", ".mka", ".mks", ".webm":
+		if f.mkv, err = probeMKV(src); err == nil {
+			f.tracks = f.mkv.subtitles()
+		}
+	case ".mp4", ".m4v", ".mov":
+		if f.mp4, err = probeMP4(src); err == nil {
+			f.tracks = f.mp4.subtitles()
+		}
+	default:
+		return nil, ErrUnsupported
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Subtitles returns subtitle tracks of the file
+func (f *File) Subtitles() []*Track {
+	return f.tracks
+}
+
+// Extract reads subtitle blocks of the track and writes them as a subtitle file,
+// returned extension is the format of the written file
+func (f *File) Extract(number int, w io.Writer) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var track *Track
+	for _, t := range f.tracks {
+		if t.Number == number {
+			track = t
+		}
+	}
+	if track == nil {
+		return "", fmt.Errorf("Subtitle track %d not found", number)
+	}
+	if !track.Text {
+		return "", ErrNotText
+	}
+
+	var cues []*cue
+	var header string
+	var err error
+	if f.mkv != nil {
+		cues, header, err = f.mkv.extract(track)
+	} else {
+		cues, err = f.mp4.extract(track)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	sort.SliceStable(cues, func(i, j int) bool {
+		if cues[i].start == cues[j].start {
+			return cues[i].order < cues[j].order
+		}
+		return cues[i].start < cues[j].start
+	})
+	for i, c := range cues {
+		if c.end > c.start {
+			continue
+		}
+		c.end = c.start + maxGap
+		if i+1 < len(cues) && cues[i+1].start > c.start && cues[i+1].start < c.end {
+			c.end = cues[i+1].start
+		}
+	}
+
+	log.Infof("Extracted %d subtitles from track %d", len(cues), number)
+	if track.Format == FormatASS {
+		return FormatASS, writeASS(w, header, cues)
+	}
+	return FormatSRT, writeSRT(w, cues)
+}
+
+func writeSRT(w io.Writer, cues []*cue) error {
+	for i, c := range cues {
+		if _, err := fmt.Fprintf(w, "%d\r\n%s --> %s\r\n%s\r\n\r\n", i+1, srtTime(c.start), srtTime(c.end), strings.TrimSpace(c.text)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeASS writes header from the file, and events, which are stored without start and end times
+func writeASS(w io.Writer, header string, cues []*cue) error {
+	header = strings.TrimSpace(header)
+	if !strings.Contains(header, "[Events]") {
+		header += "\r\n\r\n[Events]\r\nFormat: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text"
+	}
+	if _, err := fmt.Fprintf(w, "%s\r\n", header); err != nil {
+		return err
+	}
+
+	for _, c := range cues {
+		// Block contains ReadOrder, Layer, Style, Name, MarginL, MarginR, MarginV, Effect, Text
+		parts := strings.SplitN(c.text, ",", 3)
+		if len(parts) < 3 {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "Dialogue: %s,%s,%s,%s\r\n", parts[1], assTime(c.start), assTime(c.end), parts[2]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func srtTime(d time.Duration) string {
+	ms := int64(d / time.Millisecond)
+	return fmt.Sprintf("%02d:%02d:%02d,%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+func assTime(d time.Duration) string {
+	cs := int64(d / (10 * time.Millisecond))
+	return fmt.Sprintf("%d:%02d:%02d.%02d", cs/360000, cs/6000%60, cs/100%60, cs%100)
+}
+
+// readAt reads exact range of the source
+func readAt(src Source, offset, length int64) ([]byte, error) {
+	if offset+length > src.Length() {
+		length = src.Length() - offset
+	}
+	if offset < 0 || length <= 0 {
+		return nil, io.EOF
+	}
+
+	r, err := src.Open(offset, length)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	b := make([]byte, length)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
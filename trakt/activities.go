@@ -0,0 +1,117 @@
+package trakt
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jmcvetta/napping"
+
+	"github.com/elgatito/elementum/database"
+)
+
+// Activity categories, each one is synced only if its last_activities timestamp has changed
+const (
+	ActivityMoviesWatched      = "movies.watched"
+	ActivityEpisodesWatched    = "episodes.watched"
+	ActivityMoviesWatchlist    = "movies.watchlist"
+	ActivityShowsWatchlist     = "shows.watchlist"
+	ActivityMoviesCollection   = "movies.collection"
+	ActivityEpisodesCollection = "episodes.collection"
+	ActivityLists              = "lists"
+	ActivityMoviesPaused       = "movies.paused"
+	ActivityEpisodesPaused     = "episodes.paused"
+)
+
+const historyPageLimit = 1000
+
+// Get returns timestamp of the activity category
+func (a *UserActivities) Get(category string) time.Time {
+	switch category {
+	case ActivityMoviesWatched:
+		return a.Movies.WatchedAt
+	case ActivityEpisodesWatched:
+		return a.Episodes.WatchedAt
+	case ActivityMoviesWatchlist:
+		return a.Movies.WatchlistedAt
+	case ActivityShowsWatchlist:
+		return a.Shows.WatchlistedAt
+	case ActivityMoviesCollection:
+		return a.Movies.CollectedAt
+	case ActivityEpisodesCollection:
+		return a.Episodes.CollectedAt
+	case ActivityLists:
+		return a.Lists.UpdatedAt
+	case ActivityMoviesPaused:
+		return a.Movies.PausedAt
+	case ActivityEpisodesPaused:
+		return a.Episodes.PausedAt
+	}
+	return time.Time{}
+}
+
+// Changed checks whether any of categories has changed since it was synced
+func (a *UserActivities) Changed(categories ...string) bool {
+	for _, c := range categories {
+		if a.Get(c).After(SyncedActivity(c)) {
+			return true
+		}
+	}
+	return false
+}
+
+// MarkSynced stores timestamps of categories, so next sync can skip them if nothing changes
+func (a *UserActivities) MarkSynced(categories ...string) {
+	for _, c := range categories {
+		SetSyncedActivity(c, a.Get(c))
+	}
+}
+
+// SyncedActivity returns timestamp of the category at the time of the last sync
+func SyncedActivity(category string) (t time.Time) {
+	if value := database.Get().GetSetting("trakt.activity." + category); value != "" {
+		t, _ = time.Parse(time.RFC3339Nano, value)
+	}
+	return
+}
+
+// SetSyncedActivity stores timestamp of the synced category
+func SetSyncedActivity(category string, t time.Time) {
+	database.Get().SetSetting("trakt.activity."+category, t.Format(time.RFC3339Nano))
+}
+
+// HistorySince returns watched history of movies or episodes, added after given time
+func HistorySince(itemType string, since time.Time) (items []*HistoryItem, err error) {
+	if err := Authorized(); err != nil {
+		return items, err
+	}
+
+	endPoint := fmt.Sprintf("sync/history/%s", itemType)
+	for page := 1; ; page++ {
+		params := napping.Params{
+			"start_at": since.UTC().Format(time.RFC3339),
+			"page":     strconv.Itoa(page),
+			"limit":    strconv.Itoa(historyPageLimit),
+		}.AsUrlValues()
+
+		resp, err := GetWithAuth(endPoint, params)
+		if err != nil {
+			return items, err
+		} else if resp.Status() != 200 {
+			return items, fmt.Errorf("Bad status getting Trakt history for %s: %d", itemType, resp.Status())
+		}
+
+		var pageItems []*HistoryItem
+		if err := resp.Unmarshal(&pageItems); err != nil {
+			return items, err
+		}
+		items = append(items, pageItems...)
+
+		pagination := getPagination(resp.HttpResponse().Header)
+		if len(pageItems) == 0 || page >= pagination.PageCount {
+			break
+		}
+	}
+
+	return
+}
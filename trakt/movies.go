@@ -550,7 +550,7 @@ func WatchedMovies() (movies []*WatchedMovie, err error) {
 	keyLong := "com.trakt.movies.watched.previous"
 	watchedKey := "com.trakt.progress.movies.watched"
 
-	defer cacheStore.Set(watchedKey, lastActivities.Episodes.WatchedAt, activitiesExpiration)
+	defer cacheStore.Set(watchedKey, lastActivities.Movies.WatchedAt, activitiesExpiration)
 
 	var cachedWatchedAt time.Time
 	cacheStore.Get(watchedKey, &cachedWatchedAt)
@@ -0,0 +1,192 @@
+package trakt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/elgatito/elementum/cache"
+	"github.com/elgatito/elementum/config"
+	"github.com/elgatito/elementum/database"
+	"github.com/jmcvetta/napping"
+)
+
+const (
+	outboxInterval    = 1 * time.Minute
+	outboxMaxBackoff  = 6 * time.Hour
+	outboxMaxAttempts = 20
+)
+
+// ErrQueued is returned when request could not be sent and is stored in the outbox
+var ErrQueued = errors.New("Trakt is not reachable, change is queued and will be sent later")
+
+var (
+	outboxMu sync.Mutex
+
+	refreshMu  sync.Mutex
+	refreshing bool
+)
+
+// OutboxStatus ...
+type OutboxStatus struct {
+	Pending int                         `json:"pending"`
+	Items   []*database.TraktOutboxItem `json:"items"`
+}
+
+func setRefreshing(value bool) {
+	refreshMu.Lock()
+	defer refreshMu.Unlock()
+
+	refreshing = value
+}
+
+func isRefreshing() bool {
+	refreshMu.Lock()
+	defer refreshMu.Unlock()
+
+	return refreshing
+}
+
+// isTransient checks whether request failed because Trakt is not reachable,
+// or token is not valid anymore, such requests are worth repeating
+func isTransient(resp *napping.Response, err error) bool {
+	if err != nil || resp == nil {
+		return true
+	}
+
+	status := resp.Status()
+	return status >= 500 || status == 401 || status == 429
+}
+
+// postQueued sends request to Trakt, request is stored in the outbox if Trakt is not reachable,
+// or if older requests are still waiting, to keep the order of changes
+func postQueued(endPoint string, payload []byte) (resp *napping.Response, err error) {
+	if !isRefreshing() && database.Get().CountTraktOutbox() == 0 {
+		resp, err = Post(endPoint, bytes.NewBuffer(payload))
+		if !isTransient(resp, err) {
+			return
+		}
+
+		if err != nil {
+			log.Warningf("Could not send %s to Trakt, queueing: %s", endPoint, err)
+		} else {
+			log.Warningf("Could not send %s to Trakt, queueing: %d", endPoint, resp.Status())
+		}
+	}
+
+	if errAdd := database.Get().AddTraktOutbox(endPoint, string(payload)); errAdd != nil {
+		if resp == nil && err == nil {
+			err = errAdd
+		}
+		return
+	}
+
+	log.Noticef("Queued %s for Trakt, %d requests are waiting", endPoint, database.Get().CountTraktOutbox())
+	return nil, ErrQueued
+}
+
+// postJSONQueued ...
+func postJSONQueued(endPoint string, obj interface{}) (resp *napping.Response, err error) {
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return
+	}
+
+	return postQueued(endPoint, b)
+}
+
+// OutboxHandler replays queued requests, while Trakt is reachable
+func OutboxHandler() {
+	ticker := time.NewTicker(outboxInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ReplayOutbox()
+	}
+}
+
+// ReplayOutbox sends pending requests in the order they were queued,
+// replay stops at the first request, that could not be sent, and it is retried with a backoff
+func ReplayOutbox() (sent int) {
+	outboxMu.Lock()
+	defer outboxMu.Unlock()
+
+	if config.Get().TraktToken == "" || isRefreshing() {
+		return
+	}
+
+	defer func() {
+		if sent > 0 {
+			log.Noticef("Sent %d queued requests to Trakt", sent)
+			cacheStore := cache.NewDBStore()
+			cacheStore.Delete("com.trakt.movies.watched")
+			cacheStore.Delete("com.trakt.shows.watched")
+		}
+	}()
+
+	now := time.Now()
+	for _, i := range database.Get().GetTraktOutbox(true) {
+		if i.NextTry.After(now) {
+			return
+		}
+
+		resp, err := Post(i.EndPoint, bytes.NewBufferString(i.Payload))
+		if err == nil && resp.Status() >= 200 && resp.Status() < 300 {
+			database.Get().DeleteTraktOutbox(i.ID)
+			sent++
+			continue
+		}
+
+		i.Attempts++
+		if err != nil {
+			i.Error = err.Error()
+		} else {
+			i.Error = fmt.Sprintf("Bad status: %d", resp.Status())
+		}
+
+		// Rejected requests would never succeed, so they are put aside, not to block following requests
+		if !isTransient(resp, err) || i.Attempts >= outboxMaxAttempts {
+			log.Warningf("Queued %s request to Trakt failed after %d attempts: %s", i.EndPoint, i.Attempts, i.Error)
+			i.Status = database.OutboxFailed
+			database.Get().UpdateTraktOutbox(i)
+			continue
+		}
+
+		backoff := outboxInterval << uint(i.Attempts)
+		if backoff > outboxMaxBackoff || backoff <= 0 {
+			backoff = outboxMaxBackoff
+		}
+		i.NextTry = now.Add(backoff)
+		database.Get().UpdateTraktOutbox(i)
+
+		log.Infof("Trakt is still not reachable, next try in %s: %s", backoff, i.Error)
+		return
+	}
+
+	return
+}
+
+// GetOutbox returns pending and failed requests
+func GetOutbox() *OutboxStatus {
+	return &OutboxStatus{
+		Pending: database.Get().CountTraktOutbox(),
+		Items:   database.Get().GetTraktOutbox(false),
+	}
+}
+
+// RetryOutbox resets request, or all requests if id is 0, and replays the outbox immediately
+func RetryOutbox(id int) (int, error) {
+	if err := database.Get().RetryTraktOutbox(id); err != nil {
+		return 0, err
+	}
+
+	return ReplayOutbox(), nil
+}
+
+// DeleteOutbox removes request from the outbox
+func DeleteOutbox(id int) error {
+	return database.Get().DeleteTraktOutbox(id)
+}
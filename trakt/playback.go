@@ -0,0 +1,105 @@
+package trakt
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jmcvetta/napping"
+
+	"github.com/elgatito/elementum/cache"
+)
+
+// PlaybackProgresses returns paused movies and episodes, list is requested again only if something was paused since
+func PlaybackProgresses() (items []*PlaybackProgress, err error) {
+	if err := Authorized(); err != nil {
+		return items, err
+	}
+
+	lastActivities, errAct := GetLastActivities()
+	if errAct != nil {
+		return items, errAct
+	}
+
+	pausedAt := lastActivities.Movies.PausedAt
+	if lastActivities.Episodes.PausedAt.After(pausedAt) {
+		pausedAt = lastActivities.Episodes.PausedAt
+	}
+
+	cacheStore := cache.NewDBStore()
+	key := "com.trakt.playback"
+	pausedKey := "com.trakt.progress.playback.paused"
+
+	var cachedPausedAt time.Time
+	if err := cacheStore.Get(pausedKey, &cachedPausedAt); err == nil && !pausedAt.After(cachedPausedAt) {
+		if err := cacheStore.Get(key, &items); err == nil {
+			return items, nil
+		}
+	}
+
+	endPoint := "sync/playback"
+	params := napping.Params{
+		"extended": "full",
+	}.AsUrlValues()
+
+	resp, err := GetWithAuth(endPoint, params)
+	if err != nil {
+		return items, err
+	} else if resp.Status() != 200 {
+		return items, fmt.Errorf("Bad status getting Trakt playback progress: %d", resp.Status())
+	}
+
+	if err := resp.Unmarshal(&items); err != nil {
+		log.Warning(err)
+	}
+
+	cacheStore.Set(key, items, progressExpiration)
+	cacheStore.Set(pausedKey, pausedAt, activitiesExpiration)
+
+	return
+}
+
+// FindPlaybackMovie returns playback progress of the movie, or nil
+func FindPlaybackMovie(tmdbID int) *PlaybackProgress {
+	items, err := PlaybackProgresses()
+	if err != nil {
+		log.Debugf("Could not get playback progress: %s", err)
+		return nil
+	}
+
+	for _, i := range items {
+		if i.Movie != nil && i.Movie.IDs != nil && i.Movie.IDs.TMDB == tmdbID {
+			return i
+		}
+	}
+	return nil
+}
+
+// FindPlaybackEpisode returns playback progress of the episode, or nil
+func FindPlaybackEpisode(showID, season, episode int) *PlaybackProgress {
+	items, err := PlaybackProgresses()
+	if err != nil {
+		log.Debugf("Could not get playback progress: %s", err)
+		return nil
+	}
+
+	for _, i := range items {
+		if i.Show != nil && i.Show.IDs != nil && i.Episode != nil && i.Show.IDs.TMDB == showID && i.Episode.Season == season && i.Episode.Number == episode {
+			return i
+		}
+	}
+	return nil
+}
+
+// Position returns paused position in seconds, runtime of the item is used if total is unknown
+func (p *PlaybackProgress) Position(total float64) (position float64, duration float64) {
+	duration = total
+	if duration <= 0 {
+		if p.Movie != nil && p.Movie.Runtime > 0 {
+			duration = float64(p.Movie.Runtime * 60)
+		} else if p.Show != nil && p.Show.Runtime > 0 {
+			duration = float64(p.Show.Runtime * 60)
+		}
+	}
+
+	return duration * p.Progress / 100, duration
+}
@@ -397,6 +397,28 @@ type WatchedMovie struct {
 	Movie         *Movie    `json:"movie"`
 }
 
+// HistoryItem is an entry of watched history, returned by sync/history
+type HistoryItem struct {
+	ID        int64     `json:"id"`
+	WatchedAt time.Time `json:"watched_at"`
+	Action    string    `json:"action"`
+	Type      string    `json:"type"`
+	Movie     *Movie    `json:"movie"`
+	Show      *Show     `json:"show"`
+	Episode   *Episode  `json:"episode"`
+}
+
+// PlaybackProgress is a paused movie or episode, returned by sync/playback
+type PlaybackProgress struct {
+	ID       int64     `json:"id"`
+	Progress float64   `json:"progress"`
+	PausedAt time.Time `json:"paused_at"`
+	Type     string    `json:"type"`
+	Movie    *Movie    `json:"movie"`
+	Show     *Show     `json:"show"`
+	Episode  *Episode  `json:"episode"`
+}
+
 // WatchedShow ...
 type WatchedShow struct {
 	Plays         int `json:"plays"`
@@ -825,8 +847,11 @@ func TokenRefreshHandler() {
 		select {
 		case <-ticker.C:
 			if time.Now().Unix() > int64(config.Get().TraktTokenExpiry)-int64(259200) {
+				// Changes are queued until new token is stored
+				setRefreshing(true)
 				resp, err := RefreshToken()
 				if err != nil {
+					setRefreshing(false)
 					xbmc.Notify("Elementum", err.Error(), config.AddonIcon())
 					log.Error(err)
 					return
@@ -848,6 +873,7 @@ func TokenRefreshHandler() {
 					xbmc.Notify("Elementum", err.Error(), config.AddonIcon())
 					log.Error(err)
 				}
+				setRefreshing(false)
 			}
 		}
 	}
@@ -961,7 +987,7 @@ func AddToWatchlist(itemType string, tmdbID string) (resp *napping.Response, err
 	}
 
 	endPoint := "sync/watchlist"
-	return Post(endPoint, bytes.NewBufferString(fmt.Sprintf(`{"%s": [{"ids": {"tmdb": %s}}]}`, itemType, tmdbID)))
+	return postQueued(endPoint, []byte(fmt.Sprintf(`{"%s": [{"ids": {"tmdb": %s}}]}`, itemType, tmdbID)))
 }
 
 // AddToUserlist ...
@@ -983,7 +1009,7 @@ func AddToUserlist(listID int, itemType string, tmdbID string) (resp *napping.Re
 		payload.Shows = append(payload.Shows, i)
 	}
 
-	return PostJSON(endPoint, payload)
+	return postJSONQueued(endPoint, payload)
 }
 
 // RemoveFromUserlist ...
@@ -1005,7 +1031,7 @@ func RemoveFromUserlist(listID int, itemType string, tmdbID string) (resp *nappi
 		payload.Shows = append(payload.Shows, i)
 	}
 
-	return PostJSON(endPoint, payload)
+	return postJSONQueued(endPoint, payload)
 }
 
 // RemoveFromWatchlist ...
@@ -1015,7 +1041,7 @@ func RemoveFromWatchlist(itemType string, tmdbID string) (resp *napping.Response
 	}
 
 	endPoint := "sync/watchlist/remove"
-	return Post(endPoint, bytes.NewBufferString(fmt.Sprintf(`{"%s": [{"ids": {"tmdb": %s}}]}`, itemType, tmdbID)))
+	return postQueued(endPoint, []byte(fmt.Sprintf(`{"%s": [{"ids": {"tmdb": %s}}]}`, itemType, tmdbID)))
 }
 
 // AddToCollection ...
@@ -1025,7 +1051,7 @@ func AddToCollection(itemType string, tmdbID string) (resp *napping.Response, er
 	}
 
 	endPoint := "sync/collection"
-	return Post(endPoint, bytes.NewBufferString(fmt.Sprintf(`{"%s": [{"ids": {"tmdb": %s}}]}`, itemType, tmdbID)))
+	return postQueued(endPoint, []byte(fmt.Sprintf(`{"%s": [{"ids": {"tmdb": %s}}]}`, itemType, tmdbID)))
 }
 
 // RemoveFromCollection ...
@@ -1035,7 +1061,7 @@ func RemoveFromCollection(itemType string, tmdbID string) (resp *napping.Respons
 	}
 
 	endPoint := "sync/collection/remove"
-	return Post(endPoint, bytes.NewBufferString(fmt.Sprintf(`{"%s": [{"ids": {"tmdb": %s}}]}`, itemType, tmdbID)))
+	return postQueued(endPoint, []byte(fmt.Sprintf(`{"%s": [{"ids": {"tmdb": %s}}]}`, itemType, tmdbID)))
 }
 
 // SetWatched addes and removes from watched history
@@ -1056,7 +1082,7 @@ func SetWatched(item *WatchedItem) (resp *napping.Response, err error) {
 		endPoint = "sync/history/remove"
 	}
 
-	return Post(endPoint, bytes.NewBufferString(pre+query+post))
+	return postQueued(endPoint, []byte(pre+query+post))
 }
 
 // SetMultipleWatched adds and removes from watched history
@@ -1088,7 +1114,7 @@ func SetMultipleWatched(items []*WatchedItem) (resp *napping.Response, err error
 	cache.NewDBStore().Delete(fmt.Sprintf("com.trakt.%ss.watched", items[0].MediaType))
 
 	log.Debugf("Setting watch state for %d %s items", len(items), items[0].MediaType)
-	return Post(endPoint, bytes.NewBufferString(pre+query+post))
+	return postQueued(endPoint, []byte(pre+query+post))
 }
 
 func (item *WatchedItem) String() (query string) {
@@ -1144,8 +1170,19 @@ func Scrobble(action string, contentType string, tmdbID int, watched float64, ru
 	endPoint := fmt.Sprintf("scrobble/%s", action)
 	payload := fmt.Sprintf(`{"%s": {"ids": {"tmdb": %d}}, "progress": %f, "app_version": "%s"}`,
 		contentType, tmdbID, progress, util.GetVersion())
-	resp, err := Post(endPoint, bytes.NewBufferString(payload))
-	if err != nil {
+
+	// Start of playback is meaningless later, other events are queued if Trakt is not reachable
+	var resp *napping.Response
+	var err error
+	if action == "start" {
+		resp, err = Post(endPoint, bytes.NewBufferString(payload))
+	} else {
+		resp, err = postQueued(endPoint, []byte(payload))
+	}
+
+	if err == ErrQueued {
+		log.Noticef("Scrobble %s of %s #%d is queued", action, contentType, tmdbID)
+	} else if err != nil {
 		log.Error(err.Error())
 		xbmc.Notify("Elementum", "Scrobble failed, check your logs.", config.AddonIcon())
 	} else if resp.Status() != 201 {
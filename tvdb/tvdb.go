@@ -56,6 +56,11 @@ type Episode struct {
 
 	AbsoluteNumber       int    `xml:"-"`
 	AbsoluteNumberString string `xml:"absolute_number"`
+
+	DVDSeason        int    `xml:"-"`
+	DVDSeasonString  string `xml:"DVD_season"`
+	DVDEpisode       int    `xml:"-"`
+	DVDEpisodeString string `xml:"DVD_episodenumber"`
 }
 
 // Show ...
@@ -195,6 +200,13 @@ func getShow(tvdbID int, language string) (*Show, error) {
 		if an, err := strconv.Atoi(episode.AbsoluteNumberString); err == nil {
 			episode.AbsoluteNumber = an
 		}
+		// DVD episode number is a float, parts of split episodes are numbered like 1.1 and 1.2
+		if ds, err := strconv.Atoi(episode.DVDSeasonString); err == nil {
+			episode.DVDSeason = ds
+		}
+		if de, err := strconv.ParseFloat(episode.DVDEpisodeString, 64); err == nil {
+			episode.DVDEpisode = int(de)
+		}
 		season.Episodes = append(season.Episodes, episode)
 	}
 
@@ -166,6 +166,7 @@ type VideoLibraryMovieItem struct {
 	File       string    `json:"file"`
 	Year       int       `json:"year"`
 	UniqueIDs  UniqueIDs `json:"uniqueid"`
+	LastPlayed string    `json:"lastplayed"`
 	Resume     *Resume
 }
 
@@ -219,15 +220,16 @@ type VideoLibraryEpisode struct {
 
 // VideoLibraryEpisodeItem ...
 type VideoLibraryEpisodeItem struct {
-	ID        int       `json:"episodeid"`
-	Title     string    `json:"label"`
-	Season    int       `json:"season"`
-	Episode   int       `json:"episode"`
-	TVShowID  int       `json:"tvshowid"`
-	PlayCount int       `json:"playcount"`
-	File      string    `json:"file"`
-	UniqueIDs UniqueIDs `json:"uniqueid"`
-	Resume    *Resume
+	ID         int       `json:"episodeid"`
+	Title      string    `json:"label"`
+	Season     int       `json:"season"`
+	Episode    int       `json:"episode"`
+	TVShowID   int       `json:"tvshowid"`
+	PlayCount  int       `json:"playcount"`
+	File       string    `json:"file"`
+	UniqueIDs  UniqueIDs `json:"uniqueid"`
+	LastPlayed string    `json:"lastplayed"`
+	Resume     *Resume
 }
 
 // UniqueIDs ...
@@ -48,6 +48,7 @@ func VideoLibraryGetMovies() (movies *VideoLibraryMovies, err error) {
 		"playcount",
 		"file",
 		"resume",
+		"lastplayed",
 	}
 	if KodiVersion > 16 {
 		list = append(list, "uniqueid", "year")
@@ -153,6 +154,7 @@ func VideoLibraryGetEpisodes(tvshowID int) (episodes *VideoLibraryEpisodes, err
 		"playcount",
 		"file",
 		"resume",
+		"lastplayed",
 	}}
 	err = executeJSONRPCO("VideoLibrary.GetEpisodes", &episodes, params)
 	if err != nil {
@@ -170,6 +172,7 @@ func VideoLibraryGetAllEpisodes() (episodes *VideoLibraryEpisodes, err error) {
 		"playcount",
 		"file",
 		"resume",
+		"lastplayed",
 	}
 	if KodiVersion > 16 {
 		list = append(list, "uniqueid")
@@ -226,6 +229,20 @@ func SetMovieProgress(movieID int, position int, total int) (ret string) {
 	return
 }
 
+// SetMovieProgressWithDate ...
+func SetMovieProgressWithDate(movieID int, position int, total int, dt time.Time) (ret string) {
+	params := map[string]interface{}{
+		"movieid": movieID,
+		"resume": map[string]interface{}{
+			"position": position,
+			"total":    total,
+		},
+		"lastplayed": dt.Local().Format("2006-01-02 15:04:05"),
+	}
+	executeJSONRPCO("VideoLibrary.SetMovieDetails", &ret, params)
+	return
+}
+
 // SetMoviePlaycount ...
 func SetMoviePlaycount(movieID int, playcount int) (ret string) {
 	params := map[string]interface{}{
@@ -302,6 +319,20 @@ func SetEpisodeProgress(episodeID int, position int, total int) (ret string) {
 	return
 }
 
+// SetEpisodeProgressWithDate ...
+func SetEpisodeProgressWithDate(episodeID int, position int, total int, dt time.Time) (ret string) {
+	params := map[string]interface{}{
+		"episodeid": episodeID,
+		"resume": map[string]interface{}{
+			"position": position,
+			"total":    total,
+		},
+		"lastplayed": dt.Local().Format("2006-01-02 15:04:05"),
+	}
+	executeJSONRPCO("VideoLibrary.SetEpisodeDetails", &ret, params)
+	return
+}
+
 // SetEpisodePlaycount ...
 func SetEpisodePlaycount(episodeID int, playcount int) (ret string) {
 	params := map[string]interface{}{